@@ -14,7 +14,9 @@ package main
 import (
 	"goCal/internal/config"
 	"goCal/internal/db"
+	"goCal/internal/jobs"
 	"goCal/internal/logger"
+	"time"
 )
 
 func main() {
@@ -23,6 +25,14 @@ func main() {
 	config.StorageInit()
 	db.DBConnect()
 
+	jobs.StartStorageReconciler(config.GetDurationEnv("STORAGE_RECONCILE_INTERVAL", time.Hour))
+	jobs.StartUploadCleaner(config.GetStorageBackend(), config.GetDurationEnv("TUS_CLEANUP_INTERVAL", time.Hour))
+	jobs.StartTrashPurger(config.GetStorageBackend(), config.GetDurationEnv("TRASH_RETENTION", 30*24*time.Hour), config.GetDurationEnv("TRASH_PURGE_INTERVAL", time.Hour))
+	jobs.StartBlobCollector(config.GetStorageBackend(), config.GetDurationEnv("BLOB_GC_INTERVAL", time.Hour))
+	jobs.StartEmailWorker(config.GetIntEnv("EMAIL_WORKERS", 4), config.GetDurationEnv("EMAIL_POLL_INTERVAL", 5*time.Second))
+	jobs.StartContentIndexer(config.GetStorageBackend(), config.GetIntEnv("CONTENT_INDEX_WORKERS", 2), config.GetDurationEnv("CONTENT_INDEX_POLL_INTERVAL", 10*time.Second))
+	jobs.StartThumbnailGenerator(config.GetStorageBackend(), config.GetIntEnv("THUMBNAIL_WORKERS", 2), config.GetDurationEnv("THUMBNAIL_POLL_INTERVAL", 5*time.Second))
+
 	r := config.InitRouter()
 	r.Run(":8080")
 }
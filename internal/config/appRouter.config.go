@@ -1,26 +1,116 @@
 package config
 
 import (
+	"goCal/internal/logger"
+	"goCal/internal/middleware"
+	"goCal/internal/ratelimit"
 	"goCal/internal/routes"
+	"os"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
 var mainRouter *gin.Engine
 
+// Rate limit policies, applied per route group below
+var (
+	apiRateLimit = ratelimit.Policy{Name: "api", Limit: 300, Period: time.Minute, Burst: 100}
+
+	// Guessing passwords and codes
+	authRateLimit = ratelimit.Policy{Name: "auth", Limit: 10, Period: time.Minute, ByIP: true, Routes: []string{
+		"POST /api/user/login",
+		"POST /api/user/login/2fa",
+		"POST /api/user/verify",
+		"POST /api/user/refresh",
+		"POST /api/user/reset-password",
+	}}
+
+	// Each of these sends an email with blocking SMTP retries
+	emailRateLimit = ratelimit.Policy{Name: "email", Limit: 3, Period: 15 * time.Minute, ByIP: true, Routes: []string{
+		"POST /api/user/",
+		"POST /api/user/resend-verification",
+		"POST /api/user/forgot-password",
+	}}
+
+	uploadRateLimit = ratelimit.Policy{Name: "upload", Limit: 30, Period: time.Minute, Burst: 10, Routes: []string{
+		"POST /api/file/",
+		"POST /api/file/uploads",
+		"POST /api/file/:id/versions",
+	}}
+
+	shareRateLimit = ratelimit.Policy{Name: "share", Limit: 60, Period: time.Minute, Burst: 30}
+)
+
+func trustedProxies() []string {
+	var proxies []string
+	for _, proxy := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			proxies = append(proxies, proxy)
+		}
+	}
+	return proxies
+}
+
 func InitRouter() *gin.Engine {
 	mainRouter = gin.Default()
 
-	storageClient := GetStorageClient()
+	// ClientIP keys the rate limits, so forwarding headers are only believed
+	// from proxies listed in TRUSTED_PROXIES (comma separated IPs or CIDRs)
+	if err := mainRouter.SetTrustedProxies(trustedProxies()); err != nil {
+		logger.Error("Invalid TRUSTED_PROXIES, trusting none: %v", err)
+		mainRouter.SetTrustedProxies(nil)
+	}
+
+	storageBackend := GetStorageBackend()
+	rateLimitStore := ratelimit.NewMemoryStore()
 
 	healthRouter := mainRouter.Group("/api/health")
 	routes.RegisterHealthRoute(healthRouter)
 
 	userRouter := mainRouter.Group("/api/user")
+	userRouter.Use(
+		middleware.RateLimit(rateLimitStore, apiRateLimit),
+		middleware.RateLimit(rateLimitStore, authRateLimit),
+		middleware.RateLimit(rateLimitStore, emailRateLimit),
+	)
 	routes.UserRoutes(userRouter)
 
 	fileRouter := mainRouter.Group("/api/file")
-	routes.FileRoutes(fileRouter, storageClient)
+	fileRouter.Use(
+		middleware.RateLimit(rateLimitStore, apiRateLimit),
+		middleware.RateLimit(rateLimitStore, uploadRateLimit),
+	)
+	routes.FileRoutes(fileRouter, storageBackend)
+
+	folderRouter := mainRouter.Group("/api/folder")
+	folderRouter.Use(middleware.RateLimit(rateLimitStore, apiRateLimit))
+	routes.FolderRoutes(folderRouter)
+
+	trashRouter := mainRouter.Group("/api/trash")
+	trashRouter.Use(middleware.RateLimit(rateLimitStore, apiRateLimit))
+	routes.TrashRoutes(trashRouter, storageBackend)
+
+	searchRouter := mainRouter.Group("/api/search")
+	searchRouter.Use(middleware.RateLimit(rateLimitStore, apiRateLimit))
+	routes.SearchRoutes(searchRouter)
+
+	shareRouter := mainRouter.Group("/api/share")
+	shareRouter.Use(middleware.RateLimit(rateLimitStore, shareRateLimit))
+	routes.ShareRoutes(shareRouter, storageBackend)
+
+	storageRouter := mainRouter.Group("/api/storage")
+	storageRouter.Use(middleware.RateLimit(rateLimitStore, apiRateLimit))
+	routes.StorageRoutes(storageRouter, storageBackend)
+
+	emailRouter := mainRouter.Group("/api/email-outbox")
+	emailRouter.Use(middleware.RateLimit(rateLimitStore, apiRateLimit))
+	routes.EmailOutboxRoutes(emailRouter)
+
+	emailTemplateRouter := mainRouter.Group("/api/email-templates")
+	emailTemplateRouter.Use(middleware.RateLimit(rateLimitStore, apiRateLimit))
+	routes.EmailTemplateRoutes(emailTemplateRouter)
 
 	return mainRouter
 }
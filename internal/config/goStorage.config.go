@@ -3,55 +3,71 @@ package config
 import (
 	"fmt"
 	"goCal/internal/logger"
+	"goCal/internal/storage"
 	"os"
-
-	storage_go "github.com/supabase-community/storage-go"
+	"strings"
 )
 
-var storageClient *storage_go.Client
-var storageBucketVideos storage_go.Bucket
-var storageBucketAlbums storage_go.Bucket
-var storageBucketAudios storage_go.Bucket
-var storageBucketDocs storage_go.Bucket
-var storageBucketOthers storage_go.Bucket
+var storageBackend storage.StorageBackend
 
 func ensureBucket(name string) {
-	_, err := storageClient.GetBucket(name)
-	if err == nil {
-		fmt.Printf("Bucket %s already exists\n", name)
-		return
-	}
-
-	// If not found, create it
-	_, err = storageClient.CreateBucket(name, storage_go.BucketOptions{
-		Public:        true,
-		FileSizeLimit: "100",
-	})
-	if err != nil {
-		logger.Error(fmt.Sprintf("Failed to create bucket %s: %v", name, err))
+	if err := storageBackend.EnsureBucket(name); err != nil {
+		logger.Error(fmt.Sprintf("Failed to ensure bucket %s: %v", name, err))
 		return
 	}
-	fmt.Printf("Bucket %s created successfully\n", name)
+	fmt.Printf("Bucket %s is ready\n", name)
 }
 
+// StorageInit selects the storage driver from STORAGE_DRIVER ("supabase" or "local").
+// When the variable is unset Supabase is used if its credentials are present,
+// otherwise files are kept on local disk so the API can run offline. Local
+// storage signs its URLs with STORAGE_SIGNING_KEY, which must be set.
 func StorageInit() {
+	driver := strings.ToLower(os.Getenv("STORAGE_DRIVER"))
 	url := os.Getenv("SUPABASE_PROJECT_URL")
 	key := os.Getenv("SUPABASE_PROJECT_KEY")
 
-	if url == "" || key == "" {
-		logger.Error("Missing Supabase credentials")
-		return
+	if driver == "" {
+		if url != "" && key != "" {
+			driver = "supabase"
+		} else {
+			logger.Warn("Missing Supabase credentials, falling back to local storage")
+			driver = "local"
+		}
 	}
 
-	storageClient = storage_go.NewClient(url+"/storage/v1", key, nil)
+	switch driver {
+	case "supabase":
+		if url == "" || key == "" {
+			logger.Error("Missing Supabase credentials")
+			panic("STORAGE_DRIVER=supabase requires SUPABASE_PROJECT_URL and SUPABASE_PROJECT_KEY")
+		}
+		storageBackend = storage.NewSupabaseBackend(url, key)
+	case "local":
+		root := os.Getenv("LOCAL_STORAGE_PATH")
+		if root == "" {
+			root = "storage"
+		}
+		baseURL := os.Getenv("STORAGE_PUBLIC_BASE_URL")
+		if baseURL == "" {
+			baseURL = "http://localhost:8080"
+		}
+		localBackend, err := storage.NewLocalBackend(root, baseURL, os.Getenv("STORAGE_SIGNING_KEY"))
+		if err != nil {
+			logger.Error("Failed to initialize local storage: %v", err)
+			panic(fmt.Errorf("failed to initialize local storage: %w", err))
+		}
+		storageBackend = localBackend
+	default:
+		panic(fmt.Sprintf("unknown STORAGE_DRIVER %q", driver))
+	}
 
-	ensureBucket("goCal-Other-Bucket")
-	ensureBucket("goCal-Docs-Bucket")
-	ensureBucket("goCal-Albums-Bucket")
-	ensureBucket("goCal-Audios-Bucket")
-	ensureBucket("goCal-Videos-Bucket")
+	for _, bucket := range storage.Buckets {
+		ensureBucket(bucket)
+	}
+	logger.Info(fmt.Sprintf("Storage initialized with %s driver", storageBackend.Name()))
 }
 
-func GetStorageClient() *storage_go.Client {
-	return storageClient
+func GetStorageBackend() storage.StorageBackend {
+	return storageBackend
 }
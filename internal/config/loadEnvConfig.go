@@ -3,6 +3,9 @@ package config
 import (
 	"fmt"
 	"goCal/internal/logger"
+	"os"
+	"strconv"
+	"time"
 
 	"github.com/joho/godotenv"
 )
@@ -15,3 +18,31 @@ func GetLoadEnvVars() {
 		return
 	}
 }
+
+// GetDurationEnv parses a Go duration (e.g. "30m") from the environment, falling back to def
+func GetDurationEnv(name string, def time.Duration) time.Duration {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		logger.Warn(fmt.Sprintf("Invalid duration %q for %s, using %s", value, name, def))
+		return def
+	}
+	return duration
+}
+
+// GetIntEnv parses an integer from the environment, falling back to def
+func GetIntEnv(name string, def int) int {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+	number, err := strconv.Atoi(value)
+	if err != nil {
+		logger.Warn(fmt.Sprintf("Invalid integer %q for %s, using %d", value, name, def))
+		return def
+	}
+	return number
+}
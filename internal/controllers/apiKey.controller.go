@@ -0,0 +1,91 @@
+package controllers
+
+import (
+	"errors"
+	"goCal/internal/schema"
+	"goCal/internal/services"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type ApiKeyController struct {
+	ApiKeyService *services.ApiKeyService
+}
+
+func NewApiKeyController(apiKeyService *services.ApiKeyService) *ApiKeyController {
+	return &ApiKeyController{
+		ApiKeyService: apiKeyService,
+	}
+}
+
+// apiKeyErrorStatus maps API key errors onto HTTP status codes
+func apiKeyErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, services.ErrApiKeyNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, services.ErrApiKeyScopeInvalid), errors.Is(err, services.ErrApiKeyExpiryInvalid):
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// CreateApiKey returns the key once, only its hash is kept
+func (akc *ApiKeyController) CreateApiKey(ctx *gin.Context) {
+	var request schema.CreateApiKeyRequest
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	apiKey, key, err := akc.ApiKeyService.CreateApiKey(ctx.GetString("userId"), &request)
+	if err != nil {
+		ctx.JSON(apiKeyErrorStatus(err), gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, gin.H{
+		"success": true,
+		"message": "Store this key now, it will not be shown again",
+		"key":     key,
+		"api_key": apiKey,
+	})
+}
+
+func (akc *ApiKeyController) GetApiKeys(ctx *gin.Context) {
+	apiKeys, err := akc.ApiKeyService.GetApiKeys(ctx.GetString("userId"))
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"success":  true,
+		"api_keys": apiKeys,
+	})
+}
+
+func (akc *ApiKeyController) RevokeApiKey(ctx *gin.Context) {
+	if err := akc.ApiKeyService.RevokeApiKey(ctx.Param("keyId"), ctx.GetString("userId")); err != nil {
+		ctx.JSON(apiKeyErrorStatus(err), gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "API Key Revoked",
+	})
+}
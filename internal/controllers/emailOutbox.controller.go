@@ -0,0 +1,82 @@
+package controllers
+
+import (
+	"errors"
+	"goCal/internal/schema"
+	"goCal/internal/services"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type EmailOutboxController struct {
+	EmailOutboxService *services.EmailOutboxService
+}
+
+func NewEmailOutboxController(emailOutboxService *services.EmailOutboxService) *EmailOutboxController {
+	return &EmailOutboxController{
+		EmailOutboxService: emailOutboxService,
+	}
+}
+
+// GetStatus returns how many emails are in each status
+func (eoc *EmailOutboxController) GetStatus(ctx *gin.Context) {
+	counts, err := eoc.EmailOutboxService.GetStatus()
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"counts":  counts,
+	})
+}
+
+// GetMessages lists recent emails, ?status= and ?kind= narrow it down
+func (eoc *EmailOutboxController) GetMessages(ctx *gin.Context) {
+	var query schema.EmailOutboxListQuery
+	if !bindListQuery(ctx, &query) {
+		return
+	}
+
+	messages, page, err := eoc.EmailOutboxService.GetMessages(&query)
+	if err != nil {
+		ctx.JSON(listErrorStatus(err), gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"success":    true,
+		"messages":   messages,
+		"pagination": page,
+	})
+}
+
+// Retry requeues a dead-lettered email
+func (eoc *EmailOutboxController) Retry(ctx *gin.Context) {
+	message, err := eoc.EmailOutboxService.Retry(ctx.Param("id"))
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, services.ErrOutboxMessageNotFound) {
+			status = http.StatusNotFound
+		}
+		ctx.JSON(status, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Email queued for retry",
+		"email":   message,
+	})
+}
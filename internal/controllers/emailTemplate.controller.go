@@ -0,0 +1,74 @@
+package controllers
+
+import (
+	"errors"
+	"goCal/internal/emails"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type EmailTemplateController struct{}
+
+func NewEmailTemplateController() *EmailTemplateController {
+	return &EmailTemplateController{}
+}
+
+// GetTemplates lists the message types and the locales they come in
+func (etc *EmailTemplateController) GetTemplates(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, gin.H{
+		"success":        true,
+		"templates":      emails.Names(),
+		"locales":        emails.Locales(),
+		"default_locale": emails.DefaultLocale,
+	})
+}
+
+// PreviewTemplate renders a template with sample data. ?locale= picks the
+// language, ?format=html or ?format=text returns that part on its own.
+func (etc *EmailTemplateController) PreviewTemplate(ctx *gin.Context) {
+	name := ctx.Param("name")
+	locale := ctx.DefaultQuery("locale", emails.DefaultLocale)
+	if !emails.IsSupportedLocale(locale) {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   "unsupported locale",
+		})
+		return
+	}
+
+	data, err := emails.SampleData(name)
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	message, err := emails.Render(name, locale, data)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, emails.ErrUnknownTemplate) {
+			status = http.StatusNotFound
+		}
+		ctx.JSON(status, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	switch ctx.Query("format") {
+	case "html":
+		ctx.Data(http.StatusOK, "text/html; charset=utf-8", []byte(message.HTML))
+	case "text":
+		ctx.Data(http.StatusOK, "text/plain; charset=utf-8", []byte(message.Text))
+	default:
+		ctx.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"locale":  locale,
+			"email":   message,
+		})
+	}
+}
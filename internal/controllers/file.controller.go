@@ -1,6 +1,7 @@
 package controllers
 
 import (
+	"errors"
 	"fmt"
 	"goCal/internal/logger"
 	"goCal/internal/schema"
@@ -15,20 +16,47 @@ type FileController struct {
 	FileService        *services.FileService
 	UserService        *services.UserService
 	FileStorageService *services.FileStorageService
+	QuotaService       *services.QuotaService
 }
 
-func NewFileController(fileService *services.FileService, userService *services.UserService, fileStorageService *services.FileStorageService) *FileController {
+func NewFileController(fileService *services.FileService, userService *services.UserService, fileStorageService *services.FileStorageService, quotaService *services.QuotaService) *FileController {
 	return &FileController{
 		FileService:        fileService,
 		UserService:        userService,
 		FileStorageService: fileStorageService,
+		QuotaService:       quotaService,
 	}
 }
 
+// fileErrorStatus maps file service errors onto HTTP status codes
+func fileErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, services.ErrFileNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, services.ErrFolderNotFound), errors.Is(err, services.ErrFileVersionNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, services.ErrFileAccessDenied), errors.Is(err, services.ErrFolderAccessDenied):
+		return http.StatusForbidden
+	case errors.Is(err, services.ErrQuotaExceeded):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, services.ErrFileNameTaken):
+		return http.StatusConflict
+	default:
+		return http.StatusBadRequest
+	}
+}
+
+// GetAllFiles lists the files the caller can see: public files for anonymous
+// callers, plus their own and shared-with-them files when authenticated
 func (fc *FileController) GetAllFiles(ctx *gin.Context) {
-	files, err := fc.FileService.GetFiles()
+	var query schema.FileListQuery
+	if !bindListQuery(ctx, &query) {
+		return
+	}
+
+	files, page, err := fc.FileService.GetFiles(ctx.GetString("userId"), &query)
 	if err != nil {
-		ctx.JSON(http.StatusBadRequest, gin.H{
+		ctx.JSON(listErrorStatus(err), gin.H{
 			"success": false,
 			"error":   err.Error(),
 		})
@@ -36,8 +64,9 @@ func (fc *FileController) GetAllFiles(ctx *gin.Context) {
 	}
 
 	ctx.JSON(http.StatusOK, gin.H{
-		"success": true,
-		"files":   files,
+		"success":    true,
+		"files":      files,
+		"pagination": page,
 	})
 	return
 }
@@ -50,17 +79,19 @@ func (fc *FileController) GetFile(ctx *gin.Context) {
 			"success": false,
 			"message": "Failed to get the id of the request",
 		})
+		return
 	}
 
-	file, error := fc.FileService.GetFile(id)
+	file, error := fc.FileService.GetVisibleFile(id, ctx.GetString("userId"))
 
 	if error != nil {
 		logger.Error("Failed to find the find %s", error.Error())
-		ctx.JSON(http.StatusNotFound, gin.H{
+		ctx.JSON(fileErrorStatus(error), gin.H{
 			"success": false,
 			"message": "Failed to get the file ",
 			"error":   error.Error(),
 		})
+		return
 	}
 
 	ctx.JSON(http.StatusOK, gin.H{
@@ -125,6 +156,19 @@ func (fc *FileController) CreateFile(ctx *gin.Context) {
 		return
 	}
 
+	var folderId *uuid.UUID
+	if rawFolderId := ctx.PostForm("folder_id"); rawFolderId != "" {
+		parsedFolderId, err := uuid.Parse(rawFolderId)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"error":   "Invalid folder_id",
+			})
+			return
+		}
+		folderId = &parsedFolderId
+	}
+
 	files := form.File["files"]
 
 	if len(files) == 0 {
@@ -141,8 +185,16 @@ func (fc *FileController) CreateFile(ctx *gin.Context) {
 
 	var createdFiles []schema.File
 	var uploadErrors []string
+	var quotaError *services.QuotaExceededError
 
 	for _, fileHeader := range files {
+		// Reject early so we don't push bytes to storage that can't be kept
+		if errQuota := fc.QuotaService.CheckQuota(userIdStr, fileHeader.Size); errQuota != nil {
+			errors.As(errQuota, &quotaError)
+			uploadErrors = append(uploadErrors, fmt.Sprintf("Failed to upload %s: %v", fileHeader.Filename, errQuota))
+			continue
+		}
+
 		src, errFileOpen := fileHeader.Open()
 		if errFileOpen != nil {
 			logger.Error("Failed to open uploaded file: %v", errFileOpen)
@@ -150,7 +202,7 @@ func (fc *FileController) CreateFile(ctx *gin.Context) {
 		}
 		fileType := fileHeader.Header.Get("Content-Type")
 
-		fileUrl, uploadError := fc.FileStorageService.UploadFile(userIdStr, fileHeader.Filename, src, fileType)
+		storedObject, uploadError := fc.FileStorageService.UploadFile(userIdStr, fileHeader.Filename, src, fileType)
 		src.Close()
 
 		if uploadError != nil {
@@ -160,24 +212,39 @@ func (fc *FileController) CreateFile(ctx *gin.Context) {
 		}
 
 		newFile := &schema.File{
-			FileName:     fileHeader.Filename,
-			FileUrl:      fileUrl,
-			FileSize:     fileHeader.Size,
-			FileType:     fileType,
-			UploadedById: uuid.MustParse(userIdStr),
+			FileName:      fileHeader.Filename,
+			FileSize:      fileHeader.Size,
+			FileType:      fileType,
+			FolderId:      folderId,
+			StorageBucket: storedObject.Bucket,
+			StorageKey:    storedObject.Key,
+			ContentHash:   storedObject.Hash,
+			UploadedById:  uuid.MustParse(userIdStr),
 		}
 
 		createdFile, errFileCreate := fc.FileService.CreateFile(newFile, userIdStr)
 		if errFileCreate != nil {
 			logger.Error("Error creating file record for %s: %v\n", fileHeader.Filename, errFileCreate)
+			errors.As(errFileCreate, &quotaError)
 			uploadErrors = append(uploadErrors, fmt.Sprintf("Failed to save %s to database: %v", fileHeader.Filename, errFileCreate))
-			// TODO: Consider deleting the uploaded file from storage here
+			// Don't leave an orphaned object behind when the record could not be saved
+			fc.FileStorageService.ReleaseObject(storedObject.Bucket, storedObject.Key)
 			continue
 		}
 
 		createdFiles = append(createdFiles, *createdFile)
 	}
 
+	if len(createdFiles) == 0 && quotaError != nil {
+		ctx.JSON(http.StatusRequestEntityTooLarge, gin.H{
+			"success": false,
+			"error":   "Storage quota exceeded",
+			"quota":   quotaError,
+			"details": uploadErrors,
+		})
+		return
+	}
+
 	if len(createdFiles) == 0 {
 		ctx.JSON(http.StatusInternalServerError, gin.H{
 			"success": false,
@@ -209,6 +276,7 @@ func (fc *FileController) DeleteFile(ctx *gin.Context) {
 			"success": false,
 			"message": "Failed to get the id of the request",
 		})
+		return
 	}
 
 	userId, exists := ctx.Get("userId")
@@ -217,6 +285,7 @@ func (fc *FileController) DeleteFile(ctx *gin.Context) {
 			"success": false,
 			"error":   "Not Authorized",
 		})
+		return
 	}
 
 	userIdStr, ok := userId.(string)
@@ -243,11 +312,12 @@ func (fc *FileController) DeleteFile(ctx *gin.Context) {
 			"success": false,
 			"error":   "User Is Not Verified",
 		})
+		return
 	}
 
 	message, err := fc.FileService.DeleteFile(id, userIdStr)
 	if err != nil {
-		ctx.JSON(http.StatusNotFound, gin.H{
+		ctx.JSON(fileErrorStatus(err), gin.H{
 			"success": false,
 			"error":   err.Error(),
 			"message": message,
@@ -257,7 +327,7 @@ func (fc *FileController) DeleteFile(ctx *gin.Context) {
 
 	ctx.JSON(http.StatusOK, gin.H{
 		"success": true,
-		"message": "File Deleted Successfully",
+		"message": message,
 	})
 
 	return
@@ -272,6 +342,7 @@ func (fc *FileController) UpdateFile(ctx *gin.Context) {
 			"success": false,
 			"message": "Failed to get the id of the request",
 		})
+		return
 	}
 
 	userId, exists := ctx.Get("userId")
@@ -280,6 +351,7 @@ func (fc *FileController) UpdateFile(ctx *gin.Context) {
 			"success": false,
 			"error":   "Not Authorized",
 		})
+		return
 	}
 
 	userIdStr, ok := userId.(string)
@@ -305,6 +377,7 @@ func (fc *FileController) UpdateFile(ctx *gin.Context) {
 			"success": false,
 			"error":   "User Is Not Verified",
 		})
+		return
 	}
 
 	var updateRequest *schema.UpdateFileRequest
@@ -318,7 +391,7 @@ func (fc *FileController) UpdateFile(ctx *gin.Context) {
 
 	updateFile, updateFileError := fc.FileService.UpdateFile(id, userIdStr, updateRequest)
 	if updateFileError != nil {
-		ctx.JSON(http.StatusBadRequest, gin.H{
+		ctx.JSON(fileErrorStatus(updateFileError), gin.H{
 			"success": false,
 			"error":   updateFileError.Error(),
 		})
@@ -334,3 +407,30 @@ func (fc *FileController) UpdateFile(ctx *gin.Context) {
 	return
 
 }
+
+// MoveFile moves one of the caller's files into another of their folders
+func (fc *FileController) MoveFile(ctx *gin.Context) {
+	var moveRequest schema.MoveFileRequest
+	if err := ctx.ShouldBindJSON(&moveRequest); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	file, err := fc.FileService.MoveFile(ctx.Param("id"), ctx.GetString("userId"), moveRequest.FolderId)
+	if err != nil {
+		ctx.JSON(fileErrorStatus(err), gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "File Moved Successfully",
+		"file":    file,
+	})
+}
@@ -0,0 +1,209 @@
+package controllers
+
+import (
+	"goCal/internal/logger"
+	"goCal/internal/schema"
+	"goCal/internal/services"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+type FileAccessController struct {
+	FileService       *services.FileService
+	FileAccessService *services.FileAccessService
+	UserService       *services.UserService
+}
+
+func NewFileAccessController(fileService *services.FileService, fileAccessService *services.FileAccessService, userService *services.UserService) *FileAccessController {
+	return &FileAccessController{
+		FileService:       fileService,
+		FileAccessService: fileAccessService,
+		UserService:       userService,
+	}
+}
+
+// loadFile resolves the logged-in user and the :id file, writing the error response on failure
+func (fac *FileAccessController) loadFile(ctx *gin.Context) (*schema.File, string, bool) {
+	userIdStr := ctx.GetString("userId")
+	if userIdStr == "" {
+		ctx.JSON(http.StatusUnauthorized, gin.H{
+			"success": false,
+			"error":   "Not Authorized",
+		})
+		return nil, "", false
+	}
+
+	file, err := fac.FileService.GetFile(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(fileErrorStatus(err), gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return nil, "", false
+	}
+	return file, userIdStr, true
+}
+
+// loadOwnedFile is loadFile restricted to the file owner
+func (fac *FileAccessController) loadOwnedFile(ctx *gin.Context) (*schema.File, bool) {
+	file, userIdStr, ok := fac.loadFile(ctx)
+	if !ok {
+		return nil, false
+	}
+	if file.UploadedById.String() != userIdStr {
+		ctx.JSON(http.StatusForbidden, gin.H{
+			"success": false,
+			"error":   "Only the file owner can manage access",
+		})
+		return nil, false
+	}
+	return file, true
+}
+
+func (fac *FileAccessController) ListAccess(ctx *gin.Context) {
+	file, ok := fac.loadOwnedFile(ctx)
+	if !ok {
+		return
+	}
+
+	accessList, err := fac.FileAccessService.GetAccessList(file.Id)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"success":     true,
+		"visibility":  file.Visibility,
+		"access_list": accessList,
+	})
+}
+
+func (fac *FileAccessController) GrantAccess(ctx *gin.Context) {
+	file, ok := fac.loadOwnedFile(ctx)
+	if !ok {
+		return
+	}
+
+	var request schema.GrantFileAccessRequest
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	var targetUser *schema.User
+	var err error
+	switch {
+	case request.UserId != nil:
+		targetUser, err = fac.UserService.GetUser(*request.UserId)
+	case request.Email != nil:
+		targetUser, err = fac.UserService.GetUserByEmail(*request.Email)
+	default:
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   "user_id or email is required",
+		})
+		return
+	}
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"success": false,
+			"error":   "User Not Found",
+		})
+		return
+	}
+
+	access, err := fac.FileAccessService.GrantAccess(file, targetUser.ID, request.AccessType)
+	if err != nil {
+		logger.Error("Failed to grant access: %v", err)
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"success":    true,
+		"message":    "Access Granted",
+		"access":     access,
+		"visibility": file.Visibility,
+	})
+}
+
+func (fac *FileAccessController) UpdateAccess(ctx *gin.Context) {
+	file, ok := fac.loadOwnedFile(ctx)
+	if !ok {
+		return
+	}
+
+	var request schema.UpdateFileAccessRequest
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	access, err := fac.FileAccessService.UpdateAccess(file.Id, ctx.Param("userId"), request.AccessType)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Access Updated",
+		"access":  access,
+	})
+}
+
+// RevokeAccess removes a user's access. The owner can revoke anyone and a
+// grantee can remove themselves from a file shared with them.
+func (fac *FileAccessController) RevokeAccess(ctx *gin.Context) {
+	file, userIdStr, ok := fac.loadFile(ctx)
+	if !ok {
+		return
+	}
+
+	targetUserId := ctx.Param("userId")
+	if _, err := uuid.Parse(targetUserId); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   "Invalid user id",
+		})
+		return
+	}
+	if file.UploadedById.String() != userIdStr && targetUserId != userIdStr {
+		ctx.JSON(http.StatusForbidden, gin.H{
+			"success": false,
+			"error":   "Only the file owner can manage access",
+		})
+		return
+	}
+
+	if err := fac.FileAccessService.RevokeAccess(file.Id, targetUserId); err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Access Revoked",
+	})
+}
@@ -0,0 +1,226 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"goCal/internal/logger"
+	"goCal/internal/schema"
+	"goCal/internal/services"
+	"goCal/internal/storage"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var errRangeNotSatisfiable = errors.New("range not satisfiable")
+
+// storedContent describes a blob to stream back to the client
+type storedContent struct {
+	Bucket       string
+	Key          string
+	FileName     string
+	ContentType  string
+	ETag         string
+	LastModified time.Time
+	Public       bool
+	// BeforeBody runs once a body starting at byte start is about to be sent,
+	// never for HEAD, 304 or 416. Returning false means it already answered.
+	BeforeBody func(ctx *gin.Context, start int64) bool
+}
+
+// parseByteRange parses a single "bytes=" range against size. ok is false when
+// the header should be ignored and the whole object served (absent, malformed or
+// multi-range requests).
+func parseByteRange(header string, size int64) (start int64, length int64, ok bool, err error) {
+	if !strings.HasPrefix(header, "bytes=") {
+		return 0, 0, false, nil
+	}
+	spec := strings.TrimSpace(strings.TrimPrefix(header, "bytes="))
+	if strings.Contains(spec, ",") {
+		return 0, 0, false, nil
+	}
+
+	startStr, endStr, found := strings.Cut(spec, "-")
+	if !found {
+		return 0, 0, false, nil
+	}
+	startStr, endStr = strings.TrimSpace(startStr), strings.TrimSpace(endStr)
+
+	if startStr == "" {
+		// Suffix range: the last N bytes
+		suffix, parseErr := strconv.ParseInt(endStr, 10, 64)
+		if parseErr != nil || suffix < 0 {
+			return 0, 0, false, nil
+		}
+		if suffix == 0 || size == 0 {
+			return 0, 0, false, errRangeNotSatisfiable
+		}
+		if suffix > size {
+			suffix = size
+		}
+		return size - suffix, suffix, true, nil
+	}
+
+	start, parseErr := strconv.ParseInt(startStr, 10, 64)
+	if parseErr != nil || start < 0 {
+		return 0, 0, false, nil
+	}
+	if start >= size {
+		return 0, 0, false, errRangeNotSatisfiable
+	}
+
+	end := size - 1
+	if endStr != "" {
+		end, parseErr = strconv.ParseInt(endStr, 10, 64)
+		if parseErr != nil || end < start {
+			return 0, 0, false, nil
+		}
+		if end >= size {
+			end = size - 1
+		}
+	}
+	return start, end - start + 1, true, nil
+}
+
+// etagMatches reports whether an If-None-Match / If-Range value matches etag
+func etagMatches(header string, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// serveStoredContent streams a blob with Range, ETag, If-None-Match and
+// Content-Disposition support. Pass ?download=1 to force an attachment.
+func serveStoredContent(ctx *gin.Context, fileStorageService *services.FileStorageService, content storedContent) {
+	if content.Bucket == "" || content.Key == "" {
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"success": false,
+			"error":   "File content is not available",
+		})
+		return
+	}
+
+	info, err := fileStorageService.Stat(content.Bucket, content.Key)
+	if err != nil {
+		if errors.Is(err, storage.ErrObjectNotFound) {
+			ctx.JSON(http.StatusNotFound, gin.H{
+				"success": false,
+				"error":   "File content is not available",
+			})
+			return
+		}
+		logger.Error("Failed to stat %s/%s: %v", content.Bucket, content.Key, err)
+		ctx.JSON(http.StatusBadGateway, gin.H{
+			"success": false,
+			"error":   "Failed to read file from storage",
+		})
+		return
+	}
+	size := info.Size
+	etag := `"` + content.ETag + `"`
+
+	contentType := content.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	disposition := "inline"
+	if download, _ := strconv.ParseBool(ctx.Query("download")); download {
+		disposition = "attachment"
+	}
+
+	ctx.Header("Accept-Ranges", "bytes")
+	ctx.Header("ETag", etag)
+	ctx.Header("Last-Modified", content.LastModified.UTC().Format(http.TimeFormat))
+	ctx.Header("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": content.FileName}))
+	if content.Public {
+		ctx.Header("Cache-Control", "public, max-age=0, must-revalidate")
+	} else {
+		ctx.Header("Cache-Control", "private, max-age=0, must-revalidate")
+	}
+
+	if ifNoneMatch := ctx.GetHeader("If-None-Match"); ifNoneMatch != "" && etagMatches(ifNoneMatch, etag) {
+		ctx.Status(http.StatusNotModified)
+		return
+	}
+
+	status := http.StatusOK
+	start, length := int64(0), size
+	rangeHeader := ctx.GetHeader("Range")
+	// If-Range: only honour the range when the client's copy is still current
+	if ifRange := ctx.GetHeader("If-Range"); ifRange != "" && !etagMatches(ifRange, etag) {
+		rangeHeader = ""
+	}
+	if rangeHeader != "" {
+		rangeStart, rangeLength, ok, err := parseByteRange(rangeHeader, size)
+		if err != nil {
+			ctx.Header("Content-Range", fmt.Sprintf("bytes */%d", size))
+			ctx.Status(http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+		if ok {
+			status = http.StatusPartialContent
+			start, length = rangeStart, rangeLength
+			ctx.Header("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, start+length-1, size))
+		}
+	}
+
+	if ctx.Request.Method == http.MethodHead {
+		ctx.Header("Content-Type", contentType)
+		ctx.Header("Content-Length", strconv.FormatInt(length, 10))
+		ctx.Status(status)
+		return
+	}
+
+	if content.BeforeBody != nil && !content.BeforeBody(ctx, start) {
+		return
+	}
+
+	var reader io.ReadCloser
+	if status == http.StatusPartialContent {
+		reader, err = fileStorageService.OpenRange(content.Bucket, content.Key, start, length)
+	} else {
+		reader, err = fileStorageService.Open(content.Bucket, content.Key)
+	}
+	if err != nil {
+		logger.Error("Failed to open %s/%s: %v", content.Bucket, content.Key, err)
+		ctx.JSON(http.StatusBadGateway, gin.H{
+			"success": false,
+			"error":   "Failed to read file from storage",
+		})
+		return
+	}
+	defer reader.Close()
+
+	ctx.DataFromReader(status, length, contentType, reader, nil)
+}
+
+// GetFileContent streams a file the caller is allowed to view
+func (fc *FileController) GetFileContent(ctx *gin.Context) {
+	file, err := fc.FileService.GetVisibleFile(ctx.Param("id"), ctx.GetString("userId"))
+	if err != nil {
+		ctx.JSON(fileErrorStatus(err), gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	serveStoredContent(ctx, fc.FileStorageService, storedContent{
+		Bucket:       file.StorageBucket,
+		Key:          file.StorageKey,
+		FileName:     file.FileName,
+		ContentType:  file.FileType,
+		ETag:         fmt.Sprintf("%s-%x", file.Id, file.UpdatedAt.UnixNano()),
+		LastModified: file.UpdatedAt,
+		Public:       file.Visibility == schema.Public,
+	})
+}
@@ -0,0 +1,101 @@
+package controllers
+
+import (
+	"goCal/internal/services"
+	"goCal/internal/storage"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+	os.Exit(m.Run())
+}
+
+// serveSample answers a request for a 26 byte object and reports the start
+// offsets BeforeBody was called with
+func serveSample(t *testing.T, request *http.Request, answer bool) (*httptest.ResponseRecorder, []int64) {
+	t.Helper()
+	backend, err := storage.NewLocalBackend(t.TempDir(), "http://localhost", "test-signing-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := backend.EnsureBucket("files"); err != nil {
+		t.Fatal(err)
+	}
+	if err := backend.Put("files", "sample", strings.NewReader("abcdefghijklmnopqrstuvwxyz"), "text/plain"); err != nil {
+		t.Fatal(err)
+	}
+
+	var starts []int64
+	recorder := httptest.NewRecorder()
+	ctx, _ := gin.CreateTestContext(recorder)
+	ctx.Request = request
+	serveStoredContent(ctx, services.NewFileStorageService(backend), storedContent{
+		Bucket:       "files",
+		Key:          "sample",
+		FileName:     "sample.txt",
+		ContentType:  "text/plain",
+		ETag:         "v1",
+		LastModified: time.Unix(0, 0),
+		BeforeBody: func(ctx *gin.Context, start int64) bool {
+			starts = append(starts, start)
+			if !answer {
+				ctx.JSON(http.StatusGone, gin.H{"success": false})
+			}
+			return answer
+		},
+	})
+	// The engine flushes a bare status once the handler returns
+	ctx.Writer.WriteHeaderNow()
+	return recorder, starts
+}
+
+func TestServeStoredContentBeforeBody(t *testing.T) {
+	tests := []struct {
+		name       string
+		method     string
+		headers    map[string]string
+		wantStatus int
+		wantBody   string
+		wantStarts []int64
+	}{
+		{"whole file", http.MethodGet, nil, http.StatusOK, "abcdefghijklmnopqrstuvwxyz", []int64{0}},
+		{"range from the first byte", http.MethodGet, map[string]string{"Range": "bytes=0-9"}, http.StatusPartialContent, "abcdefghij", []int64{0}},
+		{"resumed range", http.MethodGet, map[string]string{"Range": "bytes=10-"}, http.StatusPartialContent, "klmnopqrstuvwxyz", []int64{10}},
+		{"suffix range", http.MethodGet, map[string]string{"Range": "bytes=-6"}, http.StatusPartialContent, "uvwxyz", []int64{20}},
+		{"stale If-Range sends the whole file", http.MethodGet, map[string]string{"Range": "bytes=10-", "If-Range": `"v0"`}, http.StatusOK, "abcdefghijklmnopqrstuvwxyz", []int64{0}},
+		{"not modified", http.MethodGet, map[string]string{"If-None-Match": `"v1"`}, http.StatusNotModified, "", nil},
+		{"unsatisfiable range", http.MethodGet, map[string]string{"Range": "bytes=30-"}, http.StatusRequestedRangeNotSatisfiable, "", nil},
+		{"head", http.MethodHead, nil, http.StatusOK, "", nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request := httptest.NewRequest(test.method, "/content", nil)
+			for name, value := range test.headers {
+				request.Header.Set(name, value)
+			}
+			recorder, starts := serveSample(t, request, true)
+			if recorder.Code != test.wantStatus || recorder.Body.String() != test.wantBody {
+				t.Errorf("got %d %q, want %d %q", recorder.Code, recorder.Body.String(), test.wantStatus, test.wantBody)
+			}
+			if !slices.Equal(starts, test.wantStarts) {
+				t.Errorf("BeforeBody starts = %v, want %v", starts, test.wantStarts)
+			}
+		})
+	}
+}
+
+func TestServeStoredContentBeforeBodyAnswers(t *testing.T) {
+	recorder, _ := serveSample(t, httptest.NewRequest(http.MethodGet, "/content", nil), false)
+	if recorder.Code != http.StatusGone || strings.Contains(recorder.Body.String(), "abc") {
+		t.Fatalf("got %d %q, want only the BeforeBody answer", recorder.Code, recorder.Body.String())
+	}
+}
@@ -0,0 +1,188 @@
+package controllers
+
+import (
+	"goCal/internal/logger"
+	"goCal/internal/schema"
+	"goCal/internal/services"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type FileVersionController struct {
+	FileService        *services.FileService
+	FileVersionService *services.FileVersionService
+	FileStorageService *services.FileStorageService
+	QuotaService       *services.QuotaService
+}
+
+func NewFileVersionController(fileService *services.FileService, fileVersionService *services.FileVersionService, fileStorageService *services.FileStorageService, quotaService *services.QuotaService) *FileVersionController {
+	return &FileVersionController{
+		FileService:        fileService,
+		FileVersionService: fileVersionService,
+		FileStorageService: fileStorageService,
+		QuotaService:       quotaService,
+	}
+}
+
+// loadVisibleFile resolves the :id file when the caller may view it
+func (fvc *FileVersionController) loadVisibleFile(ctx *gin.Context) (*schema.File, bool) {
+	file, err := fvc.FileService.GetVisibleFile(ctx.Param("id"), ctx.GetString("userId"))
+	if err != nil {
+		ctx.JSON(fileErrorStatus(err), gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return nil, false
+	}
+	return file, true
+}
+
+// UploadVersion replaces the file's content with the uploaded "file" and keeps
+// the previous content in the history
+func (fvc *FileVersionController) UploadVersion(ctx *gin.Context) {
+	userIdStr := ctx.GetString("userId")
+
+	file, ok := fvc.loadVisibleFile(ctx)
+	if !ok {
+		return
+	}
+	if err := fvc.FileVersionService.CheckCanEdit(file, userIdStr); err != nil {
+		ctx.JSON(fileErrorStatus(err), gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	fileHeader, err := ctx.FormFile("file")
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   "No file uploaded",
+		})
+		return
+	}
+
+	// The owner pays for every version, whoever uploads it
+	ownerId := file.UploadedById.String()
+	if errQuota := fvc.QuotaService.CheckQuota(ownerId, fileHeader.Size); errQuota != nil {
+		ctx.JSON(fileErrorStatus(errQuota), gin.H{
+			"success": false,
+			"error":   errQuota.Error(),
+			"quota":   errQuota,
+		})
+		return
+	}
+
+	src, err := fileHeader.Open()
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   "Failed to read uploaded file",
+		})
+		return
+	}
+	defer src.Close()
+
+	fileType := fileHeader.Header.Get("Content-Type")
+	storedObject, err := fvc.FileStorageService.UploadFile(ownerId, file.FileName, src, fileType)
+	if err != nil {
+		logger.Error("Failed to upload new version of %s: %v", file.Id, err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	updatedFile, err := fvc.FileVersionService.AddVersion(file, userIdStr, storedObject, fileType)
+	if err != nil {
+		fvc.FileStorageService.ReleaseObject(storedObject.Bucket, storedObject.Key)
+		ctx.JSON(fileErrorStatus(err), gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, gin.H{
+		"success": true,
+		"message": "New Version Uploaded",
+		"file":    updatedFile,
+	})
+}
+
+func (fvc *FileVersionController) GetVersions(ctx *gin.Context) {
+	file, ok := fvc.loadVisibleFile(ctx)
+	if !ok {
+		return
+	}
+
+	versions, err := fvc.FileVersionService.GetVersions(file.Id)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"success":         true,
+		"current_version": file.Version,
+		"versions":        versions,
+	})
+}
+
+// GetVersionContent streams an earlier version, with the same Range and
+// caching support as the current content
+func (fvc *FileVersionController) GetVersionContent(ctx *gin.Context) {
+	file, ok := fvc.loadVisibleFile(ctx)
+	if !ok {
+		return
+	}
+
+	version, err := fvc.FileVersionService.GetVersion(file.Id, ctx.Param("versionId"))
+	if err != nil {
+		ctx.JSON(fileErrorStatus(err), gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	// Versions never change, so their id is a stable ETag
+	serveStoredContent(ctx, fvc.FileStorageService, storedContent{
+		Bucket:       version.StorageBucket,
+		Key:          version.StorageKey,
+		FileName:     file.FileName,
+		ContentType:  version.FileType,
+		ETag:         version.ID.String(),
+		LastModified: version.CreatedAt,
+		Public:       file.Visibility == schema.Public,
+	})
+}
+
+// RestoreVersion makes an earlier version the current content again
+func (fvc *FileVersionController) RestoreVersion(ctx *gin.Context) {
+	file, ok := fvc.loadVisibleFile(ctx)
+	if !ok {
+		return
+	}
+
+	restoredFile, err := fvc.FileVersionService.RestoreVersion(file, ctx.Param("versionId"), ctx.GetString("userId"))
+	if err != nil {
+		ctx.JSON(fileErrorStatus(err), gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Version Restored Successfully",
+		"file":    restoredFile,
+	})
+}
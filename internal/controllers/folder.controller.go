@@ -1,6 +1,7 @@
 package controllers
 
 import (
+	"errors"
 	"goCal/internal/logger"
 	"goCal/internal/schema"
 	"goCal/internal/services"
@@ -23,20 +24,40 @@ func NewFolderController(folderService *services.FolderService, userService *ser
 	}
 }
 
+// folderErrorStatus maps folder service errors onto HTTP status codes
+func folderErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, services.ErrFolderNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, services.ErrFolderAccessDenied):
+		return http.StatusForbidden
+	case errors.Is(err, services.ErrFolderNameTaken), errors.Is(err, services.ErrFolderCycle):
+		return http.StatusConflict
+	default:
+		return http.StatusBadRequest
+	}
+}
+
 func (fo *FolderController) GetAllFolders(ctx *gin.Context) {
-	folders, err := fo.FolderService.GetFolders()
+	var query schema.FolderListQuery
+	if !bindListQuery(ctx, &query) {
+		return
+	}
+
+	folders, page, err := fo.FolderService.GetFolders(&query)
 	if err != nil {
 		logger.Error("Failed to get all folders %v ", err)
-		ctx.JSON(http.StatusBadRequest, gin.H{
+		ctx.JSON(listErrorStatus(err), gin.H{
 			"success": false,
-			"error":   err,
+			"error":   err.Error(),
 		})
 		return
 	}
 
 	ctx.JSON(http.StatusOK, gin.H{
-		"success": true,
-		"folders": folders,
+		"success":    true,
+		"folders":    folders,
+		"pagination": page,
 	})
 	return
 }
@@ -49,13 +70,14 @@ func (fo *FolderController) GetFolder(ctx *gin.Context) {
 			"success": false,
 			"error":   "Folder Id Not Provided",
 		})
+		return
 	}
 	folderFound, err := fo.FolderService.GetFolder(id)
 	if err != nil {
 		logger.Error("Failed to get the folder\n")
-		ctx.JSON(http.StatusBadRequest, gin.H{
+		ctx.JSON(folderErrorStatus(err), gin.H{
 			"success": false,
-			"error":   err,
+			"error":   err.Error(),
 			"message": "Failed to get the folder",
 		})
 		return
@@ -76,6 +98,7 @@ func (fo *FolderController) CreateFolder(ctx *gin.Context) {
 			"success": false,
 			"error":   "Not Authorized",
 		})
+		return
 	}
 
 	userIdStr, ok := userId.(string)
@@ -113,23 +136,24 @@ func (fo *FolderController) CreateFolder(ctx *gin.Context) {
 			"success": false,
 			"error":   "User Is Not Verified",
 		})
+		return
 	}
 
 	folder, error := fo.FolderService.CreateFolder(newFolder, userIdStr)
 
 	if error != nil {
 		logger.Error("Error creating folder %v\n", error.Error())
-		ctx.JSON(http.StatusNotFound, gin.H{
+		ctx.JSON(folderErrorStatus(error), gin.H{
 			"success": false,
 			"error":   error.Error(),
 		})
 		return
 	}
 
-	ctx.JSON(http.StatusOK, gin.H{
+	ctx.JSON(http.StatusCreated, gin.H{
 		"success": true,
 		"message": "Folder Created",
-		"file":    folder,
+		"folder":  folder,
 	})
 
 	return
@@ -144,6 +168,7 @@ func (fo *FolderController) DeleteFolder(ctx *gin.Context) {
 			"success": false,
 			"message": "Failed to get the id of the request",
 		})
+		return
 	}
 
 	userId, exists := ctx.Get("userId")
@@ -153,6 +178,7 @@ func (fo *FolderController) DeleteFolder(ctx *gin.Context) {
 			"success": false,
 			"error":   "Not Authorized",
 		})
+		return
 	}
 
 	userIdStr, ok := userId.(string)
@@ -180,13 +206,14 @@ func (fo *FolderController) DeleteFolder(ctx *gin.Context) {
 			"success": false,
 			"error":   "User Is Not Verified",
 		})
+		return
 	}
 
 	message, error := fo.FolderService.DeleteFolder(id, userIdStr)
 
 	if error != nil {
 		logger.Error("Error deleting folder %v\n", error.Error())
-		ctx.JSON(http.StatusNotFound, gin.H{
+		ctx.JSON(folderErrorStatus(error), gin.H{
 			"success": false,
 			"error":   error.Error(),
 			"message": message,
@@ -210,6 +237,7 @@ func (fo *FolderController) UpdateFolder(ctx *gin.Context) {
 			"success": false,
 			"message": "Failed to get the id of the request",
 		})
+		return
 	}
 
 	userId, exists := ctx.Get("userId")
@@ -219,6 +247,7 @@ func (fo *FolderController) UpdateFolder(ctx *gin.Context) {
 			"success": false,
 			"error":   "Not Authorized",
 		})
+		return
 	}
 
 	userIdStr, ok := userId.(string)
@@ -246,6 +275,7 @@ func (fo *FolderController) UpdateFolder(ctx *gin.Context) {
 			"success": false,
 			"error":   "User Is Not Verified",
 		})
+		return
 	}
 
 	var updateRequest *schema.UpdateFolderRequest
@@ -261,7 +291,7 @@ func (fo *FolderController) UpdateFolder(ctx *gin.Context) {
 
 	if error != nil {
 		logger.Error("Error updating folder %v\n", error.Error())
-		ctx.JSON(http.StatusNotFound, gin.H{
+		ctx.JSON(folderErrorStatus(error), gin.H{
 			"success": false,
 			"error":   error.Error(),
 		})
@@ -275,3 +305,101 @@ func (fo *FolderController) UpdateFolder(ctx *gin.Context) {
 	return
 
 }
+
+// GetFolderContents lists the direct subfolders and files of one of the caller's folders
+func (fo *FolderController) GetFolderContents(ctx *gin.Context) {
+	folder, err := fo.FolderService.GetFolderContents(ctx.Param("id"), ctx.GetString("userId"))
+	if err != nil {
+		ctx.JSON(folderErrorStatus(err), gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"folder":  folder,
+	})
+}
+
+// GetBreadcrumbs returns the folders from the root down to the requested one
+func (fo *FolderController) GetBreadcrumbs(ctx *gin.Context) {
+	breadcrumbs, err := fo.FolderService.GetBreadcrumbs(ctx.Param("id"), ctx.GetString("userId"))
+	if err != nil {
+		ctx.JSON(folderErrorStatus(err), gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"success":     true,
+		"breadcrumbs": breadcrumbs,
+	})
+}
+
+// GetFolderByPath resolves ?path=/a/b against the caller's folder tree
+func (fo *FolderController) GetFolderByPath(ctx *gin.Context) {
+	path := ctx.Query("path")
+	if path == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   "path query parameter is required",
+		})
+		return
+	}
+
+	userIdStr := ctx.GetString("userId")
+	folder, err := fo.FolderService.ResolvePath(path, userIdStr)
+	if err != nil {
+		ctx.JSON(folderErrorStatus(err), gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	breadcrumbs, err := fo.FolderService.GetBreadcrumbs(folder.ID.String(), userIdStr)
+	if err != nil {
+		ctx.JSON(folderErrorStatus(err), gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"success":     true,
+		"folder":      folder,
+		"breadcrumbs": breadcrumbs,
+	})
+}
+
+// MoveFolder re-parents one of the caller's folders
+func (fo *FolderController) MoveFolder(ctx *gin.Context) {
+	var moveRequest schema.MoveFolderRequest
+	if err := ctx.ShouldBindJSON(&moveRequest); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	folder, err := fo.FolderService.MoveFolder(ctx.Param("id"), ctx.GetString("userId"), moveRequest.ParentId)
+	if err != nil {
+		ctx.JSON(folderErrorStatus(err), gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Folder Moved Successfully",
+		"folder":  folder,
+	})
+}
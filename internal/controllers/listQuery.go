@@ -0,0 +1,38 @@
+package controllers
+
+import (
+	"errors"
+	"goCal/internal/pagination"
+	"goCal/internal/services"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// bindListQuery binds the pagination and filter parameters of a list
+// endpoint, answering 400 itself when they are malformed
+func bindListQuery(ctx *gin.Context, query any) bool {
+	if err := ctx.ShouldBindQuery(query); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return false
+	}
+	return true
+}
+
+// isListQueryError reports whether err came from bad list parameters
+func isListQueryError(err error) bool {
+	return errors.Is(err, pagination.ErrInvalidCursor) ||
+		errors.Is(err, pagination.ErrInvalidSort) ||
+		errors.Is(err, services.ErrInvalidListFilter)
+}
+
+// listErrorStatus maps errors from a list query onto HTTP status codes
+func listErrorStatus(err error) int {
+	if isListQueryError(err) {
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,115 @@
+package controllers
+
+import (
+	"errors"
+	"goCal/internal/services"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type OidcController struct {
+	OidcService *services.OidcService
+	AuthService *services.AuthService
+}
+
+func NewOidcController(oidcService *services.OidcService, authService *services.AuthService) *OidcController {
+	return &OidcController{
+		OidcService: oidcService,
+		AuthService: authService,
+	}
+}
+
+// oidcErrorStatus maps OIDC login errors onto HTTP status codes
+func oidcErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, services.ErrOidcNotConfigured):
+		return http.StatusNotFound
+	case errors.Is(err, services.ErrOidcStateInvalid), errors.Is(err, services.ErrOidcTokenInvalid), errors.Is(err, services.ErrOidcEmailUnverified):
+		return http.StatusUnauthorized
+	case errors.Is(err, services.ErrOidcAccountDeleted), errors.Is(err, services.ErrOidcAccountUnverified):
+		return http.StatusForbidden
+	default:
+		return http.StatusBadGateway
+	}
+}
+
+// Login redirects the browser to the identity provider
+func (oc *OidcController) Login(ctx *gin.Context) {
+	authorizationUrl, err := oc.OidcService.AuthorizationURL()
+	if err != nil {
+		ctx.JSON(oidcErrorStatus(err), gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+	ctx.Redirect(http.StatusFound, authorizationUrl)
+}
+
+// Callback is where the identity provider sends the browser back. It answers
+// like LoginUser does, including the 2FA challenge.
+func (oc *OidcController) Callback(ctx *gin.Context) {
+	if providerError := ctx.Query("error"); providerError != "" {
+		ctx.JSON(http.StatusUnauthorized, gin.H{
+			"success": false,
+			"error":   providerError + ": " + ctx.Query("error_description"),
+		})
+		return
+	}
+
+	state, code := ctx.Query("state"), ctx.Query("code")
+	if state == "" || code == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   "state and code are required",
+		})
+		return
+	}
+
+	user, err := oc.OidcService.HandleCallback(state, code)
+	if err != nil {
+		ctx.JSON(oidcErrorStatus(err), gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	if user.TwoFactorEnabled {
+		challenge, expiresAt, err := oc.AuthService.IssueTwoFactorChallenge(user)
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"success": false,
+				"error":   err.Error(),
+			})
+			return
+		}
+		ctx.JSON(http.StatusOK, gin.H{
+			"success":             true,
+			"two_factor_required": true,
+			"challenge_token":     challenge,
+			"expires_at":          expiresAt,
+		})
+		return
+	}
+
+	tokens, err := oc.AuthService.IssueTokens(user, ctx.Request.UserAgent(), ctx.ClientIP())
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"success":       true,
+		"token":         tokens.AccessToken,
+		"refresh_token": tokens.RefreshToken,
+		"expires_at":    tokens.ExpiresAt,
+		"session_id":    tokens.SessionId,
+		"email":         user.Email,
+		"id":            user.ID,
+	})
+}
@@ -0,0 +1,59 @@
+package controllers
+
+import (
+	"errors"
+	"goCal/internal/middleware"
+	"goCal/internal/schema"
+	"goCal/internal/services"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type SearchController struct {
+	SearchService *services.SearchService
+}
+
+func NewSearchController(searchService *services.SearchService) *SearchController {
+	return &SearchController{
+		SearchService: searchService,
+	}
+}
+
+// Search looks through the names of files and the names, descriptions and
+// tags of folders. API keys only see the kinds of items their scopes cover.
+func (sc *SearchController) Search(ctx *gin.Context) {
+	var query schema.SearchQuery
+	if !bindListQuery(ctx, &query) {
+		return
+	}
+
+	includeFiles := middleware.HasScope(ctx, schema.ScopeFilesRead)
+	includeFolders := middleware.HasScope(ctx, schema.ScopeFoldersRead)
+	if !includeFiles && !includeFolders {
+		ctx.JSON(http.StatusForbidden, gin.H{
+			"success": false,
+			"error":   "API key needs the " + schema.ScopeFilesRead + " or " + schema.ScopeFoldersRead + " scope",
+		})
+		return
+	}
+
+	results, page, err := sc.SearchService.Search(ctx.GetString("userId"), &query, includeFiles, includeFolders)
+	if err != nil {
+		status := listErrorStatus(err)
+		if errors.Is(err, services.ErrSearchQueryEmpty) {
+			status = http.StatusBadRequest
+		}
+		ctx.JSON(status, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"success":    true,
+		"results":    results,
+		"pagination": page,
+	})
+}
@@ -0,0 +1,252 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"goCal/internal/logger"
+	"goCal/internal/pagination"
+	"goCal/internal/schema"
+	"goCal/internal/services"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type ShareLinkController struct {
+	FileService        *services.FileService
+	ShareLinkService   *services.ShareLinkService
+	FileStorageService *services.FileStorageService
+}
+
+func NewShareLinkController(fileService *services.FileService, shareLinkService *services.ShareLinkService, fileStorageService *services.FileStorageService) *ShareLinkController {
+	return &ShareLinkController{
+		FileService:        fileService,
+		ShareLinkService:   shareLinkService,
+		FileStorageService: fileStorageService,
+	}
+}
+
+// shareLinkResponse adds the public token and url to a link
+type shareLinkResponse struct {
+	*schema.ShareLink
+	Token string `json:"token"`
+	Url   string `json:"url"`
+}
+
+func (slc *ShareLinkController) toResponse(link *schema.ShareLink) shareLinkResponse {
+	token := slc.ShareLinkService.Token(link)
+	return shareLinkResponse{
+		ShareLink: link,
+		Token:     token,
+		Url:       "/api/share/" + token,
+	}
+}
+
+// shareLinkErrorStatus maps share link errors onto HTTP status codes
+func shareLinkErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, services.ErrShareLinkInvalid):
+		return http.StatusNotFound
+	case errors.Is(err, services.ErrShareLinkExpired), errors.Is(err, services.ErrShareLinkRevoked), errors.Is(err, services.ErrShareLinkExhausted):
+		return http.StatusGone
+	case errors.Is(err, services.ErrShareLinkPasswordRequired), errors.Is(err, services.ErrShareLinkPasswordInvalid):
+		return http.StatusUnauthorized
+	default:
+		return http.StatusBadRequest
+	}
+}
+
+// loadOwnedFile resolves the :id file, which only its owner may share
+func (slc *ShareLinkController) loadOwnedFile(ctx *gin.Context) (*schema.File, bool) {
+	file, err := slc.FileService.GetUserFile(ctx.Param("id"), ctx.GetString("userId"))
+	if err != nil {
+		ctx.JSON(fileErrorStatus(err), gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return nil, false
+	}
+	return file, true
+}
+
+func (slc *ShareLinkController) CreateShareLink(ctx *gin.Context) {
+	file, ok := slc.loadOwnedFile(ctx)
+	if !ok {
+		return
+	}
+
+	var request schema.CreateShareLinkRequest
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	link, err := slc.ShareLinkService.CreateShareLink(file, ctx.GetString("userId"), &request)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, gin.H{
+		"success":    true,
+		"message":    "Share Link Created",
+		"share_link": slc.toResponse(link),
+	})
+}
+
+func (slc *ShareLinkController) GetShareLinks(ctx *gin.Context) {
+	file, ok := slc.loadOwnedFile(ctx)
+	if !ok {
+		return
+	}
+
+	links, err := slc.ShareLinkService.GetShareLinks(file.Id)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	responses := make([]shareLinkResponse, 0, len(links))
+	for _, link := range links {
+		responses = append(responses, slc.toResponse(link))
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"success":     true,
+		"share_links": responses,
+	})
+}
+
+func (slc *ShareLinkController) RevokeShareLink(ctx *gin.Context) {
+	file, ok := slc.loadOwnedFile(ctx)
+	if !ok {
+		return
+	}
+
+	link, err := slc.ShareLinkService.RevokeShareLink(file.Id, ctx.Param("linkId"))
+	if err != nil {
+		ctx.JSON(shareLinkErrorStatus(err), gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"success":    true,
+		"message":    "Share Link Revoked",
+		"share_link": link,
+	})
+}
+
+func (slc *ShareLinkController) GetShareLinkDownloads(ctx *gin.Context) {
+	file, ok := slc.loadOwnedFile(ctx)
+	if !ok {
+		return
+	}
+
+	var params pagination.Params
+	if !bindListQuery(ctx, &params) {
+		return
+	}
+
+	downloads, page, err := slc.ShareLinkService.GetShareLinkDownloads(file.Id, ctx.Param("linkId"), params)
+	if err != nil {
+		status := shareLinkErrorStatus(err)
+		if isListQueryError(err) {
+			status = http.StatusBadRequest
+		}
+		ctx.JSON(status, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"success":    true,
+		"downloads":  downloads,
+		"pagination": page,
+	})
+}
+
+// resolveLink validates the :token param. The password is only taken from the
+// X-Share-Password header, query strings end up in logs and Referer headers.
+func (slc *ShareLinkController) resolveLink(ctx *gin.Context) (*schema.ShareLink, bool) {
+	link, err := slc.ShareLinkService.ResolveToken(ctx.Param("token"), ctx.GetHeader("X-Share-Password"))
+	if errors.Is(err, services.ErrTooManyAttempts) {
+		respondThrottled(ctx, err)
+		return nil, false
+	}
+	if err != nil {
+		ctx.JSON(shareLinkErrorStatus(err), gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return nil, false
+	}
+	return link, true
+}
+
+// GetSharedFileInfo describes the shared file without counting a download
+func (slc *ShareLinkController) GetSharedFileInfo(ctx *gin.Context) {
+	link, ok := slc.resolveLink(ctx)
+	if !ok {
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"file": gin.H{
+			"file_name": link.File.FileName,
+			"file_type": link.File.FileType,
+			"file_size": link.File.FileSize,
+		},
+		"expires_at":     link.ExpiresAt,
+		"max_downloads":  link.MaxDownloads,
+		"download_count": link.DownloadCount,
+	})
+}
+
+// DownloadSharedFile streams the shared file. A download is counted when the
+// body starts at the first byte, so resuming with a later range, HEAD and 304
+// answers are free.
+func (slc *ShareLinkController) DownloadSharedFile(ctx *gin.Context) {
+	link, ok := slc.resolveLink(ctx)
+	if !ok {
+		return
+	}
+
+	file := link.File
+	serveStoredContent(ctx, slc.FileStorageService, storedContent{
+		Bucket:       file.StorageBucket,
+		Key:          file.StorageKey,
+		FileName:     file.FileName,
+		ContentType:  file.FileType,
+		ETag:         fmt.Sprintf("%s-%x", file.Id, file.UpdatedAt.UnixNano()),
+		LastModified: file.UpdatedAt,
+		BeforeBody: func(ctx *gin.Context, start int64) bool {
+			if start > 0 {
+				return true
+			}
+			if err := slc.ShareLinkService.RecordDownload(link, ctx.ClientIP(), ctx.Request.UserAgent()); err != nil {
+				logger.Error("Failed to record share link download %s: %v", link.ID, err)
+				ctx.JSON(shareLinkErrorStatus(err), gin.H{
+					"success": false,
+					"error":   err.Error(),
+				})
+				return false
+			}
+			return true
+		},
+	})
+}
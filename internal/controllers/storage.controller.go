@@ -0,0 +1,83 @@
+package controllers
+
+import (
+	"errors"
+	"goCal/internal/logger"
+	"goCal/internal/storage"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+type StorageController struct {
+	StorageBackend storage.StorageBackend
+}
+
+func NewStorageController(storageBackend storage.StorageBackend) *StorageController {
+	return &StorageController{
+		StorageBackend: storageBackend,
+	}
+}
+
+// GetObject streams an object from the local storage driver for a presigned URL.
+// Remote drivers such as Supabase sign their own URLs so this returns 404 for them.
+func (sc *StorageController) GetObject(ctx *gin.Context) {
+	localBackend, ok := sc.StorageBackend.(*storage.LocalBackend)
+	if !ok {
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"success": false,
+			"error":   "Storage route is only available for the local driver",
+		})
+		return
+	}
+
+	bucket := ctx.Param("bucket")
+	key := strings.TrimPrefix(ctx.Param("key"), "/")
+
+	expires, err := strconv.ParseInt(ctx.Query("expires"), 10, 64)
+	if err != nil || !localBackend.VerifySignature(bucket, key, expires, ctx.Query("signature")) {
+		ctx.JSON(http.StatusForbidden, gin.H{
+			"success": false,
+			"error":   "Invalid or expired signature",
+		})
+		return
+	}
+
+	info, err := localBackend.Stat(bucket, key)
+	if err != nil {
+		if errors.Is(err, storage.ErrObjectNotFound) {
+			ctx.JSON(http.StatusNotFound, gin.H{
+				"success": false,
+				"error":   "Object Not Found",
+			})
+			return
+		}
+		logger.Error("Failed to stat object %s/%s: %v", bucket, key, err)
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	reader, err := localBackend.Get(bucket, key)
+	if err != nil {
+		logger.Error("Failed to open object %s/%s: %v", bucket, key, err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"error":   "Failed to read object",
+		})
+		return
+	}
+	defer reader.Close()
+
+	ctx.Header("ETag", `"`+info.ETag+`"`)
+	if seeker, ok := reader.(io.ReadSeeker); ok {
+		http.ServeContent(ctx.Writer, ctx.Request, key, info.LastModified, seeker)
+		return
+	}
+	ctx.DataFromReader(http.StatusOK, info.Size, info.ContentType, reader, nil)
+}
@@ -0,0 +1,93 @@
+package controllers
+
+import (
+	"errors"
+	"goCal/internal/logger"
+	"goCal/internal/schema"
+	"goCal/internal/services"
+	"goCal/internal/thumbnail"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+type ThumbnailController struct {
+	FileService        *services.FileService
+	ThumbnailService   *services.ThumbnailService
+	FileStorageService *services.FileStorageService
+}
+
+func NewThumbnailController(fileService *services.FileService, thumbnailService *services.ThumbnailService, fileStorageService *services.FileStorageService) *ThumbnailController {
+	return &ThumbnailController{
+		FileService:        fileService,
+		ThumbnailService:   thumbnailService,
+		FileStorageService: fileStorageService,
+	}
+}
+
+func thumbnailErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, services.ErrInvalidThumbnailSize):
+		return http.StatusBadRequest
+	case errors.Is(err, services.ErrThumbnailUnavailable):
+		return http.StatusNotFound
+	case errors.Is(err, services.ErrThumbnailFailed):
+		return http.StatusUnprocessableEntity
+	default:
+		return http.StatusBadGateway
+	}
+}
+
+// thumbnailRetryAfter is how long clients are asked to wait for thumbnails
+// that are being generated
+const thumbnailRetryAfter = "5"
+
+// GetFileThumbnail serves a JPEG preview of an image the caller may view.
+// ?size= picks small, medium (the default) or large. Missing previews are
+// only generated during the request for the file's owner, and never for
+// HEAD, everyone else gets 202 until the generator has made them.
+func (tc *ThumbnailController) GetFileThumbnail(ctx *gin.Context) {
+	file, err := tc.FileService.GetVisibleFile(ctx.Param("id"), ctx.GetString("userId"))
+	if err != nil {
+		ctx.JSON(fileErrorStatus(err), gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	generateMissing := ctx.Request.Method == http.MethodGet && file.UploadedById.String() == ctx.GetString("userId")
+	thumb, err := tc.ThumbnailService.GetThumbnail(file, ctx.Query("size"), generateMissing)
+	if errors.Is(err, services.ErrThumbnailPending) {
+		ctx.Header("Retry-After", thumbnailRetryAfter)
+		ctx.JSON(http.StatusAccepted, gin.H{
+			"success": true,
+			"status":  schema.ThumbnailStatusPending,
+			"message": err.Error(),
+		})
+		return
+	}
+	if err != nil {
+		status := thumbnailErrorStatus(err)
+		if status == http.StatusBadGateway {
+			logger.Error("Failed to get the thumbnail of file %s: %v", file.Id, err)
+		}
+		ctx.JSON(status, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	serveStoredContent(ctx, tc.FileStorageService, storedContent{
+		Bucket:       thumb.Bucket,
+		Key:          thumb.Key,
+		FileName:     strings.TrimSuffix(file.FileName, path.Ext(file.FileName)) + "-" + thumb.Size + ".jpg",
+		ContentType:  thumbnail.ContentType,
+		ETag:         thumb.ContentHash + "-" + thumb.Size,
+		LastModified: thumb.GeneratedAt,
+		Public:       file.Visibility == schema.Public,
+	})
+}
@@ -0,0 +1,127 @@
+package controllers
+
+import (
+	"errors"
+	"goCal/internal/services"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type TrashController struct {
+	TrashService *services.TrashService
+}
+
+func NewTrashController(trashService *services.TrashService) *TrashController {
+	return &TrashController{
+		TrashService: trashService,
+	}
+}
+
+// trashErrorStatus maps trash service errors onto HTTP status codes
+func trashErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, services.ErrTrashItemNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, services.ErrFileNameTaken), errors.Is(err, services.ErrFolderNameTaken):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func (tc *TrashController) GetTrash(ctx *gin.Context) {
+	trash, err := tc.TrashService.GetTrash(ctx.GetString("userId"))
+	if err != nil {
+		ctx.JSON(trashErrorStatus(err), gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"files":   trash.Files,
+		"folders": trash.Folders,
+	})
+}
+
+func (tc *TrashController) RestoreFile(ctx *gin.Context) {
+	file, err := tc.TrashService.RestoreFile(ctx.Param("id"), ctx.GetString("userId"))
+	if err != nil {
+		ctx.JSON(trashErrorStatus(err), gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "File Restored Successfully",
+		"file":    file,
+	})
+}
+
+func (tc *TrashController) RestoreFolder(ctx *gin.Context) {
+	folder, err := tc.TrashService.RestoreFolder(ctx.Param("id"), ctx.GetString("userId"))
+	if err != nil {
+		ctx.JSON(trashErrorStatus(err), gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Folder Restored Successfully",
+		"folder":  folder,
+	})
+}
+
+func (tc *TrashController) DeleteFilePermanently(ctx *gin.Context) {
+	if err := tc.TrashService.DeleteFilePermanently(ctx.Param("id"), ctx.GetString("userId")); err != nil {
+		ctx.JSON(trashErrorStatus(err), gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "File Deleted Permanently",
+	})
+}
+
+func (tc *TrashController) DeleteFolderPermanently(ctx *gin.Context) {
+	if err := tc.TrashService.DeleteFolderPermanently(ctx.Param("id"), ctx.GetString("userId")); err != nil {
+		ctx.JSON(trashErrorStatus(err), gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Folder Deleted Permanently",
+	})
+}
+
+func (tc *TrashController) EmptyTrash(ctx *gin.Context) {
+	if err := tc.TrashService.EmptyTrash(ctx.GetString("userId")); err != nil {
+		ctx.JSON(trashErrorStatus(err), gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Trash Emptied",
+	})
+}
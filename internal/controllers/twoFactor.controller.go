@@ -0,0 +1,220 @@
+package controllers
+
+import (
+	"errors"
+	"goCal/internal/schema"
+	"goCal/internal/services"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type TwoFactorController struct {
+	TwoFactorService     *services.TwoFactorService
+	UserService          *services.UserService
+	AuthService          *services.AuthService
+	LoginThrottleService *services.LoginThrottleService
+}
+
+func NewTwoFactorController(twoFactorService *services.TwoFactorService, userService *services.UserService, authService *services.AuthService, loginThrottleService *services.LoginThrottleService) *TwoFactorController {
+	return &TwoFactorController{
+		TwoFactorService:     twoFactorService,
+		UserService:          userService,
+		AuthService:          authService,
+		LoginThrottleService: loginThrottleService,
+	}
+}
+
+// twoFactorErrorStatus maps 2FA errors onto HTTP status codes
+func twoFactorErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, services.ErrTwoFactorCodeInvalid), errors.Is(err, services.ErrIncorrectPassword):
+		return http.StatusUnauthorized
+	case errors.Is(err, services.ErrTwoFactorAlreadyEnabled), errors.Is(err, services.ErrTwoFactorNotEnabled), errors.Is(err, services.ErrTwoFactorNotEnrolling):
+		return http.StatusConflict
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return http.StatusNotFound
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// Setup starts enrollment and returns the secret and otpauth URI to scan
+func (tc *TwoFactorController) Setup(ctx *gin.Context) {
+	enrollment, err := tc.TwoFactorService.BeginEnrollment(ctx.GetString("userId"))
+	if err != nil {
+		ctx.JSON(twoFactorErrorStatus(err), gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"success":     true,
+		"secret":      enrollment.Secret,
+		"otpauth_uri": enrollment.OtpauthUri,
+	})
+}
+
+// Confirm enables 2FA and hands out the recovery codes once
+func (tc *TwoFactorController) Confirm(ctx *gin.Context) {
+	var request schema.TwoFactorCodeRequest
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	codes, err := tc.TwoFactorService.ConfirmEnrollment(ctx.GetString("userId"), request.Code)
+	if err != nil {
+		ctx.JSON(twoFactorErrorStatus(err), gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"success":        true,
+		"message":        "Two-Factor Authentication Enabled. Store the recovery codes somewhere safe",
+		"recovery_codes": codes,
+	})
+}
+
+func (tc *TwoFactorController) RegenerateRecoveryCodes(ctx *gin.Context) {
+	var request schema.TwoFactorCodeRequest
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	codes, err := tc.TwoFactorService.RegenerateRecoveryCodes(ctx.GetString("userId"), request.Code)
+	if err != nil {
+		ctx.JSON(twoFactorErrorStatus(err), gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"success":        true,
+		"recovery_codes": codes,
+	})
+}
+
+func (tc *TwoFactorController) Disable(ctx *gin.Context) {
+	var request schema.DisableTwoFactorRequest
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	if err := tc.TwoFactorService.Disable(ctx.GetString("userId"), request.Password, request.Code); err != nil {
+		ctx.JSON(twoFactorErrorStatus(err), gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Two-Factor Authentication Disabled",
+	})
+}
+
+// Login finishes a login that LoginUser answered with a challenge
+func (tc *TwoFactorController) Login(ctx *gin.Context) {
+	var request schema.TwoFactorLoginRequest
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	userId, err := tc.AuthService.ParseTwoFactorChallenge(request.ChallengeToken)
+	if err != nil {
+		ctx.JSON(http.StatusUnauthorized, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	user, err := tc.UserService.GetUser(userId)
+	if err != nil {
+		ctx.JSON(http.StatusUnauthorized, gin.H{
+			"success": false,
+			"error":   services.ErrChallengeInvalid.Error(),
+		})
+		return
+	}
+
+	// Guessing codes counts toward the same lockout as guessing passwords
+	if err := tc.LoginThrottleService.CheckAccount(user); err != nil {
+		respondThrottled(ctx, err)
+		return
+	}
+
+	if err := tc.TwoFactorService.Verify(userId, request.Code); err != nil {
+		if errors.Is(err, services.ErrTwoFactorCodeInvalid) {
+			tc.LoginThrottleService.RecordFailure(user, ctx.ClientIP())
+		}
+		ctx.JSON(twoFactorErrorStatus(err), gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+	tc.LoginThrottleService.RecordSuccess(user)
+
+	tokens, err := tc.AuthService.IssueTokens(user, ctx.Request.UserAgent(), ctx.ClientIP())
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"success":       true,
+		"token":         tokens.AccessToken,
+		"refresh_token": tokens.RefreshToken,
+		"expires_at":    tokens.ExpiresAt,
+		"session_id":    tokens.SessionId,
+		"email":         user.Email,
+		"id":            user.ID,
+	})
+}
+
+// Reset turns off 2FA for another user who is locked out (admin only)
+func (tc *TwoFactorController) Reset(ctx *gin.Context) {
+	user, err := tc.TwoFactorService.Reset(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(twoFactorErrorStatus(err), gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Two-Factor Authentication Reset",
+		"user":    user,
+	})
+}
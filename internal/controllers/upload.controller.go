@@ -0,0 +1,217 @@
+package controllers
+
+import (
+	"encoding/base64"
+	"errors"
+	"goCal/internal/logger"
+	"goCal/internal/services"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const tusVersion = "1.0.0"
+
+// UploadController speaks the tus resumable upload protocol (https://tus.io/protocols/resumable-upload)
+type UploadController struct {
+	UploadService *services.UploadService
+	UserService   *services.UserService
+}
+
+func NewUploadController(uploadService *services.UploadService, userService *services.UserService) *UploadController {
+	return &UploadController{
+		UploadService: uploadService,
+		UserService:   userService,
+	}
+}
+
+func (uc *UploadController) setTusHeaders(ctx *gin.Context) {
+	ctx.Header("Tus-Resumable", tusVersion)
+	ctx.Header("Cache-Control", "no-store")
+}
+
+func (uc *UploadController) tusError(ctx *gin.Context, status int, message string) {
+	uc.setTusHeaders(ctx)
+	ctx.AbortWithStatusJSON(status, gin.H{
+		"success": false,
+		"error":   message,
+	})
+}
+
+// uploadErrorStatus maps upload service errors onto HTTP status codes
+func uploadErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, services.ErrUploadNotFound), errors.Is(err, services.ErrFolderNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, services.ErrFolderAccessDenied):
+		return http.StatusForbidden
+	case errors.Is(err, services.ErrUploadExpired):
+		return http.StatusGone
+	case errors.Is(err, services.ErrUploadLocked), errors.Is(err, services.ErrUploadOffsetMismatch):
+		return http.StatusConflict
+	case errors.Is(err, services.ErrUploadTooLarge), errors.Is(err, services.ErrQuotaExceeded):
+		return http.StatusRequestEntityTooLarge
+	default:
+		return http.StatusBadRequest
+	}
+}
+
+// RequireTusResumable rejects requests from clients speaking another protocol version
+func (uc *UploadController) RequireTusResumable(ctx *gin.Context) {
+	if ctx.GetHeader("Tus-Resumable") != tusVersion {
+		ctx.Header("Tus-Version", tusVersion)
+		uc.tusError(ctx, http.StatusPreconditionFailed, "Unsupported Tus-Resumable version")
+		return
+	}
+	ctx.Next()
+}
+
+// parseUploadMetadata decodes the tus Upload-Metadata header ("key base64value,key2 base64value2")
+func parseUploadMetadata(header string) map[string]string {
+	metadata := make(map[string]string)
+	for _, pair := range strings.Split(header, ",") {
+		parts := strings.Fields(pair)
+		if len(parts) == 0 {
+			continue
+		}
+		value := ""
+		if len(parts) > 1 {
+			decoded, err := base64.StdEncoding.DecodeString(parts[1])
+			if err != nil {
+				continue
+			}
+			value = string(decoded)
+		}
+		metadata[parts[0]] = value
+	}
+	return metadata
+}
+
+func (uc *UploadController) setUploadState(ctx *gin.Context, offset int64, length int64, expiresAt time.Time) {
+	ctx.Header("Upload-Offset", strconv.FormatInt(offset, 10))
+	ctx.Header("Upload-Length", strconv.FormatInt(length, 10))
+	ctx.Header("Upload-Expires", expiresAt.UTC().Format(http.TimeFormat))
+}
+
+// Options advertises the protocol version and supported extensions
+func (uc *UploadController) Options(ctx *gin.Context) {
+	uc.setTusHeaders(ctx)
+	ctx.Header("Tus-Version", tusVersion)
+	ctx.Header("Tus-Extension", "creation,termination,expiration")
+	ctx.Header("Tus-Max-Size", strconv.FormatInt(uc.UploadService.MaxSize(), 10))
+	ctx.Status(http.StatusNoContent)
+}
+
+// CreateUpload starts a new upload. The filename, filetype and optional
+// folder_id are passed in Upload-Metadata.
+func (uc *UploadController) CreateUpload(ctx *gin.Context) {
+	userIdStr := ctx.GetString("userId")
+	loggedInUserFound, loggedInUserError := uc.UserService.GetUser(userIdStr)
+	if loggedInUserError != nil {
+		uc.tusError(ctx, http.StatusNotFound, loggedInUserError.Error())
+		return
+	}
+	if !loggedInUserFound.IsVerified {
+		uc.tusError(ctx, http.StatusForbidden, "User Is Not Verified")
+		return
+	}
+
+	uploadLength, err := strconv.ParseInt(ctx.GetHeader("Upload-Length"), 10, 64)
+	if err != nil || uploadLength < 0 {
+		uc.tusError(ctx, http.StatusBadRequest, "Invalid or missing Upload-Length header")
+		return
+	}
+
+	metadata := parseUploadMetadata(ctx.GetHeader("Upload-Metadata"))
+	fileType := metadata["filetype"]
+	if fileType == "" {
+		fileType = "application/octet-stream"
+	}
+
+	var folderId *uuid.UUID
+	if rawFolderId := metadata["folder_id"]; rawFolderId != "" {
+		parsedFolderId, err := uuid.Parse(rawFolderId)
+		if err != nil {
+			uc.tusError(ctx, http.StatusBadRequest, "Invalid folder_id metadata")
+			return
+		}
+		folderId = &parsedFolderId
+	}
+
+	session, err := uc.UploadService.CreateUpload(userIdStr, uploadLength, metadata["filename"], fileType, folderId)
+	if err != nil {
+		logger.Error("Failed to create upload: %v", err)
+		uc.tusError(ctx, uploadErrorStatus(err), err.Error())
+		return
+	}
+
+	uc.setTusHeaders(ctx)
+	ctx.Header("Location", strings.TrimSuffix(ctx.Request.URL.Path, "/")+"/"+session.ID.String())
+	ctx.Header("Upload-Expires", session.ExpiresAt.UTC().Format(http.TimeFormat))
+	ctx.Status(http.StatusCreated)
+}
+
+// GetUploadOffset reports how many bytes the server has so the client can resume
+func (uc *UploadController) GetUploadOffset(ctx *gin.Context) {
+	session, err := uc.UploadService.GetUpload(ctx.Param("uploadId"), ctx.GetString("userId"))
+	if err != nil {
+		uc.setTusHeaders(ctx)
+		ctx.Status(uploadErrorStatus(err))
+		return
+	}
+
+	uc.setTusHeaders(ctx)
+	uc.setUploadState(ctx, session.UploadOffset, session.UploadLength, session.ExpiresAt)
+	if session.FileId != nil {
+		ctx.Header("X-File-Id", session.FileId.String())
+	}
+	ctx.Status(http.StatusOK)
+}
+
+// PatchUpload appends a chunk at Upload-Offset
+func (uc *UploadController) PatchUpload(ctx *gin.Context) {
+	if ctx.ContentType() != "application/offset+octet-stream" {
+		uc.tusError(ctx, http.StatusUnsupportedMediaType, "Content-Type must be application/offset+octet-stream")
+		return
+	}
+
+	offset, err := strconv.ParseInt(ctx.GetHeader("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		uc.tusError(ctx, http.StatusBadRequest, "Invalid or missing Upload-Offset header")
+		return
+	}
+
+	session, file, err := uc.UploadService.WriteChunk(ctx.Param("uploadId"), ctx.GetString("userId"), offset, ctx.Request.Body)
+	if err != nil {
+		logger.Error("Failed to write upload chunk: %v", err)
+		if session != nil {
+			ctx.Header("Upload-Offset", strconv.FormatInt(session.UploadOffset, 10))
+		}
+		uc.tusError(ctx, uploadErrorStatus(err), err.Error())
+		return
+	}
+
+	uc.setTusHeaders(ctx)
+	uc.setUploadState(ctx, session.UploadOffset, session.UploadLength, session.ExpiresAt)
+	if file != nil {
+		ctx.Header("X-File-Id", file.Id.String())
+	} else if session.FileId != nil {
+		ctx.Header("X-File-Id", session.FileId.String())
+	}
+	ctx.Status(http.StatusNoContent)
+}
+
+// TerminateUpload discards an unfinished upload
+func (uc *UploadController) TerminateUpload(ctx *gin.Context) {
+	if err := uc.UploadService.TerminateUpload(ctx.Param("uploadId"), ctx.GetString("userId")); err != nil {
+		uc.tusError(ctx, uploadErrorStatus(err), err.Error())
+		return
+	}
+
+	uc.setTusHeaders(ctx)
+	ctx.Status(http.StatusNoContent)
+}
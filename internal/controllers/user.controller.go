@@ -1,51 +1,74 @@
 package controllers
 
 import (
+	"errors"
 	"fmt"
 	"goCal/internal/logger"
 	"goCal/internal/schema"
 	"goCal/internal/services"
 	"goCal/internal/utils"
+	"math"
 	"net/http"
-	"os"
-	"strings"
-	"time"
+	"strconv"
 
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt/v4"
+	"gorm.io/gorm"
 )
 
 type UserController struct {
-	UserService *services.UserService
+	UserService          *services.UserService
+	QuotaService         *services.QuotaService
+	AuthService          *services.AuthService
+	LoginThrottleService *services.LoginThrottleService
 }
 
-var ADMIN_EMAIL string
-
-func Init() {
-	ADMIN_EMAIL = os.Getenv("ADMIN_EMAIL")
-	if ADMIN_EMAIL == "" {
-		fmt.Printf(`Failed to get the ADMIN_EMAIL`)
+func NewUserController(userService *services.UserService, quotaService *services.QuotaService, authService *services.AuthService, loginThrottleService *services.LoginThrottleService) *UserController {
+	return &UserController{
+		UserService:          userService,
+		QuotaService:         quotaService,
+		AuthService:          authService,
+		LoginThrottleService: loginThrottleService,
 	}
 }
 
-func NewUserController(userService *services.UserService) *UserController {
-	return &UserController{
-		UserService: userService,
+// respondThrottled answers a request refused by the login throttle
+func respondThrottled(ctx *gin.Context, err error) {
+	var lockout *services.LockoutError
+	if !errors.As(err, &lockout) {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
 	}
+
+	retryAfter := int(math.Ceil(lockout.RetryAfter.Seconds()))
+	ctx.Header("Retry-After", strconv.Itoa(retryAfter))
+	ctx.JSON(http.StatusTooManyRequests, gin.H{
+		"success":     false,
+		"error":       lockout.Error(),
+		"retry_after": retryAfter,
+	})
 }
 
 func (uc *UserController) GetUsers(ctx *gin.Context) {
-	users, error := uc.UserService.GetUsers()
+	var query schema.UserListQuery
+	if !bindListQuery(ctx, &query) {
+		return
+	}
+
+	users, page, error := uc.UserService.GetUsers(&query)
 	if error != nil {
-		ctx.JSON(http.StatusBadRequest, gin.H{
+		ctx.JSON(listErrorStatus(error), gin.H{
 			"success": false,
-			"error":   error,
+			"error":   error.Error(),
 		})
 		return
 	}
 	ctx.JSON(http.StatusOK, gin.H{
-		"success": true,
-		"users":   users,
+		"success":    true,
+		"users":      users,
+		"pagination": page,
 	})
 	return
 }
@@ -75,15 +98,15 @@ func (uc *UserController) GetUser(ctx *gin.Context) {
 }
 
 func (uc *UserController) CreateUser(ctx *gin.Context) {
-	var newUser *schema.User
-	if err := ctx.ShouldBindJSON(&newUser); err != nil {
+	var request schema.CreateUserRequest
+	if err := ctx.ShouldBindJSON(&request); err != nil {
 		ctx.JSON(http.StatusBadRequest, gin.H{
 			"success": false,
 			"error":   err.Error(),
 		})
 		return
 	}
-	hashedPassword, err := utils.HashPassword(newUser.Password)
+	hashedPassword, err := utils.HashPassword(request.Password)
 	if err != nil {
 		ctx.JSON(http.StatusBadRequest, gin.H{
 			"success": false,
@@ -91,7 +114,16 @@ func (uc *UserController) CreateUser(ctx *gin.Context) {
 		})
 		return
 	}
-	newUser.Password = hashedPassword
+	newUser := &schema.User{
+		Username: request.Username,
+		Email:    request.Email,
+		Password: hashedPassword,
+		Locale:   request.Locale,
+	}
+	if newUser.Locale == "" {
+		// Emails follow the browser's language unless one was picked
+		newUser.Locale = ctx.GetHeader("Accept-Language")
+	}
 
 	user, error := uc.UserService.CreateUser(newUser)
 	if error != nil {
@@ -120,27 +152,32 @@ func (uc *UserController) LoginUser(ctx *gin.Context) {
 		})
 		return
 	}
-	JWT_KEY := os.Getenv("JWT_KEY")
-	if JWT_KEY == "" {
-		logger.Error(`Failed to get the database url`)
-		fmt.Printf(`Failed to get the database url`)
+
+	clientIp := ctx.ClientIP()
+	if err := uc.LoginThrottleService.CheckAddress(clientIp); err != nil {
+		respondThrottled(ctx, err)
+		return
 	}
+
 	userFound, error := uc.UserService.GetUserByEmail(newUser.Email)
 	if error != nil {
+		uc.LoginThrottleService.RecordAddressFailure(clientIp)
 		ctx.JSON(http.StatusNotFound, gin.H{
 			"success": false,
 			"error":   error.Error(),
 		})
 		return
 	}
-	if userFound.IsVerified == false {
-		ctx.JSON(http.StatusForbidden, gin.H{
-			"success": false,
-			"error":   "User Is Not Verified",
-		})
+
+	// A locked account is refused before the password is even checked
+	if err := uc.LoginThrottleService.CheckAccount(userFound); err != nil {
+		respondThrottled(ctx, err)
+		return
 	}
+
 	err := utils.CompareHashAndPassword(userFound.Password, newUser.Password)
 	if err != nil {
+		uc.LoginThrottleService.RecordFailure(userFound, clientIp)
 		ctx.JSON(http.StatusBadRequest, gin.H{
 			"success": false,
 			"error":   err.Error(),
@@ -158,117 +195,206 @@ func (uc *UserController) LoginUser(ctx *gin.Context) {
 		return
 	}
 
-	claims := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.StandardClaims{
-		Issuer:    userFound.Email,
-		Id:        userFound.ID.String(),
-		ExpiresAt: time.Now().Add(time.Hour * 24).Unix(),
-	})
-	token, err := claims.SignedString([]byte(JWT_KEY))
+	// With 2FA on, the password only earns a challenge for POST /login/2fa
+	if userFound.TwoFactorEnabled {
+		challenge, expiresAt, err := uc.AuthService.IssueTwoFactorChallenge(userFound)
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"success": false,
+				"error":   err.Error(),
+			})
+			return
+		}
+		ctx.JSON(http.StatusOK, gin.H{
+			"success":             true,
+			"two_factor_required": true,
+			"challenge_token":     challenge,
+			"expires_at":          expiresAt,
+		})
+		return
+	}
+
+	// Failures are only cleared once the login is complete. Clearing them on
+	// the password alone would let its holder reset the count between 2FA guesses.
+	uc.LoginThrottleService.RecordSuccess(userFound)
+
+	tokens, err := uc.AuthService.IssueTokens(userFound, ctx.Request.UserAgent(), ctx.ClientIP())
 	if err != nil {
-		ctx.JSON(http.StatusUnauthorized, gin.H{
+		ctx.JSON(http.StatusInternalServerError, gin.H{
 			"success": false,
 			"error":   err.Error(),
 		})
+		return
 	}
 
 	ctx.JSON(http.StatusOK, gin.H{
-		"success": true,
-		"token":   token,
-		"email":   userFound.Email,
-		"id":      userFound.ID,
+		"success":       true,
+		"token":         tokens.AccessToken,
+		"refresh_token": tokens.RefreshToken,
+		"expires_at":    tokens.ExpiresAt,
+		"session_id":    tokens.SessionId,
+		"email":         userFound.Email,
+		"id":            userFound.ID,
 	})
 	return
 }
 
-func (uc *UserController) DeleteUser(ctx *gin.Context) {
-	userId, exists := ctx.Get("userId")
-
-	if !exists {
-		ctx.JSON(http.StatusUnauthorized, gin.H{
+// RefreshToken trades a refresh token for a new access token and a rotated refresh token
+func (uc *UserController) RefreshToken(ctx *gin.Context) {
+	var request schema.RefreshTokenRequest
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
 			"success": false,
-			"error":   "User Id not found in context",
+			"error":   err.Error(),
 		})
 		return
 	}
 
-	userIdStr, ok := userId.(string)
-	if !ok {
-		ctx.JSON(http.StatusInternalServerError, gin.H{
+	tokens, err := uc.AuthService.Refresh(request.RefreshToken, ctx.Request.UserAgent(), ctx.ClientIP())
+	if err != nil {
+		status := http.StatusUnauthorized
+		if !errors.Is(err, services.ErrSessionInvalid) && !errors.Is(err, services.ErrRefreshTokenReused) {
+			status = http.StatusInternalServerError
+		}
+		ctx.JSON(status, gin.H{
 			"success": false,
-			"error":   "Invalid User Id type in context",
+			"error":   err.Error(),
 		})
 		return
 	}
 
-	loggedInUserFound, loggedInUserError := uc.UserService.GetUser(userIdStr)
-	if loggedInUserError != nil {
-		fmt.Printf("Error finding logged-in user: %v\n", loggedInUserError)
-		ctx.JSON(http.StatusNotFound, gin.H{
+	ctx.JSON(http.StatusOK, gin.H{
+		"success":       true,
+		"token":         tokens.AccessToken,
+		"refresh_token": tokens.RefreshToken,
+		"expires_at":    tokens.ExpiresAt,
+		"session_id":    tokens.SessionId,
+	})
+}
+
+// Logout ends the session the request was made with
+func (uc *UserController) Logout(ctx *gin.Context) {
+	if err := uc.AuthService.RevokeSession(ctx.GetString("sessionId"), ctx.GetString("userId")); err != nil {
+		ctx.JSON(sessionErrorStatus(err), gin.H{
 			"success": false,
-			"error":   loggedInUserError.Error(),
+			"error":   err.Error(),
 		})
 		return
 	}
 
-	if loggedInUserFound.IsVerified == false {
-		ctx.JSON(http.StatusForbidden, gin.H{
+	ctx.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Logged Out Successfully",
+	})
+}
+
+// LogoutAll ends every session of the caller, including the current one
+func (uc *UserController) LogoutAll(ctx *gin.Context) {
+	revoked, err := uc.AuthService.RevokeAllSessions(ctx.GetString("userId"))
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
 			"success": false,
-			"error":   "User Is Not Verified",
+			"error":   err.Error(),
 		})
+		return
 	}
 
-	message, err := uc.UserService.DeleteUser(userIdStr)
+	ctx.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Logged Out From All Devices",
+		"revoked": revoked,
+	})
+}
+
+func (uc *UserController) GetSessions(ctx *gin.Context) {
+	sessions, err := uc.AuthService.GetSessions(ctx.GetString("userId"))
 	if err != nil {
-		ctx.JSON(http.StatusNotFound, gin.H{
+		ctx.JSON(http.StatusInternalServerError, gin.H{
 			"success": false,
 			"error":   err.Error(),
 		})
 		return
 	}
+
+	currentSessionId := ctx.GetString("sessionId")
+	for _, session := range sessions {
+		session.Current = session.ID.String() == currentSessionId
+	}
+
 	ctx.JSON(http.StatusOK, gin.H{
-		"success": true,
-		"message": message,
+		"success":  true,
+		"sessions": sessions,
 	})
 }
 
-func (uc *UserController) UpdateUser(ctx *gin.Context) {
-	email, exists := ctx.Get("email")
-
-	if !exists {
-		ctx.JSON(http.StatusNotAcceptable, gin.H{
+func (uc *UserController) RevokeSession(ctx *gin.Context) {
+	if err := uc.AuthService.RevokeSession(ctx.Param("sessionId"), ctx.GetString("userId")); err != nil {
+		ctx.JSON(sessionErrorStatus(err), gin.H{
 			"success": false,
-			"error":   "Email not found in context",
+			"error":   err.Error(),
 		})
 		return
 	}
 
-	emailStr, ok := email.(string)
-	if !ok {
-		ctx.JSON(http.StatusInternalServerError, gin.H{
+	ctx.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Session Revoked",
+	})
+}
+
+// ForgotPassword always answers the same way so it can't be used to find accounts
+func (uc *UserController) ForgotPassword(ctx *gin.Context) {
+	var request schema.ForgotPasswordRequest
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
 			"success": false,
-			"error":   "Invalid email type in context",
+			"error":   err.Error(),
 		})
 		return
 	}
 
-	loggedInUserFound, loggedInUserError := uc.UserService.GetUserByEmail(emailStr)
-	if loggedInUserError != nil {
-		ctx.JSON(http.StatusNotFound, gin.H{
+	if err := uc.UserService.RequestPasswordReset(request.Email); err != nil {
+		logger.Error("Failed to request a password reset: %v", err)
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "If an account exists for this email, a password reset link has been sent",
+	})
+}
+
+func (uc *UserController) ResetPassword(ctx *gin.Context) {
+	var request schema.ResetPasswordRequest
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
 			"success": false,
-			"error":   loggedInUserError.Error(),
+			"error":   err.Error(),
 		})
 		return
 	}
 
-	if loggedInUserFound.IsVerified == false {
-		ctx.JSON(http.StatusForbidden, gin.H{
+	if err := uc.UserService.ResetPassword(request.Token, request.NewPassword); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, services.ErrPasswordResetInvalid) {
+			status = http.StatusBadRequest
+		}
+		ctx.JSON(status, gin.H{
 			"success": false,
-			"error":   "User Is Not Verified",
+			"error":   err.Error(),
 		})
+		return
 	}
 
-	var updateRequest *schema.UpdateUserRequest
-	if err := ctx.ShouldBindJSON(&updateRequest); err != nil {
+	ctx.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Password Reset Successfully. Please log in again",
+	})
+}
+
+// ChangePassword signs out every session and hands this device a new token pair
+func (uc *UserController) ChangePassword(ctx *gin.Context) {
+	var request schema.ChangePasswordRequest
+	if err := ctx.ShouldBindJSON(&request); err != nil {
 		ctx.JSON(http.StatusBadRequest, gin.H{
 			"success": false,
 			"error":   err.Error(),
@@ -276,24 +402,47 @@ func (uc *UserController) UpdateUser(ctx *gin.Context) {
 		return
 	}
 
-	updatedUser, updateUserError := uc.UserService.UpdateUser(loggedInUserFound.ID.String(), updateRequest)
-	if updateUserError != nil {
-		ctx.JSON(http.StatusBadRequest, gin.H{
+	user, err := uc.UserService.ChangePassword(ctx.GetString("userId"), request.CurrentPassword, request.NewPassword)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, services.ErrIncorrectPassword) {
+			status = http.StatusUnauthorized
+		}
+		ctx.JSON(status, gin.H{
 			"success": false,
-			"error":   updateUserError.Error(),
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	tokens, err := uc.AuthService.IssueTokens(user, ctx.Request.UserAgent(), ctx.ClientIP())
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"error":   err.Error(),
 		})
 		return
 	}
 
 	ctx.JSON(http.StatusOK, gin.H{
-		"success": true,
-		"user":    updatedUser,
+		"success":       true,
+		"message":       "Password Changed Successfully",
+		"token":         tokens.AccessToken,
+		"refresh_token": tokens.RefreshToken,
+		"expires_at":    tokens.ExpiresAt,
+		"session_id":    tokens.SessionId,
 	})
 }
 
-// GetSoftDeletedUsers returns all soft-deleted users
-func (uc *UserController) GetSoftDeletedUsers(ctx *gin.Context) {
+// sessionErrorStatus maps session errors onto HTTP status codes
+func sessionErrorStatus(err error) int {
+	if errors.Is(err, services.ErrSessionNotFound) {
+		return http.StatusNotFound
+	}
+	return http.StatusInternalServerError
+}
 
+func (uc *UserController) DeleteUser(ctx *gin.Context) {
 	userId, exists := ctx.Get("userId")
 
 	if !exists {
@@ -313,8 +462,9 @@ func (uc *UserController) GetSoftDeletedUsers(ctx *gin.Context) {
 		return
 	}
 
-	loggedInUser, loggedInUserError := uc.UserService.GetUser(userIdStr)
+	loggedInUserFound, loggedInUserError := uc.UserService.GetUser(userIdStr)
 	if loggedInUserError != nil {
+		fmt.Printf("Error finding logged-in user: %v\n", loggedInUserError)
 		ctx.JSON(http.StatusNotFound, gin.H{
 			"success": false,
 			"error":   loggedInUserError.Error(),
@@ -322,51 +472,53 @@ func (uc *UserController) GetSoftDeletedUsers(ctx *gin.Context) {
 		return
 	}
 
-	if strings.ToLower(loggedInUser.Email) != ADMIN_EMAIL {
-		ctx.JSON(http.StatusNotFound, gin.H{
+	if loggedInUserFound.IsVerified == false {
+		ctx.JSON(http.StatusForbidden, gin.H{
 			"success": false,
-			"error":   "Only Admin Can Access this api",
+			"error":   "User Is Not Verified",
 		})
-		return
 	}
 
-	users, err := uc.UserService.GetSoftDeletedUsers()
+	message, err := uc.UserService.DeleteUser(userIdStr)
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{
+		ctx.JSON(http.StatusNotFound, gin.H{
 			"success": false,
 			"error":   err.Error(),
 		})
 		return
 	}
+
+	// A deleted account must not keep working on devices that are still signed in
+	if _, err := uc.AuthService.RevokeAllSessions(userIdStr); err != nil {
+		logger.Error("Failed to revoke sessions of deleted user %s: %v", userIdStr, err)
+	}
 	ctx.JSON(http.StatusOK, gin.H{
 		"success": true,
-		"users":   users,
+		"message": message,
 	})
 }
 
-// RestoreUser restores a soft-deleted user
-func (uc *UserController) RestoreUser(ctx *gin.Context) {
-
-	userId, exists := ctx.Get("userId")
+func (uc *UserController) UpdateUser(ctx *gin.Context) {
+	email, exists := ctx.Get("email")
 
 	if !exists {
-		ctx.JSON(http.StatusUnauthorized, gin.H{
+		ctx.JSON(http.StatusNotAcceptable, gin.H{
 			"success": false,
-			"error":   "User Id not found in context",
+			"error":   "Email not found in context",
 		})
 		return
 	}
 
-	userIdStr, ok := userId.(string)
+	emailStr, ok := email.(string)
 	if !ok {
 		ctx.JSON(http.StatusInternalServerError, gin.H{
 			"success": false,
-			"error":   "Invalid User Id type in context",
+			"error":   "Invalid email type in context",
 		})
 		return
 	}
 
-	loggedInUser, loggedInUserError := uc.UserService.GetUser(userIdStr)
+	loggedInUserFound, loggedInUserError := uc.UserService.GetUserByEmail(emailStr)
 	if loggedInUserError != nil {
 		ctx.JSON(http.StatusNotFound, gin.H{
 			"success": false,
@@ -375,78 +527,88 @@ func (uc *UserController) RestoreUser(ctx *gin.Context) {
 		return
 	}
 
-	if strings.ToLower(loggedInUser.Email) != ADMIN_EMAIL {
-		ctx.JSON(http.StatusNotFound, gin.H{
+	if loggedInUserFound.IsVerified == false {
+		ctx.JSON(http.StatusForbidden, gin.H{
 			"success": false,
-			"error":   "Only Admin Can Access this api",
+			"error":   "User Is Not Verified",
 		})
-		return
 	}
 
-	id := ctx.Param("id")
-	if id == "" {
+	var updateRequest *schema.UpdateUserRequest
+	if err := ctx.ShouldBindJSON(&updateRequest); err != nil {
 		ctx.JSON(http.StatusBadRequest, gin.H{
 			"success": false,
-			"error":   "User ID is required",
+			"error":   err.Error(),
 		})
 		return
 	}
 
-	user, err := uc.UserService.RestoreUser(id)
-	if err != nil {
-		ctx.JSON(http.StatusNotFound, gin.H{
+	updatedUser, updateUserError := uc.UserService.UpdateUser(loggedInUserFound.ID.String(), updateRequest)
+	if updateUserError != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
 			"success": false,
-			"error":   err.Error(),
+			"error":   updateUserError.Error(),
 		})
 		return
 	}
 
 	ctx.JSON(http.StatusOK, gin.H{
 		"success": true,
-		"message": "User restored successfully",
-		"user":    user,
+		"user":    updatedUser,
 	})
 }
 
-// PermanentlyDeleteUser permanently deletes a user (hard delete)
-func (uc *UserController) PermanentlyDeleteUser(ctx *gin.Context) {
-
-	userId, exists := ctx.Get("userId")
-
-	if !exists {
-		ctx.JSON(http.StatusUnauthorized, gin.H{
-			"success": false,
-			"error":   "User Id not found in context",
-		})
+// GetSoftDeletedUsers returns all soft-deleted users
+func (uc *UserController) GetSoftDeletedUsers(ctx *gin.Context) {
+	var query schema.DeletedUserListQuery
+	if !bindListQuery(ctx, &query) {
 		return
 	}
 
-	userIdStr, ok := userId.(string)
-	if !ok {
-		ctx.JSON(http.StatusInternalServerError, gin.H{
+	users, page, err := uc.UserService.GetSoftDeletedUsers(&query)
+	if err != nil {
+		ctx.JSON(listErrorStatus(err), gin.H{
 			"success": false,
-			"error":   "Invalid User Id type in context",
+			"error":   err.Error(),
 		})
 		return
 	}
+	ctx.JSON(http.StatusOK, gin.H{
+		"success":    true,
+		"users":      users,
+		"pagination": page,
+	})
+}
 
-	loggedInUser, loggedInUserError := uc.UserService.GetUser(userIdStr)
-	if loggedInUserError != nil {
-		ctx.JSON(http.StatusNotFound, gin.H{
+// RestoreUser restores a soft-deleted user
+func (uc *UserController) RestoreUser(ctx *gin.Context) {
+	id := ctx.Param("id")
+	if id == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{
 			"success": false,
-			"error":   loggedInUserError.Error(),
+			"error":   "User ID is required",
 		})
 		return
 	}
 
-	if strings.ToLower(loggedInUser.Email) != ADMIN_EMAIL {
+	user, err := uc.UserService.RestoreUser(id)
+	if err != nil {
 		ctx.JSON(http.StatusNotFound, gin.H{
 			"success": false,
-			"error":   "Only Admin Can Access this api",
+			"error":   err.Error(),
 		})
 		return
 	}
 
+	ctx.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "User restored successfully",
+		"user":    user,
+	})
+}
+
+// PermanentlyDeleteUser permanently deletes a user (hard delete)
+func (uc *UserController) PermanentlyDeleteUser(ctx *gin.Context) {
 	id := ctx.Param("id")
 	if id == "" {
 		ctx.JSON(http.StatusBadRequest, gin.H{
@@ -516,8 +678,15 @@ func (uc *UserController) VerifyUser(ctx *gin.Context) {
 		return
 	}
 
+	clientIp := ctx.ClientIP()
+	if err := uc.LoginThrottleService.CheckAddress(clientIp); err != nil {
+		respondThrottled(ctx, err)
+		return
+	}
+
 	user, err := uc.UserService.VerifyUser(request.Email, request.VerificationCode)
 	if err != nil {
+		uc.LoginThrottleService.RecordAddressFailure(clientIp)
 		ctx.JSON(http.StatusBadRequest, gin.H{
 			"success": false,
 			"error":   err.Error(),
@@ -531,3 +700,86 @@ func (uc *UserController) VerifyUser(ctx *gin.Context) {
 		"user":    user,
 	})
 }
+
+// UpdateStorageLimit changes a user's storage quota (admin only)
+func (uc *UserController) UpdateStorageLimit(ctx *gin.Context) {
+	var request struct {
+		StorageLimit *int64 `json:"storage_limit" binding:"required"`
+	}
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	user, err := uc.QuotaService.SetStorageLimit(ctx.Param("id"), *request.StorageLimit)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Storage limit updated",
+		"user":    user,
+	})
+}
+
+// ReconcileStorage recomputes every user's storage usage from the files table (admin only)
+func (uc *UserController) ReconcileStorage(ctx *gin.Context) {
+	corrected, err := uc.QuotaService.ReconcileStorageUsage()
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"success":         true,
+		"message":         "Storage usage reconciled",
+		"users_corrected": corrected,
+	})
+}
+
+// UpdateRole promotes or demotes a user
+func (uc *UserController) UpdateRole(ctx *gin.Context) {
+	var request schema.UpdateRoleRequest
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	user, err := uc.UserService.SetRole(ctx.Param("id"), request.Role)
+	if err != nil {
+		status := http.StatusInternalServerError
+		switch {
+		case errors.Is(err, services.ErrInvalidRole):
+			status = http.StatusBadRequest
+		case errors.Is(err, services.ErrLastAdmin):
+			status = http.StatusConflict
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			status = http.StatusNotFound
+		}
+		ctx.JSON(status, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Role Updated",
+		"user":    user,
+	})
+}
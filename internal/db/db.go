@@ -3,6 +3,7 @@ package db
 import (
 	"fmt"
 	"goCal/internal/logger"
+	"goCal/internal/permissions"
 	"goCal/internal/schema"
 	"os"
 	"time"
@@ -44,11 +45,176 @@ func DBConnect() {
 
 	DB = db
 
-	if err := DB.AutoMigrate(&schema.User{}, &schema.FileAccess{}, &schema.File{}, &schema.Folder{}); err != nil {
+	if err := DB.AutoMigrate(&schema.User{}, &schema.FileAccess{}, &schema.File{}, &schema.FileContent{}, &schema.Folder{}, &schema.FileVersion{}, &schema.Blob{}, &schema.Thumbnail{}, &schema.FileMetadata{}, &schema.Session{}, &schema.PasswordResetToken{}, &schema.RecoveryCode{}, &schema.UserIdentity{}, &schema.OidcLoginState{}, &schema.ApiKey{}, &schema.AuthThrottle{}, &schema.EmailOutbox{}, &schema.UploadSession{}, &schema.ShareLink{}, &schema.ShareLinkDownload{}); err != nil {
 		logger.Error("Failed to auto-migrate tables: %w", err)
 		panic(fmt.Errorf("Failed to auto-migrate tables: %w", err))
 	}
 
+	if err := migrateFolderIndexes(); err != nil {
+		logger.Error("Failed to migrate folder indexes: %w", err)
+		panic(fmt.Errorf("Failed to migrate folder indexes: %w", err))
+	}
+
+	if err := migrateSearchIndexes(); err != nil {
+		logger.Error("Failed to migrate search indexes: %w", err)
+		panic(fmt.Errorf("Failed to migrate search indexes: %w", err))
+	}
+
+	if err := migrateLegacyFileUrls(); err != nil {
+		logger.Error("Failed to migrate legacy file urls: %w", err)
+		panic(fmt.Errorf("Failed to migrate legacy file urls: %w", err))
+	}
+
+	if err := promoteAdmin(); err != nil {
+		logger.Error("Failed to promote the admin account: %w", err)
+		panic(fmt.Errorf("Failed to promote the admin account: %w", err))
+	}
+
 	fmt.Println("Connection established")
 	logger.Info("Database connected")
 }
+
+// promoteAdmin makes the ADMIN_EMAIL account admin when it already exists, so
+// deployments whose users signed up before roles keep a way into the admin
+// endpoints. Accounts created later are promoted by User.BeforeCreate.
+func promoteAdmin() error {
+	adminEmail := schema.AdminEmail()
+	if adminEmail == "" {
+		logger.Warn("ADMIN_EMAIL is not set, no account is made admin")
+		return nil
+	}
+
+	result := DB.Model(&schema.User{}).
+		Where("LOWER(email) = ? AND (role IS NULL OR role <> ?)", adminEmail, permissions.RoleAdmin).
+		Update("role", permissions.RoleAdmin)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected > 0 {
+		logger.Info("Promoted %s to admin", adminEmail)
+	}
+	return nil
+}
+
+// migrateLegacyFileUrls backfills storage_bucket/storage_key for files stored
+// when file_url was a public Supabase URL, then points file_url at the content
+// endpoint since buckets are no longer public.
+func migrateLegacyFileUrls() error {
+	if err := DB.Exec(`
+		UPDATE files
+		SET storage_bucket = substring(file_url from '/object/public/([^/]+)/'),
+			storage_key = substring(file_url from '/object/public/[^/]+/(.+)$')
+		WHERE (storage_key IS NULL OR storage_key = '') AND file_url LIKE '%/object/public/%'`).Error; err != nil {
+		return err
+	}
+
+	return DB.Exec(`
+		UPDATE files
+		SET file_url = '/api/file/' || id || '/content'
+		WHERE file_url NOT LIKE '/api/file/%'`).Error
+}
+
+// migrateFolderIndexes replaces the old globally unique folder name with
+// uniqueness per owner and parent. Root folders have a NULL parent, which
+// Postgres treats as distinct, so the index coalesces it to the nil uuid.
+// Trashed folders are left out so a new folder can reuse their name.
+func migrateFolderIndexes() error {
+	for _, index := range []string{"idx_folders_folder_name", "idx_folders_owner_parent_name"} {
+		if err := DB.Exec(`DROP INDEX IF EXISTS ` + index).Error; err != nil {
+			return err
+		}
+	}
+
+	return DB.Exec(`
+		CREATE UNIQUE INDEX IF NOT EXISTS idx_folders_live_owner_parent_name
+		ON folders (created_by_id, COALESCE(parent_id, '00000000-0000-0000-0000-000000000000'::uuid), folder_name)
+		WHERE deleted_at IS NULL`).Error
+}
+
+// migrateSearchIndexes adds the search_vector columns full-text search runs
+// on. Triggers keep them current, so they are never written from Go. Names
+// are split on punctuation first so "q3-report.pdf" matches "report".
+// A file's vector also holds the text the content indexer extracted from it,
+// weighted below its name.
+// The 'simple' configuration is used because names and tags aren't English prose.
+func migrateSearchIndexes() error {
+	statements := []string{
+		`CREATE OR REPLACE FUNCTION search_words(value text) RETURNS text
+		LANGUAGE sql IMMUTABLE AS $$ SELECT regexp_replace(coalesce(value, ''), '[[:punct:]]+', ' ', 'g') $$`,
+
+		`ALTER TABLE files ADD COLUMN IF NOT EXISTS search_vector tsvector`,
+		`CREATE OR REPLACE FUNCTION files_search_vector(file_name text, file_type text) RETURNS tsvector
+		LANGUAGE sql IMMUTABLE AS $$
+			SELECT setweight(to_tsvector('simple', search_words(file_name)), 'A') ||
+				setweight(to_tsvector('simple', search_words(file_type)), 'D')
+		$$`,
+		`CREATE OR REPLACE FUNCTION files_search_vector_trigger() RETURNS trigger
+		LANGUAGE plpgsql AS $$
+		BEGIN
+			NEW.search_vector := files_search_vector(NEW.file_name, NEW.file_type) ||
+				coalesce((SELECT search_vector FROM file_contents WHERE file_id = NEW.id), ''::tsvector);
+			RETURN NEW;
+		END
+		$$`,
+		`DROP TRIGGER IF EXISTS files_search_vector_update ON files`,
+		`CREATE TRIGGER files_search_vector_update BEFORE INSERT OR UPDATE OF file_name, file_type ON files
+		FOR EACH ROW EXECUTE FUNCTION files_search_vector_trigger()`,
+		`UPDATE files SET search_vector = files_search_vector(file_name, file_type) WHERE search_vector IS NULL`,
+		`CREATE INDEX IF NOT EXISTS idx_files_search_vector ON files USING GIN (search_vector)`,
+
+		`ALTER TABLE file_contents ADD COLUMN IF NOT EXISTS search_vector tsvector`,
+		`CREATE OR REPLACE FUNCTION file_contents_search_vector_trigger() RETURNS trigger
+		LANGUAGE plpgsql AS $$
+		BEGIN
+			NEW.search_vector := setweight(to_tsvector('simple', search_words(NEW.text)), 'C');
+			RETURN NEW;
+		END
+		$$`,
+		`DROP TRIGGER IF EXISTS file_contents_search_vector_update ON file_contents`,
+		`CREATE TRIGGER file_contents_search_vector_update BEFORE INSERT OR UPDATE OF text ON file_contents
+		FOR EACH ROW EXECUTE FUNCTION file_contents_search_vector_trigger()`,
+		`CREATE OR REPLACE FUNCTION file_contents_files_search_vector_trigger() RETURNS trigger
+		LANGUAGE plpgsql AS $$
+		BEGIN
+			UPDATE files SET search_vector = files_search_vector(file_name, file_type) || coalesce(NEW.search_vector, ''::tsvector)
+			WHERE id = NEW.file_id;
+			RETURN NULL;
+		END
+		$$`,
+		`DROP TRIGGER IF EXISTS file_contents_files_search_vector_update ON file_contents`,
+		`CREATE TRIGGER file_contents_files_search_vector_update AFTER INSERT OR UPDATE OF text ON file_contents
+		FOR EACH ROW EXECUTE FUNCTION file_contents_files_search_vector_trigger()`,
+		// Files uploaded before content indexing existed go through the indexer once
+		`INSERT INTO file_contents (file_id, content_hash, status, attempts, next_attempt_at, created_at, updated_at)
+		SELECT id, content_hash, 'pending', 0, now(), now(), now() FROM files
+		WHERE NOT EXISTS (SELECT 1 FROM file_contents WHERE file_contents.file_id = files.id)`,
+
+		`ALTER TABLE folders ADD COLUMN IF NOT EXISTS search_vector tsvector`,
+		`CREATE OR REPLACE FUNCTION folders_search_vector(folder_name text, folder_tags text[], folder_description text) RETURNS tsvector
+		LANGUAGE sql IMMUTABLE AS $$
+			SELECT setweight(to_tsvector('simple', search_words(folder_name)), 'A') ||
+				setweight(to_tsvector('simple', search_words(array_to_string(folder_tags, ' '))), 'B') ||
+				setweight(to_tsvector('simple', search_words(folder_description)), 'C')
+		$$`,
+		`CREATE OR REPLACE FUNCTION folders_search_vector_trigger() RETURNS trigger
+		LANGUAGE plpgsql AS $$
+		BEGIN
+			NEW.search_vector := folders_search_vector(NEW.folder_name, NEW.folder_tags, NEW.folder_description);
+			RETURN NEW;
+		END
+		$$`,
+		`DROP TRIGGER IF EXISTS folders_search_vector_update ON folders`,
+		`CREATE TRIGGER folders_search_vector_update BEFORE INSERT OR UPDATE OF folder_name, folder_tags, folder_description ON folders
+		FOR EACH ROW EXECUTE FUNCTION folders_search_vector_trigger()`,
+		`UPDATE folders SET search_vector = folders_search_vector(folder_name, folder_tags, folder_description) WHERE search_vector IS NULL`,
+		`CREATE INDEX IF NOT EXISTS idx_folders_search_vector ON folders USING GIN (search_vector)`,
+		`CREATE INDEX IF NOT EXISTS idx_folders_folder_tags ON folders USING GIN (folder_tags)`,
+	}
+
+	for _, statement := range statements {
+		if err := DB.Exec(statement).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package emails
+
+import "time"
+
+// Template data for each message type
+
+type VerificationData struct {
+	Username         string
+	Code             string
+	ExpiresInMinutes int
+}
+
+type PasswordResetData struct {
+	Username         string
+	Token            string
+	ResetUrl         string // empty when PASSWORD_RESET_URL isn't set
+	ExpiresInMinutes int
+}
+
+type AccountLockedData struct {
+	Username    string
+	IpAddress   string
+	LockedUntil string
+}
+
+type ShareNotificationData struct {
+	Username   string
+	SharedBy   string
+	FileName   string
+	AccessType string // "view" | "edit"
+}
+
+type QuotaWarningData struct {
+	Username     string
+	Percent      int
+	StorageUsed  int64
+	StorageLimit int64
+}
+
+// Minutes rounds d up to whole minutes for templates
+func Minutes(d time.Duration) int {
+	return int((d + time.Minute - 1) / time.Minute)
+}
+
+// FormatTime prints t the way the templates show times
+func FormatTime(t time.Time) string {
+	return t.UTC().Format("2006-01-02 15:04 MST")
+}
+
+// SampleData returns made-up data for previewing name
+func SampleData(name string) (any, error) {
+	switch name {
+	case Verification:
+		return VerificationData{Username: "jane", Code: "0420", ExpiresInMinutes: 15}, nil
+	case PasswordReset:
+		return PasswordResetData{Username: "jane", Token: "sample-reset-token", ResetUrl: "https://example.com/reset?token=sample-reset-token", ExpiresInMinutes: 60}, nil
+	case AccountLocked:
+		return AccountLockedData{Username: "jane", IpAddress: "203.0.113.7", LockedUntil: FormatTime(time.Now().Add(15 * time.Minute))}, nil
+	case ShareNotification:
+		return ShareNotificationData{Username: "jane", SharedBy: "john", FileName: "budget-2025.xlsx", AccessType: "edit"}, nil
+	case QuotaWarning:
+		return QuotaWarningData{Username: "jane", Percent: 92, StorageUsed: 482344960, StorageLimit: 524288000}, nil
+	}
+	return nil, ErrUnknownTemplate
+}
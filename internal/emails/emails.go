@@ -0,0 +1,159 @@
+package emails
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"sort"
+	"strings"
+	texttemplate "text/template"
+)
+
+// Message types, each has an HTML and a plain-text template in every locale
+const (
+	Verification      = "verification"
+	PasswordReset     = "password_reset"
+	AccountLocked     = "account_locked"
+	ShareNotification = "share_notification"
+	QuotaWarning      = "quota_warning"
+)
+
+// DefaultLocale is used when a user has no locale or one we don't ship
+const DefaultLocale = "en"
+
+var ErrUnknownTemplate = errors.New("unknown email template")
+
+var names = []string{Verification, PasswordReset, AccountLocked, ShareNotification, QuotaWarning}
+
+//go:embed templates
+var files embed.FS
+
+// Message is a rendered email
+type Message struct {
+	Subject string `json:"subject"`
+	HTML    string `json:"html"`
+	Text    string `json:"text"`
+}
+
+type localized struct {
+	html *htmltemplate.Template
+	text *texttemplate.Template
+}
+
+// registry holds the parsed templates by locale, then by message type
+var registry = load()
+
+var funcs = map[string]any{
+	"bytes": FormatBytes,
+}
+
+// load parses every locale directory under templates. The files are embedded,
+// so a broken or missing template is a build mistake and panics at startup.
+func load() map[string]map[string]localized {
+	entries, err := fs.ReadDir(files, "templates")
+	if err != nil {
+		panic(err)
+	}
+
+	loaded := map[string]map[string]localized{}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		locale := entry.Name()
+		loaded[locale] = map[string]localized{}
+		for _, name := range names {
+			html := htmltemplate.Must(htmltemplate.New(name).Funcs(funcs).ParseFS(files,
+				"templates/layout.html",
+				"templates/"+locale+"/footer.html",
+				"templates/"+locale+"/"+name+".html",
+			))
+			text := texttemplate.Must(texttemplate.New(name).Funcs(funcs).ParseFS(files,
+				"templates/"+locale+"/footer.txt",
+				"templates/"+locale+"/"+name+".txt",
+			))
+			loaded[locale][name] = localized{html: html, text: text}
+		}
+	}
+	if _, ok := loaded[DefaultLocale]; !ok {
+		panic("emails: missing templates for default locale " + DefaultLocale)
+	}
+	return loaded
+}
+
+// Names lists the message types
+func Names() []string {
+	return append([]string(nil), names...)
+}
+
+// Locales lists the locales there are templates for
+func Locales() []string {
+	locales := make([]string, 0, len(registry))
+	for locale := range registry {
+		locales = append(locales, locale)
+	}
+	sort.Strings(locales)
+	return locales
+}
+
+// IsSupportedLocale reports whether locale has its own templates
+func IsSupportedLocale(locale string) bool {
+	_, ok := registry[locale]
+	return ok
+}
+
+// NormalizeLocale picks the supported locale closest to locale, which may be
+// a tag like "es-MX" or a whole Accept-Language header
+func NormalizeLocale(locale string) string {
+	for _, part := range strings.Split(locale, ",") {
+		tag := strings.ToLower(strings.TrimSpace(strings.SplitN(part, ";", 2)[0]))
+		if IsSupportedLocale(tag) {
+			return tag
+		}
+		if language, _, found := strings.Cut(tag, "-"); found && IsSupportedLocale(language) {
+			return language
+		}
+	}
+	return DefaultLocale
+}
+
+// Render builds the message name in locale, falling back to the default locale
+func Render(name string, locale string, data any) (*Message, error) {
+	templates, ok := registry[NormalizeLocale(locale)][name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownTemplate, name)
+	}
+
+	var subject, text, html strings.Builder
+	if err := templates.text.ExecuteTemplate(&subject, "subject", data); err != nil {
+		return nil, fmt.Errorf("failed to render %s subject: %w", name, err)
+	}
+	if err := templates.text.ExecuteTemplate(&text, "body", data); err != nil {
+		return nil, fmt.Errorf("failed to render %s text: %w", name, err)
+	}
+	if err := templates.html.ExecuteTemplate(&html, "layout", data); err != nil {
+		return nil, fmt.Errorf("failed to render %s html: %w", name, err)
+	}
+
+	return &Message{
+		Subject: strings.Join(strings.Fields(subject.String()), " "), // no line breaks in a header
+		HTML:    html.String(),
+		Text:    strings.TrimSpace(text.String()) + "\n",
+	}, nil
+}
+
+// FormatBytes prints a size like "1.5 GB"
+func FormatBytes(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(size)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,310 @@
+// Package exif reads the EXIF metadata embedded in JPEG, PNG and WebP images
+package exif
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"strings"
+	"time"
+)
+
+var ErrNoExif = errors.New("no exif data")
+
+const (
+	tagMake             = 0x010f
+	tagModel            = 0x0110
+	tagOrientation      = 0x0112
+	tagDateTime         = 0x0132
+	tagExifIFD          = 0x8769
+	tagGpsIFD           = 0x8825
+	tagDateTimeOriginal = 0x9003
+	tagOffsetTimeOrig   = 0x9011
+
+	tagGpsLatitudeRef  = 0x0001
+	tagGpsLatitude     = 0x0002
+	tagGpsLongitudeRef = 0x0003
+	tagGpsLongitude    = 0x0004
+)
+
+// maxIFDEntries guards against corrupt offsets sending the parser through garbage
+const maxIFDEntries = 1000
+
+// field is one raw tag value, decoded by the accessors
+type field struct {
+	typ   uint16
+	count uint32
+	value []byte
+}
+
+// Exif holds the tags of the main image, its EXIF sub-directory and its GPS directory
+type Exif struct {
+	order binary.ByteOrder
+	ifd0  map[uint16]field
+	exif  map[uint16]field
+	gps   map[uint16]field
+}
+
+// Find returns the TIFF structured EXIF block of a JPEG, PNG or WebP image, or nil
+func Find(data []byte) []byte {
+	switch {
+	case bytes.HasPrefix(data, []byte{0xff, 0xd8}):
+		return findJPEG(data)
+	case bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")):
+		return findPNG(data)
+	case len(data) >= 12 && string(data[:4]) == "RIFF" && string(data[8:12]) == "WEBP":
+		return findWebP(data)
+	}
+	return nil
+}
+
+// Read finds and parses the EXIF block of an image
+func Read(data []byte) (*Exif, error) {
+	block := Find(data)
+	if block == nil {
+		return nil, ErrNoExif
+	}
+	return Parse(block)
+}
+
+func findJPEG(data []byte) []byte {
+	for pos := 2; pos+4 <= len(data); {
+		if data[pos] != 0xff {
+			return nil
+		}
+		marker := data[pos+1]
+		if marker == 0xff {
+			pos++
+			continue
+		}
+		// Start of scan or end of image, the metadata segments come before
+		if marker == 0xda || marker == 0xd9 {
+			return nil
+		}
+		length := int(binary.BigEndian.Uint16(data[pos+2:]))
+		if length < 2 || pos+2+length > len(data) {
+			return nil
+		}
+		segment := data[pos+4 : pos+2+length]
+		if marker == 0xe1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return segment[6:]
+		}
+		pos += 2 + length
+	}
+	return nil
+}
+
+func findPNG(data []byte) []byte {
+	for pos := 8; pos+12 <= len(data); {
+		length := int(binary.BigEndian.Uint32(data[pos:]))
+		if pos+12+length > len(data) {
+			return nil
+		}
+		if string(data[pos+4:pos+8]) == "eXIf" {
+			return data[pos+8 : pos+8+length]
+		}
+		pos += 12 + length
+	}
+	return nil
+}
+
+func findWebP(data []byte) []byte {
+	for pos := 12; pos+8 <= len(data); {
+		length := int(binary.LittleEndian.Uint32(data[pos+4:]))
+		if pos+8+length > len(data) {
+			return nil
+		}
+		if string(data[pos:pos+4]) == "EXIF" {
+			// Some writers keep the JPEG style prefix
+			return bytes.TrimPrefix(data[pos+8:pos+8+length], []byte("Exif\x00\x00"))
+		}
+		pos += 8 + length + length%2
+	}
+	return nil
+}
+
+// Parse reads a TIFF structured EXIF block
+func Parse(block []byte) (*Exif, error) {
+	if len(block) < 8 {
+		return nil, ErrNoExif
+	}
+
+	e := &Exif{}
+	switch string(block[:4]) {
+	case "II*\x00":
+		e.order = binary.LittleEndian
+	case "MM\x00*":
+		e.order = binary.BigEndian
+	default:
+		return nil, ErrNoExif
+	}
+
+	e.ifd0 = e.readIFD(block, e.order.Uint32(block[4:]))
+	if e.ifd0 == nil {
+		return nil, ErrNoExif
+	}
+	if offset, ok := e.uint(e.ifd0, tagExifIFD); ok {
+		e.exif = e.readIFD(block, uint32(offset))
+	}
+	if offset, ok := e.uint(e.ifd0, tagGpsIFD); ok {
+		e.gps = e.readIFD(block, uint32(offset))
+	}
+	return e, nil
+}
+
+// typeSizes is the size in bytes of one value of each TIFF field type
+var typeSizes = map[uint16]uint32{
+	1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 6: 1, 7: 1, 8: 2, 9: 4, 10: 8, 11: 4, 12: 8,
+}
+
+func (e *Exif) readIFD(block []byte, offset uint32) map[uint16]field {
+	if offset < 8 || uint64(offset)+2 > uint64(len(block)) {
+		return nil
+	}
+	count := int(e.order.Uint16(block[offset:]))
+	if count > maxIFDEntries {
+		return nil
+	}
+
+	fields := make(map[uint16]field, count)
+	for i := 0; i < count; i++ {
+		entry := uint64(offset) + 2 + uint64(i)*12
+		if entry+12 > uint64(len(block)) {
+			break
+		}
+		tag := e.order.Uint16(block[entry:])
+		typ := e.order.Uint16(block[entry+2:])
+		valueCount := e.order.Uint32(block[entry+4:])
+
+		size, ok := typeSizes[typ]
+		if !ok {
+			continue
+		}
+		total := uint64(size) * uint64(valueCount)
+		var value []byte
+		if total <= 4 {
+			value = block[entry+8 : entry+8+total]
+		} else {
+			start := uint64(e.order.Uint32(block[entry+8:]))
+			if start+total > uint64(len(block)) {
+				continue
+			}
+			value = block[start : start+total]
+		}
+		fields[tag] = field{typ: typ, count: valueCount, value: value}
+	}
+	return fields
+}
+
+// uint reads the first value of an unsigned integer tag
+func (e *Exif) uint(fields map[uint16]field, tag uint16) (uint64, bool) {
+	f, ok := fields[tag]
+	if !ok || f.count == 0 {
+		return 0, false
+	}
+	switch f.typ {
+	case 1, 7:
+		return uint64(f.value[0]), true
+	case 3:
+		return uint64(e.order.Uint16(f.value)), true
+	case 4:
+		return uint64(e.order.Uint32(f.value)), true
+	}
+	return 0, false
+}
+
+// Orientation is how the stored pixels must be turned to display the image
+// upright, 1 to 8 as defined by the TIFF spec. It is 1 when not recorded.
+func (e *Exif) Orientation() int {
+	orientation, ok := e.uint(e.ifd0, tagOrientation)
+	if !ok || orientation < 1 || orientation > 8 {
+		return 1
+	}
+	return int(orientation)
+}
+
+// string reads an ASCII tag, without the trailing NUL and padding
+func (e *Exif) string(fields map[uint16]field, tag uint16) string {
+	f, ok := fields[tag]
+	if !ok || f.typ != 2 {
+		return ""
+	}
+	value, _, _ := bytes.Cut(f.value, []byte{0})
+	return strings.TrimSpace(string(value))
+}
+
+// rationals reads an unsigned rational tag as floats
+func (e *Exif) rationals(fields map[uint16]field, tag uint16) []float64 {
+	f, ok := fields[tag]
+	if !ok || f.typ != 5 {
+		return nil
+	}
+	values := make([]float64, 0, f.count)
+	for i := 0; i+8 <= len(f.value); i += 8 {
+		numerator := e.order.Uint32(f.value[i:])
+		denominator := e.order.Uint32(f.value[i+4:])
+		if denominator == 0 {
+			return nil
+		}
+		values = append(values, float64(numerator)/float64(denominator))
+	}
+	return values
+}
+
+// Camera is the make and model of the camera that took the picture
+func (e *Exif) Camera() (string, string) {
+	return e.string(e.ifd0, tagMake), e.string(e.ifd0, tagModel)
+}
+
+// TakenAt is when the picture was taken. Cameras record local time without a
+// zone unless they also store the offset, so it is read as UTC otherwise.
+func (e *Exif) TakenAt() (time.Time, bool) {
+	value := e.string(e.exif, tagDateTimeOriginal)
+	if value == "" {
+		value = e.string(e.ifd0, tagDateTime)
+	}
+	if value == "" {
+		return time.Time{}, false
+	}
+
+	if offset := e.string(e.exif, tagOffsetTimeOrig); offset != "" {
+		if taken, err := time.Parse("2006:01:02 15:04:05 -07:00", value+" "+offset); err == nil {
+			return taken, true
+		}
+	}
+	taken, err := time.Parse("2006:01:02 15:04:05", value)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return taken, true
+}
+
+// Location is where the picture was taken in decimal degrees, south and west negative
+func (e *Exif) Location() (float64, float64, bool) {
+	latitude, ok := e.degrees(tagGpsLatitude, tagGpsLatitudeRef, "S")
+	if !ok {
+		return 0, 0, false
+	}
+	longitude, ok := e.degrees(tagGpsLongitude, tagGpsLongitudeRef, "W")
+	if !ok {
+		return 0, 0, false
+	}
+	// 0,0 is what some phones write when they had no fix
+	if latitude == 0 && longitude == 0 {
+		return 0, 0, false
+	}
+	return latitude, longitude, true
+}
+
+// degrees reads a GPS coordinate stored as degrees, minutes and seconds
+func (e *Exif) degrees(tag uint16, refTag uint16, negative string) (float64, bool) {
+	parts := e.rationals(e.gps, tag)
+	if len(parts) != 3 {
+		return 0, false
+	}
+	degrees := parts[0] + parts[1]/60 + parts[2]/3600
+	if e.string(e.gps, refTag) == negative {
+		degrees = -degrees
+	}
+	return degrees, true
+}
@@ -0,0 +1,445 @@
+package exif
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"testing"
+	"time"
+)
+
+// byteOrder both reads and appends, like binary.LittleEndian and binary.BigEndian
+type byteOrder interface {
+	binary.ByteOrder
+	binary.AppendByteOrder
+}
+
+// tiffEntry is one tag of a test block. Entries with sub are pointers to
+// another directory, laid out after the one holding them.
+type tiffEntry struct {
+	tag   uint16
+	typ   uint16
+	count uint32
+	value func(order byteOrder) []byte
+	sub   []tiffEntry
+}
+
+func ascii(tag uint16, value string) tiffEntry {
+	return tiffEntry{tag: tag, typ: 2, count: uint32(len(value) + 1), value: func(byteOrder) []byte {
+		return append([]byte(value), 0)
+	}}
+}
+
+func short(tag uint16, value uint16) tiffEntry {
+	return tiffEntry{tag: tag, typ: 3, count: 1, value: func(order byteOrder) []byte {
+		return order.AppendUint16(nil, value)
+	}}
+}
+
+// rationals takes numerator and denominator pairs
+func rationals(tag uint16, values ...uint32) tiffEntry {
+	return tiffEntry{tag: tag, typ: 5, count: uint32(len(values) / 2), value: func(order byteOrder) []byte {
+		var out []byte
+		for _, value := range values {
+			out = order.AppendUint32(out, value)
+		}
+		return out
+	}}
+}
+
+func pointer(tag uint16, entries ...tiffEntry) tiffEntry {
+	return tiffEntry{tag: tag, typ: 4, count: 1, sub: entries}
+}
+
+type tiffBuilder struct {
+	order byteOrder
+	out   []byte
+}
+
+// buildTIFF lays out a TIFF structured block with entries as its first directory
+func buildTIFF(order byteOrder, entries ...tiffEntry) []byte {
+	b := &tiffBuilder{order: order}
+	if order == binary.LittleEndian {
+		b.out = []byte("II*\x00")
+	} else {
+		b.out = []byte("MM\x00*")
+	}
+	b.out = order.AppendUint32(b.out, 8)
+	b.ifd(entries)
+	return b.out
+}
+
+func (b *tiffBuilder) ifd(entries []tiffEntry) uint32 {
+	offset := uint32(len(b.out))
+	b.out = b.order.AppendUint16(b.out, uint16(len(entries)))
+	b.out = append(b.out, make([]byte, 12*len(entries)+4)...)
+
+	for i, entry := range entries {
+		at := offset + 2 + uint32(i)*12
+		var value []byte
+		if entry.sub != nil {
+			value = b.order.AppendUint32(nil, b.ifd(entry.sub))
+		} else {
+			value = entry.value(b.order)
+		}
+		b.order.PutUint16(b.out[at:], entry.tag)
+		b.order.PutUint16(b.out[at+2:], entry.typ)
+		b.order.PutUint32(b.out[at+4:], entry.count)
+		if len(value) <= 4 {
+			copy(b.out[at+8:], value)
+			continue
+		}
+		b.order.PutUint32(b.out[at+8:], uint32(len(b.out)))
+		b.out = append(b.out, value...)
+	}
+	return offset
+}
+
+// photo is the block of a phone picture: camera, rotation, time and place
+func photo(order byteOrder) []byte {
+	return buildTIFF(order,
+		ascii(tagMake, "Canon"),
+		ascii(tagModel, "Canon EOS R5"),
+		short(tagOrientation, 6),
+		pointer(tagExifIFD,
+			ascii(tagDateTimeOriginal, "2024:05:01 14:30:00"),
+			ascii(tagOffsetTimeOrig, "+02:00"),
+		),
+		pointer(tagGpsIFD,
+			ascii(tagGpsLatitudeRef, "S"),
+			rationals(tagGpsLatitude, 33, 1, 51, 1, 3540, 100),
+			ascii(tagGpsLongitudeRef, "E"),
+			rationals(tagGpsLongitude, 151, 1, 12, 1, 3000, 100),
+		),
+	)
+}
+
+func jpegWith(segments ...[]byte) []byte {
+	out := []byte{0xff, 0xd8}
+	for _, segment := range segments {
+		out = append(out, segment...)
+	}
+	// Start of scan, then pixels that must never be searched
+	return append(out, 0xff, 0xda, 0x00, 0x02, 0xff, 0xe1, 0xff, 0xd9)
+}
+
+func jpegSegment(marker byte, payload []byte) []byte {
+	out := []byte{0xff, marker}
+	out = binary.BigEndian.AppendUint16(out, uint16(len(payload)+2))
+	return append(out, payload...)
+}
+
+func pngWith(chunks ...[]byte) []byte {
+	out := []byte("\x89PNG\r\n\x1a\n")
+	for _, chunk := range chunks {
+		out = append(out, chunk...)
+	}
+	return out
+}
+
+func pngChunk(kind string, payload []byte) []byte {
+	out := binary.BigEndian.AppendUint32(nil, uint32(len(payload)))
+	out = append(out, kind...)
+	out = append(out, payload...)
+	// The checksum isn't checked
+	return append(out, 0, 0, 0, 0)
+}
+
+func webpWith(chunks ...[]byte) []byte {
+	var body []byte
+	for _, chunk := range chunks {
+		body = append(body, chunk...)
+	}
+	out := []byte("RIFF")
+	out = binary.LittleEndian.AppendUint32(out, uint32(len(body)+4))
+	out = append(out, "WEBP"...)
+	return append(out, body...)
+}
+
+func webpChunk(kind string, payload []byte) []byte {
+	out := []byte(kind)
+	out = binary.LittleEndian.AppendUint32(out, uint32(len(payload)))
+	out = append(out, payload...)
+	if len(payload)%2 == 1 {
+		out = append(out, 0)
+	}
+	return out
+}
+
+func near(got float64, want float64) bool {
+	return math.Abs(got-want) < 1e-6
+}
+
+func TestParse(t *testing.T) {
+	for name, order := range map[string]byteOrder{"little endian": binary.LittleEndian, "big endian": binary.BigEndian} {
+		t.Run(name, func(t *testing.T) {
+			tags, err := Parse(photo(order))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cameraMake, model := tags.Camera(); cameraMake != "Canon" || model != "Canon EOS R5" {
+				t.Errorf("camera = %q %q", cameraMake, model)
+			}
+			if got := tags.Orientation(); got != 6 {
+				t.Errorf("orientation = %d, want 6", got)
+			}
+			want := time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC)
+			if takenAt, ok := tags.TakenAt(); !ok || !takenAt.Equal(want) {
+				t.Errorf("taken at %v, want %v", takenAt, want)
+			}
+			latitude, longitude, ok := tags.Location()
+			if !ok || !near(latitude, -(33+51.0/60+35.4/3600)) || !near(longitude, 151+12.0/60+30.0/3600) {
+				t.Errorf("location = %v, %v, %v", latitude, longitude, ok)
+			}
+		})
+	}
+}
+
+func TestParseFallbacks(t *testing.T) {
+	tests := []struct {
+		name        string
+		block       []byte
+		orientation int
+		takenAt     time.Time
+		location    bool
+	}{
+		{
+			"no tags",
+			buildTIFF(binary.LittleEndian),
+			1, time.Time{}, false,
+		},
+		{
+			"date without an offset is read as UTC",
+			buildTIFF(binary.BigEndian, ascii(tagDateTime, "2023:12:31 23:59:59")),
+			1, time.Date(2023, 12, 31, 23, 59, 59, 0, time.UTC), false,
+		},
+		{
+			"bad offset falls back to UTC",
+			buildTIFF(binary.LittleEndian, pointer(tagExifIFD,
+				ascii(tagDateTimeOriginal, "2023:01:02 03:04:05"),
+				ascii(tagOffsetTimeOrig, "soon"),
+			)),
+			1, time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC), false,
+		},
+		{
+			"unparseable date",
+			buildTIFF(binary.LittleEndian, ascii(tagDateTime, "0000:00:00 00:00:00")),
+			1, time.Time{}, false,
+		},
+		{
+			"orientation out of range",
+			buildTIFF(binary.LittleEndian, short(tagOrientation, 9)),
+			1, time.Time{}, false,
+		},
+		{
+			"orientation of the wrong type",
+			buildTIFF(binary.LittleEndian, ascii(tagOrientation, "6")),
+			1, time.Time{}, false,
+		},
+		{
+			"no fix at 0,0",
+			buildTIFF(binary.LittleEndian, pointer(tagGpsIFD,
+				rationals(tagGpsLatitude, 0, 1, 0, 1, 0, 1),
+				rationals(tagGpsLongitude, 0, 1, 0, 1, 0, 1),
+			)),
+			1, time.Time{}, false,
+		},
+		{
+			"zero denominator",
+			buildTIFF(binary.LittleEndian, pointer(tagGpsIFD,
+				rationals(tagGpsLatitude, 1, 0, 2, 1, 3, 1),
+				rationals(tagGpsLongitude, 1, 1, 2, 1, 3, 1),
+			)),
+			1, time.Time{}, false,
+		},
+		{
+			"two part coordinates",
+			buildTIFF(binary.LittleEndian, pointer(tagGpsIFD,
+				rationals(tagGpsLatitude, 1, 1, 2, 1),
+				rationals(tagGpsLongitude, 1, 1, 2, 1),
+			)),
+			1, time.Time{}, false,
+		},
+		{
+			"latitude without longitude",
+			buildTIFF(binary.LittleEndian, pointer(tagGpsIFD, rationals(tagGpsLatitude, 1, 1, 2, 1, 3, 1))),
+			1, time.Time{}, false,
+		},
+		{
+			"directory pointing back at itself",
+			buildTIFF(binary.LittleEndian, short(tagOrientation, 3), short(tagExifIFD, 8), short(tagGpsIFD, 8)),
+			3, time.Time{}, false,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tags, err := Parse(test.block)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := tags.Orientation(); got != test.orientation {
+				t.Errorf("orientation = %d, want %d", got, test.orientation)
+			}
+			takenAt, ok := tags.TakenAt()
+			if ok != !test.takenAt.IsZero() || !takenAt.Equal(test.takenAt) {
+				t.Errorf("taken at %v, %v, want %v", takenAt, ok, test.takenAt)
+			}
+			if _, _, ok := tags.Location(); ok != test.location {
+				t.Errorf("location found = %v, want %v", ok, test.location)
+			}
+		})
+	}
+}
+
+func TestParseMalformed(t *testing.T) {
+	valid := buildTIFF(binary.LittleEndian, ascii(tagMake, "Canon"), ascii(tagModel, "Canon EOS R5"))
+	// The first entry starts after the header and the entry count
+	const entry = 8 + 2
+
+	patched := func(at int, value uint32) []byte {
+		block := append([]byte(nil), valid...)
+		binary.LittleEndian.PutUint32(block[at:], value)
+		return block
+	}
+	patched16 := func(at int, value uint16) []byte {
+		block := append([]byte(nil), valid...)
+		binary.LittleEndian.PutUint16(block[at:], value)
+		return block
+	}
+
+	tests := []struct {
+		name       string
+		block      []byte
+		err        error
+		cameraMake string
+	}{
+		{"empty", nil, ErrNoExif, ""},
+		{"header only", valid[:7], ErrNoExif, ""},
+		{"unknown byte order", append([]byte("XX*\x00"), valid[4:]...), ErrNoExif, ""},
+		{"first directory past the end", patched(4, 0xffffffff), ErrNoExif, ""},
+		{"first directory inside the header", patched(4, 2), ErrNoExif, ""},
+		{"too many entries", patched16(8, maxIFDEntries+1), ErrNoExif, ""},
+		{"more entries than fit", patched16(8, maxIFDEntries), nil, "Canon"},
+		{"huge value count", patched(entry+4, 0xffffffff), nil, ""},
+		{"value past the end", patched(entry+8, 0xfffffff0), nil, ""},
+		{"value offset wrapping around", patched(entry+8, 0xffffffff), nil, ""},
+		{"unknown type", patched16(entry+2, 99), nil, ""},
+		{"zero count", patched(entry+4, 0), nil, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tags, err := Parse(test.block)
+			if !errors.Is(err, test.err) {
+				t.Fatalf("err = %v, want %v", err, test.err)
+			}
+			if err != nil {
+				return
+			}
+			if cameraMake, _ := tags.Camera(); cameraMake != test.cameraMake {
+				t.Errorf("make = %q, want %q", cameraMake, test.cameraMake)
+			}
+			tags.Orientation()
+			tags.TakenAt()
+			tags.Location()
+		})
+	}
+}
+
+func TestFind(t *testing.T) {
+	block := photo(binary.LittleEndian)
+
+	tests := []struct {
+		name string
+		data []byte
+		want []byte
+	}{
+		{"jpeg", jpegWith(jpegSegment(0xe0, []byte("JFIF\x00\x01\x01")), jpegSegment(0xe1, append([]byte("Exif\x00\x00"), block...))), block},
+		{"jpeg with fill bytes", append([]byte{0xff, 0xd8, 0xff, 0xff}, jpegSegment(0xe1, append([]byte("Exif\x00\x00"), block...))[1:]...), block},
+		{"jpeg xmp segment is not exif", jpegWith(jpegSegment(0xe1, []byte("http://ns.adobe.com/xap/1.0/\x00<x/>"))), nil},
+		{"jpeg exif after the pixels", append(jpegWith(), jpegSegment(0xe1, append([]byte("Exif\x00\x00"), block...))...), nil},
+		{"png", pngWith(pngChunk("IHDR", make([]byte, 13)), pngChunk("eXIf", block), pngChunk("IEND", nil)), block},
+		{"png without exif", pngWith(pngChunk("IHDR", make([]byte, 13)), pngChunk("IEND", nil)), nil},
+		{"webp", webpWith(webpChunk("VP8L", []byte{0x2f, 0, 0, 0, 0}), webpChunk("EXIF", block)), block},
+		{"webp with the jpeg prefix", webpWith(webpChunk("EXIF", append([]byte("Exif\x00\x00"), block...))), block},
+		{"gif", []byte("GIF89a"), nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := Find(test.data); !bytes.Equal(got, test.want) {
+				t.Fatalf("found %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestFindOversizedLengths(t *testing.T) {
+	block := photo(binary.LittleEndian)
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"jpeg segment past the end", jpegWith([]byte{0xff, 0xe1, 0xff, 0xff, 'E', 'x', 'i', 'f', 0, 0})},
+		{"jpeg segment shorter than its length field", jpegWith([]byte{0xff, 0xe1, 0x00, 0x01})},
+		{"png chunk past the end", pngWith(binary.BigEndian.AppendUint32(nil, 0xffffffff), []byte("eXIf"), block)},
+		{"png chunk length near the int limit", pngWith(binary.BigEndian.AppendUint32(nil, 0x7ffffff5), []byte("eXIf"), block)},
+		{"webp chunk past the end", webpWith([]byte("EXIF"), binary.LittleEndian.AppendUint32(nil, 0xffffffff), block)},
+		{"webp odd chunk past the end", webpWith([]byte("EXIF"), binary.LittleEndian.AppendUint32(nil, 0xfffffffd), block)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := Find(test.data); got != nil {
+				t.Fatalf("found %d bytes", len(got))
+			}
+			if _, err := Read(test.data); !errors.Is(err, ErrNoExif) {
+				t.Fatalf("err = %v, want ErrNoExif", err)
+			}
+		})
+	}
+}
+
+func TestReadTruncated(t *testing.T) {
+	block := photo(binary.BigEndian)
+	images := map[string][]byte{
+		"tiff": block,
+		"jpeg": jpegWith(jpegSegment(0xe1, append([]byte("Exif\x00\x00"), block...))),
+		"png":  pngWith(pngChunk("IHDR", make([]byte, 13)), pngChunk("eXIf", block)),
+		"webp": webpWith(webpChunk("VP8X", make([]byte, 10)), webpChunk("EXIF", block)),
+	}
+	for name, data := range images {
+		t.Run(name, func(t *testing.T) {
+			for end := range data {
+				var tags *Exif
+				var err error
+				if name == "tiff" {
+					tags, err = Parse(data[:end])
+				} else {
+					tags, err = Read(data[:end])
+				}
+				if err != nil {
+					continue
+				}
+				tags.Camera()
+				tags.Orientation()
+				tags.TakenAt()
+				tags.Location()
+			}
+		})
+	}
+}
+
+func FuzzParse(f *testing.F) {
+	f.Add(photo(binary.LittleEndian))
+	f.Add(photo(binary.BigEndian))
+	f.Add(buildTIFF(binary.LittleEndian, pointer(tagGpsIFD, rationals(tagGpsLatitude, 1, 0))))
+	f.Fuzz(func(t *testing.T, block []byte) {
+		tags, err := Parse(block)
+		if err != nil {
+			return
+		}
+		tags.Camera()
+		tags.Orientation()
+		tags.TakenAt()
+		tags.Location()
+	})
+}
@@ -0,0 +1,184 @@
+// Package extract pulls plain text out of uploaded documents so their
+// contents can be searched. Each format has its own Extractor, looked up by
+// MIME type with the file extension as a fallback.
+package extract
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// MaxTextBytes caps how much text is kept per document. Postgres tsvectors
+// top out at 1MB, and the start of a document is what people search for.
+const MaxTextBytes = 256 * 1024
+
+// maxPartBytes caps how much one compressed stream or zip entry is inflated
+// to, so a tiny compressed document can't expand into gigabytes
+const maxPartBytes = 64 << 20
+
+// maxInflatedBytes caps how much all the parts of one document inflate to
+// together, so a document packed with small compressed parts can't keep the
+// indexer busy for long either
+const maxInflatedBytes = 256 << 20
+
+var ErrUnsupported = errors.New("no text extractor for this file type")
+
+// Extractor reads the document in r, size bytes long, and writes its text to w
+type Extractor interface {
+	Extract(r io.ReaderAt, size int64, w *Writer) error
+}
+
+var (
+	textExtractor = plainText{}
+	docxExtractor = ooxml{parts: []string{"word/document.xml", "word/footnotes.xml", "word/endnotes.xml"}}
+	pptxExtractor = ooxml{prefix: "ppt/slides/slide"}
+	xlsxExtractor = spreadsheet{}
+	pdfExtractor  = pdf{}
+)
+
+var byType = map[string]Extractor{
+	"text/plain":      textExtractor,
+	"text/markdown":   textExtractor,
+	"text/x-markdown": textExtractor,
+	"text/csv":        textExtractor,
+	"application/pdf": pdfExtractor,
+	"application/vnd.openxmlformats-officedocument.wordprocessingml.document":   docxExtractor,
+	"application/vnd.openxmlformats-officedocument.presentationml.presentation": pptxExtractor,
+	"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet":         xlsxExtractor,
+}
+
+var byExtension = map[string]Extractor{
+	".txt":      textExtractor,
+	".text":     textExtractor,
+	".md":       textExtractor,
+	".markdown": textExtractor,
+	".csv":      textExtractor,
+	".pdf":      pdfExtractor,
+	".docx":     docxExtractor,
+	".pptx":     pptxExtractor,
+	".xlsx":     xlsxExtractor,
+}
+
+// For returns the extractor for a file, or nil when its format isn't supported
+func For(fileType string, fileName string) Extractor {
+	mediaType := strings.ToLower(strings.TrimSpace(strings.SplitN(fileType, ";", 2)[0]))
+	if extractor, ok := byType[mediaType]; ok {
+		return extractor
+	}
+	return byExtension[strings.ToLower(path.Ext(fileName))]
+}
+
+// Supported reports whether For finds an extractor for the file
+func Supported(fileType string, fileName string) bool {
+	return For(fileType, fileName) != nil
+}
+
+// Text extracts the text of a document, at most MaxTextBytes of it. A
+// document that trips up its extractor fails instead of taking the indexer down.
+func Text(fileType string, fileName string, r io.ReaderAt, size int64) (text string, err error) {
+	extractor := For(fileType, fileName)
+	if extractor == nil {
+		return "", ErrUnsupported
+	}
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			text, err = "", fmt.Errorf("extracting text failed: %v", recovered)
+		}
+	}()
+
+	w := &Writer{}
+	if err := extractor.Extract(r, size, w); err != nil && !errors.Is(err, errFull) {
+		return "", err
+	}
+	return strings.TrimSpace(w.b.String()), nil
+}
+
+// errFull stops an extractor early once the Writer has all it will take
+var errFull = errors.New("extracted text limit reached")
+
+// inflateBudget is what is left of maxInflatedBytes for one document
+type inflateBudget struct {
+	left int64
+}
+
+func newInflateBudget() *inflateBudget {
+	return &inflateBudget{left: maxInflatedBytes}
+}
+
+// reader inflates at most maxPartBytes from r and fails with errFull once
+// the document's budget is spent, keeping the text read so far
+func (b *inflateBudget) reader(r io.Reader) io.Reader {
+	return &budgetReader{r: io.LimitReader(r, maxPartBytes), budget: b}
+}
+
+type budgetReader struct {
+	r      io.Reader
+	budget *inflateBudget
+}
+
+func (br *budgetReader) Read(p []byte) (int, error) {
+	if br.budget.left <= 0 {
+		return 0, errFull
+	}
+	if int64(len(p)) > br.budget.left {
+		p = p[:br.budget.left]
+	}
+	n, err := br.r.Read(p)
+	br.budget.left -= int64(n)
+	return n, err
+}
+
+// Writer collects extracted text. It drops control characters and invalid
+// UTF-8, which Postgres won't store, collapses runs of blank space and stops
+// at MaxTextBytes.
+type Writer struct {
+	b     strings.Builder
+	space bool
+	line  bool
+}
+
+// WriteString appends s, failing with errFull once the limit is reached.
+// Extractors hand that error back so the document isn't read any further.
+func (w *Writer) WriteString(s string) error {
+	for _, r := range s {
+		switch {
+		case r == '\n' || r == '\r' || r == '\f' || r == '\v':
+			w.Break()
+		case unicode.IsSpace(r):
+			w.Space()
+		case unicode.IsControl(r) || r == utf8.RuneError:
+			continue
+		default:
+			if w.b.Len()+utf8.RuneLen(r)+1 > MaxTextBytes {
+				return errFull
+			}
+			if w.line {
+				w.b.WriteByte('\n')
+			} else if w.space {
+				w.b.WriteByte(' ')
+			}
+			w.space, w.line = false, false
+			w.b.WriteRune(r)
+		}
+	}
+	return nil
+}
+
+// Space separates the next text from what came before
+func (w *Writer) Space() {
+	if w.b.Len() > 0 {
+		w.space = true
+	}
+}
+
+// Break starts the next text on a new line
+func (w *Writer) Break() {
+	if w.b.Len() > 0 {
+		w.line = true
+	}
+}
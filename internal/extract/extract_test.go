@@ -0,0 +1,127 @@
+package extract
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// extractTimeout is far longer than any of these documents take, reaching it
+// means an extractor is stuck
+const extractTimeout = 10 * time.Second
+
+// runExtractor extracts data like Text does, failing the test if the
+// extractor panics or doesn't return in time
+func runExtractor(t *testing.T, extractor Extractor, data []byte) (string, error) {
+	t.Helper()
+
+	type outcome struct {
+		text string
+		err  error
+	}
+	done := make(chan outcome, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				done <- outcome{err: fmt.Errorf("panic: %v", r)}
+			}
+		}()
+		w := &Writer{}
+		err := extractor.Extract(bytes.NewReader(data), int64(len(data)), w)
+		if errors.Is(err, errFull) {
+			err = nil
+		}
+		done <- outcome{text: strings.TrimSpace(w.b.String()), err: err}
+	}()
+
+	select {
+	case result := <-done:
+		if result.err != nil && strings.HasPrefix(result.err.Error(), "panic: ") {
+			t.Fatal(result.err)
+		}
+		return result.text, result.err
+	case <-time.After(extractTimeout):
+		t.Fatalf("extractor did not return within %s", extractTimeout)
+		return "", nil
+	}
+}
+
+// eachPrefix runs check on every truncation of data, stepping so large
+// documents stay quick
+func eachPrefix(data []byte, check func(prefix []byte)) {
+	step := len(data)/512 + 1
+	for end := 0; end < len(data); end += step {
+		check(data[:end])
+	}
+}
+
+func TestFor(t *testing.T) {
+	tests := []struct {
+		fileType string
+		fileName string
+		want     Extractor
+	}{
+		{"text/plain", "notes", textExtractor},
+		{"text/plain; charset=utf-8", "notes", textExtractor},
+		{"TEXT/MARKDOWN", "readme", textExtractor},
+		{"application/octet-stream", "REPORT.PDF", pdfExtractor},
+		{"", "slides.pptx", pptxExtractor},
+		{"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", "book", xlsxExtractor},
+		{"image/png", "photo.png", nil},
+		{"application/octet-stream", "archive.zip", nil},
+	}
+	for _, test := range tests {
+		got := For(test.fileType, test.fileName)
+		if fmt.Sprintf("%#v", got) != fmt.Sprintf("%#v", test.want) {
+			t.Errorf("For(%q, %q) = %#v, want %#v", test.fileType, test.fileName, got, test.want)
+		}
+	}
+}
+
+func TestPlainText(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"plain", "Hello world", "Hello world"},
+		{"blank space collapses", "  one \t two\n\n\n three  ", "one two\nthree"},
+		{"crlf line endings", "first\r\nsecond\r\n", "first\nsecond"},
+		{"markdown is kept", "# Title\n\n- item *one*", "# Title\n- item *one*"},
+		{"csv", "name,size\nreport.pdf,10", "name,size\nreport.pdf,10"},
+		{"control characters are dropped", "tab\x00le\x07s", "tables"},
+		{"invalid utf-8 is dropped", "caf\xc3\xa9 \xff\xfebar", "café bar"},
+		{"empty", "", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := runExtractor(t, textExtractor, []byte(test.input))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != test.want {
+				t.Fatalf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestTextStopsAtMaxTextBytes(t *testing.T) {
+	input := strings.Repeat("word ", MaxTextBytes)
+	got, err := Text("text/plain", "big.txt", strings.NewReader(input), int64(len(input)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) > MaxTextBytes || len(got) < MaxTextBytes-len("word ") {
+		t.Fatalf("kept %d bytes, want just under %d", len(got), MaxTextBytes)
+	}
+}
+
+func TestTextUnsupported(t *testing.T) {
+	if _, err := Text("image/png", "photo.png", strings.NewReader("x"), 1); !errors.Is(err, ErrUnsupported) {
+		t.Fatalf("err = %v, want ErrUnsupported", err)
+	}
+}
@@ -0,0 +1,238 @@
+package extract
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"errors"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ooxml reads Word and PowerPoint documents. Both keep their text in <t>
+// elements of the XML parts listed in parts, or of every part whose name
+// starts with prefix.
+type ooxml struct {
+	parts  []string
+	prefix string
+}
+
+func (o ooxml) Extract(r io.ReaderAt, size int64, w *Writer) error {
+	archive, err := zip.NewReader(r, size)
+	if err != nil {
+		return err
+	}
+
+	budget := newInflateBudget()
+	for _, part := range o.find(archive) {
+		if err := readXML(part, budget, func(d *xml.Decoder, token xml.Token) error {
+			switch t := token.(type) {
+			case xml.StartElement:
+				switch t.Name.Local {
+				case "t":
+					text, err := elementText(d)
+					if err != nil {
+						return err
+					}
+					return w.WriteString(text)
+				case "tab":
+					w.Space()
+				case "br", "cr":
+					w.Break()
+				}
+			case xml.EndElement:
+				if t.Name.Local == "p" {
+					w.Break()
+				}
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+		w.Break()
+	}
+	return nil
+}
+
+func (o ooxml) find(archive *zip.Reader) []*zip.File {
+	var found []*zip.File
+	if o.prefix != "" {
+		for _, file := range archive.File {
+			if strings.HasPrefix(file.Name, o.prefix) && strings.HasSuffix(file.Name, ".xml") {
+				found = append(found, file)
+			}
+		}
+		sortParts(found, o.prefix)
+		return found
+	}
+
+	for _, name := range o.parts {
+		for _, file := range archive.File {
+			if file.Name == name {
+				found = append(found, file)
+				break
+			}
+		}
+	}
+	return found
+}
+
+// spreadsheet reads Excel workbooks. Cells that hold text point into the
+// shared strings table, the rest keep their value inline.
+type spreadsheet struct{}
+
+func (spreadsheet) Extract(r io.ReaderAt, size int64, w *Writer) error {
+	archive, err := zip.NewReader(r, size)
+	if err != nil {
+		return err
+	}
+
+	budget := newInflateBudget()
+	var shared []string
+	var sheets []*zip.File
+	for _, file := range archive.File {
+		switch {
+		case file.Name == "xl/sharedStrings.xml":
+			if shared, err = sharedStrings(file, budget); err != nil {
+				return err
+			}
+		case strings.HasPrefix(file.Name, "xl/worksheets/sheet") && strings.HasSuffix(file.Name, ".xml"):
+			sheets = append(sheets, file)
+		}
+	}
+	sortParts(sheets, "xl/worksheets/sheet")
+
+	for _, sheet := range sheets {
+		cellType := ""
+		if err := readXML(sheet, budget, func(d *xml.Decoder, token xml.Token) error {
+			switch t := token.(type) {
+			case xml.StartElement:
+				switch t.Name.Local {
+				case "c":
+					cellType = attr(t, "t")
+				case "v":
+					value, err := elementText(d)
+					if err != nil {
+						return err
+					}
+					if cellType == "s" {
+						index, err := strconv.Atoi(strings.TrimSpace(value))
+						if err != nil || index < 0 || index >= len(shared) {
+							return nil
+						}
+						value = shared[index]
+					}
+					w.Space()
+					return w.WriteString(value)
+				case "t":
+					// Inline strings, <is><t>
+					value, err := elementText(d)
+					if err != nil {
+						return err
+					}
+					w.Space()
+					return w.WriteString(value)
+				}
+			case xml.EndElement:
+				if t.Name.Local == "row" {
+					w.Break()
+				}
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+		w.Break()
+	}
+	return nil
+}
+
+// sharedStrings reads the workbook's string table. A rich text entry is split
+// into runs, each with its own <t>.
+func sharedStrings(file *zip.File, budget *inflateBudget) ([]string, error) {
+	var shared []string
+	var current strings.Builder
+	err := readXML(file, budget, func(d *xml.Decoder, token xml.Token) error {
+		switch t := token.(type) {
+		case xml.StartElement:
+			if t.Name.Local == "t" {
+				text, err := elementText(d)
+				if err != nil {
+					return err
+				}
+				current.WriteString(text)
+			}
+		case xml.EndElement:
+			if t.Name.Local == "si" {
+				shared = append(shared, current.String())
+				current.Reset()
+			}
+		}
+		return nil
+	})
+	return shared, err
+}
+
+// readXML calls visit with every token of an XML part
+func readXML(file *zip.File, budget *inflateBudget, visit func(*xml.Decoder, xml.Token) error) error {
+	content, err := file.Open()
+	if err != nil {
+		return err
+	}
+	defer content.Close()
+
+	d := xml.NewDecoder(budget.reader(content))
+	for {
+		token, err := d.Token()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := visit(d, token); err != nil {
+			return err
+		}
+	}
+}
+
+// elementText reads the character data up to the end of the element that was just started
+func elementText(d *xml.Decoder) (string, error) {
+	var text strings.Builder
+	for depth := 1; depth > 0; {
+		token, err := d.Token()
+		if err != nil {
+			return "", err
+		}
+		switch t := token.(type) {
+		case xml.CharData:
+			text.Write(t)
+		case xml.StartElement:
+			depth++
+		case xml.EndElement:
+			depth--
+		}
+	}
+	return text.String(), nil
+}
+
+func attr(element xml.StartElement, name string) string {
+	for _, a := range element.Attr {
+		if a.Name.Local == name {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+// sortParts orders numbered parts like slide2.xml before slide10.xml
+func sortParts(parts []*zip.File, prefix string) {
+	number := func(file *zip.File) int {
+		n, _ := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(file.Name, prefix), ".xml"))
+		return n
+	}
+	sort.SliceStable(parts, func(i, j int) bool {
+		return number(parts[i]) < number(parts[j])
+	})
+}
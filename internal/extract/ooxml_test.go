@@ -0,0 +1,228 @@
+package extract
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+type zipEntry struct {
+	name    string
+	content string
+}
+
+func buildZip(t *testing.T, entries ...zipEntry) []byte {
+	t.Helper()
+	var out bytes.Buffer
+	archive := zip.NewWriter(&out)
+	for _, entry := range entries {
+		w, err := archive.Create(entry.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(entry.content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return out.Bytes()
+}
+
+const wordNS = `xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main"`
+
+func wordPart(body string) string {
+	return `<?xml version="1.0" encoding="UTF-8" standalone="yes"?><w:document ` + wordNS + `><w:body>` + body + `</w:body></w:document>`
+}
+
+func slidePart(text string) string {
+	return `<p:sld xmlns:p="http://schemas.openxmlformats.org/presentationml/2006/main" xmlns:a="http://schemas.openxmlformats.org/drawingml/2006/main"><p:cSld><p:spTree><p:sp><p:txBody><a:p><a:r><a:t>` + text + `</a:t></a:r></a:p></p:txBody></p:sp></p:spTree></p:cSld></p:sld>`
+}
+
+func TestOOXMLText(t *testing.T) {
+	tests := []struct {
+		name      string
+		extractor Extractor
+		entries   []zipEntry
+		want      string
+	}{
+		{
+			"docx paragraphs, tabs and breaks",
+			docxExtractor,
+			[]zipEntry{
+				{"[Content_Types].xml", "<Types/>"},
+				{"word/document.xml", wordPart(`<w:p><w:r><w:t>First</w:t><w:tab/><w:t>line</w:t></w:r></w:p><w:p><w:r><w:t xml:space="preserve">Second </w:t><w:br/><w:t>third</w:t></w:r></w:p>`)},
+			},
+			"First line\nSecond\nthird",
+		},
+		{
+			"docx footnotes come after the body",
+			docxExtractor,
+			[]zipEntry{
+				{"word/footnotes.xml", `<w:footnotes ` + wordNS + `><w:footnote><w:p><w:r><w:t>Note</w:t></w:r></w:p></w:footnote></w:footnotes>`},
+				{"word/document.xml", wordPart(`<w:p><w:r><w:t>Body</w:t></w:r></w:p>`)},
+			},
+			"Body\nNote",
+		},
+		{
+			"docx entities",
+			docxExtractor,
+			[]zipEntry{{"word/document.xml", wordPart(`<w:p><w:r><w:t>Fish &amp; chips &lt;3</w:t></w:r></w:p>`)}},
+			"Fish & chips <3",
+		},
+		{
+			"pptx slides in number order",
+			pptxExtractor,
+			[]zipEntry{
+				{"ppt/slides/slide10.xml", slidePart("Ten")},
+				{"ppt/slides/slide2.xml", slidePart("Two")},
+				{"ppt/slides/_rels/slide2.xml.rels", "<Relationships/>"},
+				{"ppt/slides/slide1.xml", slidePart("One")},
+			},
+			"One\nTwo\nTen",
+		},
+		{
+			"xlsx shared, inline and number cells",
+			xlsxExtractor,
+			[]zipEntry{
+				{"xl/sharedStrings.xml", `<sst><si><t>Name</t></si><si><r><t>Rich </t></r><r><t>text</t></r></si></sst>`},
+				{"xl/worksheets/sheet1.xml", `<worksheet><sheetData>` +
+					`<row><c t="s"><v>0</v></c><c t="s"><v>1</v></c></row>` +
+					`<row><c t="inlineStr"><is><t>Inline</t></is></c><c><v>42.5</v></c></row>` +
+					`</sheetData></worksheet>`},
+			},
+			"Name Rich text\nInline 42.5",
+		},
+		{
+			"xlsx shared string index out of range",
+			xlsxExtractor,
+			[]zipEntry{
+				{"xl/sharedStrings.xml", `<sst><si><t>Only</t></si></sst>`},
+				{"xl/worksheets/sheet1.xml", `<worksheet><sheetData><row><c t="s"><v>7</v></c><c t="s"><v>-1</v></c><c t="s"><v>x</v></c><c t="s"><v>0</v></c></row></sheetData></worksheet>`},
+			},
+			"Only",
+		},
+		{
+			"xlsx without shared strings",
+			xlsxExtractor,
+			[]zipEntry{{"xl/worksheets/sheet1.xml", `<worksheet><sheetData><row><c t="s"><v>0</v></c><c><v>7</v></c></row></sheetData></worksheet>`}},
+			"7",
+		},
+		{
+			"no text parts",
+			docxExtractor,
+			[]zipEntry{{"docProps/core.xml", "<cp:coreProperties/>"}},
+			"",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := runExtractor(t, test.extractor, buildZip(t, test.entries...))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != test.want {
+				t.Fatalf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestOOXMLMalformed(t *testing.T) {
+	validDocx := buildZip(t, zipEntry{"word/document.xml", wordPart(`<w:p><w:r><w:t>Hello</w:t></w:r></w:p>`)})
+
+	// Flip a byte of the compressed document so its checksum no longer matches
+	corrupt := append([]byte(nil), validDocx...)
+	corrupt[bytes.Index(corrupt, []byte("word/document.xml"))+len("word/document.xml")+4] ^= 0xff
+
+	// A central directory that understates how large the entry inflates to
+	understated := append([]byte(nil), validDocx...)
+	directory := bytes.Index(understated, []byte("PK\x01\x02"))
+	binary.LittleEndian.PutUint32(understated[directory+24:], 1)
+
+	tests := []struct {
+		name      string
+		extractor Extractor
+		data      []byte
+	}{
+		{"empty", docxExtractor, nil},
+		{"not a zip", docxExtractor, []byte("PK\x03\x04 but not really a zip")},
+		{"plain text", xlsxExtractor, []byte("name,size\nreport,10\n")},
+		{"corrupt deflate data", docxExtractor, corrupt},
+		{"understated entry size", docxExtractor, understated},
+		{"unclosed xml", docxExtractor, buildZip(t, zipEntry{"word/document.xml", `<w:document><w:body><w:p><w:t>open`})},
+		{"unclosed text element", pptxExtractor, buildZip(t, zipEntry{"ppt/slides/slide1.xml", `<p:sld><a:t>open`})},
+		{"not xml", xlsxExtractor, buildZip(t, zipEntry{"xl/sharedStrings.xml", "\x00\x01\x02<<<>>>"})},
+		{"undefined entity", docxExtractor, buildZip(t, zipEntry{"word/document.xml", wordPart(`<w:t>&bogus;</w:t>`)})},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := runExtractor(t, test.extractor, test.data); err == nil {
+				t.Fatal("no error")
+			}
+		})
+	}
+}
+
+func TestOOXMLTruncated(t *testing.T) {
+	documents := map[string]struct {
+		extractor Extractor
+		data      []byte
+	}{
+		"docx": {docxExtractor, buildZip(t, zipEntry{"word/document.xml", wordPart(`<w:p><w:r><w:t>Hello truncated world</w:t></w:r></w:p>`)})},
+		"pptx": {pptxExtractor, buildZip(t, zipEntry{"ppt/slides/slide1.xml", slidePart("One")}, zipEntry{"ppt/slides/slide2.xml", slidePart("Two")})},
+		"xlsx": {xlsxExtractor, buildZip(t,
+			zipEntry{"xl/sharedStrings.xml", `<sst><si><t>Name</t></si></sst>`},
+			zipEntry{"xl/worksheets/sheet1.xml", `<worksheet><sheetData><row><c t="s"><v>0</v></c></row></sheetData></worksheet>`})},
+	}
+	for name, document := range documents {
+		t.Run(name, func(t *testing.T) {
+			eachPrefix(document.data, func(prefix []byte) {
+				runExtractor(t, document.extractor, prefix)
+			})
+		})
+	}
+}
+
+func TestOOXMLOversizedPart(t *testing.T) {
+	if testing.Short() {
+		t.Skip("inflates hundreds of megabytes")
+	}
+	// Blank space inflates far past maxPartBytes from a small archive, the
+	// part is cut off there instead of being read to the end
+	padding := strings.Repeat(" ", maxPartBytes+1<<20)
+	data := buildZip(t, zipEntry{"word/document.xml", wordPart(`<w:p><w:r><w:t>start</w:t></w:r></w:p>` + padding + `<w:p><w:r><w:t>end</w:t></w:r></w:p>`)})
+	if len(data) > maxPartBytes/100 {
+		t.Fatalf("test archive is %d bytes, it should compress", len(data))
+	}
+
+	got, _ := runExtractor(t, docxExtractor, data)
+	if strings.Contains(got, "end") {
+		t.Fatal("text past maxPartBytes was read")
+	}
+}
+
+func TestOOXMLInflationIsCappedPerDocument(t *testing.T) {
+	if testing.Short() {
+		t.Skip("inflates hundreds of megabytes")
+	}
+	// Each slide stays under maxPartBytes, together they don't
+	padding := strings.Repeat(" ", maxPartBytes-1<<10)
+	var entries []zipEntry
+	for i := 1; i <= maxInflatedBytes/maxPartBytes+2; i++ {
+		entries = append(entries, zipEntry{fmt.Sprintf("ppt/slides/slide%d.xml", i), `<p:sld>` + padding + `</p:sld>`})
+	}
+	entries = append(entries, zipEntry{"ppt/slides/slide99.xml", slidePart("after the padding")})
+
+	got, err := runExtractor(t, pptxExtractor, buildZip(t, entries...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(got, "after") {
+		t.Fatal("parts past maxInflatedBytes were read")
+	}
+}
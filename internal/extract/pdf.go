@@ -0,0 +1,465 @@
+package extract
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"io"
+	"regexp"
+	"strconv"
+	"unicode/utf16"
+)
+
+// maxPDFBytes caps how much of a PDF is read, text past it isn't indexed
+const maxPDFBytes = 64 << 20
+
+// maxPDFDictBytes is how far back a stream's dictionary is looked for
+const maxPDFDictBytes = 64 << 10
+
+var ErrEncryptedPDF = errors.New("pdf is encrypted")
+
+var (
+	pdfFilterPattern  = regexp.MustCompile(`/Filter\s*(\[[^\]]*\]|/\w+)`)
+	pdfNamePattern    = regexp.MustCompile(`/\w+`)
+	pdfSkipPattern    = regexp.MustCompile(`/(Image|XRef|ObjStm|Metadata|EmbeddedFile|Length1|Length2|Length3|Type1C|CIDFontType0C|OpenType)\b`)
+	pdfEncryptPattern = regexp.MustCompile(`/Encrypt\b`)
+)
+
+// pdf pulls the strings shown by the text operators of every page content
+// stream. It doesn't read the document structure: streams are found by
+// scanning for them, and only uncompressed or Flate streams are decoded.
+// Fonts with custom encodings, such as CID fonts, can't be mapped back to
+// text without parsing them, so their strings are left out.
+type pdf struct{}
+
+func (pdf) Extract(r io.ReaderAt, size int64, w *Writer) error {
+	content, err := io.ReadAll(io.LimitReader(io.NewSectionReader(r, 0, size), maxPDFBytes))
+	if err != nil {
+		return err
+	}
+	if !bytes.HasPrefix(bytes.TrimLeft(content, " \t\r\n"), []byte("%PDF-")) {
+		return errors.New("not a pdf")
+	}
+	if pdfEncryptPattern.Match(content) {
+		return ErrEncryptedPDF
+	}
+
+	budget := newInflateBudget()
+	for offset := 0; budget.left > 0; {
+		dict, data, next := nextPDFStream(content, offset)
+		if next < 0 {
+			return nil
+		}
+		offset = next
+
+		decoded, ok := decodePDFStream(dict, data, budget)
+		if !ok {
+			continue
+		}
+		if err := showPDFText(decoded, w); err != nil {
+			return err
+		}
+		w.Break()
+	}
+	return nil
+}
+
+// nextPDFStream finds the first stream at or after offset and returns its
+// dictionary, its raw data and where to keep looking. next is -1 when there
+// are no more streams.
+func nextPDFStream(content []byte, offset int) (dict []byte, data []byte, next int) {
+	// The dictionary can't reach back into the stream before it
+	from := offset
+	for {
+		index := bytes.Index(content[offset:], []byte("stream"))
+		if index < 0 {
+			return nil, nil, -1
+		}
+		start := offset + index
+		offset = start + len("stream")
+
+		// Skip "endstream" and any "stream" not right after a dictionary
+		before := bytes.TrimRight(content[:start], " \t\r\n")
+		if !bytes.HasSuffix(before, []byte(">>")) {
+			continue
+		}
+
+		dataStart := offset
+		if bytes.HasPrefix(content[dataStart:], []byte("\r\n")) {
+			dataStart += 2
+		} else if dataStart < len(content) && (content[dataStart] == '\n' || content[dataStart] == '\r') {
+			dataStart++
+		}
+		end := bytes.Index(content[dataStart:], []byte("endstream"))
+		if end < 0 {
+			return nil, nil, -1
+		}
+		dataEnd := dataStart + end
+
+		return pdfDictionary(before[from:]), content[dataStart:dataEnd], dataEnd + len("endstream")
+	}
+}
+
+// pdfDictionary returns the dictionary that before ends with, nested ones
+// included. Only the last maxPDFDictBytes are searched, so unbalanced ">>"
+// can't make a stream scan far back.
+func pdfDictionary(before []byte) []byte {
+	if len(before) > maxPDFDictBytes {
+		before = before[len(before)-maxPDFDictBytes:]
+	}
+	depth := 0
+	for i := len(before) - 1; i > 0; i-- {
+		switch {
+		case before[i] == '>' && before[i-1] == '>':
+			depth++
+			i--
+		case before[i] == '<' && before[i-1] == '<':
+			depth--
+			i--
+			if depth == 0 {
+				return before[i:]
+			}
+		}
+	}
+	return before
+}
+
+// decodePDFStream inflates a stream that may hold page content. Images, fonts
+// and other binary streams are skipped, as are filters other than Flate.
+func decodePDFStream(dict []byte, data []byte, budget *inflateBudget) ([]byte, bool) {
+	if pdfSkipPattern.Match(dict) {
+		return nil, false
+	}
+
+	filter := pdfFilterPattern.FindSubmatch(dict)
+	if filter == nil {
+		return data, true
+	}
+	filters := pdfNamePattern.FindAll(filter[1], -1)
+	if len(filters) != 1 || (string(filters[0]) != "/FlateDecode" && string(filters[0]) != "/Fl") {
+		return nil, false
+	}
+
+	inflater, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, false
+	}
+	defer inflater.Close()
+
+	// Keep what was inflated when the stream is cut short
+	decoded, err := io.ReadAll(budget.reader(inflater))
+	if err != nil && len(decoded) == 0 {
+		return nil, false
+	}
+	return decoded, true
+}
+
+// showPDFText runs through a content stream and writes the strings passed to
+// the text showing operators Tj, TJ, ' and "
+func showPDFText(stream []byte, w *Writer) error {
+	lexer := &pdfLexer{data: stream}
+	var operands []pdfToken
+
+	for {
+		token, ok := lexer.next()
+		if !ok {
+			return nil
+		}
+		if token.kind != pdfOperator {
+			operands = append(operands, token)
+			if len(operands) > 64 {
+				operands = operands[1:]
+			}
+			continue
+		}
+
+		var err error
+		switch token.text {
+		case "Tj":
+			err = writePDFStrings(w, operands, false)
+		case "TJ":
+			err = writePDFStrings(w, operands, true)
+		case "'", "\"":
+			w.Break()
+			err = writePDFStrings(w, operands, false)
+		case "T*":
+			w.Break()
+		case "Td", "TD":
+			// A move down starts a new line, a move along the line is a gap
+			if len(operands) >= 2 && operands[len(operands)-1].number() != 0 {
+				w.Break()
+			} else {
+				w.Space()
+			}
+		case "Tm", "ET":
+			w.Space()
+		case "BI":
+			lexer.skipInlineImage()
+		}
+		if err != nil {
+			return err
+		}
+		operands = operands[:0]
+	}
+}
+
+// writePDFStrings writes the last string operand, or for TJ the strings of
+// the array. Large negative adjustments inside TJ are gaps between words.
+func writePDFStrings(w *Writer, operands []pdfToken, array bool) error {
+	if !array {
+		for i := len(operands) - 1; i >= 0; i-- {
+			if operands[i].kind == pdfString {
+				return w.WriteString(pdfText(operands[i].text))
+			}
+		}
+		return nil
+	}
+
+	start := -1
+	for i := len(operands) - 1; i >= 0; i-- {
+		if operands[i].kind == pdfArrayStart {
+			start = i
+			break
+		}
+	}
+	if start < 0 {
+		return nil
+	}
+	for _, operand := range operands[start+1:] {
+		switch operand.kind {
+		case pdfString:
+			if err := w.WriteString(pdfText(operand.text)); err != nil {
+				return err
+			}
+		case pdfNumber:
+			if operand.number() < -200 {
+				w.Space()
+			}
+		}
+	}
+	return nil
+}
+
+// pdfText decodes a PDF string. UTF-16 strings start with a byte order mark,
+// everything else is taken as PDFDocEncoding, which matches Latin-1 for
+// text. Strings with NUL or other low control bytes come from fonts with
+// custom encodings and are dropped.
+func pdfText(raw string) string {
+	if len(raw) >= 2 && raw[0] == 0xfe && raw[1] == 0xff {
+		units := make([]uint16, 0, len(raw)/2)
+		for i := 2; i+1 < len(raw); i += 2 {
+			units = append(units, uint16(raw[i])<<8|uint16(raw[i+1]))
+		}
+		return string(utf16.Decode(units))
+	}
+
+	runes := make([]rune, 0, len(raw))
+	for i := 0; i < len(raw); i++ {
+		b := raw[i]
+		if b < 0x20 && b != '\t' && b != '\n' && b != '\r' {
+			return ""
+		}
+		runes = append(runes, rune(b))
+	}
+	return string(runes)
+}
+
+type pdfTokenKind int
+
+const (
+	pdfOperator pdfTokenKind = iota
+	pdfString
+	pdfNumber
+	pdfName
+	pdfArrayStart
+	pdfArrayEnd
+	pdfOther
+)
+
+type pdfToken struct {
+	kind pdfTokenKind
+	text string
+}
+
+func (t pdfToken) number() float64 {
+	if t.kind != pdfNumber {
+		return 0
+	}
+	n, _ := strconv.ParseFloat(t.text, 64)
+	return n
+}
+
+// pdfLexer splits a content stream into the tokens showPDFText needs
+type pdfLexer struct {
+	data []byte
+	pos  int
+}
+
+func isPDFSpace(b byte) bool {
+	return b == ' ' || b == '\t' || b == '\r' || b == '\n' || b == '\f' || b == 0
+}
+
+func isPDFDelimiter(b byte) bool {
+	return bytes.IndexByte([]byte("()<>[]{}/%"), b) >= 0
+}
+
+func (l *pdfLexer) next() (pdfToken, bool) {
+	for l.pos < len(l.data) {
+		b := l.data[l.pos]
+		switch {
+		case isPDFSpace(b):
+			l.pos++
+		case b == '%':
+			for l.pos < len(l.data) && l.data[l.pos] != '\n' && l.data[l.pos] != '\r' {
+				l.pos++
+			}
+		case b == '(':
+			return pdfToken{kind: pdfString, text: l.literalString()}, true
+		case b == '<' && l.pos+1 < len(l.data) && l.data[l.pos+1] == '<':
+			l.pos += 2
+			return pdfToken{kind: pdfOther, text: "<<"}, true
+		case b == '>' && l.pos+1 < len(l.data) && l.data[l.pos+1] == '>':
+			l.pos += 2
+			return pdfToken{kind: pdfOther, text: ">>"}, true
+		case b == '<':
+			return pdfToken{kind: pdfString, text: l.hexString()}, true
+		case b == '[':
+			l.pos++
+			return pdfToken{kind: pdfArrayStart, text: "["}, true
+		case b == ']':
+			l.pos++
+			return pdfToken{kind: pdfArrayEnd, text: "]"}, true
+		case b == '/':
+			l.pos++
+			return pdfToken{kind: pdfName, text: l.word()}, true
+		case isPDFDelimiter(b):
+			l.pos++
+		default:
+			word := l.word()
+			if _, err := strconv.ParseFloat(word, 64); err == nil {
+				return pdfToken{kind: pdfNumber, text: word}, true
+			}
+			return pdfToken{kind: pdfOperator, text: word}, true
+		}
+	}
+	return pdfToken{}, false
+}
+
+func (l *pdfLexer) word() string {
+	start := l.pos
+	for l.pos < len(l.data) && !isPDFSpace(l.data[l.pos]) && !isPDFDelimiter(l.data[l.pos]) {
+		l.pos++
+	}
+	if l.pos == start {
+		// Lone delimiter, step over it so the lexer moves on
+		l.pos++
+	}
+	return string(l.data[start:l.pos])
+}
+
+// literalString reads a (string), which may hold balanced parentheses and escapes
+func (l *pdfLexer) literalString() string {
+	l.pos++
+	var out []byte
+	for depth := 1; l.pos < len(l.data); l.pos++ {
+		b := l.data[l.pos]
+		switch b {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				l.pos++
+				return string(out)
+			}
+		case '\\':
+			l.pos++
+			if l.pos >= len(l.data) {
+				return string(out)
+			}
+			switch e := l.data[l.pos]; e {
+			case 'n':
+				out = append(out, '\n')
+			case 'r':
+				out = append(out, '\r')
+			case 't':
+				out = append(out, '\t')
+			case 'b', 'f':
+			case '\r':
+				// Line continuation
+				if l.pos+1 < len(l.data) && l.data[l.pos+1] == '\n' {
+					l.pos++
+				}
+			case '\n':
+			default:
+				if e >= '0' && e <= '7' {
+					value := 0
+					for n := 0; n < 3 && l.pos < len(l.data) && l.data[l.pos] >= '0' && l.data[l.pos] <= '7'; n++ {
+						value = value*8 + int(l.data[l.pos]-'0')
+						l.pos++
+					}
+					l.pos--
+					out = append(out, byte(value))
+				} else {
+					out = append(out, e)
+				}
+			}
+			continue
+		}
+		out = append(out, b)
+	}
+	return string(out)
+}
+
+// hexString reads a <hex string>, an odd last digit is padded with 0
+func (l *pdfLexer) hexString() string {
+	l.pos++
+	var out []byte
+	high, half := byte(0), false
+	for ; l.pos < len(l.data) && l.data[l.pos] != '>'; l.pos++ {
+		var value byte
+		switch b := l.data[l.pos]; {
+		case b >= '0' && b <= '9':
+			value = b - '0'
+		case b >= 'a' && b <= 'f':
+			value = b - 'a' + 10
+		case b >= 'A' && b <= 'F':
+			value = b - 'A' + 10
+		default:
+			continue
+		}
+		if half {
+			out = append(out, high<<4|value)
+		} else {
+			high = value
+		}
+		half = !half
+	}
+	if half {
+		out = append(out, high<<4)
+	}
+	l.pos++
+	return string(out)
+}
+
+// skipInlineImage moves past the data of an inline image, up to its EI
+func (l *pdfLexer) skipInlineImage() {
+	id := bytes.Index(l.data[l.pos:], []byte("ID"))
+	if id < 0 {
+		l.pos = len(l.data)
+		return
+	}
+	l.pos += id + 2
+	for l.pos < len(l.data) {
+		ei := bytes.Index(l.data[l.pos:], []byte("EI"))
+		if ei < 0 {
+			l.pos = len(l.data)
+			return
+		}
+		end := l.pos + ei
+		l.pos = end + 2
+		if end > 0 && isPDFSpace(l.data[end-1]) && (l.pos >= len(l.data) || isPDFSpace(l.data[l.pos])) {
+			return
+		}
+	}
+}
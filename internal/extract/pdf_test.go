@@ -0,0 +1,284 @@
+package extract
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func deflate(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var compressed bytes.Buffer
+	w := zlib.NewWriter(&compressed)
+	if _, err := w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return compressed.Bytes()
+}
+
+// pdfStream is one stream object of a test document
+type pdfStream struct {
+	dict string
+	data []byte
+}
+
+// buildPDF lays streams out as numbered objects with an xref table and
+// trailer, enough for a real reader to open it
+func buildPDF(streams ...pdfStream) []byte {
+	var out bytes.Buffer
+	out.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+	offsets := []int{}
+	for i, stream := range streams {
+		offsets = append(offsets, out.Len())
+		dict := stream.dict
+		if dict == "" {
+			dict = fmt.Sprintf("<< /Length %d >>", len(stream.data))
+		}
+		fmt.Fprintf(&out, "%d 0 obj\n%s\nstream\n", i+1, dict)
+		out.Write(stream.data)
+		out.WriteString("\nendstream\nendobj\n")
+	}
+	xref := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", len(streams)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&out, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&out, "trailer\n<< /Size %d >>\nstartxref\n%d\n%%%%EOF\n", len(streams)+1, xref)
+	return out.Bytes()
+}
+
+func TestPDFText(t *testing.T) {
+	helloFlate := deflate(t, []byte("BT /F1 12 Tf 72 712 Td (Hello from Flate) Tj ET"))
+
+	tests := []struct {
+		name    string
+		streams []pdfStream
+		want    string
+	}{
+		{
+			"uncompressed Tj",
+			[]pdfStream{{data: []byte("BT /F1 12 Tf 72 712 Td (Hello world) Tj ET")}},
+			"Hello world",
+		},
+		{
+			"flate stream",
+			[]pdfStream{{dict: fmt.Sprintf("<< /Length %d /Filter /FlateDecode >>", len(helloFlate)), data: helloFlate}},
+			"Hello from Flate",
+		},
+		{
+			"flate in a filter array",
+			[]pdfStream{{dict: fmt.Sprintf("<< /Length %d /Filter [/FlateDecode] >>", len(helloFlate)), data: helloFlate}},
+			"Hello from Flate",
+		},
+		{
+			"TJ kerning and word gaps",
+			[]pdfStream{{data: []byte("BT [(Hel) 20 (lo) -500 (world)] TJ ET")}},
+			"Hello world",
+		},
+		{
+			"lines from T* and quote operators",
+			[]pdfStream{{data: []byte("BT (first) Tj T* (second) Tj (third) ' ET")}},
+			"first\nsecond\nthird",
+		},
+		{
+			"escapes in literal strings",
+			[]pdfStream{{data: []byte(`BT (a \(nested\) string with \101\102 octal) Tj ET`)}},
+			"a (nested) string with AB octal",
+		},
+		{
+			"hex and UTF-16 strings",
+			[]pdfStream{{data: []byte("BT <48656c6c6f> Tj <FEFF00E9007400E9> Tj ET")}},
+			"Helloété",
+		},
+		{
+			"latin-1 bytes",
+			[]pdfStream{{data: []byte("BT (caf\xe9) Tj ET")}},
+			"café",
+		},
+		{
+			"custom encoded strings are dropped",
+			[]pdfStream{{data: []byte("BT <00410042> Tj (kept) Tj ET")}},
+			"kept",
+		},
+		{
+			"inline images are skipped",
+			[]pdfStream{{data: []byte("BI /W 2 /H 2 ID (Tj) junk EI BT (after) Tj ET")}},
+			"after",
+		},
+		{
+			"images and fonts are skipped",
+			[]pdfStream{
+				{dict: "<< /Subtype /Image /Length 15 >>", data: []byte("BT (pixels) Tj")},
+				{dict: "<< /Length1 20 /Length 15 >>", data: []byte("BT (glyphs) Tj")},
+				{data: []byte("BT (page) Tj ET")},
+			},
+			"page",
+		},
+		{
+			"nested dictionaries",
+			[]pdfStream{{dict: "<< /Length 15 /DecodeParms << /Columns 4 >> >>", data: []byte("BT (nested) Tj ET")}},
+			"nested",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := runExtractor(t, pdfExtractor, buildPDF(test.streams...))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != test.want {
+				t.Fatalf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestPDFRejects(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want error
+	}{
+		{"empty", nil, nil},
+		{"not a pdf", []byte("GIF89a stream endstream"), nil},
+		{"encrypted", append(buildPDF(pdfStream{data: []byte("BT (secret) Tj ET")}), []byte("trailer << /Encrypt 5 0 R >>")...), ErrEncryptedPDF},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := runExtractor(t, pdfExtractor, test.data)
+			if err == nil {
+				t.Fatal("no error")
+			}
+			if test.want != nil && !errors.Is(err, test.want) {
+				t.Fatalf("err = %v, want %v", err, test.want)
+			}
+		})
+	}
+}
+
+func TestPDFMalformed(t *testing.T) {
+	helloFlate := deflate(t, []byte("BT (Hello from Flate) Tj ET"))
+
+	tests := []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{
+			"bad xref and trailer",
+			[]byte("%PDF-1.7\n1 0 obj << /Length 99999 >>\nstream\nBT (still read) Tj ET\nendstream\nxref\n0 -3\nzzz\ntrailer << /Size 1e99 /Root 9 9 R >>\nstartxref\n-42\n%%EOF"),
+			"still read",
+		},
+		{
+			"no endstream",
+			[]byte("%PDF-1.4\n<< /Length 5 >>\nstream\nBT (lost) Tj ET"),
+			"",
+		},
+		{
+			"stream keyword without a dictionary",
+			[]byte("%PDF-1.4\nstream (no) Tj endstream\n<< >>stream\nBT (yes) Tj ET\nendstream"),
+			"yes",
+		},
+		{
+			"corrupt flate data",
+			buildPDF(
+				pdfStream{dict: "<< /Filter /FlateDecode /Length 9 >>", data: []byte("not zlib!")},
+				pdfStream{data: []byte("BT (next stream) Tj ET")},
+			),
+			"next stream",
+		},
+		{
+			"truncated flate data",
+			buildPDF(pdfStream{dict: "<< /Filter /FlateDecode >>", data: helloFlate[:len(helloFlate)-6]}),
+			"",
+		},
+		{
+			"unsupported filter",
+			buildPDF(pdfStream{dict: "<< /Filter /LZWDecode >>", data: []byte("BT (lzw) Tj ET")}),
+			"",
+		},
+		{
+			"unterminated strings",
+			buildPDF(pdfStream{data: []byte("BT (open string Tj <4142 Tj (trailing backslash\\")}),
+			"",
+		},
+		{
+			"inline image without EI",
+			buildPDF(pdfStream{data: []byte("BT (before) Tj ET BI /W 1 ID \x00\x01\x02 E I")}),
+			"before",
+		},
+		{
+			"inline image without ID",
+			buildPDF(pdfStream{data: []byte("BT (before) Tj ET BI /W 1")}),
+			"before",
+		},
+		{
+			"operators without operands",
+			buildPDF(pdfStream{data: []byte("Tj TJ ' \" Td T* ] ) > } BT [ (x) ET")}),
+			"",
+		},
+		{
+			"unbalanced dictionary",
+			[]byte("%PDF-1.4\n>> >> >>stream\nBT (odd) Tj ET\nendstream"),
+			"odd",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, _ := runExtractor(t, pdfExtractor, test.data)
+			if test.want != "" && !strings.Contains(got, test.want) {
+				t.Fatalf("got %q, want it to contain %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestPDFTruncated(t *testing.T) {
+	compressed := deflate(t, []byte("BT (compressed text) Tj ET"))
+	document := buildPDF(
+		pdfStream{data: []byte("BT [(plain) -300 (text)] TJ (more) ' <FEFF0041> Tj ET")},
+		pdfStream{dict: fmt.Sprintf("<< /Length %d /Filter /FlateDecode >>", len(compressed)), data: compressed},
+		pdfStream{data: []byte("BI /W 1 ID xyz EI BT (last) Tj ET")},
+	)
+	eachPrefix(document, func(prefix []byte) {
+		runExtractor(t, pdfExtractor, prefix)
+	})
+}
+
+func TestPDFManyStreamsStaysLinear(t *testing.T) {
+	// Every stream sits after an unmatched >>, so finding its dictionary
+	// would walk back to the start of the file each time
+	var document bytes.Buffer
+	document.WriteString("%PDF-1.4\n")
+	for document.Len() < 4<<20 {
+		document.WriteString(">>stream\nendstream\n")
+	}
+	runExtractor(t, pdfExtractor, document.Bytes())
+}
+
+func TestPDFDecompressionIsCapped(t *testing.T) {
+	if testing.Short() {
+		t.Skip("inflates hundreds of megabytes")
+	}
+	// Streams of blank space compress about a thousandfold
+	bomb := deflate(t, bytes.Repeat([]byte(" "), maxPartBytes))
+	var streams []pdfStream
+	for i := 0; i < maxInflatedBytes/maxPartBytes+2; i++ {
+		streams = append(streams, pdfStream{dict: fmt.Sprintf("<< /Length %d /Filter /FlateDecode >>", len(bomb)), data: bomb})
+	}
+	streams = append(streams, pdfStream{data: []byte("BT (after the bombs) Tj ET")})
+
+	got, err := runExtractor(t, pdfExtractor, buildPDF(streams...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(got, "after") {
+		t.Fatal("streams past maxInflatedBytes were read")
+	}
+}
@@ -0,0 +1,18 @@
+package extract
+
+import (
+	"io"
+)
+
+// plainText handles text and markdown. Markdown syntax is left in, its
+// punctuation is dropped when the search vector is built anyway.
+type plainText struct{}
+
+func (plainText) Extract(r io.ReaderAt, size int64, w *Writer) error {
+	// Blank space collapses, so read a little past the limit
+	content, err := io.ReadAll(io.LimitReader(io.NewSectionReader(r, 0, size), 2*MaxTextBytes))
+	if err != nil {
+		return err
+	}
+	return w.WriteString(string(content))
+}
@@ -0,0 +1,40 @@
+package jobs
+
+import (
+	"fmt"
+	"goCal/internal/logger"
+	"goCal/internal/services"
+	"goCal/internal/storage"
+	"time"
+)
+
+// blobGracePeriod keeps blobs touched recently out of collection so an upload
+// that has stored its blob but not yet saved its file row isn't undone
+const blobGracePeriod = time.Hour
+
+// StartBlobCollector periodically corrects blob reference counts and deletes
+// blobs no file or file version points at. A zero or negative interval disables it.
+func StartBlobCollector(storageBackend storage.StorageBackend, interval time.Duration) {
+	if interval <= 0 {
+		logger.Info("Blob collector disabled")
+		return
+	}
+
+	fileStorageService := services.NewFileStorageService(storageBackend)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			collected, err := fileStorageService.CollectGarbage(blobGracePeriod)
+			if err != nil {
+				logger.Error(fmt.Sprintf("Blob collection failed: %v", err))
+				continue
+			}
+			if collected > 0 {
+				logger.Info(fmt.Sprintf("Blob collector removed %d unreferenced blob(s)", collected))
+			}
+		}
+	}()
+	logger.Info(fmt.Sprintf("Blob collector running every %s", interval))
+}
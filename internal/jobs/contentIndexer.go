@@ -0,0 +1,41 @@
+package jobs
+
+import (
+	"fmt"
+	"goCal/internal/logger"
+	"goCal/internal/services"
+	"goCal/internal/storage"
+	"time"
+)
+
+// StartContentIndexer starts workers goroutines that extract the text of new
+// and changed files for search. Each one drains the queue, then waits
+// pollInterval before looking again. Zero or negative workers disables it.
+func StartContentIndexer(storageBackend storage.StorageBackend, workers int, pollInterval time.Duration) {
+	if workers <= 0 || pollInterval <= 0 {
+		logger.Info("Content indexer disabled")
+		return
+	}
+
+	indexService := services.NewContentIndexService(services.NewFileStorageService(storageBackend))
+	for i := 0; i < workers; i++ {
+		go func() {
+			ticker := time.NewTicker(pollInterval)
+			defer ticker.Stop()
+
+			for range ticker.C {
+				for {
+					processed, err := indexService.ProcessNext()
+					if err != nil {
+						logger.Error(fmt.Sprintf("Content indexing failed: %v", err))
+						break
+					}
+					if !processed {
+						break
+					}
+				}
+			}
+		}()
+	}
+	logger.Info(fmt.Sprintf("Content indexer running with %d worker(s), polling every %s", workers, pollInterval))
+}
@@ -0,0 +1,40 @@
+package jobs
+
+import (
+	"fmt"
+	"goCal/internal/logger"
+	"goCal/internal/services"
+	"time"
+)
+
+// StartEmailWorker starts workers goroutines that deliver queued emails. Each
+// one drains the outbox, then waits pollInterval before looking again. Zero or
+// negative workers disables it.
+func StartEmailWorker(workers int, pollInterval time.Duration) {
+	if workers <= 0 || pollInterval <= 0 {
+		logger.Info("Email worker disabled")
+		return
+	}
+
+	outboxService := services.NewEmailOutboxService()
+	for i := 0; i < workers; i++ {
+		go func() {
+			ticker := time.NewTicker(pollInterval)
+			defer ticker.Stop()
+
+			for range ticker.C {
+				for {
+					processed, err := outboxService.ProcessNext()
+					if err != nil {
+						logger.Error(fmt.Sprintf("Email outbox processing failed: %v", err))
+						break
+					}
+					if !processed {
+						break
+					}
+				}
+			}
+		}()
+	}
+	logger.Info(fmt.Sprintf("Email worker running with %d worker(s), polling every %s", workers, pollInterval))
+}
@@ -0,0 +1,31 @@
+package jobs
+
+import (
+	"fmt"
+	"goCal/internal/logger"
+	"goCal/internal/services"
+	"time"
+)
+
+// StartStorageReconciler periodically recomputes users' storage_used from the
+// files table so drift from failed requests doesn't accumulate. A zero or
+// negative interval disables it.
+func StartStorageReconciler(interval time.Duration) {
+	if interval <= 0 {
+		logger.Info("Storage reconciler disabled")
+		return
+	}
+
+	quotaService := services.NewQuotaService()
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			if _, err := quotaService.ReconcileStorageUsage(); err != nil {
+				logger.Error(fmt.Sprintf("Storage reconciliation failed: %v", err))
+			}
+		}
+	}()
+	logger.Info(fmt.Sprintf("Storage reconciler running every %s", interval))
+}
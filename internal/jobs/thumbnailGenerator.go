@@ -0,0 +1,41 @@
+package jobs
+
+import (
+	"fmt"
+	"goCal/internal/logger"
+	"goCal/internal/services"
+	"goCal/internal/storage"
+	"time"
+)
+
+// StartThumbnailGenerator starts workers goroutines that make the previews of
+// newly uploaded images. Each one drains the queue, then waits pollInterval
+// before looking again. Zero or negative workers disables it.
+func StartThumbnailGenerator(storageBackend storage.StorageBackend, workers int, pollInterval time.Duration) {
+	if workers <= 0 || pollInterval <= 0 {
+		logger.Info("Thumbnail generator disabled")
+		return
+	}
+
+	thumbnailService := services.NewThumbnailService(services.NewFileStorageService(storageBackend))
+	for i := 0; i < workers; i++ {
+		go func() {
+			ticker := time.NewTicker(pollInterval)
+			defer ticker.Stop()
+
+			for range ticker.C {
+				for {
+					processed, err := thumbnailService.ProcessNext()
+					if err != nil {
+						logger.Error(fmt.Sprintf("Thumbnail generation failed: %v", err))
+						break
+					}
+					if !processed {
+						break
+					}
+				}
+			}
+		}()
+	}
+	logger.Info(fmt.Sprintf("Thumbnail generator running with %d worker(s), polling every %s", workers, pollInterval))
+}
@@ -0,0 +1,32 @@
+package jobs
+
+import (
+	"fmt"
+	"goCal/internal/logger"
+	"goCal/internal/services"
+	"goCal/internal/storage"
+	"time"
+)
+
+// StartTrashPurger periodically purges files and folders that have been in
+// the trash longer than retention, removing their stored objects too. A zero
+// or negative interval disables it.
+func StartTrashPurger(storageBackend storage.StorageBackend, retention time.Duration, interval time.Duration) {
+	if interval <= 0 {
+		logger.Info("Trash purger disabled")
+		return
+	}
+
+	trashService := services.NewTrashService(services.NewFileStorageService(storageBackend))
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			if err := trashService.PurgeExpired(retention); err != nil {
+				logger.Error(fmt.Sprintf("Trash purge failed: %v", err))
+			}
+		}
+	}()
+	logger.Info(fmt.Sprintf("Trash purger running every %s, keeping items for %s", interval, retention))
+}
@@ -0,0 +1,36 @@
+package jobs
+
+import (
+	"fmt"
+	"goCal/internal/logger"
+	"goCal/internal/services"
+	"goCal/internal/storage"
+	"time"
+)
+
+// StartUploadCleaner periodically removes expired resumable upload sessions
+func StartUploadCleaner(storageBackend storage.StorageBackend, interval time.Duration) {
+	if interval <= 0 {
+		logger.Info("Upload cleaner disabled")
+		return
+	}
+
+	fileStorageService := services.NewFileStorageService(storageBackend)
+	uploadService := services.NewUploadService(services.NewFileService(), fileStorageService, services.NewQuotaService())
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			removed, err := uploadService.CleanupExpiredUploads()
+			if err != nil {
+				logger.Error(fmt.Sprintf("Upload cleanup failed: %v", err))
+				continue
+			}
+			if removed > 0 {
+				logger.Info(fmt.Sprintf("Removed %d expired upload(s)", removed))
+			}
+		}
+	}()
+}
@@ -0,0 +1,57 @@
+package media
+
+import (
+	"encoding/binary"
+	"io"
+)
+
+const (
+	flacStreamInfo    = 0
+	flacVorbisComment = 4
+	// maxFLACBlockBytes skips over blocks too big to be tags, like cover art
+	maxFLACBlockBytes = 1 << 20
+)
+
+// readFLAC walks the metadata blocks after the "fLaC" marker. STREAMINFO
+// carries the length, VORBIS_COMMENT the tags.
+func readFLAC(r io.ReaderAt, size int64) (*Info, error) {
+	info := &Info{Kind: KindAudio}
+
+	offset := int64(4)
+	for offset+4 <= size {
+		header, err := readAt(r, offset, 4)
+		if err != nil {
+			return nil, err
+		}
+		if len(header) < 4 {
+			break
+		}
+		last := header[0]&0x80 != 0
+		blockType := header[0] & 0x7f
+		length := int64(header[1])<<16 | int64(header[2])<<8 | int64(header[3])
+		offset += 4
+
+		if (blockType == flacStreamInfo || blockType == flacVorbisComment) && length <= maxFLACBlockBytes {
+			block, err := readAt(r, offset, length)
+			if err != nil {
+				return nil, err
+			}
+			if blockType == flacStreamInfo && len(block) >= 18 {
+				sampleRate := uint32(block[10])<<12 | uint32(block[11])<<4 | uint32(block[12])>>4
+				samples := uint64(block[13]&0x0f)<<32 | uint64(binary.BigEndian.Uint32(block[14:]))
+				if sampleRate > 0 {
+					info.Duration = float64(samples) / float64(sampleRate)
+				}
+			}
+			if blockType == flacVorbisComment {
+				readVorbisComment(block, info)
+			}
+		}
+
+		offset += length
+		if last {
+			break
+		}
+	}
+	return info, nil
+}
@@ -0,0 +1,134 @@
+package media
+
+import (
+	"testing"
+)
+
+func flacBlock(blockType byte, last bool, payload []byte) []byte {
+	return concat(flacHeader(blockType, last, uint32(len(payload))), payload)
+}
+
+// flacHeader is a block header claiming length, whatever follows it
+func flacHeader(blockType byte, last bool, length uint32) []byte {
+	header := []byte{blockType, byte(length >> 16), byte(length >> 8), byte(length)}
+	if last {
+		header[0] |= 0x80
+	}
+	return header
+}
+
+// streamInfo packs the sample rate and sample count the way STREAMINFO does,
+// 20 and 36 bits wide around the channel count and sample size
+func streamInfo(sampleRate uint32, samples uint64) []byte {
+	block := make([]byte, 34)
+	block[10] = byte(sampleRate >> 12)
+	block[11] = byte(sampleRate >> 4)
+	block[12] = byte(sampleRate<<4) | 1<<1 // two channels
+	block[13] = 15<<4 | byte(samples>>32)  // 16 bits per sample
+	copy(block[14:], be32(uint32(samples)))
+	return block
+}
+
+func buildFLAC(blocks ...[]byte) []byte {
+	return concat(append([][]byte{[]byte("fLaC")}, blocks...)...)
+}
+
+func sampleFLAC() []byte {
+	return buildFLAC(
+		flacBlock(flacStreamInfo, false, streamInfo(44100, 441000)),
+		flacBlock(6, false, make([]byte, 300)), // PICTURE
+		flacBlock(flacVorbisComment, false, vorbisComment("reference libFLAC 1.4.3", "TITLE=Title", "artist=Artist", "ALBUM=Album", "ALBUM=Second album")),
+		flacBlock(1, true, make([]byte, 64)), // PADDING
+		make([]byte, 128),
+	)
+}
+
+func TestFLAC(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want Info
+	}{
+		{"streaminfo and tags", sampleFLAC(), Info{Kind: KindAudio, Duration: 10, Title: "Title", Artist: "Artist", Album: "Album"}},
+		{"36 bit sample count", buildFLAC(flacBlock(flacStreamInfo, true, streamInfo(96000, 1<<33))), Info{Kind: KindAudio, Duration: float64(1<<33) / 96000}},
+		{"zero sample rate", buildFLAC(flacBlock(flacStreamInfo, true, streamInfo(0, 1000))), Info{Kind: KindAudio}},
+		{"short streaminfo", buildFLAC(flacBlock(flacStreamInfo, true, streamInfo(44100, 441000)[:17])), Info{Kind: KindAudio}},
+		{
+			"blocks after the last one are ignored",
+			buildFLAC(flacBlock(flacStreamInfo, true, streamInfo(44100, 44100)), flacBlock(flacVorbisComment, false, vorbisComment("", "TITLE=Ignored"))),
+			Info{Kind: KindAudio, Duration: 1},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			info, err := readMedia(t, test.data, "audio/flac")
+			if err != nil {
+				t.Fatal(err)
+			}
+			check(t, info, test.want)
+		})
+	}
+}
+
+func TestFLACOversizedLengths(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want Info
+	}{
+		{
+			"streaminfo claiming the largest block length",
+			buildFLAC(flacHeader(flacStreamInfo, false, 0xffffff), streamInfo(44100, 441000)),
+			Info{Kind: KindAudio},
+		},
+		{
+			"picture claiming more than the file",
+			buildFLAC(flacBlock(flacStreamInfo, false, streamInfo(44100, 441000)), flacHeader(6, false, 0xffffff), flacBlock(flacVorbisComment, true, vorbisComment("", "TITLE=Lost"))),
+			Info{Kind: KindAudio, Duration: 10},
+		},
+		{
+			"comment block over the tag limit is skipped",
+			buildFLAC(flacHeader(flacVorbisComment, false, maxFLACBlockBytes+1), vorbisComment("", "TITLE=Skipped")),
+			Info{Kind: KindAudio},
+		},
+		{
+			"comment block cut off by the end of the file",
+			buildFLAC(flacHeader(flacVorbisComment, true, 4096), vorbisComment("", "TITLE=Kept", "ARTIST=Cut")[:25]),
+			Info{Kind: KindAudio, Title: "Kept"},
+		},
+		{
+			"comment fields claiming more than the block",
+			buildFLAC(flacBlock(flacVorbisComment, true, concat(le32(0), le32(2), le32(10), []byte("TITLE=Kept"), le32(0xffffffff), []byte("ARTIST=x")))),
+			Info{Kind: KindAudio, Title: "Kept"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			info, err := readMedia(t, test.data, "audio/flac")
+			if err != nil {
+				t.Fatal(err)
+			}
+			check(t, info, test.want)
+		})
+	}
+}
+
+func TestFLACManyEmptyBlocks(t *testing.T) {
+	blocks := [][]byte{flacBlock(flacStreamInfo, false, streamInfo(44100, 44100))}
+	for range 100000 {
+		blocks = append(blocks, flacBlock(1, false, nil))
+	}
+	blocks = append(blocks, flacBlock(flacVorbisComment, true, vorbisComment("", "TITLE=Last")))
+
+	info, err := readMedia(t, buildFLAC(blocks...), "audio/flac")
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(t, info, Info{Kind: KindAudio, Duration: 1, Title: "Last"})
+}
+
+func TestFLACTruncated(t *testing.T) {
+	eachPrefix(sampleFLAC(), func(prefix []byte) {
+		readMedia(t, prefix, "audio/flac")
+	})
+}
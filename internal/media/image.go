@@ -0,0 +1,47 @@
+package media
+
+import (
+	"bytes"
+	"goCal/internal/exif"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+
+	_ "golang.org/x/image/webp"
+)
+
+// maxImageBytes caps how much of an image is read. WebP keeps its EXIF
+// after the pixels, so the whole file is needed.
+const maxImageBytes = 64 << 20
+
+func readImage(r io.ReaderAt, size int64) (*Info, error) {
+	data, err := readAt(r, 0, min(size, maxImageBytes))
+	if err != nil {
+		return nil, err
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	info := &Info{Kind: KindImage, Width: config.Width, Height: config.Height}
+
+	tags, err := exif.Read(data)
+	if err != nil {
+		return info, nil
+	}
+	// Pictures taken with the camera on its side are stored on their side
+	if tags.Orientation() >= 5 {
+		info.Width, info.Height = info.Height, info.Width
+	}
+	info.CameraMake, info.CameraModel = tags.Camera()
+	if takenAt, ok := tags.TakenAt(); ok {
+		info.TakenAt = &takenAt
+	}
+	if latitude, longitude, ok := tags.Location(); ok {
+		info.Latitude, info.Longitude = &latitude, &longitude
+	}
+	return info, nil
+}
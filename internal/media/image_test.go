@@ -0,0 +1,157 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+// exifBlock is a little endian TIFF block with the camera and orientation in its first directory
+func exifBlock(orientation uint16, cameraMake string, model string) []byte {
+	const entries = 3
+	data := uint32(8 + 2 + entries*12 + 4)
+	block := []byte("II*\x00\x08\x00\x00\x00")
+	block = binary.LittleEndian.AppendUint16(block, entries)
+	entry := func(tag uint16, typ uint16, count uint32, value uint32) {
+		block = binary.LittleEndian.AppendUint16(block, tag)
+		block = binary.LittleEndian.AppendUint16(block, typ)
+		block = binary.LittleEndian.AppendUint32(block, count)
+		block = binary.LittleEndian.AppendUint32(block, value)
+	}
+	entry(0x010f, 2, uint32(len(cameraMake)+1), data)
+	entry(0x0110, 2, uint32(len(model)+1), data+uint32(len(cameraMake)+1))
+	entry(0x0112, 3, 1, uint32(orientation))
+	block = append(block, 0, 0, 0, 0)
+	block = append(block, cameraMake+"\x00"+model+"\x00"...)
+	return block
+}
+
+func pngImage(t testing.TB, exif []byte) []byte {
+	var out bytes.Buffer
+	if err := png.Encode(&out, image.NewGray(image.Rect(0, 0, 3, 2))); err != nil {
+		t.Fatal(err)
+	}
+	data := out.Bytes()
+	if exif == nil {
+		return data
+	}
+	// eXIf goes after the 8 byte signature and the 25 byte IHDR chunk
+	chunk := concat(be32(uint32(len(exif))), []byte("eXIf"), exif)
+	chunk = binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+	return concat(data[:33], chunk, data[33:])
+}
+
+func jpegImage(t testing.TB, exif []byte) []byte {
+	var out bytes.Buffer
+	if err := jpeg.Encode(&out, image.NewGray(image.Rect(0, 0, 4, 3)), nil); err != nil {
+		t.Fatal(err)
+	}
+	data := out.Bytes()
+	if exif == nil {
+		return data
+	}
+	segment := append([]byte("Exif\x00\x00"), exif...)
+	app1 := concat([]byte{0xff, 0xe1}, binary.BigEndian.AppendUint16(nil, uint16(len(segment)+2)), segment)
+	return concat(data[:2], app1, data[2:])
+}
+
+func gifImage(t testing.TB) []byte {
+	var out bytes.Buffer
+	if err := gif.Encode(&out, image.NewGray(image.Rect(0, 0, 5, 7)), nil); err != nil {
+		t.Fatal(err)
+	}
+	return out.Bytes()
+}
+
+// webpImage is a lossless WebP header, enough to read the size from, with
+// the EXIF chunk after it like real files
+func webpImage(width int, height int, exif []byte) []byte {
+	bits := uint32(width-1) | uint32(height-1)<<14
+	body := concat([]byte("WEBP"), riffChunk("VP8L", concat([]byte{0x2f}, le32(bits))))
+	if exif != nil {
+		body = concat(body, riffChunk("EXIF", exif))
+	}
+	return concat([]byte("RIFF"), le32(uint32(len(body))), body)
+}
+
+func TestImage(t *testing.T) {
+	upright := exifBlock(1, "Canon", "Canon EOS R5")
+	sideways := exifBlock(6, "Apple", "iPhone 15")
+
+	tests := []struct {
+		name string
+		data []byte
+		want Info
+	}{
+		{"png", pngImage(t, nil), Info{Kind: KindImage, Width: 3, Height: 2}},
+		{"png with exif", pngImage(t, upright), Info{Kind: KindImage, Width: 3, Height: 2, CameraMake: "Canon", CameraModel: "Canon EOS R5"}},
+		{"jpeg", jpegImage(t, nil), Info{Kind: KindImage, Width: 4, Height: 3}},
+		{"jpeg taken on its side", jpegImage(t, sideways), Info{Kind: KindImage, Width: 3, Height: 4, CameraMake: "Apple", CameraModel: "iPhone 15"}},
+		{"gif", gifImage(t), Info{Kind: KindImage, Width: 5, Height: 7}},
+		{"webp", webpImage(300, 200, nil), Info{Kind: KindImage, Width: 300, Height: 200}},
+		{"webp with exif after the pixels", webpImage(300, 200, sideways), Info{Kind: KindImage, Width: 200, Height: 300, CameraMake: "Apple", CameraModel: "iPhone 15"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			info, err := readMedia(t, test.data, "image/*")
+			if err != nil {
+				t.Fatal(err)
+			}
+			check(t, info, test.want)
+		})
+	}
+}
+
+func TestImageMalformed(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"jpeg start marker only", []byte{0xff, 0xd8}},
+		{"png signature only", []byte("\x89PNG\r\n\x1a\n")},
+		{"png with a huge chunk length", concat([]byte("\x89PNG\r\n\x1a\n"), be32(0xffffffff), []byte("IHDR"))},
+		{"gif header only", []byte("GIF89a")},
+		{"webp with a huge chunk length", concat([]byte("RIFF"), le32(0xffffffff), []byte("WEBPVP8L"), le32(0xffffffff), []byte{0x2f})},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := readMedia(t, test.data, "image/*"); err == nil {
+				t.Fatal("no error")
+			}
+		})
+	}
+}
+
+func TestImageBadExifIsIgnored(t *testing.T) {
+	block := exifBlock(6, "Canon", "Canon EOS R5")
+	// The directory claims more entries than the limit
+	binary.LittleEndian.PutUint16(block[8:], 0xffff)
+
+	info, err := readMedia(t, jpegImage(t, block), "image/jpeg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(t, info, Info{Kind: KindImage, Width: 4, Height: 3})
+}
+
+func TestImageTruncated(t *testing.T) {
+	exif := exifBlock(6, "Canon", "Canon EOS R5")
+	images := map[string][]byte{
+		"png":  pngImage(t, exif),
+		"jpeg": jpegImage(t, exif),
+		"gif":  gifImage(t),
+		"webp": webpImage(300, 200, exif),
+	}
+	for name, data := range images {
+		t.Run(name, func(t *testing.T) {
+			eachPrefix(data, func(prefix []byte) {
+				readMedia(t, prefix, "image/*")
+			})
+		})
+	}
+}
@@ -0,0 +1,152 @@
+// Package media reads the metadata of photos, music and videos: dimensions,
+// camera details, tags and running time. Only headers are read, nothing is decoded.
+package media
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"time"
+)
+
+const (
+	KindImage = "image"
+	KindAudio = "audio"
+	KindVideo = "video"
+)
+
+var ErrUnsupported = errors.New("no metadata reader for this file type")
+
+// Info is what could be read from a file. Fields the format doesn't carry are left empty.
+type Info struct {
+	Kind     string
+	Width    int
+	Height   int
+	Duration float64 // seconds
+
+	CameraMake  string
+	CameraModel string
+	TakenAt     *time.Time
+	Latitude    *float64
+	Longitude   *float64
+
+	Title  string
+	Artist string
+	Album  string
+}
+
+// Supported reports whether Read may find metadata in a file of this MIME type
+func Supported(fileType string) bool {
+	family, _, _ := strings.Cut(strings.ToLower(strings.TrimSpace(fileType)), "/")
+	return family == "image" || family == "audio" || family == "video"
+}
+
+// Read works out the format from the first bytes of the file and reads its metadata
+func Read(r io.ReaderAt, size int64, fileType string) (*Info, error) {
+	if !Supported(fileType) {
+		return nil, ErrUnsupported
+	}
+	family, _, _ := strings.Cut(strings.ToLower(strings.TrimSpace(fileType)), "/")
+
+	head := make([]byte, 16)
+	n, err := r.ReadAt(head, 0)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	head = head[:n]
+
+	switch {
+	case bytes.HasPrefix(head, []byte{0xff, 0xd8}),
+		bytes.HasPrefix(head, []byte("\x89PNG")),
+		bytes.HasPrefix(head, []byte("GIF8")),
+		len(head) >= 12 && string(head[:4]) == "RIFF" && string(head[8:12]) == "WEBP":
+		return readImage(r, size)
+	case len(head) >= 12 && string(head[:4]) == "RIFF" && string(head[8:12]) == "WAVE":
+		return readWAV(r, size)
+	// HEIC and AVIF images share the MP4 container but not its boxes
+	case len(head) >= 8 && string(head[4:8]) == "ftyp" && family != "image":
+		return readMP4(r, size)
+	case bytes.HasPrefix(head, []byte("fLaC")):
+		return readFLAC(r, size)
+	case bytes.HasPrefix(head, []byte("OggS")):
+		return readOgg(r, size)
+	case bytes.HasPrefix(head, []byte("ID3")),
+		len(head) >= 2 && head[0] == 0xff && head[1]&0xe0 == 0xe0:
+		return readMP3(r, size)
+	}
+	return nil, ErrUnsupported
+}
+
+// readAt reads length bytes at offset, fewer at the end of the file
+func readAt(r io.ReaderAt, offset int64, length int64) ([]byte, error) {
+	if offset < 0 || length < 0 {
+		return nil, errors.New("invalid read")
+	}
+	buf := make([]byte, length)
+	n, err := r.ReadAt(buf, offset)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	return buf[:n], nil
+}
+
+// readVorbisComment reads the tags FLAC and Ogg files share: a vendor string
+// then KEY=value entries, all little endian length prefixed
+func readVorbisComment(data []byte, info *Info) {
+	next := func() ([]byte, bool) {
+		if len(data) < 4 {
+			return nil, false
+		}
+		length := int(uint32(data[0]) | uint32(data[1])<<8 | uint32(data[2])<<16 | uint32(data[3])<<24)
+		data = data[4:]
+		if length < 0 || length > len(data) {
+			return nil, false
+		}
+		value := data[:length]
+		data = data[length:]
+		return value, true
+	}
+
+	if _, ok := next(); !ok {
+		return
+	}
+	if len(data) < 4 {
+		return
+	}
+	count := int(uint32(data[0]) | uint32(data[1])<<8 | uint32(data[2])<<16 | uint32(data[3])<<24)
+	data = data[4:]
+	for i := 0; i < count; i++ {
+		entry, ok := next()
+		if !ok {
+			return
+		}
+		key, value, found := strings.Cut(string(entry), "=")
+		if !found {
+			continue
+		}
+		setTag(info, strings.ToUpper(key), value)
+	}
+}
+
+// setTag keeps the first title, artist and album a file names
+func setTag(info *Info, key string, value string) {
+	value = strings.TrimSpace(strings.ToValidUTF8(strings.Trim(value, "\x00"), ""))
+	if value == "" {
+		return
+	}
+	switch key {
+	case "TITLE":
+		if info.Title == "" {
+			info.Title = value
+		}
+	case "ARTIST":
+		if info.Artist == "" {
+			info.Artist = value
+		}
+	case "ALBUM":
+		if info.Album == "" {
+			info.Album = value
+		}
+	}
+}
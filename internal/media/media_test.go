@@ -0,0 +1,185 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readTimeout is far longer than any of these files take, reaching it means
+// a reader is stuck
+const readTimeout = 10 * time.Second
+
+// readMedia reads data like the upload path does, failing the test if the
+// reader panics or doesn't return in time
+func readMedia(t *testing.T, data []byte, fileType string) (*Info, error) {
+	t.Helper()
+
+	type outcome struct {
+		info *Info
+		err  error
+	}
+	done := make(chan outcome, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				done <- outcome{err: fmt.Errorf("panic: %v", r)}
+			}
+		}()
+		info, err := Read(bytes.NewReader(data), int64(len(data)), fileType)
+		done <- outcome{info: info, err: err}
+	}()
+
+	select {
+	case result := <-done:
+		if result.err != nil && strings.HasPrefix(result.err.Error(), "panic: ") {
+			t.Fatal(result.err)
+		}
+		return result.info, result.err
+	case <-time.After(readTimeout):
+		t.Fatalf("reader did not return within %s", readTimeout)
+		return nil, nil
+	}
+}
+
+// eachPrefix runs check on every truncation of data, stepping so large
+// files stay quick
+func eachPrefix(data []byte, check func(prefix []byte)) {
+	step := len(data)/512 + 1
+	for end := 0; end < len(data); end += step {
+		check(data[:end])
+	}
+}
+
+func concat(parts ...[]byte) []byte {
+	return bytes.Join(parts, nil)
+}
+
+func le32(value uint32) []byte {
+	return binary.LittleEndian.AppendUint32(nil, value)
+}
+
+func be32(value uint32) []byte {
+	return binary.BigEndian.AppendUint32(nil, value)
+}
+
+// vorbisComment builds the tag block FLAC and Ogg share
+func vorbisComment(vendor string, comments ...string) []byte {
+	out := binary.LittleEndian.AppendUint32(nil, uint32(len(vendor)))
+	out = append(out, vendor...)
+	out = binary.LittleEndian.AppendUint32(out, uint32(len(comments)))
+	for _, comment := range comments {
+		out = binary.LittleEndian.AppendUint32(out, uint32(len(comment)))
+		out = append(out, comment...)
+	}
+	return out
+}
+
+func near(got float64, want float64) bool {
+	return math.Abs(got-want) < 1e-6
+}
+
+// check compares the fields of info a test cares about, zero fields of want included
+func check(t *testing.T, got *Info, want Info) {
+	t.Helper()
+	if got == nil {
+		t.Fatal("no info")
+	}
+	if got.Kind != want.Kind || got.Width != want.Width || got.Height != want.Height {
+		t.Errorf("got %s %dx%d, want %s %dx%d", got.Kind, got.Width, got.Height, want.Kind, want.Width, want.Height)
+	}
+	if !near(got.Duration, want.Duration) {
+		t.Errorf("duration = %v, want %v", got.Duration, want.Duration)
+	}
+	if got.Title != want.Title || got.Artist != want.Artist || got.Album != want.Album {
+		t.Errorf("tags = %q / %q / %q, want %q / %q / %q", got.Title, got.Artist, got.Album, want.Title, want.Artist, want.Album)
+	}
+	if got.CameraMake != want.CameraMake || got.CameraModel != want.CameraModel {
+		t.Errorf("camera = %q %q, want %q %q", got.CameraMake, got.CameraModel, want.CameraMake, want.CameraModel)
+	}
+}
+
+func TestSupported(t *testing.T) {
+	tests := map[string]bool{
+		"image/jpeg":          true,
+		" Audio/MPEG ":        true,
+		"video/mp4; codecs=x": true,
+		"application/pdf":     false,
+		"":                    false,
+		"imagery/png":         false,
+	}
+	for fileType, want := range tests {
+		if got := Supported(fileType); got != want {
+			t.Errorf("Supported(%q) = %v, want %v", fileType, got, want)
+		}
+	}
+}
+
+func TestReadUnsupported(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     []byte
+		fileType string
+	}{
+		{"not a media type", buildWAV(wavFormat(16000)), "application/octet-stream"},
+		{"empty file", nil, "audio/mpeg"},
+		{"unknown format", []byte("#!/bin/sh\necho hello\n"), "audio/mpeg"},
+		{"heic shares the mp4 container", box("ftyp", []byte("heic\x00\x00\x00\x00")), "image/heic"},
+		{"riff that is neither wave nor webp", []byte("RIFF\x04\x00\x00\x00AVI "), "video/avi"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := readMedia(t, test.data, test.fileType); !errors.Is(err, ErrUnsupported) {
+				t.Fatalf("err = %v, want ErrUnsupported", err)
+			}
+		})
+	}
+}
+
+func TestVorbisCommentOversizedLengths(t *testing.T) {
+	tests := []struct {
+		name  string
+		data  []byte
+		title string
+	}{
+		{"vendor longer than the block", concat(le32(0xffffffff), []byte("vendor")), ""},
+		{"comment count far past the entries", concat(le32(1), []byte("v"), le32(0xffffffff), le32(10), []byte("TITLE=kept")), "kept"},
+		{"comment longer than the block", concat(le32(1), []byte("v"), le32(2), le32(0x7fffffff), []byte("TITLE=x")), ""},
+		{"comment length wrapping negative", concat(le32(1), []byte("v"), le32(1), le32(0xffffffff), []byte("TITLE=x")), ""},
+		{"entry without an equals sign", vorbisComment("v", "TITLE", "title=lower case key"), "lower case key"},
+		{"no count", vorbisComment("v")[:5], ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			info := &Info{}
+			readVorbisComment(test.data, info)
+			if info.Title != test.title {
+				t.Fatalf("title = %q, want %q", info.Title, test.title)
+			}
+		})
+	}
+}
+
+func FuzzRead(f *testing.F) {
+	for _, seed := range [][]byte{
+		pngImage(f, nil),
+		jpegImage(f, nil),
+		gifImage(f),
+		webpImage(3, 2, nil),
+		sampleMP3(),
+		sampleFLAC(),
+		sampleOgg(),
+		sampleWAV(),
+		sampleMP4(),
+	} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		Read(bytes.NewReader(data), int64(len(data)), "video/mp4")
+	})
+}
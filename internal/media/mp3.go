@@ -0,0 +1,223 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// maxID3Bytes caps how much of an ID3 tag is read, cover art can be megabytes
+const maxID3Bytes = 1 << 20
+
+// id3Frames maps the ID3v2.3/2.4 and the three letter ID3v2.2 frames we read to their tag
+var id3Frames = map[string]string{
+	"TIT2": "TITLE", "TT2": "TITLE",
+	"TPE1": "ARTIST", "TP1": "ARTIST",
+	"TALB": "ALBUM", "TAL": "ALBUM",
+	"TLEN": "LENGTH", "TLE": "LENGTH",
+}
+
+func readMP3(r io.ReaderAt, size int64) (*Info, error) {
+	info := &Info{Kind: KindAudio}
+
+	audioStart := int64(0)
+	header, err := readAt(r, 0, 10)
+	if err != nil {
+		return nil, err
+	}
+	if len(header) == 10 && string(header[:3]) == "ID3" {
+		tagSize := int64(syncsafe(header[6:10])) + 10
+		if header[5]&0x10 != 0 {
+			tagSize += 10 // footer
+		}
+		tag, err := readAt(r, 10, min(tagSize-10, maxID3Bytes))
+		if err != nil {
+			return nil, err
+		}
+		readID3v2(tag, header[3], header[5], info)
+		audioStart = tagSize
+	}
+
+	// ID3v1 sits in the last 128 bytes
+	audioEnd := size
+	if size >= 128 {
+		if trailer, err := readAt(r, size-128, 128); err == nil && len(trailer) == 128 && string(trailer[:3]) == "TAG" {
+			audioEnd -= 128
+			setTag(info, "TITLE", latin1(trailer[3:33]))
+			setTag(info, "ARTIST", latin1(trailer[33:63]))
+			setTag(info, "ALBUM", latin1(trailer[63:93]))
+		}
+	}
+
+	if info.Duration == 0 {
+		info.Duration = mp3Duration(r, audioStart, audioEnd)
+	}
+	return info, nil
+}
+
+func syncsafe(b []byte) int {
+	return int(b[0]&0x7f)<<21 | int(b[1]&0x7f)<<14 | int(b[2]&0x7f)<<7 | int(b[3]&0x7f)
+}
+
+// readID3v2 reads the text frames of an ID3v2 tag, without its 10 byte header
+func readID3v2(tag []byte, version byte, flags byte, info *Info) {
+	if flags&0x40 != 0 && len(tag) >= 4 {
+		// Extended header, v2.4 counts its own size
+		extended := int(binary.BigEndian.Uint32(tag))
+		if version >= 4 {
+			extended = syncsafe(tag)
+		} else {
+			extended += 4
+		}
+		if extended > len(tag) {
+			return
+		}
+		tag = tag[extended:]
+	}
+
+	idLength, headerLength := 4, 10
+	if version == 2 {
+		idLength, headerLength = 3, 6
+	}
+	for len(tag) >= headerLength && tag[0] != 0 {
+		id := string(tag[:idLength])
+		var frameSize int
+		switch version {
+		case 2:
+			frameSize = int(tag[3])<<16 | int(tag[4])<<8 | int(tag[5])
+		case 3:
+			frameSize = int(binary.BigEndian.Uint32(tag[4:]))
+		default:
+			frameSize = syncsafe(tag[4:8])
+		}
+		if frameSize < 0 || headerLength+frameSize > len(tag) {
+			return
+		}
+		frame := tag[headerLength : headerLength+frameSize]
+		tag = tag[headerLength+frameSize:]
+
+		key, ok := id3Frames[id]
+		if !ok || len(frame) < 1 {
+			continue
+		}
+		value := id3Text(frame[0], frame[1:])
+		if key == "LENGTH" {
+			if ms, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil && ms > 0 {
+				info.Duration = ms / 1000
+			}
+			continue
+		}
+		setTag(info, key, value)
+	}
+}
+
+// id3Text decodes a text frame. v2.4 separates multiple values with NUL, the first is kept.
+func id3Text(encoding byte, data []byte) string {
+	switch encoding {
+	case 1, 2:
+		order := binary.ByteOrder(binary.BigEndian)
+		if encoding == 1 && len(data) >= 2 {
+			if data[0] == 0xff && data[1] == 0xfe {
+				order = binary.LittleEndian
+			}
+			if (data[0] == 0xff && data[1] == 0xfe) || (data[0] == 0xfe && data[1] == 0xff) {
+				data = data[2:]
+			}
+		}
+		units := make([]uint16, 0, len(data)/2)
+		for i := 0; i+1 < len(data); i += 2 {
+			unit := order.Uint16(data[i:])
+			if unit == 0 {
+				break
+			}
+			units = append(units, unit)
+		}
+		return string(utf16.Decode(units))
+	case 3:
+		value, _, _ := bytes.Cut(data, []byte{0})
+		return string(value)
+	default:
+		value, _, _ := bytes.Cut(data, []byte{0})
+		return latin1(value)
+	}
+}
+
+func latin1(data []byte) string {
+	value, _, _ := bytes.Cut(data, []byte{0})
+	runes := make([]rune, len(value))
+	for i, b := range value {
+		runes[i] = rune(b)
+	}
+	return string(runes)
+}
+
+var (
+	mp3Bitrates = [2][16]int{
+		{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 0}, // MPEG-1
+		{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160, 0},     // MPEG-2 and 2.5
+	}
+	mp3SampleRates = map[byte][3]int{
+		3: {44100, 48000, 32000}, // MPEG-1
+		2: {22050, 24000, 16000}, // MPEG-2
+		0: {11025, 12000, 8000},  // MPEG-2.5
+	}
+)
+
+// mp3Duration reads the length from the Xing or VBRI header of the first
+// frame, which variable bitrate encoders write. Without one the file is
+// taken to be constant bitrate.
+func mp3Duration(r io.ReaderAt, start int64, end int64) float64 {
+	data, err := readAt(r, start, min(end-start, 64<<10))
+	if err != nil {
+		return 0
+	}
+
+	for i := 0; i+4 <= len(data); i++ {
+		if data[i] != 0xff || data[i+1]&0xe0 != 0xe0 {
+			continue
+		}
+		version := (data[i+1] >> 3) & 3
+		layer := (data[i+1] >> 1) & 3
+		bitrateIndex := data[i+2] >> 4
+		rateIndex := (data[i+2] >> 2) & 3
+		// Layer III only, and skip bit patterns the spec reserves
+		if version == 1 || layer != 1 || bitrateIndex == 0 || bitrateIndex == 15 || rateIndex == 3 {
+			continue
+		}
+
+		table, samplesPerFrame := 0, 1152
+		if version != 3 {
+			table, samplesPerFrame = 1, 576
+		}
+		bitrate := mp3Bitrates[table][bitrateIndex] * 1000
+		sampleRate := mp3SampleRates[version][rateIndex]
+
+		mono := data[i+3]>>6 == 3
+		sideInfo := 32
+		switch {
+		case version == 3 && mono:
+			sideInfo = 17
+		case version != 3 && mono:
+			sideInfo = 9
+		case version != 3:
+			sideInfo = 17
+		}
+
+		if xing := i + 4 + sideInfo; xing+12 <= len(data) {
+			tag := string(data[xing : xing+4])
+			if (tag == "Xing" || tag == "Info") && data[xing+7]&1 != 0 {
+				frames := binary.BigEndian.Uint32(data[xing+8:])
+				return float64(frames) * float64(samplesPerFrame) / float64(sampleRate)
+			}
+		}
+		if vbri := i + 36; vbri+18 <= len(data) && string(data[vbri:vbri+4]) == "VBRI" {
+			frames := binary.BigEndian.Uint32(data[vbri+14:])
+			return float64(frames) * float64(samplesPerFrame) / float64(sampleRate)
+		}
+		return float64(end-start-int64(i)) * 8 / float64(bitrate)
+	}
+	return 0
+}
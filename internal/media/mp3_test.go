@@ -0,0 +1,234 @@
+package media
+
+import (
+	"bytes"
+	"testing"
+)
+
+func syncsafeBytes(n uint32) []byte {
+	return []byte{byte(n>>21) & 0x7f, byte(n>>14) & 0x7f, byte(n>>7) & 0x7f, byte(n) & 0x7f}
+}
+
+func id3Tag(version byte, flags byte, frames ...[]byte) []byte {
+	body := concat(frames...)
+	return concat([]byte{'I', 'D', '3', version, 0, flags}, syncsafeBytes(uint32(len(body))), body)
+}
+
+func id3Frame(version byte, id string, payload []byte) []byte {
+	return concat(id3FrameHeader(version, id, uint32(len(payload))), payload)
+}
+
+// id3FrameHeader is a frame header claiming size, whatever follows it
+func id3FrameHeader(version byte, id string, size uint32) []byte {
+	switch version {
+	case 2:
+		return concat([]byte(id), []byte{byte(size >> 16), byte(size >> 8), byte(size)})
+	case 3:
+		return concat([]byte(id), be32(size), []byte{0, 0})
+	default:
+		return concat([]byte(id), syncsafeBytes(size), []byte{0, 0})
+	}
+}
+
+// mp3Frame is one MPEG-1 Layer III frame at 128kbps and 44.1kHz, 417 bytes
+// long, with extra written where a Xing or VBRI header would go
+func mp3Frame(extra []byte) []byte {
+	frame := make([]byte, 417)
+	copy(frame, []byte{0xff, 0xfb, 0x90, 0x00})
+	copy(frame[36:], extra)
+	return frame
+}
+
+func mp3Frames(n int) []byte {
+	return bytes.Repeat(mp3Frame(nil), n)
+}
+
+func xingHeader(frames uint32) []byte {
+	return concat([]byte("Xing"), be32(1), be32(frames))
+}
+
+func id3v1(title string, artist string, album string) []byte {
+	trailer := make([]byte, 128)
+	copy(trailer, "TAG")
+	copy(trailer[3:33], title)
+	copy(trailer[33:63], artist)
+	copy(trailer[63:93], album)
+	return trailer
+}
+
+func sampleMP3() []byte {
+	return concat(
+		id3Tag(3, 0,
+			id3Frame(3, "TIT2", []byte("\x00Title")),
+			id3Frame(3, "TPE1", []byte("\x01\xff\xfeA\x00r\x00t\x00i\x00s\x00t\x00\x00\x00")),
+			id3Frame(3, "APIC", make([]byte, 200)),
+			id3Frame(3, "TALB", []byte("\x00Album\x00")),
+		),
+		mp3Frame(xingHeader(100)),
+		mp3Frames(3),
+	)
+}
+
+func TestMP3(t *testing.T) {
+	// Constant bitrate files are timed by their size at 128kbps
+	cbr := func(bytes int) float64 { return float64(bytes) * 8 / 128000 }
+
+	tests := []struct {
+		name string
+		data []byte
+		want Info
+	}{
+		{
+			"id3v2.3 and a xing header",
+			sampleMP3(),
+			Info{Kind: KindAudio, Duration: 100 * 1152 / 44100.0, Title: "Title", Artist: "Artist", Album: "Album"},
+		},
+		{
+			"id3v2.4 with an extended header and a length frame",
+			concat(
+				id3Tag(4, 0x40,
+					[]byte{0, 0, 0, 6, 1, 0},
+					id3Frame(4, "TIT2", []byte("\x03Tïtle\x00Second value")),
+					id3Frame(4, "TPE1", []byte("\x02\x00A\x00r\x00t\x00i\x00s\x00t")),
+					id3Frame(4, "TLEN", []byte("\x005000")),
+				),
+				mp3Frames(2),
+			),
+			Info{Kind: KindAudio, Duration: 5, Title: "Tïtle", Artist: "Artist"},
+		},
+		{
+			"id3v2.3 extended header",
+			concat(id3Tag(3, 0x40, []byte{0, 0, 0, 6, 0, 0, 0, 0, 0, 0}, id3Frame(3, "TIT2", []byte("\x00Title"))), mp3Frames(1)),
+			Info{Kind: KindAudio, Duration: cbr(417), Title: "Title"},
+		},
+		{
+			"id3v2.2 three letter frames",
+			concat(id3Tag(2, 0, id3Frame(2, "TT2", []byte("\x00Title")), id3Frame(2, "TP1", []byte("\x00Artist")), id3Frame(2, "TAL", []byte("\x00Album"))), mp3Frames(1)),
+			Info{Kind: KindAudio, Duration: cbr(417), Title: "Title", Artist: "Artist", Album: "Album"},
+		},
+		{
+			"id3v1 and constant bitrate",
+			concat(mp3Frames(10), id3v1("Caf\xe9", "Artist", "Album")),
+			Info{Kind: KindAudio, Duration: cbr(4170), Title: "Café", Artist: "Artist", Album: "Album"},
+		},
+		{
+			"id3v2 wins over id3v1",
+			concat(id3Tag(3, 0, id3Frame(3, "TIT2", []byte("\x00New"))), mp3Frames(1), id3v1("Old", "Artist", "")),
+			Info{Kind: KindAudio, Duration: cbr(417), Title: "New", Artist: "Artist"},
+		},
+		{
+			"vbri header",
+			concat(mp3Frame(concat([]byte("VBRI"), make([]byte, 10), be32(50))), mp3Frames(1)),
+			Info{Kind: KindAudio, Duration: 50 * 1152 / 44100.0},
+		},
+		{
+			"mpeg-2 mono xing header",
+			concat([]byte{0xff, 0xf3, 0x80, 0xc0}, make([]byte, 9), xingHeader(200), make([]byte, 100)),
+			Info{Kind: KindAudio, Duration: 200 * 576 / 22050.0},
+		},
+		{
+			"xing header without a frame count",
+			mp3Frame(concat([]byte("Xing"), be32(0), be32(100))),
+			Info{Kind: KindAudio, Duration: cbr(417)},
+		},
+		{
+			"junk before the first frame",
+			concat([]byte{0xff, 0xff, 0xff, 0xe0, 0x00}, mp3Frames(2)),
+			Info{Kind: KindAudio, Duration: cbr(834)},
+		},
+		{
+			"no frames",
+			concat(id3Tag(3, 0, id3Frame(3, "TIT2", []byte("\x00Title"))), bytes.Repeat([]byte{0xff}, 100)),
+			Info{Kind: KindAudio, Title: "Title"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			info, err := readMedia(t, test.data, "audio/mpeg")
+			if err != nil {
+				t.Fatal(err)
+			}
+			check(t, info, test.want)
+		})
+	}
+}
+
+func TestMP3OversizedLengths(t *testing.T) {
+	title := id3Frame(3, "TIT2", []byte("\x00Title"))
+
+	tests := []struct {
+		name string
+		data []byte
+		want Info
+	}{
+		{
+			"tag claiming more than the file",
+			concat([]byte("ID3\x03\x00\x00"), syncsafeBytes(0x0fffffff), title, mp3Frames(1)),
+			Info{Kind: KindAudio, Title: "Title"},
+		},
+		{
+			"v2.3 frame claiming more than the tag",
+			concat(id3Tag(3, 0, title, id3FrameHeader(3, "TPE1", 0xffffffff), []byte("\x00Artist")), mp3Frames(1)),
+			Info{Kind: KindAudio, Duration: 417 * 8 / 128000.0, Title: "Title"},
+		},
+		{
+			"v2.4 frame at the largest syncsafe size",
+			concat(id3Tag(4, 0, id3Frame(4, "TIT2", []byte("\x00Title")), id3FrameHeader(4, "TPE1", 0x0fffffff), []byte("\x00Artist"))),
+			Info{Kind: KindAudio, Title: "Title"},
+		},
+		{
+			"v2.2 frame claiming more than the tag",
+			concat(id3Tag(2, 0, id3Frame(2, "TT2", []byte("\x00Title")), id3FrameHeader(2, "TP1", 0xffffff), []byte("\x00Artist"))),
+			Info{Kind: KindAudio, Title: "Title"},
+		},
+		{
+			"v2.3 extended header claiming more than the tag",
+			id3Tag(3, 0x40, be32(0xffffffff), title),
+			Info{Kind: KindAudio},
+		},
+		{
+			"v2.4 extended header claiming more than the tag",
+			id3Tag(4, 0x40, syncsafeBytes(0x0fffffff), id3Frame(4, "TIT2", []byte("\x00Title"))),
+			Info{Kind: KindAudio},
+		},
+		{
+			"empty frames",
+			id3Tag(3, 0, bytes.Repeat(id3Frame(3, "TXXX", nil), 1000), id3Frame(3, "TIT2", nil), title),
+			Info{Kind: KindAudio, Title: "Title"},
+		},
+		{
+			"utf-16 frames with only a byte order mark or an odd length",
+			id3Tag(3, 0, id3Frame(3, "TIT2", []byte("\x01\xff\xfe")), id3Frame(3, "TALB", []byte("\x01\xff\xfeA\x00B"))),
+			Info{Kind: KindAudio, Album: "A"},
+		},
+		{
+			"xing header cut off by the end of the file",
+			concat([]byte{0xff, 0xfb, 0x90, 0x00}, make([]byte, 32), []byte("Xing\x00\x00")),
+			Info{Kind: KindAudio, Duration: 42 * 8 / 128000.0},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			info, err := readMedia(t, test.data, "audio/mpeg")
+			if err != nil {
+				t.Fatal(err)
+			}
+			check(t, info, test.want)
+		})
+	}
+}
+
+func TestMP3Truncated(t *testing.T) {
+	files := map[string][]byte{
+		"id3v2":  sampleMP3(),
+		"id3v1":  concat(mp3Frames(2), id3v1("Title", "Artist", "Album")),
+		"mpeg-2": concat([]byte{0xff, 0xf3, 0x80, 0xc0}, make([]byte, 9), xingHeader(200)),
+	}
+	for name, data := range files {
+		t.Run(name, func(t *testing.T) {
+			eachPrefix(data, func(prefix []byte) {
+				readMedia(t, prefix, "audio/mpeg")
+			})
+		})
+	}
+}
@@ -0,0 +1,218 @@
+package media
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+const (
+	// maxMP4Boxes bounds how many boxes a file may make us visit
+	maxMP4Boxes = 10000
+	// Metadata boxes larger than this are cover art or worse and are skipped
+	maxMP4BoxBytes = 1 << 20
+)
+
+var errTooManyBoxes = errors.New("too many boxes")
+
+// mp4Tags maps the iTunes style ilst items we read to their tag
+var mp4Tags = map[string]string{
+	"\xa9nam": "TITLE",
+	"\xa9ART": "ARTIST",
+	"\xa9alb": "ALBUM",
+}
+
+// mp4Reader walks the box tree of an MP4 or QuickTime file, reading only the
+// boxes it needs
+type mp4Reader struct {
+	r       io.ReaderAt
+	info    *Info
+	visited int
+}
+
+// mp4Box is the payload of a box, between start and end in the file
+type mp4Box struct {
+	kind       string
+	start, end int64
+}
+
+// readMP4 reads the running time from mvhd, the picture size from the tkhd
+// of the video track and the tags from the ilst under udta/meta
+func readMP4(r io.ReaderAt, size int64) (*Info, error) {
+	reader := &mp4Reader{r: r, info: &Info{Kind: KindAudio}}
+	err := reader.walk(0, size, func(box mp4Box) error {
+		if box.kind == "moov" {
+			return reader.readMovie(box)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if reader.info.Width > 0 && reader.info.Height > 0 {
+		reader.info.Kind = KindVideo
+	}
+	return reader.info, nil
+}
+
+func (m *mp4Reader) walk(start int64, end int64, visit func(mp4Box) error) error {
+	for offset := start; offset+8 <= end; {
+		m.visited++
+		if m.visited > maxMP4Boxes {
+			return errTooManyBoxes
+		}
+
+		header, err := readAt(m.r, offset, 16)
+		if err != nil {
+			return err
+		}
+		if len(header) < 8 {
+			return nil
+		}
+		boxSize := int64(binary.BigEndian.Uint32(header))
+		headerSize := int64(8)
+		switch boxSize {
+		case 0: // runs to the end
+			boxSize = end - offset
+		case 1: // 64 bit size follows the type
+			if len(header) < 16 {
+				return nil
+			}
+			boxSize = int64(binary.BigEndian.Uint64(header[8:]))
+			headerSize = 16
+		}
+		// Compared against what is left so a 64 bit size can't overflow the sum
+		if boxSize < headerSize || boxSize > end-offset {
+			return nil
+		}
+
+		if err := visit(mp4Box{kind: string(header[4:8]), start: offset + headerSize, end: offset + boxSize}); err != nil {
+			return err
+		}
+		offset += boxSize
+	}
+	return nil
+}
+
+func (m *mp4Reader) payload(box mp4Box) ([]byte, error) {
+	return readAt(m.r, box.start, min(box.end-box.start, maxMP4BoxBytes))
+}
+
+func (m *mp4Reader) readMovie(moov mp4Box) error {
+	return m.walk(moov.start, moov.end, func(box mp4Box) error {
+		switch box.kind {
+		case "mvhd":
+			data, err := m.payload(box)
+			if err != nil {
+				return err
+			}
+			m.readMovieHeader(data)
+		case "trak":
+			return m.readTrack(box)
+		case "udta":
+			return m.walk(box.start, box.end, func(child mp4Box) error {
+				if child.kind == "meta" {
+					return m.readMeta(child)
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+}
+
+// readMovieHeader reads the duration, in units of the timescale. Version 1
+// widens the times to 64 bits.
+func (m *mp4Reader) readMovieHeader(data []byte) {
+	var timescale, duration uint64
+	switch {
+	case len(data) >= 32 && data[0] == 1:
+		timescale = uint64(binary.BigEndian.Uint32(data[20:]))
+		duration = binary.BigEndian.Uint64(data[24:])
+	case len(data) >= 20 && data[0] == 0:
+		timescale = uint64(binary.BigEndian.Uint32(data[12:]))
+		duration = uint64(binary.BigEndian.Uint32(data[16:]))
+	}
+	// All ones marks an unknown duration
+	if timescale > 0 && duration != ^uint64(0) && duration != 0xffffffff {
+		m.info.Duration = float64(duration) / float64(timescale)
+	}
+}
+
+// readTrack takes the picture size of the first video track. tkhd ends with
+// the width and height as 16.16 fixed point numbers.
+func (m *mp4Reader) readTrack(trak mp4Box) error {
+	var width, height int
+	var handler string
+	err := m.walk(trak.start, trak.end, func(box mp4Box) error {
+		switch box.kind {
+		case "tkhd":
+			data, err := m.payload(box)
+			if err != nil {
+				return err
+			}
+			if len(data) >= 84 {
+				width = int(binary.BigEndian.Uint32(data[len(data)-8:]) >> 16)
+				height = int(binary.BigEndian.Uint32(data[len(data)-4:]) >> 16)
+			}
+		case "mdia":
+			return m.walk(box.start, box.end, func(child mp4Box) error {
+				if child.kind != "hdlr" {
+					return nil
+				}
+				data, err := m.payload(child)
+				if err != nil {
+					return err
+				}
+				if len(data) >= 12 {
+					handler = string(data[8:12])
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if handler == "vide" && m.info.Width == 0 && width > 0 && height > 0 {
+		m.info.Width, m.info.Height = width, height
+	}
+	return nil
+}
+
+// readMeta reads the ilst tags. MP4 makes meta a full box with four bytes of
+// version and flags before its children, QuickTime doesn't.
+func (m *mp4Reader) readMeta(meta mp4Box) error {
+	start := meta.start
+	if peek, err := readAt(m.r, start, 8); err == nil && len(peek) == 8 && string(peek[4:8]) != "hdlr" {
+		start += 4
+	}
+
+	return m.walk(start, meta.end, func(box mp4Box) error {
+		if box.kind != "ilst" {
+			return nil
+		}
+		return m.walk(box.start, box.end, func(item mp4Box) error {
+			key, ok := mp4Tags[item.kind]
+			if !ok {
+				return nil
+			}
+			return m.walk(item.start, item.end, func(value mp4Box) error {
+				if value.kind != "data" {
+					return nil
+				}
+				data, err := m.payload(value)
+				if err != nil {
+					return err
+				}
+				// Type 1 is UTF-8, after four bytes of type and four of locale
+				if len(data) >= 8 && binary.BigEndian.Uint32(data) == 1 {
+					setTag(m.info, key, string(data[8:]))
+				}
+				return nil
+			})
+		})
+	})
+}
@@ -0,0 +1,208 @@
+package media
+
+import (
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+func box(kind string, payload ...[]byte) []byte {
+	body := concat(payload...)
+	return concat(be32(uint32(len(body)+8)), []byte(kind), body)
+}
+
+// boxHeader is a box header claiming size, whatever follows it
+func boxHeader(kind string, size uint32) []byte {
+	return concat(be32(size), []byte(kind))
+}
+
+// largeBox uses the 64 bit size that follows the type
+func largeBox(kind string, size uint64, payload []byte) []byte {
+	return concat(be32(1), []byte(kind), binary.BigEndian.AppendUint64(nil, size), payload)
+}
+
+func fullBox(version byte) []byte {
+	return []byte{version, 0, 0, 0}
+}
+
+func mvhd(timescale uint32, duration uint32) []byte {
+	return box("mvhd", fullBox(0), make([]byte, 8), be32(timescale), be32(duration), make([]byte, 80))
+}
+
+func mvhd64(timescale uint32, duration uint64) []byte {
+	return box("mvhd", fullBox(1), make([]byte, 16), be32(timescale), binary.BigEndian.AppendUint64(nil, duration), make([]byte, 80))
+}
+
+// tkhd ends with the width and height as 16.16 fixed point
+func tkhd(width uint32, height uint32) []byte {
+	return box("tkhd", fullBox(0), make([]byte, 72), be32(width<<16), be32(height<<16))
+}
+
+func hdlr(handler string) []byte {
+	return box("hdlr", fullBox(0), make([]byte, 4), []byte(handler), make([]byte, 13))
+}
+
+func track(width uint32, height uint32, handler string) []byte {
+	return box("trak", tkhd(width, height), box("mdia", box("mdhd", fullBox(0), make([]byte, 20)), hdlr(handler)))
+}
+
+func ilstItem(kind string, value string) []byte {
+	return box(kind, box("data", be32(1), be32(0), []byte(value)))
+}
+
+// iTunesMeta is udta/meta the way MP4 writers lay it out, meta being a full box
+func iTunesMeta(items ...[]byte) []byte {
+	return box("udta", box("meta", fullBox(0), hdlr("mdir"), box("ilst", items...)))
+}
+
+func sampleMP4() []byte {
+	return concat(
+		box("ftyp", []byte("isom\x00\x00\x02\x00isomiso2mp41")),
+		box("free"),
+		box("moov",
+			mvhd(1000, 12500),
+			track(0, 0, "soun"),
+			track(1920, 1080, "vide"),
+			track(640, 480, "vide"),
+			iTunesMeta(
+				ilstItem("\xa9too", "Lavf60.16.100"),
+				ilstItem("\xa9nam", "Title"),
+				ilstItem("\xa9ART", "Artist"),
+				ilstItem("\xa9alb", "Album"),
+			),
+		),
+		box("mdat", make([]byte, 100)),
+	)
+}
+
+func TestMP4(t *testing.T) {
+	ftyp := box("ftyp", []byte("M4A \x00\x00\x00\x00M4A mp42isom"))
+
+	tests := []struct {
+		name string
+		data []byte
+		want Info
+	}{
+		{"video", sampleMP4(), Info{Kind: KindVideo, Width: 1920, Height: 1080, Duration: 12.5, Title: "Title", Artist: "Artist", Album: "Album"}},
+		{
+			"audio with a 64 bit header",
+			concat(ftyp, box("moov", mvhd64(44100, 44100*30), track(0, 0, "soun"), iTunesMeta(ilstItem("\xa9nam", "Song")))),
+			Info{Kind: KindAudio, Duration: 30, Title: "Song"},
+		},
+		{
+			"quicktime meta without version and flags",
+			concat(ftyp, box("moov", mvhd(600, 1200), box("udta", box("meta", hdlr("mdir"), box("ilst", ilstItem("\xa9ART", "Artist")))))),
+			Info{Kind: KindAudio, Duration: 2, Artist: "Artist"},
+		},
+		{
+			"64 bit media data before the movie",
+			concat(ftyp, largeBox("mdat", 16+1000, make([]byte, 1000)), box("moov", mvhd(1000, 500))),
+			Info{Kind: KindAudio, Duration: 0.5},
+		},
+		{
+			"movie running to the end of the file",
+			concat(ftyp, boxHeader("moov", 0), mvhd(1000, 3000), track(320, 240, "vide")),
+			Info{Kind: KindVideo, Width: 320, Height: 240, Duration: 3},
+		},
+		{
+			"unknown duration",
+			concat(ftyp, box("moov", mvhd(1000, 0xffffffff))),
+			Info{Kind: KindAudio},
+		},
+		{
+			"zero timescale",
+			concat(ftyp, box("moov", mvhd(0, 1000))),
+			Info{Kind: KindAudio},
+		},
+		{
+			"tags that aren't text",
+			concat(ftyp, box("moov", iTunesMeta(box("\xa9nam", box("data", be32(21), be32(0), []byte{0, 1}))))),
+			Info{Kind: KindAudio},
+		},
+		{
+			"short track header",
+			concat(ftyp, box("moov", box("trak", box("tkhd", fullBox(0), make([]byte, 20)), box("mdia", hdlr("vide"))))),
+			Info{Kind: KindAudio},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			info, err := readMedia(t, test.data, "video/mp4")
+			if err != nil {
+				t.Fatal(err)
+			}
+			check(t, info, test.want)
+		})
+	}
+}
+
+func TestMP4OversizedLengths(t *testing.T) {
+	ftyp := box("ftyp", []byte("isom\x00\x00\x02\x00"))
+	movie := box("moov", mvhd(1000, 2000))
+
+	tests := []struct {
+		name string
+		data []byte
+		want Info
+	}{
+		{"box claiming more than the file", concat(ftyp, boxHeader("moov", 0xffffffff), mvhd(1000, 2000)), Info{Kind: KindAudio}},
+		{"box smaller than its header", concat(ftyp, boxHeader("free", 4), movie), Info{Kind: KindAudio}},
+		{"64 bit size past the file", concat(ftyp, largeBox("mdat", 1<<40, nil), movie), Info{Kind: KindAudio}},
+		{"64 bit size near the int64 limit", concat(ftyp, largeBox("mdat", 1<<63-1, nil), movie), Info{Kind: KindAudio}},
+		{"64 bit size wrapping negative", concat(ftyp, largeBox("mdat", 1<<64-1, nil), movie), Info{Kind: KindAudio}},
+		{"64 bit size smaller than its header", concat(ftyp, largeBox("free", 8, nil), movie), Info{Kind: KindAudio}},
+		{"64 bit size cut off", concat(ftyp, be32(1), []byte("mdat\x00\x00")), Info{Kind: KindAudio}},
+		{
+			"child claiming more than its parent",
+			concat(ftyp, box("moov", mvhd(1000, 2000), boxHeader("trak", 0xfffffff0), tkhd(1920, 1080)), box("free", make([]byte, 100))),
+			Info{Kind: KindAudio, Duration: 2},
+		},
+		{
+			"child at the largest 64 bit size",
+			concat(ftyp, box("moov", mvhd(1000, 2000), largeBox("udta", 1<<63-1, iTunesMeta(ilstItem("\xa9nam", "Lost"))))),
+			Info{Kind: KindAudio, Duration: 2},
+		},
+		{
+			"child running to the end of its parent",
+			concat(ftyp, box("moov", box("udta", boxHeader("meta", 0), fullBox(0), box("ilst", ilstItem("\xa9nam", "Title")))), box("free")),
+			Info{Kind: KindAudio, Title: "Title"},
+		},
+		{
+			"data box claiming more than its item",
+			concat(ftyp, box("moov", iTunesMeta(box("\xa9nam", boxHeader("data", 0xffffffff), be32(1), be32(0), []byte("Lost"))))),
+			Info{Kind: KindAudio},
+		},
+		{
+			"header box claiming more than the movie",
+			concat(ftyp, box("moov", boxHeader("mvhd", 0xffff), fullBox(0)), box("free", make([]byte, 0xffff))),
+			Info{Kind: KindAudio},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			info, err := readMedia(t, test.data, "video/mp4")
+			if err != nil {
+				t.Fatal(err)
+			}
+			check(t, info, test.want)
+		})
+	}
+}
+
+func TestMP4TooManyBoxes(t *testing.T) {
+	var boxes [][]byte
+	for range maxMP4Boxes {
+		boxes = append(boxes, box("free"))
+	}
+	data := concat(box("ftyp", []byte("isom")), box("moov", concat(boxes...)))
+
+	if _, err := readMedia(t, data, "video/mp4"); !errors.Is(err, errTooManyBoxes) {
+		t.Fatalf("err = %v, want errTooManyBoxes", err)
+	}
+}
+
+func TestMP4Truncated(t *testing.T) {
+	eachPrefix(sampleMP4(), func(prefix []byte) {
+		readMedia(t, prefix, "video/mp4")
+	})
+}
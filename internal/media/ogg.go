@@ -0,0 +1,91 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+)
+
+const (
+	// The identification and comment headers are the first two packets
+	maxOggHeaderBytes = 1 << 20
+	// The last page, which carries the final sample position, is searched for in this tail
+	oggTailBytes = 64 << 10
+)
+
+// readOgg reads Vorbis and Opus files. The length is the granule position of
+// the last page, a sample count.
+func readOgg(r io.ReaderAt, size int64) (*Info, error) {
+	data, err := readAt(r, 0, min(size, maxOggHeaderBytes))
+	if err != nil {
+		return nil, err
+	}
+	packets := oggPackets(data, 2)
+	if len(packets) < 2 {
+		return nil, ErrUnsupported
+	}
+
+	info := &Info{Kind: KindAudio}
+	var sampleRate, preSkip uint64
+	identification, comment := packets[0], packets[1]
+	switch {
+	case len(identification) >= 16 && bytes.HasPrefix(identification, []byte("\x01vorbis")):
+		sampleRate = uint64(binary.LittleEndian.Uint32(identification[12:]))
+		if bytes.HasPrefix(comment, []byte("\x03vorbis")) {
+			readVorbisComment(comment[7:], info)
+		}
+	case len(identification) >= 12 && bytes.HasPrefix(identification, []byte("OpusHead")):
+		// Opus positions always count at 48kHz, whatever the input rate was
+		sampleRate = 48000
+		preSkip = uint64(binary.LittleEndian.Uint16(identification[10:]))
+		if bytes.HasPrefix(comment, []byte("OpusTags")) {
+			readVorbisComment(comment[8:], info)
+		}
+	default:
+		return nil, ErrUnsupported
+	}
+
+	tailStart := max(0, size-oggTailBytes)
+	tail, err := readAt(r, tailStart, size-tailStart)
+	if err != nil {
+		return nil, err
+	}
+	if last := bytes.LastIndex(tail, []byte("OggS")); last >= 0 && last+14 <= len(tail) {
+		granule := binary.LittleEndian.Uint64(tail[last+6:])
+		if sampleRate > 0 && granule > preSkip && granule != ^uint64(0) {
+			info.Duration = float64(granule-preSkip) / float64(sampleRate)
+		}
+	}
+	return info, nil
+}
+
+// oggPackets joins the segments of the pages in data back into packets, up to count of them
+func oggPackets(data []byte, count int) [][]byte {
+	var packets [][]byte
+	var current []byte
+	for len(data) >= 27 && string(data[:4]) == "OggS" && len(packets) < count {
+		segments := int(data[26])
+		if len(data) < 27+segments {
+			break
+		}
+		table := data[27 : 27+segments]
+		body := data[27+segments:]
+		for _, length := range table {
+			if int(length) > len(body) {
+				return packets
+			}
+			current = append(current, body[:length]...)
+			body = body[length:]
+			// A segment shorter than 255 bytes ends its packet
+			if length < 255 {
+				packets = append(packets, current)
+				current = nil
+				if len(packets) == count {
+					return packets
+				}
+			}
+		}
+		data = body
+	}
+	return packets
+}
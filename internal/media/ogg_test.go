@@ -0,0 +1,154 @@
+package media
+
+import (
+	"encoding/binary"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// oggPage wraps the segments in a page header. The checksum isn't checked.
+func oggPage(granule uint64, segments []byte, body []byte) []byte {
+	header := concat([]byte("OggS\x00\x00"), binary.LittleEndian.AppendUint64(nil, granule), make([]byte, 12), []byte{byte(len(segments))})
+	return concat(header, segments, body)
+}
+
+// lacing is the segment table of a packet of n bytes. An unfinished packet
+// carries on into the next page, so it doesn't end with a short segment.
+func lacing(n int, finished bool) []byte {
+	var table []byte
+	for ; n >= 255; n -= 255 {
+		table = append(table, 255)
+	}
+	if finished {
+		table = append(table, byte(n))
+	}
+	return table
+}
+
+// oggPacketsPage puts whole packets on one page
+func oggPacketsPage(granule uint64, packets ...[]byte) []byte {
+	var segments []byte
+	for _, packet := range packets {
+		segments = append(segments, lacing(len(packet), true)...)
+	}
+	return oggPage(granule, segments, concat(packets...))
+}
+
+func vorbisIdentification(sampleRate uint32) []byte {
+	return concat([]byte("\x01vorbis"), le32(0), []byte{2}, le32(sampleRate), make([]byte, 14))
+}
+
+func opusHead(preSkip uint16) []byte {
+	return concat([]byte("OpusHead\x01\x02"), binary.LittleEndian.AppendUint16(nil, preSkip), le32(44100), make([]byte, 3))
+}
+
+func sampleOgg() []byte {
+	return concat(
+		oggPacketsPage(0, vorbisIdentification(44100)),
+		oggPacketsPage(0, concat([]byte("\x03vorbis"), vorbisComment("Xiph.Org libVorbis", "TITLE=Title", "ARTIST=Artist", "ALBUM=Album"), []byte{1})),
+		oggPacketsPage(220500, make([]byte, 300)),
+		oggPacketsPage(441000, make([]byte, 300)),
+	)
+}
+
+func TestOgg(t *testing.T) {
+	// A comment packet too long for one page, split after its first 255 byte segment
+	artist := strings.Repeat("a", 600)
+	long := concat([]byte("OpusTags"), vorbisComment("libopus", "TITLE=Long", "ARTIST="+artist))
+
+	tests := []struct {
+		name string
+		data []byte
+		want Info
+	}{
+		{"vorbis", sampleOgg(), Info{Kind: KindAudio, Duration: 10, Title: "Title", Artist: "Artist", Album: "Album"}},
+		{
+			"opus counts from the pre-skip at 48kHz",
+			concat(
+				oggPacketsPage(0, opusHead(312)),
+				oggPacketsPage(0, concat([]byte("OpusTags"), vorbisComment("libopus", "TITLE=Opus"))),
+				oggPacketsPage(96312, make([]byte, 100)),
+			),
+			Info{Kind: KindAudio, Duration: 2, Title: "Opus"},
+		},
+		{
+			"both header packets on one page",
+			concat(
+				oggPacketsPage(0, vorbisIdentification(48000), concat([]byte("\x03vorbis"), vorbisComment("", "TITLE=Shared"))),
+				oggPacketsPage(48000, make([]byte, 10)),
+			),
+			Info{Kind: KindAudio, Duration: 1, Title: "Shared"},
+		},
+		{
+			"comment packet spanning pages",
+			concat(
+				oggPacketsPage(0, opusHead(0)),
+				oggPage(0, lacing(255, false), long[:255]),
+				oggPage(0, lacing(len(long)-255, true), long[255:]),
+				oggPacketsPage(48000, make([]byte, 10)),
+			),
+			Info{Kind: KindAudio, Duration: 1, Title: "Long", Artist: artist},
+		},
+		{
+			"unknown last position",
+			concat(oggPacketsPage(0, vorbisIdentification(44100), concat([]byte("\x03vorbis"), vorbisComment(""))), oggPacketsPage(^uint64(0), nil)),
+			Info{Kind: KindAudio},
+		},
+		{
+			"position before the pre-skip",
+			concat(oggPacketsPage(0, opusHead(312), []byte("OpusTags")), oggPacketsPage(100, nil)),
+			Info{Kind: KindAudio},
+		},
+		{
+			"comment packet of another codec",
+			concat(oggPacketsPage(0, vorbisIdentification(44100), concat([]byte("OpusTags"), vorbisComment("", "TITLE=Wrong"))), oggPacketsPage(44100, nil)),
+			Info{Kind: KindAudio, Duration: 1},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			info, err := readMedia(t, test.data, "audio/ogg")
+			if err != nil {
+				t.Fatal(err)
+			}
+			check(t, info, test.want)
+		})
+	}
+}
+
+func TestOggUnsupported(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"theora video", oggPacketsPage(0, []byte("\x80theora"), []byte("\x81theora"))},
+		{"one packet", oggPacketsPage(0, vorbisIdentification(44100))},
+		{"short identification", oggPacketsPage(0, []byte("\x01vorbis"), []byte("\x03vorbis"))},
+		{"segment table past the end", concat(oggPage(0, nil, nil)[:26], []byte{255}, make([]byte, 10))},
+		{"segments longer than the page", oggPage(0, []byte{200, 200}, make([]byte, 50))},
+		{"page without the capture pattern", concat(oggPacketsPage(0, vorbisIdentification(44100)), []byte("Oggs"), make([]byte, 40))},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := readMedia(t, test.data, "audio/ogg"); !errors.Is(err, ErrUnsupported) {
+				t.Fatalf("err = %v, want ErrUnsupported", err)
+			}
+		})
+	}
+}
+
+func TestOggLastPageCutOff(t *testing.T) {
+	data := concat(oggPacketsPage(0, vorbisIdentification(44100), concat([]byte("\x03vorbis"), vorbisComment(""))), []byte("OggS\x00\x00\x44\xac"))
+	info, err := readMedia(t, data, "audio/ogg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(t, info, Info{Kind: KindAudio})
+}
+
+func TestOggTruncated(t *testing.T) {
+	eachPrefix(sampleOgg(), func(prefix []byte) {
+		readMedia(t, prefix, "audio/ogg")
+	})
+}
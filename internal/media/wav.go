@@ -0,0 +1,79 @@
+package media
+
+import (
+	"encoding/binary"
+	"io"
+)
+
+// readWAV walks the RIFF chunks. The length follows from the byte rate in
+// "fmt " and the size of "data", the tags live in a LIST INFO chunk.
+func readWAV(r io.ReaderAt, size int64) (*Info, error) {
+	info := &Info{Kind: KindAudio}
+
+	var byteRate uint32
+	var dataSize int64
+	offset := int64(12)
+	for offset+8 <= size {
+		header, err := readAt(r, offset, 8)
+		if err != nil {
+			return nil, err
+		}
+		if len(header) < 8 {
+			break
+		}
+		id := string(header[:4])
+		length := int64(binary.LittleEndian.Uint32(header[4:]))
+		offset += 8
+
+		switch id {
+		case "fmt ":
+			chunk, err := readAt(r, offset, min(length, 16))
+			if err != nil {
+				return nil, err
+			}
+			if len(chunk) >= 12 {
+				byteRate = binary.LittleEndian.Uint32(chunk[8:])
+			}
+		case "data":
+			// Files still being written can claim more than they hold
+			dataSize = min(length, size-offset)
+		case "LIST":
+			chunk, err := readAt(r, offset, min(length, maxID3Bytes))
+			if err != nil {
+				return nil, err
+			}
+			if len(chunk) >= 4 && string(chunk[:4]) == "INFO" {
+				readRIFFInfo(chunk[4:], info)
+			}
+		}
+
+		// Chunks are padded to an even length
+		offset += length + length%2
+	}
+
+	if byteRate > 0 {
+		info.Duration = float64(dataSize) / float64(byteRate)
+	}
+	return info, nil
+}
+
+var riffInfoTags = map[string]string{
+	"INAM": "TITLE",
+	"IART": "ARTIST",
+	"IPRD": "ALBUM",
+}
+
+func readRIFFInfo(data []byte, info *Info) {
+	for len(data) >= 8 {
+		id := string(data[:4])
+		length := int(binary.LittleEndian.Uint32(data[4:]))
+		data = data[8:]
+		if length < 0 || length > len(data) {
+			return
+		}
+		if key, ok := riffInfoTags[id]; ok {
+			setTag(info, key, string(data[:length]))
+		}
+		data = data[min(length+length%2, len(data)):]
+	}
+}
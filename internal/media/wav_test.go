@@ -0,0 +1,113 @@
+package media
+
+import (
+	"testing"
+)
+
+// riffChunk pads odd payloads to an even length like RIFF writers do
+func riffChunk(id string, payload []byte) []byte {
+	out := concat([]byte(id), le32(uint32(len(payload))), payload)
+	if len(payload)%2 == 1 {
+		out = append(out, 0)
+	}
+	return out
+}
+
+func buildWAV(chunks ...[]byte) []byte {
+	body := concat(append([][]byte{[]byte("WAVE")}, chunks...)...)
+	return concat([]byte("RIFF"), le32(uint32(len(body))), body)
+}
+
+// wavFormat is a PCM "fmt " chunk, only its byte rate matters
+func wavFormat(byteRate uint32) []byte {
+	return riffChunk("fmt ", concat([]byte{1, 0, 1, 0}, le32(byteRate/2), le32(byteRate), []byte{2, 0, 16, 0}))
+}
+
+func riffInfo(entries ...[]byte) []byte {
+	return riffChunk("LIST", concat(append([][]byte{[]byte("INFO")}, entries...)...))
+}
+
+func sampleWAV() []byte {
+	return buildWAV(
+		wavFormat(16000),
+		riffChunk("data", make([]byte, 32000)),
+		riffInfo(riffChunk("INAM", []byte("Title\x00")), riffChunk("IART", []byte("Bob")), riffChunk("IPRD", []byte("Album\x00"))),
+	)
+}
+
+func TestWAV(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want Info
+	}{
+		{"pcm with info tags", sampleWAV(), Info{Kind: KindAudio, Duration: 2, Title: "Title", Artist: "Bob", Album: "Album"}},
+		{
+			"unknown chunks are skipped",
+			buildWAV(riffChunk("JUNK", []byte("odd")), wavFormat(8000), riffChunk("fact", le32(4000)), riffChunk("data", make([]byte, 4000))),
+			Info{Kind: KindAudio, Duration: 0.5},
+		},
+		{"no format chunk", buildWAV(riffChunk("data", make([]byte, 100))), Info{Kind: KindAudio}},
+		{"short format chunk", buildWAV(riffChunk("fmt ", []byte{1, 0, 1, 0, 0x40, 0x1f, 0, 0}), riffChunk("data", make([]byte, 100))), Info{Kind: KindAudio}},
+		{"list that isn't info", buildWAV(riffChunk("LIST", []byte("adtlINAMxxxx"))), Info{Kind: KindAudio}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			info, err := readMedia(t, test.data, "audio/wav")
+			if err != nil {
+				t.Fatal(err)
+			}
+			check(t, info, test.want)
+		})
+	}
+}
+
+func TestWAVOversizedLengths(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want Info
+	}{
+		{
+			// Recorders that stop early leave the placeholder size behind
+			"data chunk claiming more than the file",
+			buildWAV(wavFormat(16000), concat([]byte("data"), le32(0xffffffff), make([]byte, 16000))),
+			Info{Kind: KindAudio, Duration: 1},
+		},
+		{
+			"format chunk claiming more than the file",
+			buildWAV(concat([]byte("fmt "), le32(0xffffffff), []byte{1, 0, 1, 0}, le32(8000), le32(16000))),
+			Info{Kind: KindAudio},
+		},
+		{
+			"list chunk claiming more than the file",
+			buildWAV(concat([]byte("LIST"), le32(0xfffffffe), []byte("INFO"), riffChunk("INAM", []byte("kept")))),
+			Info{Kind: KindAudio, Title: "kept"},
+		},
+		{
+			"info entry claiming more than the list",
+			buildWAV(riffInfo(riffChunk("IART", []byte("kept")), concat([]byte("INAM"), le32(0xffffffff), []byte("lost")))),
+			Info{Kind: KindAudio, Artist: "kept"},
+		},
+		{
+			"info entry length wrapping negative",
+			buildWAV(riffInfo(concat([]byte("INAM"), le32(0x80000000), []byte("lost")))),
+			Info{Kind: KindAudio},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			info, err := readMedia(t, test.data, "audio/wav")
+			if err != nil {
+				t.Fatal(err)
+			}
+			check(t, info, test.want)
+		})
+	}
+}
+
+func TestWAVTruncated(t *testing.T) {
+	eachPrefix(sampleWAV(), func(prefix []byte) {
+		readMedia(t, prefix, "audio/wav")
+	})
+}
@@ -1,78 +1,120 @@
 package middleware
 
 import (
-	"fmt"
+	"errors"
 	"goCal/internal/logger"
-	"goCal/internal/types"
-	"os"
+	"goCal/internal/schema"
+	"goCal/internal/services"
 	"strings"
 
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt/v4"
 )
 
-func AuthMiddleware() gin.HandlerFunc {
-	var ADMIN_EMAIL string
+var errMissingToken = errors.New("Missing Authorization header")
+
+// extractToken reads the bearer token from the Authorization header, falling back to the token header
+func extractToken(ctx *gin.Context) string {
+	var tokenString string
 
-	ADMIN_EMAIL = os.Getenv("ADMIN_EMAIL")
-	if ADMIN_EMAIL == "" {
-		fmt.Printf(`Failed to get the ADMIN_EMAIL`)
+	// Check Authorization header first (standard way)
+	authHeader := ctx.GetHeader("Authorization")
+	if authHeader != "" {
+		// Extract token from "Bearer <token>" format
+		tokenParts := strings.Split(authHeader, " ")
+		if len(tokenParts) == 2 && tokenParts[0] == "Bearer" {
+			tokenString = tokenParts[1]
+		}
 	}
-	JWT_KEY := os.Getenv("JWT_KEY")
-	if JWT_KEY == "" {
-		logger.Error(`Failed to get the database url`)
-		fmt.Printf(`Failed to get the database url`)
+
+	// Fallback to token header if Authorization is not present or invalid
+	if tokenString == "" {
+		tokenString = ctx.GetHeader("token")
 	}
-	return func(ctx *gin.Context) {
-		var tokenString string
-
-		// Check Authorization header first (standard way)
-		authHeader := ctx.GetHeader("Authorization")
-		if authHeader != "" {
-			// Extract token from "Bearer <token>" format
-			tokenParts := strings.Split(authHeader, " ")
-			if len(tokenParts) == 2 && tokenParts[0] == "Bearer" {
-				tokenString = tokenParts[1]
-			}
-		}
+	return tokenString
+}
 
-		// Fallback to token header if Authorization is not present or invalid
-		if tokenString == "" {
-			tokenString = ctx.GetHeader("token")
-		}
+// authenticate validates the request token and its session, or the API key,
+// and stores the caller in the context
+func authenticate(ctx *gin.Context, authService *services.AuthService, apiKeyService *services.ApiKeyService) error {
+	tokenString := extractToken(ctx)
+	if tokenString == "" {
+		return errMissingToken
+	}
 
-		if tokenString == "" {
-			ctx.JSON(401, gin.H{"error": "Missing Authorization header", "success": false})
-			ctx.Abort()
-			return
+	if services.IsApiKey(tokenString) {
+		apiKey, user, err := authenticateApiKey(ctx, apiKeyService, tokenString)
+		if err != nil {
+			logger.Error("Rejected API key: %v", err)
+			return err
 		}
+		ctx.Set("userId", user.ID.String())
+		ctx.Set("email", user.Email)
+		ctx.Set("apiKeyId", apiKey.ID.String())
+		// GetStringSlice only finds a plain []string
+		ctx.Set("scopes", []string(apiKey.Scopes))
+		return nil
+	}
 
-		claims := &types.Claims{}
-		token, err := jwt.ParseWithClaims(tokenString, claims,
-			func(t *jwt.Token) (interface{}, error) {
-				return []byte(JWT_KEY), nil
-			})
-		if err != nil {
-			fmt.Printf("JWT Parse Error: %v", err)
-			ctx.JSON(401, gin.H{"error": "Invalid token", "success": false})
+	claims, err := authService.ParseAccessToken(tokenString)
+	if err != nil {
+		logger.Error("Rejected access token: %v", err)
+		return err
+	}
+
+	ctx.Set("userId", claims.Id)
+	ctx.Set("email", claims.Issuer)
+	ctx.Set("sessionId", claims.SessionId)
+	return nil
+}
+
+// apiKeyAuth is the context key of an API key already authenticated for this
+// request, so the rate limiters and authentication look it up once
+const apiKeyAuth = "apiKeyAuth"
+
+type authenticatedApiKey struct {
+	apiKey *schema.ApiKey
+	user   *schema.User
+	err    error
+}
+
+func authenticateApiKey(ctx *gin.Context, apiKeyService *services.ApiKeyService, key string) (*schema.ApiKey, *schema.User, error) {
+	if cached, ok := ctx.Get(apiKeyAuth); ok {
+		result := cached.(*authenticatedApiKey)
+		return result.apiKey, result.user, result.err
+	}
+	apiKey, user, err := apiKeyService.Authenticate(key)
+	ctx.Set(apiKeyAuth, &authenticatedApiKey{apiKey: apiKey, user: user, err: err})
+	return apiKey, user, err
+}
+
+func AuthMiddleware() gin.HandlerFunc {
+	authService := services.NewAuthService()
+	apiKeyService := services.NewApiKeyService()
+	return func(ctx *gin.Context) {
+		if err := authenticate(ctx, authService, apiKeyService); err != nil {
+			ctx.JSON(401, gin.H{"error": err.Error(), "success": false})
 			ctx.Abort()
 			return
 		}
-		if !token.Valid {
-			fmt.Printf("Token is not valid")
-			ctx.JSON(401, gin.H{"error": "Invalid token", "success": false})
+
+		ctx.Next()
+	}
+}
+
+// OptionalAuthMiddleware identifies the caller when a token is sent but lets
+// anonymous requests through, for routes that also serve public content.
+// A token that is present but invalid is still rejected.
+func OptionalAuthMiddleware() gin.HandlerFunc {
+	authService := services.NewAuthService()
+	apiKeyService := services.NewApiKeyService()
+
+	return func(ctx *gin.Context) {
+		if err := authenticate(ctx, authService, apiKeyService); err != nil && !errors.Is(err, errMissingToken) {
+			ctx.JSON(401, gin.H{"error": err.Error(), "success": false})
 			ctx.Abort()
 			return
 		}
 
-		ctx.Set("userId", claims.Id)
-		ctx.Set("email", claims.Issuer)
-		if strings.ToLower(claims.Issuer) == strings.ToLower(ADMIN_EMAIL) {
-			ctx.Set("role", "admin")
-		} else {
-			ctx.Set("role", "user")
-		}
-
 		ctx.Next()
 	}
 }
@@ -0,0 +1,44 @@
+package middleware
+
+import (
+	"goCal/internal/logger"
+	"goCal/internal/permissions"
+	"goCal/internal/services"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RequirePermission lets the request through only when the caller's role
+// grants every listed permission. The role is read from the users table on
+// each request so a demotion takes effect immediately. Use after AuthMiddleware.
+func RequirePermission(required ...string) gin.HandlerFunc {
+	authService := services.NewAuthService()
+
+	return func(ctx *gin.Context) {
+		role, err := authService.GetRole(ctx.GetString("userId"))
+		if err != nil {
+			logger.Error("Failed to load the role of user %s: %v", ctx.GetString("userId"), err)
+			ctx.JSON(http.StatusUnauthorized, gin.H{
+				"success": false,
+				"error":   "User Not Found",
+			})
+			ctx.Abort()
+			return
+		}
+
+		for _, permission := range required {
+			if !permissions.HasPermission(role, permission) {
+				ctx.JSON(http.StatusForbidden, gin.H{
+					"success": false,
+					"error":   "You do not have the " + permission + " permission",
+				})
+				ctx.Abort()
+				return
+			}
+		}
+
+		ctx.Set("role", role)
+		ctx.Next()
+	}
+}
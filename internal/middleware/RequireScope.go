@@ -0,0 +1,49 @@
+package middleware
+
+import (
+	"net/http"
+	"slices"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RequireScope lets API keys through only when they carry scope. Requests
+// signed in with a session are not limited by scopes.
+func RequireScope(scope string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if !HasScope(ctx, scope) {
+			ctx.JSON(http.StatusForbidden, gin.H{
+				"success": false,
+				"error":   "API key is missing the " + scope + " scope",
+			})
+			ctx.Abort()
+			return
+		}
+		ctx.Next()
+	}
+}
+
+// HasScope reports whether the request may use scope, which is always the
+// case for sessions
+func HasScope(ctx *gin.Context, scope string) bool {
+	if ctx.GetString("apiKeyId") == "" {
+		return true
+	}
+	return slices.Contains(ctx.GetStringSlice("scopes"), scope)
+}
+
+// SessionOnly keeps API keys away from account management, such as minting
+// more keys or changing the password
+func SessionOnly() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if ctx.GetString("apiKeyId") != "" {
+			ctx.JSON(http.StatusForbidden, gin.H{
+				"success": false,
+				"error":   "API keys cannot access this api, log in instead",
+			})
+			ctx.Abort()
+			return
+		}
+		ctx.Next()
+	}
+}
@@ -0,0 +1,296 @@
+package pagination
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+const (
+	DefaultLimit = 50
+	MaxLimit     = 200
+)
+
+var (
+	ErrInvalidCursor = errors.New("invalid cursor")
+	ErrInvalidSort   = errors.New("invalid sort field")
+)
+
+// Kind says how a sort column's value is carried in a cursor
+type Kind int
+
+const (
+	String Kind = iota
+	Int
+	Float
+	Time
+)
+
+// Sort is a column a list can be ordered by. Lists always add the primary key
+// as a tie-breaker so every row has a distinct position.
+type Sort struct {
+	Column string
+	Kind   Kind
+}
+
+// Sorts are the columns a list accepts in ?sort=, plus the one used when none is given
+type Sorts struct {
+	Fields  map[string]Sort
+	Default string
+	// DefaultDesc orders the default sort newest (or largest) first
+	DefaultDesc bool
+}
+
+// Params are the query parameters shared by every list endpoint
+type Params struct {
+	Limit  int    `form:"limit" binding:"omitempty,min=1"`
+	Cursor string `form:"cursor"`
+	Sort   string `form:"sort"`
+	Order  string `form:"order" binding:"omitempty,oneof=asc desc"`
+}
+
+// PageLimit is the page size params ask for, within MaxLimit
+func (p Params) PageLimit() int {
+	if p.Limit <= 0 {
+		return DefaultLimit
+	}
+	return min(p.Limit, MaxLimit)
+}
+
+// Page is returned next to the items as "pagination"
+type Page struct {
+	Limit      int     `json:"limit"`
+	NextCursor *string `json:"next_cursor"` // null on the last page
+	Total      int64   `json:"total"`
+}
+
+// cursor marks the last row of a page. Sort and Desc are kept so a cursor
+// can't be replayed against a different ordering.
+type cursor struct {
+	Sort  string `json:"s"`
+	Desc  bool   `json:"d,omitempty"`
+	Value string `json:"v"`
+	Id    string `json:"id"`
+}
+
+var schemaCache sync.Map
+
+// Find runs query one page at a time, keyset-paginated on the chosen sort
+// column and idColumn. query should carry the list's filters but no order or limit.
+func Find[T any](query *gorm.DB, params Params, sorts Sorts, idColumn string) ([]T, *Page, error) {
+	sortName := params.Sort
+	if sortName == "" {
+		sortName = sorts.Default
+	}
+	sort, ok := sorts.Fields[sortName]
+	if !ok {
+		return nil, nil, fmt.Errorf("%w %q", ErrInvalidSort, sortName)
+	}
+	desc := params.Order == "desc" || (params.Order == "" && params.Sort == "" && sorts.DefaultDesc)
+
+	limit := params.PageLimit()
+
+	// A bad cursor is the caller's mistake, it is checked before anything
+	// reaches the database
+	var after []any
+	if params.Cursor != "" {
+		var err error
+		if after, err = parseCursor[T](query, params.Cursor, sortName, sort, desc, idColumn); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	base := query.Session(&gorm.Session{})
+
+	var total int64
+	if err := base.Model(new(T)).Count(&total).Error; err != nil {
+		return nil, nil, err
+	}
+
+	page := base
+	if after != nil {
+		operator := ">"
+		if desc {
+			operator = "<"
+		}
+		page = page.Where(fmt.Sprintf("(%s, %s) %s (?, ?)", sort.Column, idColumn, operator), after...)
+	}
+
+	var items []T
+	if err := page.
+		Order(clause.OrderBy{Columns: []clause.OrderByColumn{
+			{Column: clause.Column{Name: sort.Column, Raw: true}, Desc: desc},
+			{Column: clause.Column{Name: idColumn, Raw: true}, Desc: desc},
+		}}).
+		Limit(limit + 1).
+		Find(&items).Error; err != nil {
+		return nil, nil, err
+	}
+
+	result := &Page{Limit: limit, Total: total}
+	if len(items) > limit {
+		items = items[:limit]
+		next, err := encodeCursor(query, items[limit-1], sortName, sort, desc, idColumn)
+		if err != nil {
+			return nil, nil, err
+		}
+		result.NextCursor = &next
+	}
+	return items, result, nil
+}
+
+// parseCursor decodes a cursor into the sort value and id to resume after,
+// each parsed as the type its column holds
+func parseCursor[T any](query *gorm.DB, encoded string, sortName string, sort Sort, desc bool, idColumn string) ([]any, error) {
+	after, err := decodeCursor(encoded, sortName, desc)
+	if err != nil {
+		return nil, err
+	}
+	value, err := parseValue(sort.Kind, after.Value)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	parsed, err := schema.Parse(new(T), &schemaCache, query.NamingStrategy)
+	if err != nil {
+		return nil, err
+	}
+	id, err := parseId(parsed, idColumn, after.Id)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	return []any{value, id}, nil
+}
+
+func encodeCursor[T any](query *gorm.DB, last T, sortName string, sort Sort, desc bool, idColumn string) (string, error) {
+	parsed, err := schema.Parse(new(T), &schemaCache, query.NamingStrategy)
+	if err != nil {
+		return "", err
+	}
+	row := reflect.Indirect(reflect.ValueOf(last))
+
+	value, err := fieldValue(parsed, row, sort.Column)
+	if err != nil {
+		return "", err
+	}
+	id, err := fieldValue(parsed, row, idColumn)
+	if err != nil {
+		return "", err
+	}
+
+	encoded, err := json.Marshal(cursor{Sort: sortName, Desc: desc, Value: formatValue(sort.Kind, value), Id: fmt.Sprint(id)})
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(encoded), nil
+}
+
+// lookUpColumn finds the field of column, which may be qualified with its table
+func lookUpColumn(parsed *schema.Schema, column string) (*schema.Field, error) {
+	name := column
+	if i := strings.LastIndex(column, "."); i >= 0 {
+		name = column[i+1:]
+	}
+	field := parsed.LookUpField(name)
+	if field == nil {
+		return nil, fmt.Errorf("pagination: %s has no column %s", parsed.Name, column)
+	}
+	return field, nil
+}
+
+// fieldValue reads column off row
+func fieldValue(parsed *schema.Schema, row reflect.Value, column string) (any, error) {
+	field, err := lookUpColumn(parsed, column)
+	if err != nil {
+		return nil, err
+	}
+	value, _ := field.ValueOf(context.Background(), row)
+	if valuer, ok := value.(driver.Valuer); ok {
+		return valuer.Value()
+	}
+	return value, nil
+}
+
+func formatValue(kind Kind, value any) string {
+	if kind == Time {
+		if t, ok := value.(time.Time); ok {
+			return t.UTC().Format(time.RFC3339Nano)
+		}
+	}
+	return fmt.Sprint(value)
+}
+
+func parseValue(kind Kind, value string) (any, error) {
+	switch kind {
+	case Int:
+		return strconv.ParseInt(value, 10, 64)
+	case Float:
+		number, err := strconv.ParseFloat(value, 64)
+		if err != nil || math.IsNaN(number) || math.IsInf(number, 0) {
+			return nil, ErrInvalidCursor
+		}
+		return number, nil
+	case Time:
+		return time.Parse(time.RFC3339Nano, value)
+	default:
+		// Postgres rejects NUL in text
+		if strings.ContainsRune(value, 0) {
+			return nil, ErrInvalidCursor
+		}
+		return value, nil
+	}
+}
+
+// parseId converts a cursor's id into the type of the id column, so a
+// tampered id is rejected here instead of by the database
+func parseId(parsed *schema.Schema, column string, id string) (any, error) {
+	field, err := lookUpColumn(parsed, column)
+	if err != nil {
+		return nil, err
+	}
+
+	target := reflect.New(field.IndirectFieldType)
+	if scanner, ok := target.Interface().(sql.Scanner); ok {
+		if err := scanner.Scan(id); err != nil {
+			return nil, err
+		}
+		return target.Elem().Interface(), nil
+	}
+	switch field.IndirectFieldType.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.ParseInt(id, 10, 64)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.ParseUint(id, 10, 64)
+	case reflect.String:
+		return parseValue(String, id)
+	}
+	return nil, fmt.Errorf("pagination: unsupported id column %s", column)
+}
+
+func decodeCursor(encoded string, sortName string, desc bool) (*cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var after cursor
+	if err := json.Unmarshal(raw, &after); err != nil || after.Id == "" {
+		return nil, ErrInvalidCursor
+	}
+	if after.Sort != sortName || after.Desc != desc {
+		return nil, fmt.Errorf("%w: it was issued for a different sort", ErrInvalidCursor)
+	}
+	return &after, nil
+}
@@ -0,0 +1,339 @@
+package pagination
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+type item struct {
+	ID        uuid.UUID `gorm:"primaryKey;type:uuid"`
+	Name      string
+	Size      int64
+	Score     float64
+	CreatedAt time.Time
+}
+
+var itemSorts = Sorts{
+	Fields: map[string]Sort{
+		"name":       {Column: "name", Kind: String},
+		"size":       {Column: "size", Kind: Int},
+		"score":      {Column: "score", Kind: Float},
+		"created_at": {Column: "created_at", Kind: Time},
+	},
+	Default:     "created_at",
+	DefaultDesc: true,
+}
+
+// recordingConn is a database/sql driver that answers every query with the
+// rows queued on it and keeps the SQL and arguments it was sent
+type recordingConn struct {
+	mu      sync.Mutex
+	queries []recordedQuery
+	results [][][]driver.Value
+}
+
+type recordedQuery struct {
+	sql  string
+	args []driver.Value
+}
+
+func (c *recordingConn) Prepare(string) (driver.Stmt, error) {
+	return nil, errors.New("prepare is not supported")
+}
+func (c *recordingConn) Close() error { return nil }
+func (c *recordingConn) Begin() (driver.Tx, error) {
+	return nil, errors.New("transactions are not supported")
+}
+
+func (c *recordingConn) QueryContext(_ context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	values := make([]driver.Value, len(args))
+	for i, arg := range args {
+		values[i] = arg.Value
+	}
+	c.queries = append(c.queries, recordedQuery{sql: query, args: values})
+
+	rows := &fakeRows{}
+	if strings.Contains(query, "count(*)") {
+		rows.columns = []string{"count"}
+		rows.values = [][]driver.Value{{int64(0)}}
+		return rows, nil
+	}
+	rows.columns = []string{"id", "name", "size", "score", "created_at"}
+	if len(c.results) > 0 {
+		rows.values, c.results = c.results[0], c.results[1:]
+	}
+	return rows, nil
+}
+
+type fakeRows struct {
+	columns []string
+	values  [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string { return r.columns }
+func (r *fakeRows) Close() error      { return nil }
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.values[0])
+	r.values = r.values[1:]
+	return nil
+}
+
+var registerOnce sync.Once
+
+func openRecording(t *testing.T, results ...[]item) (*gorm.DB, *recordingConn) {
+	t.Helper()
+	conn := &recordingConn{}
+	for _, page := range results {
+		var rows [][]driver.Value
+		for _, row := range page {
+			rows = append(rows, []driver.Value{row.ID.String(), row.Name, row.Size, row.Score, row.CreatedAt})
+		}
+		conn.results = append(conn.results, rows)
+	}
+
+	registerOnce.Do(func() { sql.Register("pagination-recording", &connector{}) })
+	connectors.Store(t.Name(), conn)
+	t.Cleanup(func() { connectors.Delete(t.Name()) })
+
+	sqlDB, err := sql.Open("pagination-recording", t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sqlDB.Close() })
+
+	gormDB, err := gorm.Open(postgres.New(postgres.Config{Conn: sqlDB}), &gorm.Config{
+		DisableAutomaticPing: true,
+		Logger:               logger.Discard,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return gormDB, conn
+}
+
+// connector hands each test its own recordingConn, keyed by the DSN
+type connector struct{}
+
+var connectors sync.Map
+
+func (connector) Open(name string) (driver.Conn, error) {
+	conn, ok := connectors.Load(name)
+	if !ok {
+		return nil, errors.New("no recording connection for " + name)
+	}
+	return conn.(*recordingConn), nil
+}
+
+func (c *recordingConn) pageQueries() []recordedQuery {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var pages []recordedQuery
+	for _, query := range c.queries {
+		if !strings.Contains(query.sql, "count(*)") {
+			pages = append(pages, query)
+		}
+	}
+	return pages
+}
+
+func makeItems(n int, name func(i int) string) []item {
+	start := time.Date(2024, 3, 1, 12, 0, 0, 123456789, time.UTC)
+	items := make([]item, n)
+	for i := range items {
+		items[i] = item{
+			ID:        uuid.New(),
+			Name:      name(i),
+			Size:      int64(100 * i),
+			Score:     float64(float32(0.1) * float32(i+1)),
+			CreatedAt: start.Add(time.Duration(i) * time.Second),
+		}
+	}
+	return items
+}
+
+func TestCursorRoundTrip(t *testing.T) {
+	rows := makeItems(3, func(i int) string { return string(rune('a' + i)) })
+	last := rows[1]
+
+	tests := []struct {
+		name  string
+		sort  string
+		order string
+		value any
+	}{
+		{"string sort", "name", "asc", last.Name},
+		{"int sort", "size", "desc", last.Size},
+		{"float sort keeps every bit", "score", "desc", last.Score},
+		{"time sort keeps nanoseconds", "created_at", "asc", last.CreatedAt},
+		{"default sort", "", "", last.CreatedAt},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			gormDB, conn := openRecording(t, rows, nil)
+			params := Params{Limit: 2, Sort: test.sort, Order: test.order}
+
+			items, page, err := Find[*item](gormDB.Table("items"), params, itemSorts, "id")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(items) != 2 {
+				t.Fatalf("got %d items, want 2", len(items))
+			}
+			if page.NextCursor == nil {
+				t.Fatal("no next cursor with more rows left")
+			}
+
+			params.Cursor = *page.NextCursor
+			if _, page, err = Find[*item](gormDB.Table("items"), params, itemSorts, "id"); err != nil {
+				t.Fatal(err)
+			}
+			if page.NextCursor != nil {
+				t.Fatal("next cursor on the last page")
+			}
+
+			queries := conn.pageQueries()
+			if len(queries) != 2 {
+				t.Fatalf("ran %d page queries, want 2", len(queries))
+			}
+			args := queries[1].args
+			if len(args) < 2 {
+				t.Fatalf("second page sent %d args, want the cursor's value and id", len(args))
+			}
+			if !argEqual(args[0], test.value) {
+				t.Errorf("resumed after value %v, want %v", args[0], test.value)
+			}
+			if !argEqual(args[1], last.ID) {
+				t.Errorf("resumed after id %v, want %v", args[1], last.ID)
+			}
+		})
+	}
+}
+
+func argEqual(arg driver.Value, want any) bool {
+	switch want := want.(type) {
+	case time.Time:
+		got, ok := arg.(time.Time)
+		return ok && got.Equal(want)
+	case uuid.UUID:
+		return arg == want.String()
+	}
+	return arg == want
+}
+
+func TestTieBreakingOrdersById(t *testing.T) {
+	// Every row shares a name so only the id tells them apart
+	rows := makeItems(3, func(int) string { return "same" })
+
+	tests := []struct {
+		order    string
+		orderBy  string
+		operator string
+	}{
+		{"asc", "ORDER BY name,id LIMIT", "(name, id) > ("},
+		{"desc", "ORDER BY name DESC,id DESC LIMIT", "(name, id) < ("},
+	}
+	for _, test := range tests {
+		t.Run(test.order, func(t *testing.T) {
+			gormDB, conn := openRecording(t, rows, nil)
+			params := Params{Limit: 2, Sort: "name", Order: test.order}
+
+			_, page, err := Find[*item](gormDB.Table("items"), params, itemSorts, "id")
+			if err != nil {
+				t.Fatal(err)
+			}
+			params.Cursor = *page.NextCursor
+			if _, _, err := Find[*item](gormDB.Table("items"), params, itemSorts, "id"); err != nil {
+				t.Fatal(err)
+			}
+
+			queries := conn.pageQueries()
+			for _, query := range queries {
+				if !strings.Contains(query.sql, test.orderBy) {
+					t.Errorf("query %q is not ordered by %q", query.sql, test.orderBy)
+				}
+			}
+			if !strings.Contains(queries[1].sql, test.operator) {
+				t.Errorf("query %q does not resume with %q", queries[1].sql, test.operator)
+			}
+			if !argEqual(queries[1].args[1], rows[1].ID) {
+				t.Errorf("resumed after id %v, want %v", queries[1].args[1], rows[1].ID)
+			}
+		})
+	}
+}
+
+func encodeRaw(t *testing.T, value any) string {
+	t.Helper()
+	raw, err := json.Marshal(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func TestFindRejectsTamperedCursors(t *testing.T) {
+	id := uuid.New().String()
+
+	tests := []struct {
+		name   string
+		params Params
+		cursor any
+	}{
+		{"not base64", Params{Sort: "name"}, "%%%"},
+		{"not json", Params{Sort: "name"}, base64.RawURLEncoding.EncodeToString([]byte("{"))},
+		{"missing id", Params{Sort: "name"}, cursor{Sort: "name", Value: "a"}},
+		{"id is not a uuid", Params{Sort: "name"}, cursor{Sort: "name", Value: "a", Id: "1 OR 1=1"}},
+		{"other sort", Params{Sort: "name"}, cursor{Sort: "size", Value: "1", Id: id}},
+		{"other order", Params{Sort: "name", Order: "desc"}, cursor{Sort: "name", Value: "a", Id: id}},
+		{"bad int", Params{Sort: "size"}, cursor{Sort: "size", Value: "ten", Id: id}},
+		{"bad float", Params{Sort: "score"}, cursor{Sort: "score", Value: "NaN", Id: id}},
+		{"bad time", Params{Sort: "created_at"}, cursor{Sort: "created_at", Value: "yesterday", Id: id}},
+		{"nul in string", Params{Sort: "name"}, cursor{Sort: "name", Value: "a\x00b", Id: id}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			gormDB, conn := openRecording(t)
+			params := test.params
+			if encoded, ok := test.cursor.(string); ok {
+				params.Cursor = encoded
+			} else {
+				params.Cursor = encodeRaw(t, test.cursor)
+			}
+
+			_, _, err := Find[*item](gormDB.Table("items"), params, itemSorts, "id")
+			if !errors.Is(err, ErrInvalidCursor) {
+				t.Fatalf("err = %v, want ErrInvalidCursor", err)
+			}
+			if len(conn.queries) != 0 {
+				t.Fatalf("a bad cursor reached the database: %q", conn.queries[0].sql)
+			}
+		})
+	}
+}
+
+func TestFindRejectsUnknownSort(t *testing.T) {
+	gormDB, _ := openRecording(t)
+	if _, _, err := Find[*item](gormDB.Table("items"), Params{Sort: "password"}, itemSorts, "id"); !errors.Is(err, ErrInvalidSort) {
+		t.Fatalf("err = %v, want ErrInvalidSort", err)
+	}
+}
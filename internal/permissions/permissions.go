@@ -0,0 +1,44 @@
+package permissions
+
+// Roles stored in users.role
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
+// Permissions checked by middleware.RequirePermission
+const (
+	ManageUsers    = "users:manage"
+	ManageRoles    = "users:manage_roles"
+	ManageStorage  = "storage:manage"
+	ResetTwoFactor = "users:reset_2fa"
+	ManageEmail    = "email:manage"
+)
+
+// rolePermissions is the single place that decides what each role may do
+var rolePermissions = map[string][]string{
+	RoleUser: {},
+	RoleAdmin: {
+		ManageUsers,
+		ManageRoles,
+		ManageStorage,
+		ResetTwoFactor,
+		ManageEmail,
+	},
+}
+
+// IsValidRole reports whether role is one the permission model knows
+func IsValidRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+// HasPermission reports whether role grants permission
+func HasPermission(role string, permission string) bool {
+	for _, granted := range rolePermissions[role] {
+		if granted == permission {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,61 @@
+package ratelimit
+
+import (
+	"strings"
+	"time"
+)
+
+// Policy is a token bucket: Limit requests per Period on average, with up to
+// Burst of them at once. Routes limits it to "METHOD /full/path" entries; an
+// empty list applies it to every route of the group it is attached to.
+// ByIP counts every caller by client IP, for routes that are used before
+// signing in where a token says nothing about who is guessing.
+type Policy struct {
+	Name   string
+	Limit  int
+	Period time.Duration
+	Burst  int
+	Routes []string
+	ByIP   bool
+}
+
+// Capacity is how many tokens a full bucket holds
+func (p Policy) Capacity() int {
+	if p.Burst > 0 {
+		return p.Burst
+	}
+	return p.Limit
+}
+
+// Interval is how long one token takes to refill
+func (p Policy) Interval() time.Duration {
+	return p.Period / time.Duration(p.Limit)
+}
+
+// AppliesTo reports whether the policy covers a request to method and fullPath
+func (p Policy) AppliesTo(method string, fullPath string) bool {
+	if len(p.Routes) == 0 {
+		return true
+	}
+	for _, route := range p.Routes {
+		routeMethod, routePath, _ := strings.Cut(route, " ")
+		if routeMethod == method && routePath == fullPath {
+			return true
+		}
+	}
+	return false
+}
+
+// Result is the state of a bucket after a request tried to take a token
+type Result struct {
+	Allowed    bool
+	Remaining  int
+	RetryAfter time.Duration // until the next token, when not allowed
+	ResetAfter time.Duration // until the bucket is full again
+}
+
+// Store keeps the buckets. MemoryStore serves a single instance; a shared
+// store (e.g. Redis) lets several instances enforce one limit.
+type Store interface {
+	Take(key string, policy Policy, now time.Time) (Result, error)
+}
@@ -0,0 +1,25 @@
+package routes
+
+import (
+	"goCal/internal/controllers"
+	"goCal/internal/middleware"
+	"goCal/internal/permissions"
+	"goCal/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// EmailOutboxRoutes lets admins watch and unstick the email queue
+func EmailOutboxRoutes(router *gin.RouterGroup) {
+	emailOutboxController := controllers.NewEmailOutboxController(services.NewEmailOutboxService())
+
+	router.Use(
+		middleware.AuthMiddleware(),
+		middleware.SessionOnly(),
+		middleware.RequirePermission(permissions.ManageEmail),
+	)
+
+	router.GET("/status", emailOutboxController.GetStatus)
+	router.GET("/messages", emailOutboxController.GetMessages)
+	router.POST("/messages/:id/retry", emailOutboxController.Retry)
+}
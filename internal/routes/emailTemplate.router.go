@@ -0,0 +1,23 @@
+package routes
+
+import (
+	"goCal/internal/controllers"
+	"goCal/internal/middleware"
+	"goCal/internal/permissions"
+
+	"github.com/gin-gonic/gin"
+)
+
+// EmailTemplateRoutes lets admins preview the emails we send
+func EmailTemplateRoutes(router *gin.RouterGroup) {
+	emailTemplateController := controllers.NewEmailTemplateController()
+
+	router.Use(
+		middleware.AuthMiddleware(),
+		middleware.SessionOnly(),
+		middleware.RequirePermission(permissions.ManageEmail),
+	)
+
+	router.GET("/", emailTemplateController.GetTemplates)
+	router.GET("/:name/preview", emailTemplateController.PreviewTemplate)
+}
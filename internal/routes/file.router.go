@@ -3,23 +3,70 @@ package routes
 import (
 	"goCal/internal/controllers"
 	"goCal/internal/middleware"
+	"goCal/internal/schema"
 	"goCal/internal/services"
+	"goCal/internal/storage"
 
 	"github.com/gin-gonic/gin"
-	storage_go "github.com/supabase-community/storage-go"
 )
 
-func FileRoutes(router *gin.RouterGroup, storageClient *storage_go.Client) {
+func FileRoutes(router *gin.RouterGroup, storageBackend storage.StorageBackend) {
 	fileService := services.NewFileService()
 	userService := services.NewUserService()
-	newFileStorageService := services.NewFileStorageService(storageClient)
-	fileController := controllers.NewFileController(fileService, userService, newFileStorageService)
-	router.GET("/", fileController.GetAllFiles)
-	router.GET("/:id", fileController.GetFile)
+	fileAccessService := services.NewFileAccessService()
+	quotaService := services.NewQuotaService()
+	newFileStorageService := services.NewFileStorageService(storageBackend)
+	fileController := controllers.NewFileController(fileService, userService, newFileStorageService, quotaService)
+	fileAccessController := controllers.NewFileAccessController(fileService, fileAccessService, userService)
+	uploadService := services.NewUploadService(fileService, newFileStorageService, quotaService)
+	uploadController := controllers.NewUploadController(uploadService, userService)
+	fileVersionController := controllers.NewFileVersionController(fileService, services.NewFileVersionService(newFileStorageService), newFileStorageService, quotaService)
+	shareLinkController := controllers.NewShareLinkController(fileService, services.NewShareLinkService(), newFileStorageService)
+	thumbnailController := controllers.NewThumbnailController(fileService, services.NewThumbnailService(newFileStorageService), newFileStorageService)
+
+	publicRoutes := router.Group("/")
+	publicRoutes.Use(middleware.OptionalAuthMiddleware())
+
+	publicRoutes.GET("/", middleware.RequireScope(schema.ScopeFilesRead), fileController.GetAllFiles)
+	publicRoutes.GET("/:id", middleware.RequireScope(schema.ScopeFilesRead), fileController.GetFile)
+	publicRoutes.GET("/:id/content", middleware.RequireScope(schema.ScopeFilesRead), fileController.GetFileContent)
+	publicRoutes.HEAD("/:id/content", middleware.RequireScope(schema.ScopeFilesRead), fileController.GetFileContent)
+	publicRoutes.GET("/:id/thumbnail", middleware.RequireScope(schema.ScopeFilesRead), thumbnailController.GetFileThumbnail)
+	publicRoutes.HEAD("/:id/thumbnail", middleware.RequireScope(schema.ScopeFilesRead), thumbnailController.GetFileThumbnail)
+	publicRoutes.GET("/:id/versions", middleware.RequireScope(schema.ScopeFilesRead), fileVersionController.GetVersions)
+	publicRoutes.GET("/:id/versions/:versionId/content", middleware.RequireScope(schema.ScopeFilesRead), fileVersionController.GetVersionContent)
+	publicRoutes.HEAD("/:id/versions/:versionId/content", middleware.RequireScope(schema.ScopeFilesRead), fileVersionController.GetVersionContent)
+
 	protectedRoutes := router.Group("/")
 	protectedRoutes.Use(middleware.AuthMiddleware())
 
-	protectedRoutes.POST("/", fileController.CreateFile)
-	protectedRoutes.DELETE("/file/:id", fileController.DeleteFile)
-	protectedRoutes.PATCH("/file/:id", fileController.UpdateFile)
+	protectedRoutes.POST("/", middleware.RequireScope(schema.ScopeFilesWrite), fileController.CreateFile)
+	protectedRoutes.DELETE("/file/:id", middleware.RequireScope(schema.ScopeFilesWrite), fileController.DeleteFile)
+	protectedRoutes.PATCH("/file/:id", middleware.RequireScope(schema.ScopeFilesWrite), fileController.UpdateFile)
+	protectedRoutes.POST("/:id/move", middleware.RequireScope(schema.ScopeFilesWrite), fileController.MoveFile)
+
+	protectedRoutes.GET("/:id/access", middleware.RequireScope(schema.ScopeFilesRead), fileAccessController.ListAccess)
+	protectedRoutes.POST("/:id/access", middleware.RequireScope(schema.ScopeFilesWrite), fileAccessController.GrantAccess)
+	protectedRoutes.PATCH("/:id/access/:userId", middleware.RequireScope(schema.ScopeFilesWrite), fileAccessController.UpdateAccess)
+	protectedRoutes.DELETE("/:id/access/:userId", middleware.RequireScope(schema.ScopeFilesWrite), fileAccessController.RevokeAccess)
+
+	protectedRoutes.POST("/:id/versions", middleware.RequireScope(schema.ScopeFilesWrite), fileVersionController.UploadVersion)
+	protectedRoutes.POST("/:id/versions/:versionId/restore", middleware.RequireScope(schema.ScopeFilesWrite), fileVersionController.RestoreVersion)
+
+	protectedRoutes.POST("/:id/share-links", middleware.RequireScope(schema.ScopeFilesWrite), shareLinkController.CreateShareLink)
+	protectedRoutes.GET("/:id/share-links", middleware.RequireScope(schema.ScopeFilesRead), shareLinkController.GetShareLinks)
+	protectedRoutes.DELETE("/:id/share-links/:linkId", middleware.RequireScope(schema.ScopeFilesWrite), shareLinkController.RevokeShareLink)
+	protectedRoutes.GET("/:id/share-links/:linkId/downloads", middleware.RequireScope(schema.ScopeFilesRead), shareLinkController.GetShareLinkDownloads)
+
+	// Resumable uploads (tus protocol)
+	router.OPTIONS("/uploads", uploadController.Options)
+	router.OPTIONS("/uploads/:uploadId", uploadController.Options)
+
+	uploadRoutes := router.Group("/uploads")
+	uploadRoutes.Use(middleware.AuthMiddleware(), uploadController.RequireTusResumable)
+
+	uploadRoutes.POST("", middleware.RequireScope(schema.ScopeFilesWrite), uploadController.CreateUpload)
+	uploadRoutes.HEAD("/:uploadId", middleware.RequireScope(schema.ScopeFilesWrite), uploadController.GetUploadOffset)
+	uploadRoutes.PATCH("/:uploadId", middleware.RequireScope(schema.ScopeFilesWrite), uploadController.PatchUpload)
+	uploadRoutes.DELETE("/:uploadId", middleware.RequireScope(schema.ScopeFilesWrite), uploadController.TerminateUpload)
 }
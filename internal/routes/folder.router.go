@@ -3,6 +3,7 @@ package routes
 import (
 	"goCal/internal/controllers"
 	"goCal/internal/middleware"
+	"goCal/internal/schema"
 	"goCal/internal/services"
 
 	"github.com/gin-gonic/gin"
@@ -20,7 +21,11 @@ func FolderRoutes(router *gin.RouterGroup) {
 	protectedRoutes := router.Group("/")
 	protectedRoutes.Use(middleware.AuthMiddleware())
 
-	protectedRoutes.POST("/", folderController.CreateFolder)
-	protectedRoutes.PATCH("/folder/:id", folderController.UpdateFolder)
-	protectedRoutes.DELETE("/folder/:id", folderController.DeleteFolder)
+	protectedRoutes.POST("/", middleware.RequireScope(schema.ScopeFoldersWrite), folderController.CreateFolder)
+	protectedRoutes.GET("/by-path", middleware.RequireScope(schema.ScopeFoldersRead), folderController.GetFolderByPath)
+	protectedRoutes.GET("/:id/contents", middleware.RequireScope(schema.ScopeFoldersRead), folderController.GetFolderContents)
+	protectedRoutes.GET("/:id/breadcrumbs", middleware.RequireScope(schema.ScopeFoldersRead), folderController.GetBreadcrumbs)
+	protectedRoutes.POST("/:id/move", middleware.RequireScope(schema.ScopeFoldersWrite), folderController.MoveFolder)
+	protectedRoutes.PATCH("/folder/:id", middleware.RequireScope(schema.ScopeFoldersWrite), folderController.UpdateFolder)
+	protectedRoutes.DELETE("/folder/:id", middleware.RequireScope(schema.ScopeFoldersWrite), folderController.DeleteFolder)
 }
@@ -0,0 +1,18 @@
+package routes
+
+import (
+	"goCal/internal/controllers"
+	"goCal/internal/middleware"
+	"goCal/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SearchRoutes searches files and folders. Signed-out callers only find public files.
+func SearchRoutes(router *gin.RouterGroup) {
+	searchController := controllers.NewSearchController(services.NewSearchService())
+
+	router.Use(middleware.OptionalAuthMiddleware())
+
+	router.GET("", searchController.Search)
+}
@@ -0,0 +1,21 @@
+package routes
+
+import (
+	"goCal/internal/controllers"
+	"goCal/internal/services"
+	"goCal/internal/storage"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ShareRoutes serves files through public share link tokens
+func ShareRoutes(router *gin.RouterGroup, storageBackend storage.StorageBackend) {
+	fileService := services.NewFileService()
+	shareLinkService := services.NewShareLinkService()
+	fileStorageService := services.NewFileStorageService(storageBackend)
+	shareLinkController := controllers.NewShareLinkController(fileService, shareLinkService, fileStorageService)
+
+	router.GET("/:token", shareLinkController.DownloadSharedFile)
+	router.HEAD("/:token", shareLinkController.DownloadSharedFile)
+	router.GET("/:token/info", shareLinkController.GetSharedFileInfo)
+}
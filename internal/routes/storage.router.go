@@ -0,0 +1,16 @@
+package routes
+
+import (
+	"goCal/internal/controllers"
+	"goCal/internal/storage"
+
+	"github.com/gin-gonic/gin"
+)
+
+// StorageRoutes serves objects kept by the local storage driver
+func StorageRoutes(router *gin.RouterGroup, storageBackend storage.StorageBackend) {
+	storageController := controllers.NewStorageController(storageBackend)
+
+	router.GET("/:bucket/*key", storageController.GetObject)
+	router.HEAD("/:bucket/*key", storageController.GetObject)
+}
@@ -0,0 +1,25 @@
+package routes
+
+import (
+	"goCal/internal/controllers"
+	"goCal/internal/middleware"
+	"goCal/internal/schema"
+	"goCal/internal/services"
+	"goCal/internal/storage"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TrashRoutes(router *gin.RouterGroup, storageBackend storage.StorageBackend) {
+	trashService := services.NewTrashService(services.NewFileStorageService(storageBackend))
+	trashController := controllers.NewTrashController(trashService)
+
+	router.Use(middleware.AuthMiddleware())
+
+	router.GET("/", middleware.RequireScope(schema.ScopeFilesRead), trashController.GetTrash)
+	router.DELETE("/", middleware.RequireScope(schema.ScopeFilesWrite), trashController.EmptyTrash)
+	router.POST("/files/:id/restore", middleware.RequireScope(schema.ScopeFilesWrite), trashController.RestoreFile)
+	router.DELETE("/files/:id", middleware.RequireScope(schema.ScopeFilesWrite), trashController.DeleteFilePermanently)
+	router.POST("/folders/:id/restore", middleware.RequireScope(schema.ScopeFoldersWrite), trashController.RestoreFolder)
+	router.DELETE("/folders/:id", middleware.RequireScope(schema.ScopeFoldersWrite), trashController.DeleteFolderPermanently)
+}
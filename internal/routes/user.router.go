@@ -3,6 +3,7 @@ package routes
 import (
 	"goCal/internal/controllers"
 	"goCal/internal/middleware"
+	"goCal/internal/permissions"
 	"goCal/internal/services"
 
 	"github.com/gin-gonic/gin"
@@ -10,24 +11,56 @@ import (
 
 func UserRoutes(router *gin.RouterGroup) {
 	userService := services.NewUserService()
-	userController := controllers.NewUserController(userService)
+	quotaService := services.NewQuotaService()
+	authService := services.NewAuthService()
+	loginThrottleService := services.NewLoginThrottleService()
+	userController := controllers.NewUserController(userService, quotaService, authService, loginThrottleService)
+	twoFactorController := controllers.NewTwoFactorController(services.NewTwoFactorService(), userService, authService, loginThrottleService)
+	oidcController := controllers.NewOidcController(services.NewOidcService(), authService)
+	apiKeyController := controllers.NewApiKeyController(services.NewApiKeyService())
 
 	router.GET("/", userController.GetUsers)
 	router.GET("/:id", userController.GetUser)
 	router.POST("/", userController.CreateUser)
 	router.POST("/login", userController.LoginUser)
+	router.POST("/login/2fa", twoFactorController.Login)
+	router.GET("/oidc/login", oidcController.Login)
+	router.GET("/oidc/callback", oidcController.Callback)
+	router.POST("/refresh", userController.RefreshToken)
 	router.POST("/verify", userController.VerifyUser)
 	router.POST("/resend-verification", userController.ResendVerificationEmail)
+	router.POST("/forgot-password", userController.ForgotPassword)
+	router.POST("/reset-password", userController.ResetPassword)
 
 	protectedRoutes := router.Group("/")
 
-	protectedRoutes.Use(middleware.AuthMiddleware())
+	protectedRoutes.Use(middleware.AuthMiddleware(), middleware.SessionOnly())
 
 	protectedRoutes.PATCH("/", userController.UpdateUser)
 	protectedRoutes.DELETE("/", userController.DeleteUser)
 
-	protectedRoutes.GET("/deleted", userController.GetSoftDeletedUsers)
-	protectedRoutes.POST("/:id/restore", userController.RestoreUser)               // Restore soft-deleted user
-	protectedRoutes.DELETE("/:id/permanent", userController.PermanentlyDeleteUser) // Hard delete
+	protectedRoutes.POST("/logout", userController.Logout)
+	protectedRoutes.POST("/logout-all", userController.LogoutAll)
+	protectedRoutes.GET("/sessions", userController.GetSessions)
+	protectedRoutes.DELETE("/sessions/:sessionId", userController.RevokeSession)
+	protectedRoutes.POST("/change-password", userController.ChangePassword)
+
+	protectedRoutes.POST("/2fa/setup", twoFactorController.Setup)
+	protectedRoutes.POST("/2fa/confirm", twoFactorController.Confirm)
+	protectedRoutes.POST("/2fa/recovery-codes", twoFactorController.RegenerateRecoveryCodes)
+	protectedRoutes.POST("/2fa/disable", twoFactorController.Disable)
+
+	protectedRoutes.POST("/api-keys", apiKeyController.CreateApiKey)
+	protectedRoutes.GET("/api-keys", apiKeyController.GetApiKeys)
+	protectedRoutes.DELETE("/api-keys/:keyId", apiKeyController.RevokeApiKey)
+
+	protectedRoutes.GET("/deleted", middleware.RequirePermission(permissions.ManageUsers), userController.GetSoftDeletedUsers)
+	protectedRoutes.POST("/:id/restore", middleware.RequirePermission(permissions.ManageUsers), userController.RestoreUser)               // Restore soft-deleted user
+	protectedRoutes.DELETE("/:id/permanent", middleware.RequirePermission(permissions.ManageUsers), userController.PermanentlyDeleteUser) // Hard delete
+	protectedRoutes.PATCH("/:id/role", middleware.RequirePermission(permissions.ManageRoles), userController.UpdateRole)
+
+	protectedRoutes.PATCH("/:id/storage-limit", middleware.RequirePermission(permissions.ManageStorage), userController.UpdateStorageLimit)
+	protectedRoutes.DELETE("/:id/2fa", middleware.RequirePermission(permissions.ResetTwoFactor), twoFactorController.Reset)
+	protectedRoutes.POST("/storage/reconcile", middleware.RequirePermission(permissions.ManageStorage), userController.ReconcileStorage)
 
 }
@@ -11,10 +11,21 @@ const (
 
 type FileAccess struct {
 	Id         uuid.UUID  `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()" json:"id"`
-	FileID     uuid.UUID  `gorm:"type:uuid;not null;index" json:"file_id"`
-	UserId     uuid.UUID  `gorm:"type:uuid;not null;index" json:"user_id"`
+	FileID     uuid.UUID  `gorm:"type:uuid;not null;index;uniqueIndex:idx_file_access_file_user" json:"file_id"`
+	UserId     uuid.UUID  `gorm:"type:uuid;not null;index;uniqueIndex:idx_file_access_file_user" json:"user_id"`
 	AccessType AccessType `gorm:"size:50;default:'view'" json:"access_type"`
 
 	File File `gorm:"constraint:OnDelete:CASCADE;" json:"-"`
 	User User `gorm:"constraint:OnDelete:CASCADE;" json:"-"`
 }
+
+// GrantFileAccessRequest identifies the grantee by user_id or email
+type GrantFileAccessRequest struct {
+	UserId     *string    `json:"user_id,omitempty"`
+	Email      *string    `json:"email,omitempty"`
+	AccessType AccessType `json:"access_type" binding:"required"`
+}
+
+type UpdateFileAccessRequest struct {
+	AccessType AccessType `json:"access_type" binding:"required"`
+}
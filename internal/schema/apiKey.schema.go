@@ -0,0 +1,54 @@
+package schema
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"gorm.io/gorm"
+)
+
+// API key scopes. A key can only reach routes that require one of its scopes.
+const (
+	ScopeFilesRead    = "files:read"
+	ScopeFilesWrite   = "files:write"
+	ScopeFoldersRead  = "folders:read"
+	ScopeFoldersWrite = "folders:write"
+)
+
+var ApiKeyScopes = []string{ScopeFilesRead, ScopeFilesWrite, ScopeFoldersRead, ScopeFoldersWrite}
+
+// ApiKey is a long-lived credential for scripts. The key reads
+// gocal_<prefix>_<secret>; the prefix finds the row and only a hash of the
+// whole key is stored.
+type ApiKey struct {
+	ID         uuid.UUID      `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()" json:"id"`
+	UserId     uuid.UUID      `gorm:"type:uuid;not null;index" json:"user_id"`
+	Name       string         `gorm:"not null;size:100" json:"name"`
+	Prefix     string         `gorm:"not null;size:16;uniqueIndex" json:"prefix"`
+	KeyHash    string         `gorm:"not null;size:64" json:"-"`
+	Scopes     pq.StringArray `gorm:"type:text[]" json:"scopes"`
+	ExpiresAt  *time.Time     `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time     `json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time     `json:"revoked_at,omitempty"`
+	CreatedAt  time.Time      `json:"created_at"`
+
+	User User `gorm:"constraint:OnDelete:CASCADE;" json:"-"`
+}
+
+type CreateApiKeyRequest struct {
+	Name      string     `json:"name" binding:"required,max=100"`
+	Scopes    []string   `json:"scopes" binding:"required,min=1"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+func (ApiKey) TableName() string {
+	return "api_keys"
+}
+
+func (k *ApiKey) BeforeCreate(tx *gorm.DB) (err error) {
+	if k.ID == uuid.Nil {
+		k.ID = uuid.New()
+	}
+	return nil
+}
@@ -0,0 +1,17 @@
+package schema
+
+import "time"
+
+// AuthThrottle counts recent failed attempts for one key: an account
+// ("account:<id>"), a client address ("ip:<addr>") or the password of a
+// share link ("share:<id>")
+type AuthThrottle struct {
+	Key           string     `gorm:"primaryKey;size:255" json:"key"`
+	Failures      int        `gorm:"not null;default:0" json:"failures"`
+	LockedUntil   *time.Time `gorm:"index" json:"locked_until,omitempty"`
+	LastFailureAt time.Time  `json:"last_failure_at"`
+}
+
+func (AuthThrottle) TableName() string {
+	return "auth_throttles"
+}
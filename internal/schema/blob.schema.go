@@ -0,0 +1,20 @@
+package schema
+
+import "time"
+
+// Blob is a stored object shared by every file and file version with the same
+// content. RefCount counts those rows; the object is removed once it drops to zero.
+type Blob struct {
+	Hash     string `gorm:"primaryKey;size:64" json:"hash"`
+	Bucket   string `gorm:"size:100;not null;uniqueIndex:idx_blobs_location" json:"bucket"`
+	Key      string `gorm:"size:500;not null;uniqueIndex:idx_blobs_location" json:"key"`
+	Size     int64  `gorm:"not null" json:"size"`
+	RefCount int64  `gorm:"not null;default:0;index" json:"ref_count"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (Blob) TableName() string {
+	return "blobs"
+}
@@ -0,0 +1,46 @@
+package schema
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Email outbox statuses
+const (
+	EmailStatusPending = "pending"
+	EmailStatusSending = "sending"
+	EmailStatusSent    = "sent"
+	EmailStatusDead    = "dead"
+)
+
+// EmailOutbox is an email waiting to be sent, or the record of one that was.
+// Messages are queued by EmailService and delivered by the email worker.
+type EmailOutbox struct {
+	ID            uuid.UUID  `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()" json:"id"`
+	Kind          string     `gorm:"size:50;not null" json:"kind"` // e.g. "verification"
+	ToEmail       string     `gorm:"size:100;not null" json:"to_email"`
+	Subject       string     `gorm:"size:255;not null" json:"subject"`
+	HtmlBody      string     `gorm:"type:text" json:"-"`
+	TextBody      string     `gorm:"type:text" json:"-"`
+	Status        string     `gorm:"size:20;not null;default:pending;index:idx_email_outbox_status_next" json:"status"`
+	Attempts      int        `gorm:"not null;default:0" json:"attempts"`
+	NextAttemptAt time.Time  `gorm:"index:idx_email_outbox_status_next" json:"next_attempt_at"`
+	LockedUntil   *time.Time `json:"-"` // lease of the worker that is sending it
+	LastError     string     `gorm:"type:text" json:"last_error,omitempty"`
+	SentAt        *time.Time `json:"sent_at,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+}
+
+func (EmailOutbox) TableName() string {
+	return "email_outbox"
+}
+
+func (e *EmailOutbox) BeforeCreate(tx *gorm.DB) (err error) {
+	if e.ID == uuid.Nil {
+		e.ID = uuid.New()
+	}
+	return nil
+}
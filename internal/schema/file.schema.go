@@ -17,7 +17,7 @@ const (
 
 type File struct {
 	Id       uuid.UUID  `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()" json:"id"`
-	FolderId *uuid.UUID `gorm:"type:uuid" json:"folder_id,omitempty"`
+	FolderId *uuid.UUID `gorm:"type:uuid;index" json:"folder_id,omitempty"`
 	Folder   *Folder    `gorm:"constraint:OnDelete:CASCADE" json:"folder,omitempty"`
 
 	FileName string `gorm:"not null;size:255" json:"file_name"`
@@ -25,6 +25,16 @@ type File struct {
 	FileSize int64  `json:"file_size"`
 	FileUrl  string `gorm:"not null" json:"file_url"`
 
+	StorageBucket string `gorm:"size:100" json:"-"`
+	StorageKey    string `gorm:"size:500" json:"-"`
+
+	// Version counts up each time the content is replaced, see FileVersion
+	Version     int    `gorm:"not null;default:1" json:"version"`
+	ContentHash string `gorm:"size:64" json:"content_hash,omitempty"`
+
+	// Who uploaded the current content when it was replaced by a new version
+	LastModifiedById *uuid.UUID `gorm:"type:uuid" json:"last_modified_by,omitempty"`
+
 	Visibility FileVisibility `gorm:"type:varchar(20);default:'private'" json:"visibility"`
 
 	UploadedById uuid.UUID `gorm:"type:uuid;not null" json:"uploaded_by"`
@@ -33,13 +43,25 @@ type File struct {
 	CreatedAt time.Time `gorm:"autoCreateTime" json:"created_at"`
 	UpdatedAt time.Time `gorm:"autoUpdateTime" json:"updated_at"`
 
+	// Set while the file is in the trash. Trashed files still count toward the owner's quota.
+	DeletedAt gorm.DeletedAt `gorm:"index" json:"deleted_at,omitempty"`
+
 	AccessList []FileAccess `gorm:"foreignKey:FileID;constraint:OnDelete:CASCADE;" json:"access_list,omitempty"`
+
+	// Read from the content on upload, see FileMetadata
+	Metadata *FileMetadata `gorm:"-" json:"metadata,omitempty"`
+}
+
+// MoveFileRequest moves a file into folder_id, or out of any folder when it is null
+type MoveFileRequest struct {
+	FolderId *uuid.UUID `json:"folder_id"`
 }
 
+// UpdateFileRequest changes how a file is listed. The size and type follow the
+// stored content, new content goes through the versions endpoint.
 type UpdateFileRequest struct {
-	FileName *string `json:"file_name,omitempty" validate:"omitempty,min=3,max=50"`
-	FileType *string `json:"file_type"`
-	FileSize *int64  `json:"file_size"`
+	FileName   *string         `json:"file_name,omitempty" validate:"omitempty,min=3,max=50"`
+	Visibility *FileVisibility `json:"visibility,omitempty"`
 }
 
 func (File) TableName() string {
@@ -47,6 +69,8 @@ func (File) TableName() string {
 }
 
 func (fc *File) BeforeCreate(tx *gorm.DB) (err error) {
-	fc.Id = uuid.New()
+	if fc.Id == uuid.Nil {
+		fc.Id = uuid.New()
+	}
 	return nil
 }
@@ -0,0 +1,40 @@
+package schema
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// File content indexing statuses
+const (
+	ContentStatusPending     = "pending"
+	ContentStatusIndexing    = "indexing"
+	ContentStatusIndexed     = "indexed"
+	ContentStatusUnsupported = "unsupported"
+	ContentStatusFailed      = "failed"
+)
+
+// FileContent is the text extracted from a file for full-text search. It is
+// kept apart from File so listing files never loads it. The content indexer
+// fills it in after the file is created or given new content.
+type FileContent struct {
+	FileId uuid.UUID `gorm:"primaryKey;type:uuid" json:"file_id"`
+	File   *File     `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+
+	// The content Text was extracted from, files with the same hash reuse it
+	ContentHash   string     `gorm:"size:64;index" json:"content_hash,omitempty"`
+	Status        string     `gorm:"size:20;not null;default:pending;index:idx_file_contents_status_next" json:"status"`
+	Attempts      int        `gorm:"not null;default:0" json:"attempts"`
+	NextAttemptAt time.Time  `gorm:"index:idx_file_contents_status_next" json:"next_attempt_at"`
+	LockedUntil   *time.Time `json:"-"` // lease of the indexer working on it
+	Text          string     `gorm:"type:text" json:"-"`
+	LastError     string     `gorm:"type:text" json:"last_error,omitempty"`
+	IndexedAt     *time.Time `json:"indexed_at,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+}
+
+func (FileContent) TableName() string {
+	return "file_contents"
+}
@@ -0,0 +1,31 @@
+package schema
+
+import "time"
+
+// FileMetadata is what was read from the headers of a photo, song or video
+// on upload. It is kept per content hash like thumbnails, so copies and
+// versions with the same bytes share it. Fields the format doesn't carry are null.
+type FileMetadata struct {
+	ContentHash string `gorm:"primaryKey;size:64" json:"-"`
+	Kind        string `gorm:"size:20;not null;index" json:"kind"` // image, audio or video
+
+	Width           *int     `gorm:"index" json:"width,omitempty"`
+	Height          *int     `json:"height,omitempty"`
+	DurationSeconds *float64 `gorm:"index" json:"duration_seconds,omitempty"`
+
+	CameraMake  string     `gorm:"size:100" json:"camera_make,omitempty"`
+	CameraModel string     `gorm:"size:100" json:"camera_model,omitempty"`
+	TakenAt     *time.Time `gorm:"index" json:"taken_at,omitempty"`
+	Latitude    *float64   `json:"latitude,omitempty"`
+	Longitude   *float64   `json:"longitude,omitempty"`
+
+	Title  string `gorm:"size:255" json:"title,omitempty"`
+	Artist string `gorm:"size:255" json:"artist,omitempty"`
+	Album  string `gorm:"size:255" json:"album,omitempty"`
+
+	CreatedAt time.Time `json:"-"`
+}
+
+func (FileMetadata) TableName() string {
+	return "file_metadata"
+}
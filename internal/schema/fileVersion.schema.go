@@ -0,0 +1,43 @@
+package schema
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// FileVersion is an earlier content of a file. The current content lives on
+// the File row itself, versions are archived when it is replaced.
+type FileVersion struct {
+	ID            uuid.UUID `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()" json:"id"`
+	FileId        uuid.UUID `gorm:"type:uuid;not null;uniqueIndex:idx_file_versions_file_version" json:"file_id"`
+	VersionNumber int       `gorm:"not null;uniqueIndex:idx_file_versions_file_version" json:"version_number"`
+
+	FileSize      int64  `json:"file_size"`
+	FileType      string `gorm:"size:100" json:"file_type"`
+	ContentHash   string `gorm:"size:64" json:"content_hash"`
+	StorageBucket string `gorm:"size:100" json:"-"`
+	StorageKey    string `gorm:"size:500" json:"-"`
+
+	UploadedById uuid.UUID `gorm:"type:uuid;not null" json:"uploaded_by"`
+	CreatedAt    time.Time `json:"created_at"`
+
+	// Compared with the previous version, filled in when listing history
+	SizeDelta      int64 `gorm:"-" json:"size_delta"`
+	ContentChanged bool  `gorm:"-" json:"content_changed"`
+
+	File       File `gorm:"constraint:OnDelete:CASCADE;" json:"-"`
+	UploadedBy User `gorm:"constraint:OnDelete:CASCADE;" json:"-"`
+}
+
+func (FileVersion) TableName() string {
+	return "file_versions"
+}
+
+func (fv *FileVersion) BeforeCreate(tx *gorm.DB) (err error) {
+	if fv.ID == uuid.Nil {
+		fv.ID = uuid.New()
+	}
+	return nil
+}
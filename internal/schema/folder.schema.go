@@ -1,19 +1,30 @@
 package schema
 
 import (
+	"time"
+
 	"github.com/google/uuid"
 	"gorm.io/gorm"
 )
 
+// Folder names are unique per owner within the same parent, see idx_folders_live_owner_parent_name in db.DBConnect
 type Folder struct {
-	ID                uuid.UUID `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()" json:"id"`
-	FolderName        string    `gorm:"uniqueIndex;not null;size:200" json:"folder_name" validate:"required,min=3,max=50"`
-	FolderDescription string    `gorm:"not null;size:500" json:"folder_description"`
-	FolderTags        []string  `gorm:"type:text[]" json:"folder_tags"`
-	CreatedById       uuid.UUID `gorm:"type:uuid;not null" json:"created_by"`
-	createdBy         User      `gorm:"constraint:OnDelete:OnDelete;" json:"-"`
-
-	Files []File `gorm:"foreignKey:FolderId" json:"files"`
+	ID                uuid.UUID  `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()" json:"id"`
+	FolderName        string     `gorm:"not null;size:200" json:"folder_name" validate:"required,min=3,max=50"`
+	FolderDescription string     `gorm:"not null;size:500" json:"folder_description"`
+	FolderTags        []string   `gorm:"type:text[]" json:"folder_tags"`
+	ParentId          *uuid.UUID `gorm:"type:uuid;index" json:"parent_id,omitempty"`
+	Parent            *Folder    `gorm:"constraint:OnDelete:CASCADE;" json:"-"`
+	CreatedById       uuid.UUID  `gorm:"type:uuid;not null;index" json:"created_by"`
+	CreatedBy         User       `gorm:"constraint:OnDelete:CASCADE;" json:"-"`
+	CreatedAt         time.Time  `json:"created_at"`
+	UpdatedAt         time.Time  `json:"updated_at"`
+
+	// Set while the folder is in the trash
+	DeletedAt gorm.DeletedAt `gorm:"index" json:"deleted_at,omitempty"`
+
+	Children []Folder `gorm:"foreignKey:ParentId" json:"children,omitempty"`
+	Files    []File   `gorm:"foreignKey:FolderId" json:"files"`
 }
 
 type UpdateFolderRequest struct {
@@ -22,6 +33,18 @@ type UpdateFolderRequest struct {
 	FolderTags        []*string `json:"folder_tags"`
 }
 
+// MoveFolderRequest moves a folder under parent_id, or to the root when it is null
+type MoveFolderRequest struct {
+	ParentId *uuid.UUID `json:"parent_id"`
+}
+
+// FolderPathEntry is one step of a folder's breadcrumb trail
+type FolderPathEntry struct {
+	ID         uuid.UUID  `json:"id"`
+	FolderName string     `json:"folder_name"`
+	ParentId   *uuid.UUID `json:"parent_id,omitempty"`
+}
+
 func (Folder) TableName() string {
 	return "folders"
 }
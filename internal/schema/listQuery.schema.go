@@ -0,0 +1,62 @@
+package schema
+
+import (
+	"goCal/internal/pagination"
+	"time"
+)
+
+// FileListQuery filters GET /api/file
+type FileListQuery struct {
+	pagination.Params
+	Owner         string         `form:"owner"`  // uploader's user id
+	Folder        string         `form:"folder"` // folder id, or "root" for files outside any folder
+	FileType      string         `form:"type"`   // MIME type, "image/*" matches the whole family
+	Visibility    FileVisibility `form:"visibility" binding:"omitempty,oneof=private shared public"`
+	MinSize       *int64         `form:"min_size" binding:"omitempty,min=0"`
+	MaxSize       *int64         `form:"max_size" binding:"omitempty,min=0"`
+	CreatedAfter  *time.Time     `form:"created_after"`
+	CreatedBefore *time.Time     `form:"created_before"`
+
+	// Matched against the metadata read from the content, see FileMetadata
+	Kind        string     `form:"kind" binding:"omitempty,oneof=image audio video"`
+	MinWidth    *int       `form:"min_width" binding:"omitempty,min=0"`
+	MinHeight   *int       `form:"min_height" binding:"omitempty,min=0"`
+	MinDuration *float64   `form:"min_duration" binding:"omitempty,min=0"` // seconds
+	MaxDuration *float64   `form:"max_duration" binding:"omitempty,min=0"`
+	TakenAfter  *time.Time `form:"taken_after"`
+	TakenBefore *time.Time `form:"taken_before"`
+	Camera      string     `form:"camera"` // part of the camera make or model
+	Artist      string     `form:"artist"`
+	HasLocation *bool      `form:"has_location"`
+}
+
+// FolderListQuery filters GET /api/folder
+type FolderListQuery struct {
+	pagination.Params
+	Owner         string     `form:"owner"`
+	Parent        string     `form:"parent"` // folder id, or "root" for top-level folders
+	Tag           string     `form:"tag"`
+	CreatedAfter  *time.Time `form:"created_after"`
+	CreatedBefore *time.Time `form:"created_before"`
+}
+
+// UserListQuery filters the public GET /api/user
+type UserListQuery struct {
+	pagination.Params
+	CreatedAfter  *time.Time `form:"created_after"`
+	CreatedBefore *time.Time `form:"created_before"`
+}
+
+// DeletedUserListQuery filters GET /api/user/deleted, which only admins reach
+type DeletedUserListQuery struct {
+	UserListQuery
+	Role     string `form:"role"`
+	Verified *bool  `form:"verified"`
+}
+
+// EmailOutboxListQuery filters GET /api/email-outbox/messages
+type EmailOutboxListQuery struct {
+	pagination.Params
+	Status string `form:"status" binding:"omitempty,oneof=pending sending sent dead"`
+	Kind   string `form:"kind"`
+}
@@ -0,0 +1,35 @@
+package schema
+
+import (
+	"goCal/internal/pagination"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Search result types
+const (
+	SearchTypeFile   = "file"
+	SearchTypeFolder = "folder"
+)
+
+// SearchQuery is what GET /api/search accepts. At least q or one tag is required.
+type SearchQuery struct {
+	pagination.Params
+	Q    string   `form:"q"`
+	Type string   `form:"type" binding:"omitempty,oneof=file folder"`
+	Tags []string `form:"tag"` // folders carrying every tag, and the files inside them
+}
+
+type SearchResult struct {
+	Type       string         `json:"type"` // "file" | "folder"
+	Id         uuid.UUID      `json:"id"`
+	Name       string         `json:"name"`
+	Snippet    string         `json:"snippet"` // HTML-escaped, matches wrapped in <mark>
+	Rank       float64        `json:"rank"`
+	ParentId   *uuid.UUID     `json:"parent_id,omitempty"` // folder the item is in
+	FileType   string         `json:"file_type,omitempty"`
+	Visibility FileVisibility `json:"visibility,omitempty"`
+	OwnerId    uuid.UUID      `json:"owner_id"`
+	UpdatedAt  time.Time      `json:"updated_at"`
+}
@@ -0,0 +1,43 @@
+package schema
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Session is one signed-in device. Access tokens carry its id and are only
+// accepted while it is active; the refresh token rotates on every use.
+type Session struct {
+	ID                uuid.UUID  `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()" json:"id"`
+	UserId            uuid.UUID  `gorm:"type:uuid;not null;index" json:"user_id"`
+	RefreshTokenHash  string     `gorm:"size:64;not null" json:"-"`
+	PreviousTokenHash string     `gorm:"size:64" json:"-"`
+	UserAgent         string     `gorm:"size:500" json:"user_agent"`
+	IpAddress         string     `gorm:"size:100" json:"ip_address"`
+	ExpiresAt         time.Time  `gorm:"index" json:"expires_at"`
+	LastUsedAt        time.Time  `json:"last_used_at"`
+	RevokedAt         *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt         time.Time  `json:"created_at"`
+
+	// Set by the handler listing sessions, not stored
+	Current bool `gorm:"-" json:"current"`
+
+	User User `gorm:"constraint:OnDelete:CASCADE;" json:"-"`
+}
+
+type RefreshTokenRequest struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+}
+
+func (Session) TableName() string {
+	return "sessions"
+}
+
+func (s *Session) BeforeCreate(tx *gorm.DB) (err error) {
+	if s.ID == uuid.Nil {
+		s.ID = uuid.New()
+	}
+	return nil
+}
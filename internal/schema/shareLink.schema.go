@@ -0,0 +1,61 @@
+package schema
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// ShareLink grants account-less access to a single file until it expires,
+// runs out of downloads or is revoked
+type ShareLink struct {
+	ID                uuid.UUID  `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()" json:"id"`
+	FileId            uuid.UUID  `gorm:"type:uuid;not null;index" json:"file_id"`
+	CreatedById       uuid.UUID  `gorm:"type:uuid;not null" json:"created_by"`
+	PasswordHash      string     `json:"-"`
+	PasswordProtected bool       `gorm:"default:false" json:"password_protected"`
+	ExpiresAt         time.Time  `gorm:"not null" json:"expires_at"`
+	MaxDownloads      *int       `json:"max_downloads,omitempty"`
+	DownloadCount     int        `gorm:"default:0" json:"download_count"`
+	RevokedAt         *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt         time.Time  `json:"created_at"`
+
+	File      File `gorm:"constraint:OnDelete:CASCADE;" json:"-"`
+	CreatedBy User `gorm:"constraint:OnDelete:CASCADE;" json:"-"`
+}
+
+// ShareLinkDownload records a single download made through a share link
+type ShareLinkDownload struct {
+	ID           uuid.UUID `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()" json:"id"`
+	ShareLinkId  uuid.UUID `gorm:"type:uuid;not null;index" json:"share_link_id"`
+	IpAddress    string    `gorm:"size:64" json:"ip_address"`
+	UserAgent    string    `gorm:"size:500" json:"user_agent"`
+	DownloadedAt time.Time `gorm:"autoCreateTime" json:"downloaded_at"`
+
+	ShareLink ShareLink `gorm:"constraint:OnDelete:CASCADE;" json:"-"`
+}
+
+type CreateShareLinkRequest struct {
+	ExpiresAt    *time.Time `json:"expires_at,omitempty"`
+	Password     *string    `json:"password,omitempty"`
+	MaxDownloads *int       `json:"max_downloads,omitempty"`
+}
+
+func (ShareLink) TableName() string {
+	return "share_links"
+}
+
+func (ShareLinkDownload) TableName() string {
+	return "share_link_downloads"
+}
+
+func (sl *ShareLink) BeforeCreate(tx *gorm.DB) (err error) {
+	sl.ID = uuid.New()
+	return nil
+}
+
+func (sld *ShareLinkDownload) BeforeCreate(tx *gorm.DB) (err error) {
+	sld.ID = uuid.New()
+	return nil
+}
@@ -0,0 +1,33 @@
+package schema
+
+import "time"
+
+// Thumbnail generation statuses
+const (
+	ThumbnailStatusPending    = "pending"
+	ThumbnailStatusGenerating = "generating"
+	ThumbnailStatusReady      = "ready"
+	ThumbnailStatusFailed     = "failed"
+)
+
+// Thumbnail tracks the scaled down previews of one image. They are made per
+// content hash, so files and versions with the same bytes share them, and
+// stored in the bucket of the original next to its blob.
+type Thumbnail struct {
+	ContentHash   string     `gorm:"primaryKey;size:64" json:"content_hash"`
+	Status        string     `gorm:"size:20;not null;default:pending;index:idx_thumbnails_status_next" json:"status"`
+	Attempts      int        `gorm:"not null;default:0" json:"attempts"`
+	NextAttemptAt time.Time  `gorm:"index:idx_thumbnails_status_next" json:"next_attempt_at"`
+	LockedUntil   *time.Time `json:"-"` // lease of the worker generating them
+	Bucket        string     `gorm:"size:100" json:"-"`
+	Width         int        `json:"width"` // of the original, upright
+	Height        int        `json:"height"`
+	LastError     string     `gorm:"type:text" json:"last_error,omitempty"`
+	GeneratedAt   *time.Time `json:"generated_at,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+}
+
+func (Thumbnail) TableName() string {
+	return "thumbnails"
+}
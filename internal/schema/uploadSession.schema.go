@@ -0,0 +1,35 @@
+package schema
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// UploadSession tracks a resumable (tus) upload until it is finalized into a File
+type UploadSession struct {
+	ID           uuid.UUID  `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()" json:"id"`
+	UserId       uuid.UUID  `gorm:"type:uuid;not null;index" json:"user_id"`
+	FileName     string     `gorm:"not null;size:255" json:"file_name"`
+	FileType     string     `gorm:"size:100" json:"file_type"`
+	FolderId     *uuid.UUID `gorm:"type:uuid" json:"folder_id,omitempty"`
+	UploadLength int64      `gorm:"not null" json:"upload_length"`
+	UploadOffset int64      `gorm:"not null;default:0" json:"upload_offset"`
+	TempPath     string     `gorm:"not null" json:"-"`
+	FileId       *uuid.UUID `gorm:"type:uuid" json:"file_id,omitempty"`
+	ExpiresAt    time.Time  `gorm:"index" json:"expires_at"`
+	CreatedAt    time.Time  `json:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at"`
+
+	User User `gorm:"constraint:OnDelete:CASCADE;" json:"-"`
+}
+
+func (UploadSession) TableName() string {
+	return "upload_sessions"
+}
+
+func (us *UploadSession) BeforeCreate(tx *gorm.DB) (err error) {
+	us.ID = uuid.New()
+	return nil
+}
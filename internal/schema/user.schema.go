@@ -1,8 +1,8 @@
 package schema
 
 import (
-	"fmt"
-	"math/rand"
+	"goCal/internal/permissions"
+	"goCal/internal/utils"
 	"os"
 	"strings"
 	"time"
@@ -23,28 +23,46 @@ type User struct {
 	IsVerified   bool           `gorm:"default:false" json:"is_verified"`
 	VerifyCode   string         `gorm:"size:4" json:"-"`
 	CodeExpiry   time.Time      `json:"-"`
+	VerifyTries  int            `gorm:"default:0" json:"-"` // wrong guesses at the current code
 	StorageUsed  int64          `gorm:"default:0" json:"storage_used"`
 	StorageLimit int64          `gorm:"default:524288000" json:"storage_limit"`
 	Role         string         `gorm:"default:user" json:"role"` // e.g. "user" | "admin"
+	Locale       string         `gorm:"size:10;default:en" json:"locale"`
 	DeletedAt    gorm.DeletedAt `gorm:"index" json:"-"`
+
+	// TotpSecret is set while enrolling and only checked once TwoFactorEnabled
+	TwoFactorEnabled bool   `gorm:"default:false" json:"two_factor_enabled"`
+	TotpSecret       string `gorm:"size:64" json:"-"`
+	TotpLastStep     int64  `gorm:"default:0" json:"-"` // last accepted time step, so a code works once
 }
 
-var ADMIN_EMAIL string
+// VerifyCodeTTL is how long an emailed verification code stays valid
+const VerifyCodeTTL = 15 * time.Minute
 
-func init() {
-	ADMIN_EMAIL = os.Getenv("ADMIN_EMAIL")
-	if ADMIN_EMAIL == "" {
-		fmt.Printf(`Failed to get the ADMIN_EMAIL`)
-	}
+// AdminEmail is the ADMIN_EMAIL account that is made admin. It is read on use
+// since the .env file is only loaded once main runs.
+func AdminEmail() string {
+	return strings.ToLower(strings.TrimSpace(os.Getenv("ADMIN_EMAIL")))
 }
 
-// UpdateUserRequest defines which fields can be updated
+// CreateUserRequest is what a signup may set; quota, role and 2FA stay server side
+type CreateUserRequest struct {
+	Username string `json:"username" binding:"required,min=3,max=50"`
+	Email    string `json:"email" binding:"required,email"`
+	Password string `json:"password" binding:"required,min=8"`
+	Locale   string `json:"locale"`
+}
 
 // UpdateUserRequest defines which fields can be updated
 type UpdateUserRequest struct {
 	Username   *string `json:"username,omitempty" validate:"omitempty,min=3,max=50"`
 	ProfileUrl *string `json:"profile_url,omitempty"`
 	CustomLink *string `json:"custom_link,omitempty"`
+	Locale     *string `json:"locale,omitempty"`
+}
+
+type UpdateRoleRequest struct {
+	Role string `json:"role" binding:"required"`
 }
 
 func (User) TableName() string {
@@ -54,14 +72,17 @@ func (User) TableName() string {
 func (u *User) BeforeCreate(tx *gorm.DB) (err error) {
 	u.ID = uuid.New()
 
-	u.VerifyCode = fmt.Sprintf("%04d", rand.Intn(10000))
+	if u.VerifyCode, err = utils.GenerateCode(4); err != nil {
+		return err
+	}
 	u.IsVerified = false
-	u.CodeExpiry = time.Now().Add(15 * time.Minute)
+	u.CodeExpiry = time.Now().Add(VerifyCodeTTL)
 
-	if strings.ToLower(u.Email) == ADMIN_EMAIL {
-		u.Role = "admin"
+	// ADMIN_EMAIL only bootstraps the first admin, roles are managed through the API after that
+	if adminEmail := AdminEmail(); adminEmail != "" && strings.ToLower(u.Email) == adminEmail {
+		u.Role = permissions.RoleAdmin
 	} else {
-		u.Role = "user"
+		u.Role = permissions.RoleUser
 	}
 	return nil
 }
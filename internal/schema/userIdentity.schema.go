@@ -0,0 +1,46 @@
+package schema
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// UserIdentity links a user to an account at an external OpenID Connect provider
+type UserIdentity struct {
+	ID          uuid.UUID `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()" json:"id"`
+	UserId      uuid.UUID `gorm:"type:uuid;not null;index" json:"user_id"`
+	Issuer      string    `gorm:"not null;size:255;uniqueIndex:idx_user_identities_issuer_subject" json:"issuer"`
+	Subject     string    `gorm:"not null;size:255;uniqueIndex:idx_user_identities_issuer_subject" json:"subject"`
+	Email       string    `gorm:"size:100" json:"email"`
+	LastLoginAt time.Time `json:"last_login_at"`
+	CreatedAt   time.Time `json:"created_at"`
+
+	User User `gorm:"constraint:OnDelete:CASCADE;" json:"-"`
+}
+
+// OidcLoginState holds what an authorization request needs to be finished:
+// the PKCE verifier and the nonce the ID token must echo
+type OidcLoginState struct {
+	State        string    `gorm:"primaryKey;size:64" json:"-"`
+	CodeVerifier string    `gorm:"not null;size:128" json:"-"`
+	Nonce        string    `gorm:"not null;size:64" json:"-"`
+	ExpiresAt    time.Time `gorm:"index" json:"expires_at"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+func (UserIdentity) TableName() string {
+	return "user_identities"
+}
+
+func (OidcLoginState) TableName() string {
+	return "oidc_login_states"
+}
+
+func (ui *UserIdentity) BeforeCreate(tx *gorm.DB) (err error) {
+	if ui.ID == uuid.Nil {
+		ui.ID = uuid.New()
+	}
+	return nil
+}
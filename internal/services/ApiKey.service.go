@@ -0,0 +1,158 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"goCal/internal/db"
+	"goCal/internal/logger"
+	"goCal/internal/schema"
+	"goCal/internal/utils"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrApiKeyInvalid       = errors.New("API key is invalid, expired or revoked")
+	ErrApiKeyNotFound      = errors.New("API key not found")
+	ErrApiKeyScopeInvalid  = errors.New("unknown API key scope")
+	ErrApiKeyExpiryInvalid = errors.New("API key expiry must be in the future")
+)
+
+const (
+	ApiKeyPrefix = "gocal_"
+
+	// last_used_at is only written this often so busy keys don't write on every request
+	apiKeyUsageResolution = time.Minute
+)
+
+type ApiKeyService struct{}
+
+func NewApiKeyService() *ApiKeyService {
+	return &ApiKeyService{}
+}
+
+// IsApiKey tells API keys apart from JWTs in the Authorization header
+func IsApiKey(token string) bool {
+	return strings.HasPrefix(token, ApiKeyPrefix)
+}
+
+// CreateApiKey mints a key for userId. The plain key is only returned here.
+func (ak *ApiKeyService) CreateApiKey(userId string, request *schema.CreateApiKeyRequest) (*schema.ApiKey, string, error) {
+	ownerId, err := uuid.Parse(userId)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var scopes []string
+	for _, scope := range request.Scopes {
+		if !slices.Contains(schema.ApiKeyScopes, scope) {
+			return nil, "", fmt.Errorf("%w %q", ErrApiKeyScopeInvalid, scope)
+		}
+		if !slices.Contains(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+	if request.ExpiresAt != nil && !request.ExpiresAt.After(time.Now()) {
+		return nil, "", ErrApiKeyExpiryInvalid
+	}
+
+	prefixBytes := make([]byte, 6)
+	if _, err := rand.Read(prefixBytes); err != nil {
+		return nil, "", err
+	}
+	prefix := hex.EncodeToString(prefixBytes)
+	secret, err := utils.GenerateToken(32)
+	if err != nil {
+		return nil, "", err
+	}
+	key := ApiKeyPrefix + prefix + "_" + secret
+
+	apiKey := &schema.ApiKey{
+		UserId:    ownerId,
+		Name:      request.Name,
+		Prefix:    prefix,
+		KeyHash:   utils.HashToken(key),
+		Scopes:    scopes,
+		ExpiresAt: request.ExpiresAt,
+	}
+	if err := db.DB.Create(apiKey).Error; err != nil {
+		logger.Error("Failed to create API key for user %s: %v", userId, err)
+		return nil, "", err
+	}
+	return apiKey, key, nil
+}
+
+// GetApiKeys lists the keys of userId that have not been revoked
+func (ak *ApiKeyService) GetApiKeys(userId string) ([]*schema.ApiKey, error) {
+	var apiKeys []*schema.ApiKey
+	if err := db.DB.Where("user_id = ? AND revoked_at IS NULL", userId).Order("created_at DESC").Find(&apiKeys).Error; err != nil {
+		logger.Error("Failed to get the API keys of user %s: %v", userId, err)
+		return nil, err
+	}
+	return apiKeys, nil
+}
+
+func (ak *ApiKeyService) RevokeApiKey(keyId string, userId string) error {
+	id, err := uuid.Parse(keyId)
+	if err != nil {
+		return ErrApiKeyNotFound
+	}
+
+	result := db.DB.Model(&schema.ApiKey{}).
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL", id, userId).
+		Update("revoked_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrApiKeyNotFound
+	}
+	return nil
+}
+
+// Authenticate resolves a presented key to its row and owner and records the use
+func (ak *ApiKeyService) Authenticate(key string) (*schema.ApiKey, *schema.User, error) {
+	parts := strings.SplitN(strings.TrimPrefix(key, ApiKeyPrefix), "_", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return nil, nil, ErrApiKeyInvalid
+	}
+
+	var apiKey schema.ApiKey
+	result := db.DB.Where("prefix = ? AND revoked_at IS NULL", parts[0]).Limit(1).Find(&apiKey)
+	if result.Error != nil {
+		return nil, nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, nil, ErrApiKeyInvalid
+	}
+	if subtle.ConstantTimeCompare([]byte(utils.HashToken(key)), []byte(apiKey.KeyHash)) != 1 {
+		return nil, nil, ErrApiKeyInvalid
+	}
+	if apiKey.ExpiresAt != nil && time.Now().After(*apiKey.ExpiresAt) {
+		return nil, nil, ErrApiKeyInvalid
+	}
+
+	// Keys of deleted users stop working with them
+	var user schema.User
+	if err := db.DB.Where("id = ?", apiKey.UserId).First(&user).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, ErrApiKeyInvalid
+		}
+		return nil, nil, err
+	}
+
+	now := time.Now()
+	if err := db.DB.Model(&schema.ApiKey{}).
+		Where("id = ? AND (last_used_at IS NULL OR last_used_at < ?)", apiKey.ID, now.Add(-apiKeyUsageResolution)).
+		Update("last_used_at", now).Error; err != nil {
+		logger.Warn(fmt.Sprintf("Failed to record use of API key %s: %v", apiKey.ID, err))
+	}
+	return &apiKey, &user, nil
+}
@@ -0,0 +1,110 @@
+package services
+
+import (
+	"database/sql/driver"
+	"errors"
+	"goCal/internal/schema"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+// apiKeyTable keeps the one api_keys row a test creates and answers the
+// lookups Authenticate makes
+func apiKeyTable(t *testing.T, userId uuid.UUID) *map[string]driver.Value {
+	var stored map[string]driver.Value
+	useFakeDB(t, func(query string, args []driver.Value) fakeResult {
+		switch {
+		case strings.HasPrefix(query, `INSERT INTO "api_keys"`):
+			stored = insertedRow(query, args)
+			return fakeResult{affected: 1}
+		case strings.HasPrefix(query, `SELECT * FROM "api_keys"`):
+			if stored == nil || args[0] != stored["prefix"] {
+				return fakeResult{columns: []string{"id"}}
+			}
+			row := map[string]driver.Value{}
+			for column, value := range stored {
+				row[column] = value
+			}
+			// Postgres sends text[] back in its own text form, which is
+			// how pgx hands any array to database/sql
+			if scopes, ok := stored["scopes"].(string); ok {
+				row["scopes"] = strings.ReplaceAll(scopes, `"`, "")
+			}
+			return selectRow(row, "id", "user_id", "name", "prefix", "key_hash", "scopes", "expires_at", "last_used_at", "revoked_at", "created_at")
+		case strings.HasPrefix(query, `SELECT * FROM "users"`):
+			return selectRow(map[string]driver.Value{"id": userId.String(), "email": "owner@example.com"}, "id", "email")
+		case strings.HasPrefix(query, `UPDATE "api_keys" SET "last_used_at"`):
+			return fakeResult{affected: 1}
+		}
+		t.Errorf("unexpected query %q", query)
+		return fakeResult{err: errors.New("unexpected query")}
+	})
+	return &stored
+}
+
+func TestApiKeyRoundTrip(t *testing.T) {
+	userId := uuid.New()
+	stored := apiKeyTable(t, userId)
+	service := NewApiKeyService()
+
+	created, key, err := service.CreateApiKey(userId.String(), &schema.CreateApiKeyRequest{
+		Name:   "backup script",
+		Scopes: []string{schema.ScopeFilesRead, schema.ScopeFoldersWrite, schema.ScopeFilesRead},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsApiKey(key) || !strings.Contains(key, created.Prefix) {
+		t.Fatalf("key %q does not carry its prefix %q", key, created.Prefix)
+	}
+
+	// The scopes must reach the driver as one array literal, not a Go slice
+	if got, want := (*stored)["scopes"], `{"files:read","folders:write"}`; got != want {
+		t.Fatalf("scopes were stored as %#v, want the text[] literal %s", got, want)
+	}
+
+	apiKey, user, err := service.Authenticate(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{schema.ScopeFilesRead, schema.ScopeFoldersWrite}; !slices.Equal(apiKey.Scopes, want) {
+		t.Errorf("scopes = %v, want %v", apiKey.Scopes, want)
+	}
+	if user.ID != userId || apiKey.UserId != userId {
+		t.Errorf("authenticated as user %s with key of %s, want %s", user.ID, apiKey.UserId, userId)
+	}
+}
+
+func TestApiKeyRejectsWrongSecret(t *testing.T) {
+	userId := uuid.New()
+	apiKeyTable(t, userId)
+	service := NewApiKeyService()
+
+	_, key, err := service.CreateApiKey(userId.String(), &schema.CreateApiKeyRequest{Name: "ci", Scopes: []string{schema.ScopeFilesRead}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]string{
+		"other secret": key[:strings.LastIndex(key, "_")+1] + "forged",
+		"other prefix": ApiKeyPrefix + "000000000000_" + key[strings.LastIndex(key, "_")+1:],
+		"no secret":    ApiKeyPrefix + "abc",
+	}
+	for name, presented := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, _, err := service.Authenticate(presented); !errors.Is(err, ErrApiKeyInvalid) {
+				t.Fatalf("err = %v, want ErrApiKeyInvalid", err)
+			}
+		})
+	}
+}
+
+func TestCreateApiKeyRejectsUnknownScopes(t *testing.T) {
+	_, _, err := NewApiKeyService().CreateApiKey(uuid.New().String(), &schema.CreateApiKeyRequest{Name: "x", Scopes: []string{"admin"}})
+	if !errors.Is(err, ErrApiKeyScopeInvalid) {
+		t.Fatalf("err = %v, want ErrApiKeyScopeInvalid", err)
+	}
+}
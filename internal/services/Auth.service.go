@@ -0,0 +1,340 @@
+package services
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"goCal/internal/db"
+	"goCal/internal/logger"
+	"goCal/internal/schema"
+	"goCal/internal/types"
+	"goCal/internal/utils"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrInvalidToken       = errors.New("Invalid token")
+	ErrSessionInvalid     = errors.New("session is invalid or has expired, please log in again")
+	ErrSessionNotFound    = errors.New("session not found")
+	ErrRefreshTokenReused = errors.New("refresh token was already used, the session has been revoked")
+	ErrChallengeInvalid   = errors.New("two-factor challenge is invalid or has expired, please log in again")
+)
+
+const (
+	defaultAccessTokenTTL  = 15 * time.Minute
+	defaultRefreshTokenTTL = 30 * 24 * time.Hour
+
+	// A password that checked out buys this long to enter the second factor
+	twoFactorChallengeTTL      = 5 * time.Minute
+	twoFactorChallengeAudience = "2fa-challenge"
+)
+
+// TokenPair is what a successful login or refresh hands back to the client
+type TokenPair struct {
+	AccessToken  string    `json:"token"`
+	RefreshToken string    `json:"refresh_token"`
+	ExpiresAt    time.Time `json:"expires_at"`
+	SessionId    uuid.UUID `json:"session_id"`
+}
+
+type AuthService struct {
+	jwtKey          []byte
+	accessTokenTTL  time.Duration
+	refreshTokenTTL time.Duration
+}
+
+// NewAuthService reads JWT_KEY plus ACCESS_TOKEN_TTL and REFRESH_TOKEN_TTL (Go durations)
+func NewAuthService() *AuthService {
+	jwtKey := os.Getenv("JWT_KEY")
+	if jwtKey == "" {
+		logger.Error("Failed to get the JWT_KEY")
+	}
+
+	auth := &AuthService{
+		jwtKey:          []byte(jwtKey),
+		accessTokenTTL:  defaultAccessTokenTTL,
+		refreshTokenTTL: defaultRefreshTokenTTL,
+	}
+	if ttl, err := time.ParseDuration(os.Getenv("ACCESS_TOKEN_TTL")); err == nil && ttl > 0 {
+		auth.accessTokenTTL = ttl
+	}
+	if ttl, err := time.ParseDuration(os.Getenv("REFRESH_TOKEN_TTL")); err == nil && ttl > 0 {
+		auth.refreshTokenTTL = ttl
+	}
+	return auth
+}
+
+func (a *AuthService) signAccessToken(user *schema.User, sessionId uuid.UUID) (string, time.Time, error) {
+	expiresAt := time.Now().Add(a.accessTokenTTL)
+	claims := jwt.NewWithClaims(jwt.SigningMethodHS256, types.Claims{
+		StandardClaims: jwt.StandardClaims{
+			Issuer:    user.Email,
+			Id:        user.ID.String(),
+			ExpiresAt: expiresAt.Unix(),
+			IssuedAt:  time.Now().Unix(),
+		},
+		SessionId: sessionId.String(),
+	})
+	token, err := claims.SignedString(a.jwtKey)
+	return token, expiresAt, err
+}
+
+// newRefreshToken returns "<sessionId>.<secret>" and the hash that is stored
+func newRefreshToken(sessionId uuid.UUID) (string, string, error) {
+	secret, err := utils.GenerateToken(32)
+	if err != nil {
+		return "", "", err
+	}
+	token := sessionId.String() + "." + secret
+	return token, utils.HashToken(token), nil
+}
+
+// IssueTokens opens a new session for user and returns its first token pair
+func (a *AuthService) IssueTokens(user *schema.User, userAgent string, ipAddress string) (*TokenPair, error) {
+	session := &schema.Session{
+		ID:         uuid.New(),
+		UserId:     user.ID,
+		UserAgent:  userAgent,
+		IpAddress:  ipAddress,
+		ExpiresAt:  time.Now().Add(a.refreshTokenTTL),
+		LastUsedAt: time.Now(),
+	}
+
+	refreshToken, refreshHash, err := newRefreshToken(session.ID)
+	if err != nil {
+		return nil, err
+	}
+	session.RefreshTokenHash = refreshHash
+
+	if err := db.DB.Create(session).Error; err != nil {
+		logger.Error("Failed to create session for user %s: %s", user.ID, err)
+		return nil, err
+	}
+
+	accessToken, expiresAt, err := a.signAccessToken(user, session.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TokenPair{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		ExpiresAt:    expiresAt,
+		SessionId:    session.ID,
+	}, nil
+}
+
+// Refresh exchanges a refresh token for a new pair and rotates it. Presenting
+// the token that was just rotated out means it leaked, so the session is revoked.
+func (a *AuthService) Refresh(refreshToken string, userAgent string, ipAddress string) (*TokenPair, error) {
+	rawSessionId, _, found := strings.Cut(refreshToken, ".")
+	sessionId, err := uuid.Parse(rawSessionId)
+	if !found || err != nil {
+		return nil, ErrSessionInvalid
+	}
+
+	var user schema.User
+	var newToken string
+	errRefresh := db.DB.Transaction(func(tx *gorm.DB) error {
+		var session schema.Session
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", sessionId).First(&session).Error; err != nil {
+			return ErrSessionInvalid
+		}
+		if session.RevokedAt != nil || time.Now().After(session.ExpiresAt) {
+			return ErrSessionInvalid
+		}
+
+		presentedHash := utils.HashToken(refreshToken)
+		if subtle.ConstantTimeCompare([]byte(presentedHash), []byte(session.RefreshTokenHash)) != 1 {
+			if session.PreviousTokenHash != "" && subtle.ConstantTimeCompare([]byte(presentedHash), []byte(session.PreviousTokenHash)) == 1 {
+				return ErrRefreshTokenReused
+			}
+			return ErrSessionInvalid
+		}
+
+		// Soft-deleted users are not found here and can't refresh
+		if err := tx.Where("id = ?", session.UserId).First(&user).Error; err != nil {
+			return ErrSessionInvalid
+		}
+
+		var newHash string
+		newToken, newHash, err = newRefreshToken(session.ID)
+		if err != nil {
+			return err
+		}
+		return tx.Model(&session).Updates(map[string]interface{}{
+			"previous_token_hash": session.RefreshTokenHash,
+			"refresh_token_hash":  newHash,
+			"last_used_at":        time.Now(),
+			"user_agent":          userAgent,
+			"ip_address":          ipAddress,
+		}).Error
+	})
+	if errors.Is(errRefresh, ErrRefreshTokenReused) {
+		// Revoke outside the transaction, which was rolled back
+		logger.Warn(fmt.Sprintf("Refresh token reuse detected, revoking session %s", sessionId))
+		if err := db.DB.Model(&schema.Session{}).Where("id = ?", sessionId).Update("revoked_at", time.Now()).Error; err != nil {
+			return nil, err
+		}
+		return nil, errRefresh
+	}
+	if errRefresh != nil {
+		return nil, errRefresh
+	}
+
+	accessToken, expiresAt, err := a.signAccessToken(&user, sessionId)
+	if err != nil {
+		return nil, err
+	}
+	return &TokenPair{
+		AccessToken:  accessToken,
+		RefreshToken: newToken,
+		ExpiresAt:    expiresAt,
+		SessionId:    sessionId,
+	}, nil
+}
+
+// ParseAccessToken verifies an access token and that its session is still
+// active for a user that has not been deleted
+func (a *AuthService) ParseAccessToken(tokenString string) (*types.Claims, error) {
+	claims := &types.Claims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(t *jwt.Token) (interface{}, error) {
+		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method %v", t.Header["alg"])
+		}
+		return a.jwtKey, nil
+	})
+	if err != nil || !token.Valid {
+		return nil, ErrInvalidToken
+	}
+
+	// Tokens issued before sessions existed can't be revoked, so they are refused.
+	// Two-factor challenges carry no session either.
+	if claims.SessionId == "" || claims.Audience == twoFactorChallengeAudience {
+		return nil, ErrSessionInvalid
+	}
+
+	var active int64
+	if err := db.DB.Model(&schema.Session{}).
+		Joins("JOIN users ON users.id = sessions.user_id AND users.deleted_at IS NULL").
+		Where("sessions.id = ? AND sessions.user_id = ? AND sessions.revoked_at IS NULL AND sessions.expires_at > ?", claims.SessionId, claims.Id, time.Now()).
+		Count(&active).Error; err != nil {
+		return nil, err
+	}
+	if active == 0 {
+		return nil, ErrSessionInvalid
+	}
+	return claims, nil
+}
+
+// IssueTwoFactorChallenge returns the short-lived token that stands between
+// a correct password and a session when the user has 2FA enabled
+func (a *AuthService) IssueTwoFactorChallenge(user *schema.User) (string, time.Time, error) {
+	expiresAt := time.Now().Add(twoFactorChallengeTTL)
+	claims := jwt.NewWithClaims(jwt.SigningMethodHS256, types.Claims{
+		StandardClaims: jwt.StandardClaims{
+			Audience:  twoFactorChallengeAudience,
+			Issuer:    user.Email,
+			Id:        user.ID.String(),
+			ExpiresAt: expiresAt.Unix(),
+			IssuedAt:  time.Now().Unix(),
+		},
+	})
+	token, err := claims.SignedString(a.jwtKey)
+	return token, expiresAt, err
+}
+
+// ParseTwoFactorChallenge returns the user id a challenge was issued for
+func (a *AuthService) ParseTwoFactorChallenge(tokenString string) (string, error) {
+	claims := &types.Claims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(t *jwt.Token) (interface{}, error) {
+		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method %v", t.Header["alg"])
+		}
+		return a.jwtKey, nil
+	})
+	if err != nil || !token.Valid || !claims.VerifyAudience(twoFactorChallengeAudience, true) {
+		return "", ErrChallengeInvalid
+	}
+	return claims.Id, nil
+}
+
+// GetSessions lists userId's active sessions, most recently used first
+func (a *AuthService) GetSessions(userId string) ([]*schema.Session, error) {
+	var sessions []*schema.Session
+	if err := db.DB.Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", userId, time.Now()).
+		Order("last_used_at DESC").Find(&sessions).Error; err != nil {
+		logger.Error("Failed to get the sessions of user %s: %s", userId, err)
+		return nil, err
+	}
+	return sessions, nil
+}
+
+// RevokeSession ends one of userId's sessions
+func (a *AuthService) RevokeSession(sessionId string, userId string) error {
+	id, err := uuid.Parse(sessionId)
+	if err != nil {
+		return ErrSessionNotFound
+	}
+
+	result := db.DB.Model(&schema.Session{}).
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL", id, userId).
+		Update("revoked_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrSessionNotFound
+	}
+	return nil
+}
+
+// RevokeAllSessions ends every active session of userId and returns how many there were
+func (a *AuthService) RevokeAllSessions(userId string) (int64, error) {
+	result := revokeUserSessions(db.DB, userId)
+	if result.Error != nil {
+		logger.Error("Failed to revoke the sessions of user %s: %s", userId, result.Error)
+		return 0, result.Error
+	}
+	return result.RowsAffected, nil
+}
+
+// revokeUserSessions ends every active session of userId inside tx
+func revokeUserSessions(tx *gorm.DB, userId interface{}) *gorm.DB {
+	return tx.Model(&schema.Session{}).
+		Where("user_id = ? AND revoked_at IS NULL", userId).
+		Update("revoked_at", time.Now())
+}
+
+// GetRole reads the current role of userId, so permission checks never rely
+// on what a token claimed when it was issued
+func (a *AuthService) GetRole(userId string) (string, error) {
+	var user schema.User
+	if err := db.DB.Select("role").Where("id = ?", userId).First(&user).Error; err != nil {
+		return "", err
+	}
+	return user.Role, nil
+}
+
+// PeekUserId checks only the signature and expiry of an access token, without
+// the session lookup, for callers that need a cheap identity such as rate limiting
+func (a *AuthService) PeekUserId(tokenString string) (string, bool) {
+	claims := &types.Claims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(t *jwt.Token) (interface{}, error) {
+		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method %v", t.Header["alg"])
+		}
+		return a.jwtKey, nil
+	})
+	if err != nil || !token.Valid || claims.SessionId == "" {
+		return "", false
+	}
+	return claims.Id, true
+}
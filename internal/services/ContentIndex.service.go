@@ -0,0 +1,236 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"goCal/internal/db"
+	"goCal/internal/extract"
+	"goCal/internal/logger"
+	"goCal/internal/schema"
+	"io"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	defaultContentIndexMaxAttempts = 3
+	defaultContentIndexMaxFileSize = 100 << 20
+	contentIndexRetryBase          = time.Minute
+	contentIndexRetryMax           = time.Hour
+	// A file stuck in "indexing" longer than this is picked up again
+	contentIndexLease = 10 * time.Minute
+)
+
+// ContentIndexService extracts the text of queued files so search can match
+// what is inside documents, not just their names
+type ContentIndexService struct {
+	fileStorageService *FileStorageService
+	maxAttempts        int
+	maxFileSize        int64
+}
+
+// NewContentIndexService reads CONTENT_INDEX_MAX_ATTEMPTS and
+// CONTENT_INDEX_MAX_FILE_SIZE, in bytes. Larger files aren't indexed.
+func NewContentIndexService(fileStorageService *FileStorageService) *ContentIndexService {
+	service := &ContentIndexService{
+		fileStorageService: fileStorageService,
+		maxAttempts:        defaultContentIndexMaxAttempts,
+		maxFileSize:        defaultContentIndexMaxFileSize,
+	}
+	if attempts, err := strconv.Atoi(os.Getenv("CONTENT_INDEX_MAX_ATTEMPTS")); err == nil && attempts > 0 {
+		service.maxAttempts = attempts
+	}
+	if size, err := strconv.ParseInt(os.Getenv("CONTENT_INDEX_MAX_FILE_SIZE"), 10, 64); err == nil && size > 0 {
+		service.maxFileSize = size
+	}
+	return service
+}
+
+// queueContentIndex asks the content indexer to extract the file's text again
+// after it was created or given new content. The previous text stays
+// searchable until the new text replaces it.
+func queueContentIndex(tx *gorm.DB, fileId uuid.UUID, fileName string, fileType string, contentHash string) error {
+	content := &schema.FileContent{
+		FileId:        fileId,
+		ContentHash:   contentHash,
+		Status:        schema.ContentStatusPending,
+		NextAttemptAt: time.Now(),
+	}
+	updates := map[string]interface{}{
+		"content_hash":    contentHash,
+		"status":          schema.ContentStatusPending,
+		"attempts":        0,
+		"next_attempt_at": content.NextAttemptAt,
+		"locked_until":    nil,
+		"last_error":      "",
+		"updated_at":      time.Now(),
+	}
+	if !extract.Supported(fileType, fileName) {
+		content.Status = schema.ContentStatusUnsupported
+		updates["status"] = schema.ContentStatusUnsupported
+		updates["text"] = ""
+	}
+
+	if err := tx.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "file_id"}},
+		DoUpdates: clause.Assignments(updates),
+	}).Create(content).Error; err != nil {
+		logger.Error("Failed to queue file %s for content indexing: %v", fileId, err)
+		return err
+	}
+	return nil
+}
+
+// ProcessNext claims one queued file and extracts its text. It reports false
+// when nothing was due.
+func (s *ContentIndexService) ProcessNext() (bool, error) {
+	content, err := s.claim()
+	if err != nil || content == nil {
+		return false, err
+	}
+
+	// Trashed files are indexed too, so their content is searchable once restored
+	var file schema.File
+	result := db.DB.Unscoped().Where("id = ?", content.FileId).First(&file)
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return true, nil
+	}
+	if result.Error != nil {
+		return true, s.recordFailure(content, result.Error)
+	}
+
+	if extract.For(file.FileType, file.FileName) == nil {
+		return true, s.recordUnsupported(content, "")
+	}
+	if file.FileSize > s.maxFileSize {
+		return true, s.recordUnsupported(content, fmt.Sprintf("file is larger than the %d byte indexing limit", s.maxFileSize))
+	}
+
+	// The same content was already extracted for another file
+	if content.ContentHash != "" {
+		var existing schema.FileContent
+		found := db.DB.Select("text").
+			Where("content_hash = ? AND status = ? AND file_id <> ?", content.ContentHash, schema.ContentStatusIndexed, content.FileId).
+			Limit(1).Find(&existing)
+		if found.Error == nil && found.RowsAffected > 0 {
+			return true, s.recordIndexed(content, existing.Text)
+		}
+	}
+
+	text, errExtract := s.extractText(&file)
+	if errExtract != nil {
+		return true, s.recordFailure(content, errExtract)
+	}
+	return true, s.recordIndexed(content, text)
+}
+
+// claim marks the oldest due file as indexing. SKIP LOCKED lets several
+// indexers claim at once without handing out the same row.
+func (s *ContentIndexService) claim() (*schema.FileContent, error) {
+	now := time.Now()
+	due := db.DB.Model(&schema.FileContent{}).
+		Select("file_id").
+		Where("(status = ? AND next_attempt_at <= ?) OR (status = ? AND locked_until < ?)",
+			schema.ContentStatusPending, now, schema.ContentStatusIndexing, now).
+		Order("next_attempt_at").
+		Limit(1).
+		Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"})
+
+	var claimed []schema.FileContent
+	result := db.DB.Model(&claimed).
+		Clauses(clause.Returning{}).
+		Where("file_id IN (?)", due).
+		Updates(map[string]interface{}{
+			"status":       schema.ContentStatusIndexing,
+			"locked_until": now.Add(contentIndexLease),
+			"attempts":     gorm.Expr("attempts + 1"),
+		})
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if len(claimed) == 0 {
+		return nil, nil
+	}
+	return &claimed[0], nil
+}
+
+// extractText copies the file's object to a temp file, the Office formats
+// are zip archives and need random access, then runs its extractor
+func (s *ContentIndexService) extractText(file *schema.File) (string, error) {
+	object, err := s.fileStorageService.Open(file.StorageBucket, file.StorageKey)
+	if err != nil {
+		return "", err
+	}
+	defer object.Close()
+
+	spool, err := os.CreateTemp("", "gocal-index-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(spool.Name())
+	defer spool.Close()
+
+	size, err := io.Copy(spool, io.LimitReader(object, s.maxFileSize))
+	if err != nil {
+		return "", err
+	}
+	return extract.Text(file.FileType, file.FileName, spool, size)
+}
+
+// finish records the outcome of an attempt, unless the file was queued again
+// with new content while it was being indexed
+func (s *ContentIndexService) finish(content *schema.FileContent, updates map[string]interface{}) error {
+	updates["locked_until"] = nil
+	return db.DB.Model(&schema.FileContent{}).
+		Where("file_id = ? AND status = ? AND content_hash = ?", content.FileId, schema.ContentStatusIndexing, content.ContentHash).
+		Updates(updates).Error
+}
+
+func (s *ContentIndexService) recordIndexed(content *schema.FileContent, text string) error {
+	return s.finish(content, map[string]interface{}{
+		"status":     schema.ContentStatusIndexed,
+		"text":       text,
+		"indexed_at": time.Now(),
+		"last_error": "",
+	})
+}
+
+func (s *ContentIndexService) recordUnsupported(content *schema.FileContent, reason string) error {
+	return s.finish(content, map[string]interface{}{
+		"status":     schema.ContentStatusUnsupported,
+		"text":       "",
+		"last_error": reason,
+	})
+}
+
+// recordFailure schedules another attempt with exponential backoff. A file
+// that can't be read or parsed after its attempts is marked failed, and
+// only new content queues it again.
+func (s *ContentIndexService) recordFailure(content *schema.FileContent, errIndex error) error {
+	updates := map[string]interface{}{
+		"last_error": errIndex.Error(),
+	}
+
+	if content.Attempts >= s.maxAttempts {
+		updates["status"] = schema.ContentStatusFailed
+		logger.Error("Giving up on indexing file %s after %d attempts: %v", content.FileId, content.Attempts, errIndex)
+	} else {
+		delay := contentIndexRetryBase
+		for i := 1; i < content.Attempts && delay < contentIndexRetryMax; i++ {
+			delay *= 2
+		}
+		if delay > contentIndexRetryMax {
+			delay = contentIndexRetryMax
+		}
+		updates["status"] = schema.ContentStatusPending
+		updates["next_attempt_at"] = time.Now().Add(delay)
+		logger.Warn("Indexing file %s failed (attempt %d), retrying in %s: %v", content.FileId, content.Attempts, delay, errIndex)
+	}
+
+	return s.finish(content, updates)
+}
@@ -3,20 +3,22 @@ package services
 import (
 	"errors"
 	"fmt"
+	"goCal/internal/db"
+	"goCal/internal/emails"
 	"goCal/internal/logger"
 	"goCal/internal/schema"
-	"html/template"
 	"net/smtp"
+	"net/url"
 	"os"
 	"regexp"
-	"strings"
 	"time"
 
 	"github.com/domodwyer/mailyak"
+	"gorm.io/gorm"
 )
 
 type EmailService struct {
-	mail        *mailyak.MailYak
+	smtpAuth    smtp.Auth
 	adminMail   string
 	password    string
 	smtpHost    string
@@ -39,11 +41,6 @@ type EmailResponse struct {
 	Error   string `json:"error"`
 }
 
-const (
-	maxDelay    = 3
-	retrySecond = time.Second * 2
-)
-
 var emailRegex = regexp.MustCompile(`^[a-zA-Z0-9._%+-]+@[a-zA-Z0-9.-]+\.[a-zA-Z]{2,}$`)
 
 func NewEmailServices() (*EmailService, error) {
@@ -96,9 +93,7 @@ func (s *EmailService) initialize() error {
 	s.smtpPort = config.SMTPPort
 	s.fromName = config.FromName
 
-	smtpAddr := fmt.Sprintf("%s:%s", s.smtpHost, s.smtpPort)
-	auth := smtp.PlainAuth("", s.adminMail, s.password, s.smtpHost)
-	s.mail = mailyak.New(smtpAddr, auth)
+	s.smtpAuth = smtp.PlainAuth("", s.adminMail, s.password, s.smtpHost)
 
 	s.initialized = true
 	logger.Info("Email service initialized successfully")
@@ -156,11 +151,59 @@ func (s *EmailService) SendVerificationEmail(user *schema.User) (*EmailResponse,
 		}, errors.New("Verification Code Expired")
 	}
 
-	subject := "GoCal - Email Verification Code"
-	htmlBody, err := s.generateHTMLBody(user)
+	message, err := emails.Render(emails.Verification, user.Locale, emails.VerificationData{
+		Username:         user.Username,
+		Code:             user.VerifyCode,
+		ExpiresInMinutes: emails.Minutes(schema.VerifyCodeTTL),
+	})
+	if err != nil {
+		logger.Error("Failed to render email: %v", err)
+		return &EmailResponse{
+			Success: false,
+			Message: "Failed to prepare email",
+			Error:   err.Error(),
+		}, err
+	}
+
+	if err := queueEmail(db.DB, user.Email, emails.Verification, message); err != nil {
+		return &EmailResponse{
+			Success: false,
+			Message: "Failed to queue verification email",
+			Error:   err.Error(),
+		}, err
+	}
+
+	logger.Info("Verification email queued for: %s", user.Email)
+	return &EmailResponse{
+		Success: true,
+		Message: "Verification email queued",
+	}, nil
+}
+
+// SendPasswordResetEmail mails the reset token, as a link when PASSWORD_RESET_URL is set
+func (s *EmailService) SendPasswordResetEmail(user *schema.User, token string, expiresIn time.Duration) (*EmailResponse, error) {
+	if !s.initialized {
+		logger.Error("Email Service not initialized")
+		return &EmailResponse{
+			Success: false,
+			Message: "Invalid User Data",
+			Error:   "Service Not Initialized",
+		}, errors.New("Email Service Not Initialized")
+	}
+
+	resetUrl := ""
+	if baseUrl := os.Getenv("PASSWORD_RESET_URL"); baseUrl != "" {
+		resetUrl = baseUrl + "?token=" + url.QueryEscape(token)
+	}
 
+	message, err := emails.Render(emails.PasswordReset, user.Locale, emails.PasswordResetData{
+		Username:         user.Username,
+		Token:            token,
+		ResetUrl:         resetUrl,
+		ExpiresInMinutes: emails.Minutes(expiresIn),
+	})
 	if err != nil {
-		logger.Error("Failed to generate email HTML: %v", err)
+		logger.Error("Failed to render email: %v", err)
 		return &EmailResponse{
 			Success: false,
 			Message: "Failed to prepare email",
@@ -168,100 +211,127 @@ func (s *EmailService) SendVerificationEmail(user *schema.User) (*EmailResponse,
 		}, err
 	}
 
-	var lastErr error
-	for attempt := 1; attempt <= maxDelay; attempt++ {
-		if err := s.sendEmail(user.Email, subject, htmlBody); err != nil {
-			lastErr = err
-			logger.Warn("Email send attempt %d failed for %s: %v", attempt, user.Email, err)
-			if attempt < maxDelay {
-				time.Sleep(retrySecond * time.Duration(attempt))
-				continue
-			}
-		} else {
-			logger.Info("Verification email sent successfully to: %s", user.Email)
-			return &EmailResponse{
-				Success: true,
-				Message: "Verification email sent successfully",
-			}, nil
-		}
-	}
-
-	logger.Error("Failed to send email after %d attempts to %s: %v", maxDelay, user.Email, lastErr)
+	if err := queueEmail(db.DB, user.Email, emails.PasswordReset, message); err != nil {
+		return &EmailResponse{
+			Success: false,
+			Message: "Failed to queue password reset email",
+			Error:   err.Error(),
+		}, err
+	}
+
+	logger.Info("Password reset email queued for: %s", user.Email)
 	return &EmailResponse{
-		Success: false,
-		Message: "Failed to send verification email",
-		Error:   lastErr.Error(),
-	}, lastErr
+		Success: true,
+		Message: "Password reset email queued",
+	}, nil
 }
 
-func (s *EmailService) sendEmail(toEmail, subject, htmlBody string) error {
-	s.mail.From(s.adminMail)
-	s.mail.FromName(s.fromName)
-	s.mail.To(toEmail)
-	s.mail.Subject(subject)
-	s.mail.HTML().Set(htmlBody)
+// SendAccountLockedEmail tells the user sign-in was locked after repeated failed attempts
+func (s *EmailService) SendAccountLockedEmail(user *schema.User, ipAddress string, lockedUntil time.Time) (*EmailResponse, error) {
+	if !s.initialized {
+		logger.Error("Email Service not initialized")
+		return &EmailResponse{
+			Success: false,
+			Message: "Invalid User Data",
+			Error:   "Service Not Initialized",
+		}, errors.New("Email Service Not Initialized")
+	}
 
-	if err := s.mail.Send(); err != nil {
-		return fmt.Errorf("failed to send email: %w", err)
+	message, err := emails.Render(emails.AccountLocked, user.Locale, emails.AccountLockedData{
+		Username:    user.Username,
+		IpAddress:   ipAddress,
+		LockedUntil: emails.FormatTime(lockedUntil),
+	})
+	if err != nil {
+		logger.Error("Failed to render email: %v", err)
+		return &EmailResponse{
+			Success: false,
+			Message: "Failed to prepare email",
+			Error:   err.Error(),
+		}, err
+	}
+
+	if err := queueEmail(db.DB, user.Email, emails.AccountLocked, message); err != nil {
+		return &EmailResponse{
+			Success: false,
+			Message: "Failed to queue account locked email",
+			Error:   err.Error(),
+		}, err
+	}
+
+	logger.Info("Account locked email queued for: %s", user.Email)
+	return &EmailResponse{
+		Success: true,
+		Message: "Account locked email queued",
+	}, nil
+}
+
+// queueEmail stores the message in the outbox, the email worker delivers it.
+// Passing the caller's transaction means the email only goes out if it commits.
+func queueEmail(tx *gorm.DB, toEmail string, kind string, message *emails.Message) error {
+	outbox := &schema.EmailOutbox{
+		Kind:          kind,
+		ToEmail:       toEmail,
+		Subject:       message.Subject,
+		HtmlBody:      message.HTML,
+		TextBody:      message.Text,
+		Status:        schema.EmailStatusPending,
+		NextAttemptAt: time.Now(),
+	}
+	if err := tx.Create(outbox).Error; err != nil {
+		logger.Error("Failed to queue %s email to %s: %v", kind, toEmail, err)
+		return err
 	}
 	return nil
 }
 
-func (s *EmailService) generateHTMLBody(user *schema.User) (string, error) {
-	htmlTemplate := `
-<!DOCTYPE html>
-<html>
-<head>
-    <meta charset="UTF-8">
-    <meta name="viewport" content="width=device-width, initial-scale=1.0">
-    <title>Email Verification</title>
-    <style>
-        body { font-family: Arial, sans-serif; line-height: 1.6; color: #333; max-width: 600px; margin: 0 auto; padding: 20px; }
-        .header { background-color: #4CAF50; color: white; padding: 20px; text-align: center; border-radius: 5px 5px 0 0; }
-        .content { background-color: #f9f9f9; padding: 30px; border-radius: 0 0 5px 5px; }
-        .code { background-color: #007BFF; color: white; font-size: 24px; font-weight: bold; padding: 15px; text-align: center; border-radius: 5px; margin: 20px 0; letter-spacing: 3px; }
-        .footer { margin-top: 20px; padding-top: 20px; border-top: 1px solid #ddd; font-size: 12px; color: #666; text-align: center; }
-        .warning { color: #e74c3c; font-weight: bold; margin-top: 15px; }
-    </style>
-</head>
-<body>
-    <div class="header">
-        <h1>GoCal Email Verification</h1>
-    </div>
-    <div class="content">
-        <h2>Hello {{.Username}}!</h2>
-        <p>Thank you for signing up with GoCal. To complete your registration, please use the verification code below:</p>
-        
-        <div class="code">{{.VerifyCode}}</div>
-        
-        <p>This code will expire in 10 minutes for your security.</p>
-        
-        <p class="warning">If you didn't request this verification, please ignore this email.</p>
-    </div>
-    <div class="footer">
-        <p>&copy; 2025 GoCal. All rights reserved.</p>
-        <p>This is an automated message, please do not reply to this email.</p>
-    </div>
-</body>
-</html>`
-
-	tmpl, err := template.New("verification").Parse(htmlTemplate)
+// queueShareNotificationEmail tells recipient that sharedBy gave them access to fileName
+func queueShareNotificationEmail(tx *gorm.DB, recipient *schema.User, sharedBy string, fileName string, accessType schema.AccessType) error {
+	message, err := emails.Render(emails.ShareNotification, recipient.Locale, emails.ShareNotificationData{
+		Username:   recipient.Username,
+		SharedBy:   sharedBy,
+		FileName:   fileName,
+		AccessType: string(accessType),
+	})
 	if err != nil {
-		return "", fmt.Errorf("failed to parse email template: %w", err)
+		return err
 	}
+	return queueEmail(tx, recipient.Email, emails.ShareNotification, message)
+}
 
-	var buf strings.Builder
-	data := struct {
-		Username   string
-		VerifyCode string
-	}{
-		Username:   user.Username,
-		VerifyCode: user.VerifyCode,
+// queueQuotaWarningEmail warns user that their storage is nearly full
+func queueQuotaWarningEmail(tx *gorm.DB, user *schema.User) error {
+	message, err := emails.Render(emails.QuotaWarning, user.Locale, emails.QuotaWarningData{
+		Username:     user.Username,
+		Percent:      int(user.StorageUsed * 100 / user.StorageLimit),
+		StorageUsed:  user.StorageUsed,
+		StorageLimit: user.StorageLimit,
+	})
+	if err != nil {
+		return err
+	}
+	return queueEmail(tx, user.Email, emails.QuotaWarning, message)
+}
+
+// Deliver sends one message over SMTP. Each send builds its own MailYak so
+// concurrent workers never share a message.
+func (s *EmailService) Deliver(message *schema.EmailOutbox) error {
+	if !s.initialized {
+		return errors.New("Email Service Not Initialized")
 	}
 
-	if err := tmpl.Execute(&buf, data); err != nil {
-		return "", fmt.Errorf("failed to execute email template: %w", err)
+	mail := mailyak.New(fmt.Sprintf("%s:%s", s.smtpHost, s.smtpPort), s.smtpAuth)
+	mail.From(s.adminMail)
+	mail.FromName(s.fromName)
+	mail.To(message.ToEmail)
+	mail.Subject(message.Subject)
+	mail.HTML().Set(message.HtmlBody)
+	if message.TextBody != "" {
+		mail.Plain().Set(message.TextBody)
 	}
 
-	return buf.String(), nil
+	if err := mail.Send(); err != nil {
+		return fmt.Errorf("failed to send email: %w", err)
+	}
+	return nil
 }
@@ -0,0 +1,202 @@
+package services
+
+import (
+	"errors"
+	"goCal/internal/db"
+	"goCal/internal/logger"
+	"goCal/internal/pagination"
+	"goCal/internal/schema"
+	"os"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var ErrOutboxMessageNotFound = errors.New("email not found or not dead-lettered")
+
+const (
+	defaultEmailMaxAttempts = 5
+	emailRetryBase          = 30 * time.Second
+	emailRetryMax           = time.Hour
+	// A message stuck in "sending" longer than this is picked up again,
+	// e.g. after the worker holding it crashed
+	emailSendLease = 2 * time.Minute
+)
+
+// EmailOutboxService hands queued emails to the workers and records the
+// outcome of each attempt
+type EmailOutboxService struct {
+	emailService *EmailService
+	maxAttempts  int
+}
+
+// NewEmailOutboxService reads EMAIL_MAX_ATTEMPTS
+func NewEmailOutboxService() *EmailOutboxService {
+	emailService, err := NewEmailServices()
+	if err != nil {
+		logger.Error("Failed to initialize email service: %v", err)
+	}
+
+	maxAttempts := defaultEmailMaxAttempts
+	if attempts, err := strconv.Atoi(os.Getenv("EMAIL_MAX_ATTEMPTS")); err == nil && attempts > 0 {
+		maxAttempts = attempts
+	}
+
+	return &EmailOutboxService{
+		emailService: emailService,
+		maxAttempts:  maxAttempts,
+	}
+}
+
+// ProcessNext claims one due message and tries to send it. It reports false
+// when nothing was due.
+func (s *EmailOutboxService) ProcessNext() (bool, error) {
+	message, err := s.claim()
+	if err != nil || message == nil {
+		return false, err
+	}
+
+	if s.emailService == nil {
+		return true, s.recordFailure(message, errors.New("email service not available"))
+	}
+	if errSend := s.emailService.Deliver(message); errSend != nil {
+		return true, s.recordFailure(message, errSend)
+	}
+	return true, s.recordSent(message)
+}
+
+// claim marks the oldest due message as sending. SKIP LOCKED lets several
+// workers claim at once without handing out the same row.
+func (s *EmailOutboxService) claim() (*schema.EmailOutbox, error) {
+	now := time.Now()
+	due := db.DB.Model(&schema.EmailOutbox{}).
+		Select("id").
+		Where("(status = ? AND next_attempt_at <= ?) OR (status = ? AND locked_until < ?)",
+			schema.EmailStatusPending, now, schema.EmailStatusSending, now).
+		Order("next_attempt_at").
+		Limit(1).
+		Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"})
+
+	var claimed []schema.EmailOutbox
+	result := db.DB.Model(&claimed).
+		Clauses(clause.Returning{}).
+		Where("id IN (?)", due).
+		Updates(map[string]interface{}{
+			"status":       schema.EmailStatusSending,
+			"locked_until": now.Add(emailSendLease),
+			"attempts":     gorm.Expr("attempts + 1"),
+		})
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if len(claimed) == 0 {
+		return nil, nil
+	}
+	return &claimed[0], nil
+}
+
+func (s *EmailOutboxService) recordSent(message *schema.EmailOutbox) error {
+	now := time.Now()
+	return db.DB.Model(&schema.EmailOutbox{}).Where("id = ?", message.ID).Updates(map[string]interface{}{
+		"status":       schema.EmailStatusSent,
+		"sent_at":      now,
+		"locked_until": nil,
+		"last_error":   "",
+		// The body can hold one-time links, no need to keep it once delivered
+		"html_body": "",
+		"text_body": "",
+	}).Error
+}
+
+// recordFailure schedules another attempt with exponential backoff, or
+// dead-letters the message once it has used up its attempts
+func (s *EmailOutboxService) recordFailure(message *schema.EmailOutbox, errSend error) error {
+	updates := map[string]interface{}{
+		"locked_until": nil,
+		"last_error":   errSend.Error(),
+	}
+
+	if message.Attempts >= s.maxAttempts {
+		updates["status"] = schema.EmailStatusDead
+		logger.Error("Giving up on %s email to %s after %d attempts: %v", message.Kind, message.ToEmail, message.Attempts, errSend)
+	} else {
+		delay := emailRetryBase
+		for i := 1; i < message.Attempts && delay < emailRetryMax; i++ {
+			delay *= 2
+		}
+		if delay > emailRetryMax {
+			delay = emailRetryMax
+		}
+		updates["status"] = schema.EmailStatusPending
+		updates["next_attempt_at"] = time.Now().Add(delay)
+		logger.Warn("Sending %s email to %s failed (attempt %d), retrying in %s: %v", message.Kind, message.ToEmail, message.Attempts, delay, errSend)
+	}
+
+	return db.DB.Model(&schema.EmailOutbox{}).Where("id = ?", message.ID).Updates(updates).Error
+}
+
+// GetStatus counts the outbox messages in each status
+func (s *EmailOutboxService) GetStatus() (map[string]int64, error) {
+	var rows []struct {
+		Status string
+		Count  int64
+	}
+	if err := db.DB.Model(&schema.EmailOutbox{}).Select("status, COUNT(*) AS count").Group("status").Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	counts := map[string]int64{
+		schema.EmailStatusPending: 0,
+		schema.EmailStatusSending: 0,
+		schema.EmailStatusSent:    0,
+		schema.EmailStatusDead:    0,
+	}
+	for _, row := range rows {
+		counts[row.Status] = row.Count
+	}
+	return counts, nil
+}
+
+// outboxSorts are the orderings GET /api/email-outbox/messages accepts
+var outboxSorts = pagination.Sorts{
+	Fields: map[string]pagination.Sort{
+		"created_at":      {Column: "created_at", Kind: pagination.Time},
+		"next_attempt_at": {Column: "next_attempt_at", Kind: pagination.Time},
+	},
+	Default:     "created_at",
+	DefaultDesc: true,
+}
+
+// GetMessages lists a page of messages, newest first by default
+func (s *EmailOutboxService) GetMessages(query *schema.EmailOutboxListQuery) ([]schema.EmailOutbox, *pagination.Page, error) {
+	tx := db.DB.Model(&schema.EmailOutbox{})
+	if query.Status != "" {
+		tx = tx.Where("status = ?", query.Status)
+	}
+	if query.Kind != "" {
+		tx = tx.Where("kind = ?", query.Kind)
+	}
+	return pagination.Find[schema.EmailOutbox](tx, query.Params, outboxSorts, "id")
+}
+
+// Retry puts a dead-lettered message back in the queue with a fresh set of attempts
+func (s *EmailOutboxService) Retry(id string) (*schema.EmailOutbox, error) {
+	var messages []schema.EmailOutbox
+	result := db.DB.Model(&messages).
+		Clauses(clause.Returning{}).
+		Where("id = ? AND status = ?", id, schema.EmailStatusDead).
+		Updates(map[string]interface{}{
+			"status":          schema.EmailStatusPending,
+			"attempts":        0,
+			"next_attempt_at": time.Now(),
+		})
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if len(messages) == 0 {
+		return nil, ErrOutboxMessageNotFound
+	}
+	return &messages[0], nil
+}
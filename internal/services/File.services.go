@@ -4,32 +4,123 @@ import (
 	"errors"
 	"fmt"
 	"goCal/internal/db"
+	"goCal/internal/extract"
 	"goCal/internal/logger"
+	"goCal/internal/pagination"
 	"goCal/internal/schema"
+	"strings"
 
+	"github.com/google/uuid"
 	"gorm.io/gorm"
 )
 
 type FileService struct {
+	accessService *FileAccessService
 }
 
 func NewFileService() *FileService {
-	return &FileService{}
+	return &FileService{
+		accessService: NewFileAccessService(),
+	}
 }
 
-func (f *FileService) GetFiles() ([]*schema.File, error) {
-	var files []*schema.File
-	result := db.DB.Find(&files)
-	if result.Error != nil {
-		logger.Error("Failed to get all the files %s ", result.Error)
-		return nil, result.Error
+// FileContentPath is the API path that streams a file's bytes
+func FileContentPath(fileId uuid.UUID) string {
+	return "/api/file/" + fileId.String() + "/content"
+}
+
+// visibleFilesScope restricts a query to files userId is allowed to see
+func visibleFilesScope(userId string) func(*gorm.DB) *gorm.DB {
+	return func(tx *gorm.DB) *gorm.DB {
+		if userId == "" {
+			return tx.Where("files.visibility = ?", schema.Public)
+		}
+		return tx.Where(
+			"files.visibility = ? OR files.uploaded_by_id = ? OR (files.visibility = ? AND files.id IN (?))",
+			schema.Public, userId, schema.Shared,
+			db.DB.Model(&schema.FileAccess{}).Select("file_id").Where("user_id = ?", userId),
+		)
+	}
+}
+
+// fileSorts are the orderings GET /api/file accepts
+var fileSorts = pagination.Sorts{
+	Fields: map[string]pagination.Sort{
+		"created_at": {Column: "files.created_at", Kind: pagination.Time},
+		"updated_at": {Column: "files.updated_at", Kind: pagination.Time},
+		"file_name":  {Column: "files.file_name", Kind: pagination.String},
+		"file_size":  {Column: "files.file_size", Kind: pagination.Int},
+	},
+	Default:     "created_at",
+	DefaultDesc: true,
+}
+
+// GetFiles returns a page of the files visible to userId (public files only when userId is empty)
+func (f *FileService) GetFiles(userId string, query *schema.FileListQuery) ([]*schema.File, *pagination.Page, error) {
+	tx := db.DB.Model(&schema.File{}).Scopes(visibleFilesScope(userId))
+
+	if query.Owner != "" {
+		ownerId, err := uuid.Parse(query.Owner)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: owner must be a user id", ErrInvalidListFilter)
+		}
+		tx = tx.Where("files.uploaded_by_id = ?", ownerId)
+	}
+	switch query.Folder {
+	case "":
+	case "root":
+		tx = tx.Where("files.folder_id IS NULL")
+	default:
+		folderId, err := uuid.Parse(query.Folder)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: folder must be a folder id or \"root\"", ErrInvalidListFilter)
+		}
+		tx = tx.Where("files.folder_id = ?", folderId)
+	}
+	if query.FileType != "" {
+		if family, ok := strings.CutSuffix(query.FileType, "/*"); ok {
+			tx = tx.Where("files.file_type LIKE ?", escapeLike(family)+"/%")
+		} else {
+			tx = tx.Where("files.file_type = ?", query.FileType)
+		}
+	}
+	if query.Visibility != "" {
+		tx = tx.Where("files.visibility = ?", query.Visibility)
+	}
+	if query.MinSize != nil {
+		tx = tx.Where("files.file_size >= ?", *query.MinSize)
+	}
+	if query.MaxSize != nil {
+		tx = tx.Where("files.file_size <= ?", *query.MaxSize)
+	}
+	tx = createdBetween(tx, "files.created_at", query.CreatedAfter, query.CreatedBefore)
+	tx, err := filterByMetadata(tx, query, userId)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	files, page, err := pagination.Find[*schema.File](tx, query.Params, fileSorts, "files.id")
+	if err != nil {
+		logger.Error("Failed to get all the files %s ", err)
+		return nil, nil, err
+	}
+	if err := attachMetadata(userId, files...); err != nil {
+		return nil, nil, err
 	}
-	return files, nil
+	return files, page, nil
 }
 
 func (f *FileService) GetFile(id string) (*schema.File, error) {
+	fileId, err := uuid.Parse(id)
+	if err != nil {
+		return nil, ErrFileNotFound
+	}
+
 	var file *schema.File
-	result := db.DB.Find(&file).Where("id = ?", id)
+	result := db.DB.Where("id = ?", fileId).First(&file)
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return nil, ErrFileNotFound
+	}
 	if result.Error != nil {
 		logger.Error("Failed to get  the file %s ", result.Error)
 		return nil, result.Error
@@ -37,12 +128,56 @@ func (f *FileService) GetFile(id string) (*schema.File, error) {
 	return file, nil
 }
 
+// getFileWithMetadata is GetFile for responses to userId, which carry the metadata of the content
+func (f *FileService) getFileWithMetadata(id string, userId string) (*schema.File, error) {
+	file, err := f.GetFile(id)
+	if err != nil {
+		return nil, err
+	}
+	if err := attachMetadata(userId, file); err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+// GetVisibleFile returns the file when userId may view it. The owner also gets the access list.
+func (f *FileService) GetVisibleFile(id string, userId string) (*schema.File, error) {
+	file, err := f.GetFile(id)
+	if err != nil {
+		return nil, err
+	}
+
+	canView, err := f.accessService.CanView(file, userId)
+	if err != nil {
+		return nil, err
+	}
+	if !canView {
+		return nil, ErrFileAccessDenied
+	}
+
+	if file.UploadedById.String() == userId {
+		accessList, err := f.accessService.GetAccessList(file.Id)
+		if err != nil {
+			return nil, err
+		}
+		for _, access := range accessList {
+			file.AccessList = append(file.AccessList, *access)
+		}
+	}
+	if err := attachMetadata(userId, file); err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+// GetUserFile returns the file only if it is owned by userId
 func (f *FileService) GetUserFile(id string, userId string) (*schema.File, error) {
-	var file *schema.File
-	result := db.DB.Find(&file).Where("id = ? AND uploaded_by = ?", file.FileName, userId)
-	if result.Error != nil {
-		logger.Error("Failed to get  the file %s ", result.Error)
-		return nil, result.Error
+	file, err := f.GetFile(id)
+	if err != nil {
+		return nil, err
+	}
+	if file.UploadedById.String() != userId {
+		return nil, ErrFileAccessDenied
 	}
 	return file, nil
 }
@@ -54,8 +189,8 @@ func (f *FileService) CreateFile(file *schema.File, userId string) (*schema.File
 	result := db.DB.Where("file_name = ? AND uploaded_by_id = ?", file.FileName, userId).First(&existingFile)
 
 	if result.Error == nil {
-		// record found => duplicate
-		return nil, fmt.Errorf("file with same name already exists")
+		// record found => duplicate, new content goes through the versions endpoint
+		return nil, fmt.Errorf("%w, upload it as a new version of file %s", ErrFileNameTaken, existingFile.Id)
 	}
 
 	if !errors.Is(result.Error, gorm.ErrRecordNotFound) {
@@ -63,16 +198,45 @@ func (f *FileService) CreateFile(file *schema.File, userId string) (*schema.File
 		return nil, result.Error
 	}
 
-	// Create new file
-	resultFileCreation := db.DB.Create(file)
-	if resultFileCreation.Error != nil {
-		logger.Error("Failed to create file %s", resultFileCreation.Error)
-		return nil, resultFileCreation.Error
+	if file.FolderId != nil {
+		if err := ensureFolderOwner(*file.FolderId, userId); err != nil {
+			return nil, err
+		}
+	}
+
+	// Files are always served through the content endpoint so access checks apply
+	if file.Id == uuid.Nil {
+		file.Id = uuid.New()
+	}
+	file.FileUrl = FileContentPath(file.Id)
+
+	// Create new file, queue its text and thumbnails and charge it to the
+	// uploader's quota in one transaction
+	errFileCreation := db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(file).Error; err != nil {
+			return err
+		}
+		if err := queueContentIndex(tx, file.Id, file.FileName, file.FileType, file.ContentHash); err != nil {
+			return err
+		}
+		if err := queueThumbnails(tx, file.ContentHash, file.FileType); err != nil {
+			return err
+		}
+		return adjustStorageUsed(tx, userId, file.FileSize)
+	})
+	if errFileCreation != nil {
+		logger.Error("Failed to create file %s", errFileCreation)
+		return nil, errFileCreation
 	}
 
+	if err := attachMetadata(userId, file); err != nil {
+		return nil, err
+	}
 	return file, nil
 }
 
+// DeleteFile moves a file to the trash. Only the owner may delete, edit access is not enough.
+// The object and its quota charge are kept until the file is purged from the trash.
 func (f *FileService) DeleteFile(fileId string, userId string) (message string, err error) {
 	fileFound, err := f.GetUserFile(fileId, userId)
 	if err != nil {
@@ -80,38 +244,110 @@ func (f *FileService) DeleteFile(fileId string, userId string) (message string,
 		return "Failed to delete file", err
 	}
 
-	if err := db.DB.Delete(fileFound).Error; err != nil {
-		logger.Error("Failed to delete the file  with the fileId %s ", err.Error())
-		return "Failed to delete file", err
+	if errDelete := db.DB.Delete(fileFound).Error; errDelete != nil {
+		logger.Error("Failed to delete the file  with the fileId %s ", errDelete.Error())
+		return "Failed to delete file", errDelete
+	}
+	return "File Moved To Trash", nil
+}
+
+// ensureFolderOwner fails unless folderId exists and belongs to userId
+func ensureFolderOwner(folderId uuid.UUID, userId string) error {
+	var folder schema.Folder
+	result := db.DB.Select("id", "created_by_id").Where("id = ?", folderId).First(&folder)
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return ErrFolderNotFound
+	}
+	if result.Error != nil {
+		return result.Error
+	}
+	if folder.CreatedById.String() != userId {
+		return ErrFolderAccessDenied
+	}
+	return nil
+}
+
+// MoveFile puts an owned file into folderId, or takes it out of any folder when folderId is nil
+func (f *FileService) MoveFile(fileId string, userId string, folderId *uuid.UUID) (*schema.File, error) {
+	file, err := f.GetUserFile(fileId, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	if folderId != nil {
+		if err := ensureFolderOwner(*folderId, userId); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := db.DB.Model(&schema.File{}).Where("id = ?", file.Id).Update("folder_id", folderId).Error; err != nil {
+		logger.Error("Failed to move the file %s: %s", fileId, err)
+		return nil, err
 	}
-	return "File Deleted Successfully", nil
+
+	return f.getFileWithMetadata(fileId, userId)
 }
 
+// UpdateFile applies the update when userId owns the file or holds edit access.
+// Changing the visibility is reserved for the owner.
 func (f *FileService) UpdateFile(fileId string, userId string, updateFile *schema.UpdateFileRequest) (message *schema.File, err error) {
-	_, errFile := f.GetUserFile(fileId, userId)
+	file, errFile := f.GetFile(fileId)
 	if errFile != nil {
-		logger.Error("Failed to get the file  with the fileId %s ", err.Error())
+		logger.Error("Failed to get the file  with the fileId %s ", errFile.Error())
 		return nil, errFile
 	}
+
+	canEdit, err := f.accessService.CanEdit(file, userId)
+	if err != nil {
+		return nil, err
+	}
+	if !canEdit {
+		return nil, ErrFileAccessDenied
+	}
+
 	updateFields := make(map[string]interface{})
 
 	if updateFile.FileName != nil {
 		updateFields["file_name"] = *updateFile.FileName
 	}
 
-	if updateFile.FileSize != nil {
-		updateFields["file_size"] = *updateFile.FileSize
-	}
-
-	if updateFile.FileType != nil {
-		updateFields["file_type"] = *updateFile.FileType
+	if updateFile.Visibility != nil {
+		if file.UploadedById.String() != userId {
+			return nil, ErrFileAccessDenied
+		}
+		switch *updateFile.Visibility {
+		case schema.Private, schema.Shared, schema.Public:
+			updateFields["visibility"] = *updateFile.Visibility
+		default:
+			return nil, fmt.Errorf("visibility must be private, shared or public")
+		}
 	}
 
 	if len(updateFields) > 0 {
-		if err := db.DB.Model(&schema.File{}).Where("id = ? AND uploaded_by = ?", fileId, userId).Updates(updateFields).Error; err != nil {
-			return nil, err
+		errUpdate := db.DB.Transaction(func(tx *gorm.DB) error {
+			// Names are unique per owner, whoever does the renaming
+			if updateFile.FileName != nil {
+				var count int64
+				if err := tx.Model(&schema.File{}).Where("file_name = ? AND uploaded_by_id = ? AND id <> ?", *updateFile.FileName, file.UploadedById, file.Id).Count(&count).Error; err != nil {
+					return err
+				}
+				if count > 0 {
+					return ErrFileNameTaken
+				}
+			}
+			if err := tx.Model(&schema.File{}).Where("id = ?", file.Id).Updates(updateFields).Error; err != nil {
+				return err
+			}
+			// The name decides whether the text can be extracted at all
+			if updateFile.FileName != nil && extract.Supported(file.FileType, *updateFile.FileName) != extract.Supported(file.FileType, file.FileName) {
+				return queueContentIndex(tx, file.Id, *updateFile.FileName, file.FileType, file.ContentHash)
+			}
+			return nil
+		})
+		if errUpdate != nil {
+			return nil, errUpdate
 		}
 	}
 
-	return f.GetFile(fileId)
+	return f.getFileWithMetadata(fileId, userId)
 }
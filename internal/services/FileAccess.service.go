@@ -0,0 +1,207 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"goCal/internal/db"
+	"goCal/internal/logger"
+	"goCal/internal/schema"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrFileNotFound     = errors.New("file not found")
+	ErrFileAccessDenied = errors.New("you do not have access to this file")
+	ErrInvalidAccess    = errors.New("access type must be view or edit")
+	ErrFileNameTaken    = errors.New("file with same name already exists")
+)
+
+type FileAccessService struct{}
+
+func NewFileAccessService() *FileAccessService {
+	return &FileAccessService{}
+}
+
+func isValidAccessType(accessType schema.AccessType) bool {
+	return accessType == schema.View || accessType == schema.Edit
+}
+
+// GetUserAccess returns the access entry a user holds on a file, or nil
+func (fa *FileAccessService) GetUserAccess(fileId uuid.UUID, userId string) (*schema.FileAccess, error) {
+	var access schema.FileAccess
+	result := db.DB.Where("file_id = ? AND user_id = ?", fileId, userId).First(&access)
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return &access, nil
+}
+
+// CanView reports whether userId (empty for anonymous callers) may read the file.
+// Public files are readable by anyone, shared files by the owner and the access
+// list, and private files only by the owner.
+func (fa *FileAccessService) CanView(file *schema.File, userId string) (bool, error) {
+	if file.Visibility == schema.Public {
+		return true, nil
+	}
+	if userId == "" {
+		return false, nil
+	}
+	if file.UploadedById.String() == userId {
+		return true, nil
+	}
+	if file.Visibility != schema.Shared {
+		return false, nil
+	}
+
+	access, err := fa.GetUserAccess(file.Id, userId)
+	if err != nil {
+		return false, err
+	}
+	return access != nil, nil
+}
+
+// CanEdit reports whether userId may change the file. Only the owner and users
+// holding edit access on a shared or public file qualify.
+func (fa *FileAccessService) CanEdit(file *schema.File, userId string) (bool, error) {
+	if userId == "" {
+		return false, nil
+	}
+	if file.UploadedById.String() == userId {
+		return true, nil
+	}
+	if file.Visibility == schema.Private {
+		return false, nil
+	}
+
+	access, err := fa.GetUserAccess(file.Id, userId)
+	if err != nil {
+		return false, err
+	}
+	return access != nil && access.AccessType == schema.Edit, nil
+}
+
+func (fa *FileAccessService) GetAccessList(fileId uuid.UUID) ([]*schema.FileAccess, error) {
+	var accessList []*schema.FileAccess
+	result := db.DB.Where("file_id = ?", fileId).Find(&accessList)
+	if result.Error != nil {
+		logger.Error("Failed to get the access list %s ", result.Error)
+		return nil, result.Error
+	}
+	return accessList, nil
+}
+
+// GrantAccess adds or updates the access entry for targetUserId. Granting access on
+// a private file turns it into a shared one so the entry actually takes effect.
+func (fa *FileAccessService) GrantAccess(file *schema.File, targetUserId uuid.UUID, accessType schema.AccessType) (*schema.FileAccess, error) {
+	if !isValidAccessType(accessType) {
+		return nil, ErrInvalidAccess
+	}
+	if targetUserId == file.UploadedById {
+		return nil, fmt.Errorf("the owner already has full access to this file")
+	}
+
+	var access schema.FileAccess
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("file_id = ? AND user_id = ?", file.Id, targetUserId).First(&access)
+		if result.Error == nil {
+			access.AccessType = accessType
+			if err := tx.Save(&access).Error; err != nil {
+				return err
+			}
+		} else if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			access = schema.FileAccess{
+				FileID:     file.Id,
+				UserId:     targetUserId,
+				AccessType: accessType,
+			}
+			if err := tx.Create(&access).Error; err != nil {
+				return err
+			}
+			// Sharing still succeeds if the email can't be queued
+			errNotify := tx.Transaction(func(tx *gorm.DB) error {
+				return notifyFileShared(tx, file, targetUserId, accessType)
+			})
+			if errNotify != nil {
+				logger.Error("Failed to queue share notification for file %s: %v", file.Id, errNotify)
+			}
+		} else {
+			return result.Error
+		}
+
+		if file.Visibility == schema.Private {
+			if err := tx.Model(&schema.File{}).Where("id = ?", file.Id).Update("visibility", schema.Shared).Error; err != nil {
+				return err
+			}
+			file.Visibility = schema.Shared
+		}
+		return nil
+	})
+	if err != nil {
+		logger.Error("Failed to grant access on file %s: %v", file.Id, err)
+		return nil, err
+	}
+	return &access, nil
+}
+
+// notifyFileShared emails a user who was just given access to file
+func notifyFileShared(tx *gorm.DB, file *schema.File, targetUserId uuid.UUID, accessType schema.AccessType) error {
+	var users []schema.User
+	if err := tx.Where("id IN ?", []uuid.UUID{targetUserId, file.UploadedById}).Find(&users).Error; err != nil {
+		return err
+	}
+
+	var recipient, owner *schema.User
+	for i := range users {
+		switch users[i].ID {
+		case targetUserId:
+			recipient = &users[i]
+		case file.UploadedById:
+			owner = &users[i]
+		}
+	}
+	if recipient == nil || owner == nil {
+		return nil
+	}
+	return queueShareNotificationEmail(tx, recipient, owner.Username, file.FileName, accessType)
+}
+
+func (fa *FileAccessService) UpdateAccess(fileId uuid.UUID, targetUserId string, accessType schema.AccessType) (*schema.FileAccess, error) {
+	if !isValidAccessType(accessType) {
+		return nil, ErrInvalidAccess
+	}
+	if _, err := uuid.Parse(targetUserId); err != nil {
+		return nil, fmt.Errorf("invalid user id")
+	}
+
+	access, err := fa.GetUserAccess(fileId, targetUserId)
+	if err != nil {
+		return nil, err
+	}
+	if access == nil {
+		return nil, fmt.Errorf("user has no access entry on this file")
+	}
+
+	access.AccessType = accessType
+	if err := db.DB.Save(access).Error; err != nil {
+		logger.Error("Failed to update access on file %s: %v", fileId, err)
+		return nil, err
+	}
+	return access, nil
+}
+
+func (fa *FileAccessService) RevokeAccess(fileId uuid.UUID, targetUserId string) error {
+	result := db.DB.Where("file_id = ? AND user_id = ?", fileId, targetUserId).Delete(&schema.FileAccess{})
+	if result.Error != nil {
+		logger.Error("Failed to revoke access on file %s: %v", fileId, result.Error)
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("user has no access entry on this file")
+	}
+	return nil
+}
@@ -0,0 +1,174 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"goCal/internal/db"
+	"goCal/internal/logger"
+	"goCal/internal/media"
+	"goCal/internal/schema"
+	"io"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// recordMetadata reads the dimensions, camera details, tags and running time
+// of new content while its upload is still spooled. Content seen before
+// already has its row. A file we can't read simply has no metadata, so
+// failures are logged rather than failing the upload.
+func recordMetadata(hash string, content io.ReaderAt, size int64, fileType string) {
+	if !media.Supported(fileType) {
+		return
+	}
+
+	var count int64
+	if err := db.DB.Model(&schema.FileMetadata{}).Where("content_hash = ?", hash).Count(&count).Error; err != nil {
+		logger.Error("Failed to look up metadata of %s: %v", hash, err)
+		return
+	}
+	if count > 0 {
+		return
+	}
+
+	info, err := media.Read(content, size, fileType)
+	if err != nil {
+		if !errors.Is(err, media.ErrUnsupported) {
+			logger.Warn("Failed to read metadata of %s (%s): %v", hash, fileType, err)
+		}
+		return
+	}
+
+	metadata := &schema.FileMetadata{
+		ContentHash: hash,
+		Kind:        info.Kind,
+		CameraMake:  info.CameraMake,
+		CameraModel: info.CameraModel,
+		TakenAt:     info.TakenAt,
+		Latitude:    info.Latitude,
+		Longitude:   info.Longitude,
+		Title:       info.Title,
+		Artist:      info.Artist,
+		Album:       info.Album,
+	}
+	if info.Width > 0 && info.Height > 0 {
+		metadata.Width, metadata.Height = &info.Width, &info.Height
+	}
+	if info.Duration > 0 {
+		metadata.DurationSeconds = &info.Duration
+	}
+
+	// A concurrent upload of the same content may have read it first
+	if err := db.DB.Clauses(clause.OnConflict{DoNothing: true}).Create(metadata).Error; err != nil {
+		logger.Error("Failed to save metadata of %s: %v", hash, err)
+	}
+}
+
+// attachMetadata fills in the Metadata of the files whose content has any, in
+// one query. Where a photo was taken is only shown to the file's owner, userId
+// being the caller.
+func attachMetadata(userId string, files ...*schema.File) error {
+	hashes := make([]string, 0, len(files))
+	for _, file := range files {
+		if file != nil && file.ContentHash != "" {
+			hashes = append(hashes, file.ContentHash)
+		}
+	}
+	if len(hashes) == 0 {
+		return nil
+	}
+
+	var rows []*schema.FileMetadata
+	if err := db.DB.Where("content_hash IN ?", hashes).Find(&rows).Error; err != nil {
+		logger.Error("Failed to get file metadata %s", err)
+		return err
+	}
+	byHash := make(map[string]*schema.FileMetadata, len(rows))
+	for _, row := range rows {
+		byHash[row.ContentHash] = row
+	}
+	for _, file := range files {
+		if file == nil {
+			continue
+		}
+		row, ok := byHash[file.ContentHash]
+		if !ok {
+			file.Metadata = nil
+			continue
+		}
+		// Files with the same content share a row, each gets its own copy
+		metadata := *row
+		if file.UploadedById.String() != userId {
+			metadata.Latitude, metadata.Longitude = nil, nil
+		}
+		file.Metadata = &metadata
+	}
+	return nil
+}
+
+// filterByMetadata narrows a file listing to content whose metadata matches
+// the query. Files without metadata only survive when no such filter is set.
+// The location is only known to userId for their own files, so has_location
+// treats everyone else's as having none.
+func filterByMetadata(tx *gorm.DB, query *schema.FileListQuery, userId string) (*gorm.DB, error) {
+	if query.MinDuration != nil && query.MaxDuration != nil && *query.MinDuration > *query.MaxDuration {
+		return nil, fmt.Errorf("%w: min_duration is larger than max_duration", ErrInvalidListFilter)
+	}
+
+	metadata := db.DB.Model(&schema.FileMetadata{}).Select("content_hash")
+	filtered := false
+	where := func(condition string, args ...interface{}) {
+		metadata = metadata.Where(condition, args...)
+		filtered = true
+	}
+
+	if query.Kind != "" {
+		where("kind = ?", query.Kind)
+	}
+	if query.MinWidth != nil {
+		where("width >= ?", *query.MinWidth)
+	}
+	if query.MinHeight != nil {
+		where("height >= ?", *query.MinHeight)
+	}
+	if query.MinDuration != nil {
+		where("duration_seconds >= ?", *query.MinDuration)
+	}
+	if query.MaxDuration != nil {
+		where("duration_seconds <= ?", *query.MaxDuration)
+	}
+	if query.TakenAfter != nil || query.TakenBefore != nil {
+		metadata = createdBetween(metadata, "taken_at", query.TakenAfter, query.TakenBefore)
+		filtered = true
+	}
+	if query.Camera != "" {
+		pattern := "%" + escapeLike(query.Camera) + "%"
+		where("(camera_make ILIKE ? OR camera_model ILIKE ? OR camera_make || ' ' || camera_model ILIKE ?)", pattern, pattern, pattern)
+	}
+	if query.Artist != "" {
+		where("artist ILIKE ?", "%"+escapeLike(query.Artist)+"%")
+	}
+
+	if filtered {
+		tx = tx.Where("files.content_hash IN (?)", metadata)
+	}
+
+	if query.HasLocation != nil {
+		located := "EXISTS (SELECT 1 FROM file_metadata WHERE file_metadata.content_hash = files.content_hash AND file_metadata.latitude IS NOT NULL)"
+		switch {
+		case *query.HasLocation && userId == "":
+			tx = tx.Where("FALSE")
+		case *query.HasLocation:
+			tx = tx.Where("files.uploaded_by_id = ? AND "+located, userId)
+		case userId != "":
+			// Files without any metadata have no location either
+			tx = tx.Where("(files.uploaded_by_id <> ? OR NOT "+located+")", userId)
+		}
+	}
+	return tx, nil
+}
+
+// deleteMetadata removes the metadata of content that is being collected
+func deleteMetadata(tx *gorm.DB, hash string) error {
+	return tx.Where("content_hash = ?", hash).Delete(&schema.FileMetadata{}).Error
+}
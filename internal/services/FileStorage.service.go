@@ -1,71 +1,281 @@
 package services
 
 import (
-	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"goCal/internal/db"
 	"goCal/internal/logger"
+	"goCal/internal/schema"
+	"goCal/internal/storage"
 	"io"
-	"path/filepath"
+	"os"
 	"time"
 
-	storage_go "github.com/supabase-community/storage-go"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type FileStorageService struct {
-	storageClient *storage_go.Client
+	backend storage.StorageBackend
 }
 
-func NewFileStorageService(client *storage_go.Client) *FileStorageService {
-	return &FileStorageService{
-		storageClient: client,
-	}
+// StoredObject is where an uploaded file ended up in the storage backend,
+// along with the size and SHA-256 of the bytes that were written
+type StoredObject struct {
+	Bucket string
+	Key    string
+	Size   int64
+	Hash   string
 }
 
-func (nfs *FileStorageService) UploadFile(userId string, fileName string, file io.Reader, fileType string) (string, error) {
-	if userId == "" {
-		logger.Error("Failed to get the userId UnAuthorized")
-		return "", errors.New("Unauthorized User. UserId Not Found")
-	}
-	if fileName == "" {
-		logger.Error("Failed to get the File Name")
-		return "", errors.New("Failed to get the fileName")
+func NewFileStorageService(backend storage.StorageBackend) *FileStorageService {
+	return &FileStorageService{
+		backend: backend,
 	}
+}
 
-	var bucketName string
+// BucketForFileType routes a MIME type to its storage bucket
+func BucketForFileType(fileType string) string {
 	switch fileType {
 	case "image/jpeg", "image/jpg", "image/png", "image/gif", "image/bmp", "image/webp":
-		bucketName = "goCal-Albums-Bucket"
+		return storage.BucketAlbums
 	case "video/mp4", "video/webm", "video/avi", "video/mov", "video/mkv":
-		bucketName = "goCal-Videos-Bucket"
+		return storage.BucketVideos
 	case "audio/mp3", "audio/wav", "audio/aac", "audio/ogg", "audio/flac":
-		bucketName = "goCal-Audios-Bucket"
+		return storage.BucketAudios
 	case "application/pdf", "application/msword", "application/vnd.openxmlformats-officedocument.wordprocessingml.document", "application/vnd.ms-excel", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet":
-		bucketName = "goCal-Docs-Bucket"
+		return storage.BucketDocs
 	default:
-		bucketName = "goCal-Other-Bucket"
+		return storage.BucketOthers
+	}
+}
+
+// UploadFile stores the content once per SHA-256. The bytes are spooled to a
+// temp file while hashing; when a blob with the same hash exists it is reused
+// and the upload never reaches the backend. Either way the caller holds one
+// reference on the blob and must hand it back with ReleaseObject if it does
+// not end up on a file row. Quota is still charged per file, dedup only
+// saves backend storage.
+func (nfs *FileStorageService) UploadFile(userId string, fileName string, file io.Reader, fileType string) (*StoredObject, error) {
+	if userId == "" {
+		logger.Error("Failed to get the userId UnAuthorized")
+		return nil, errors.New("Unauthorized User. UserId Not Found")
+	}
+	if fileName == "" {
+		logger.Error("Failed to get the File Name")
+		return nil, errors.New("Failed to get the fileName")
+	}
+	if nfs.backend == nil {
+		logger.Error("Storage backend is not configured")
+		return nil, errors.New("storage backend is not configured")
 	}
 
-	timeStamp := time.Now().Unix()
-	fileExt := filepath.Ext(fileName)
-	baseFileName := fileName[:len(fileName)-len(fileExt)]
-	uniqueFileName := fmt.Sprintf("%s%d_%s%s", userId, timeStamp, baseFileName, fileExt)
-	logger.Info(fmt.Sprintf("Uploading file %s to bucket: %s", uniqueFileName, bucketName))
+	spool, err := os.CreateTemp("", "gocal-blob-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to buffer upload: %w", err)
+	}
+	defer os.Remove(spool.Name())
+	defer spool.Close()
 
-	fileBytes, err := io.ReadAll(file)
+	hasher := sha256.New()
+	size, err := io.Copy(io.MultiWriter(spool, hasher), file)
 	if err != nil {
-		logger.Error(fmt.Sprintf("Failed to read file: %v", err))
-		return "", errors.New("failed to read file: %w " + err.Error())
+		return nil, fmt.Errorf("failed to read upload: %w", err)
+	}
+	hash := hex.EncodeToString(hasher.Sum(nil))
+
+	// Reuse the blob when we already have this content
+	var existing schema.Blob
+	result := db.DB.Model(&existing).Clauses(clause.Returning{}).
+		Where("hash = ?", hash).
+		Update("ref_count", gorm.Expr("ref_count + 1"))
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected > 0 {
+		logger.Info(fmt.Sprintf("Reusing blob %s for %s", hash, fileName))
+		// Content stored before metadata was read gets it now
+		recordMetadata(hash, spool, size, fileType)
+		return &StoredObject{Bucket: existing.Bucket, Key: existing.Key, Size: size, Hash: hash}, nil
+	}
+
+	bucketName := BucketForFileType(fileType)
+	key := blobKey(hash)
+	logger.Info(fmt.Sprintf("Uploading blob %s for %s to bucket: %s", hash, fileName, bucketName))
+
+	if _, err := spool.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("failed to read upload: %w", err)
+	}
+	if err := nfs.backend.Put(bucketName, key, spool, fileType); err != nil {
+		logger.Error(fmt.Sprintf("Failed to upload file to %s storage %v ", nfs.backend.Name(), err))
+		return nil, fmt.Errorf("failed to upload file: %w", err)
+	}
+
+	// A concurrent upload of the same content may have created the row first
+	blob := schema.Blob{Hash: hash, Bucket: bucketName, Key: key, Size: size, RefCount: 1}
+	if err := db.DB.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "hash"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"ref_count":  gorm.Expr("blobs.ref_count + 1"),
+			"updated_at": time.Now(),
+		}),
+	}, clause.Returning{}).Create(&blob).Error; err != nil {
+		nfs.DeleteFile(bucketName, key)
+		return nil, err
+	}
+	if blob.Bucket != bucketName {
+		// Same content already lives in another bucket, drop our copy
+		nfs.DeleteFile(bucketName, key)
+	}
+
+	logger.Info(fmt.Sprintf("File uploaded successfully to %s/%s", blob.Bucket, blob.Key))
+	recordMetadata(hash, spool, size, fileType)
+
+	return &StoredObject{
+		Bucket: blob.Bucket,
+		Key:    blob.Key,
+		Size:   size,
+		Hash:   hash,
+	}, nil
+}
+
+// blobKey is where content with the given hash is stored inside its bucket
+func blobKey(hash string) string {
+	return "blobs/" + hash[:2] + "/" + hash
+}
+
+// acquireBlobReference adds a reference for another row pointing at the
+// object. Objects stored before deduplication have no blob row and are left alone.
+func acquireBlobReference(tx *gorm.DB, bucket string, key string) error {
+	return tx.Model(&schema.Blob{}).Where("bucket = ? AND key = ?", bucket, key).
+		Update("ref_count", gorm.Expr("ref_count + 1")).Error
+}
+
+// releaseBlobReference drops the reference of a row that no longer points at
+// the object. Call CollectObject after the transaction commits.
+func releaseBlobReference(tx *gorm.DB, bucket string, key string) error {
+	return tx.Model(&schema.Blob{}).Where("bucket = ? AND key = ?", bucket, key).
+		Update("ref_count", gorm.Expr("GREATEST(ref_count - 1, 0)")).Error
+}
+
+// ReleaseObject hands back the reference UploadFile took when the object did
+// not end up on a file row
+func (nfs *FileStorageService) ReleaseObject(bucket string, key string) error {
+	if err := releaseBlobReference(db.DB, bucket, key); err != nil {
+		return err
+	}
+	return nfs.CollectObject(bucket, key)
+}
+
+// CollectObject removes an object nothing references any more. Blobs are
+// locked while their object is deleted so a concurrent upload can't take a
+// reference to content that is going away. Objects stored before
+// deduplication are deleted once no file or file version points at them.
+func (nfs *FileStorageService) CollectObject(bucket string, key string) error {
+	if bucket == "" || key == "" {
+		return nil
+	}
+
+	var blobCount int64
+	if err := db.DB.Model(&schema.Blob{}).Where("bucket = ? AND key = ?", bucket, key).Count(&blobCount).Error; err != nil {
+		return err
+	}
+	if blobCount > 0 {
+		return db.DB.Transaction(func(tx *gorm.DB) error {
+			var blob schema.Blob
+			result := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+				Where("bucket = ? AND key = ? AND ref_count = 0", bucket, key).Limit(1).Find(&blob)
+			if result.Error != nil || result.RowsAffected == 0 {
+				return result.Error
+			}
+			if err := nfs.DeleteFile(blob.Bucket, blob.Key); err != nil && !errors.Is(err, storage.ErrObjectNotFound) {
+				return err
+			}
+			if err := nfs.deleteThumbnails(tx, blob.Hash); err != nil {
+				return err
+			}
+			if err := deleteMetadata(tx, blob.Hash); err != nil {
+				return err
+			}
+			return tx.Delete(&blob).Error
+		})
+	}
+
+	var references int64
+	if err := db.DB.Raw(`
+		SELECT (SELECT COUNT(*) FROM files WHERE storage_bucket = ? AND storage_key = ?)
+		     + (SELECT COUNT(*) FROM file_versions WHERE storage_bucket = ? AND storage_key = ?)`,
+		bucket, key, bucket, key).Scan(&references).Error; err != nil {
+		return err
+	}
+	if references > 0 {
+		return nil
+	}
+	return nfs.DeleteFile(bucket, key)
+}
+
+// blobReferencesQuery counts the files and file versions pointing at a blob
+const blobReferencesQuery = `
+	(SELECT COUNT(*) FROM files WHERE files.storage_bucket = blobs.bucket AND files.storage_key = blobs.key)
+	+ (SELECT COUNT(*) FROM file_versions WHERE file_versions.storage_bucket = blobs.bucket AND file_versions.storage_key = blobs.key)`
+
+// CollectGarbage fixes reference counts that drifted from the files and
+// file_versions tables and removes blobs left without references. Blobs
+// touched within grace are skipped so in-flight uploads keep their reference.
+func (nfs *FileStorageService) CollectGarbage(grace time.Duration) (int, error) {
+	cutoff := time.Now().Add(-grace)
+
+	if err := db.DB.Exec(`
+		UPDATE blobs SET ref_count = (`+blobReferencesQuery+`)
+		WHERE updated_at < ? AND ref_count IS DISTINCT FROM (`+blobReferencesQuery+`)`, cutoff).Error; err != nil {
+		return 0, err
 	}
 
-	_, errUpload := nfs.storageClient.UploadFile(bucketName, uniqueFileName, bytes.NewReader(fileBytes))
-	if errUpload != nil {
-		logger.Error(fmt.Sprintf("Failed to upload file to supabase %v ", err))
-		return fmt.Sprintf("Failed to upload file to supabase %v ", err), nil
+	var orphans []schema.Blob
+	if err := db.DB.Where("ref_count = 0 AND updated_at < ?", cutoff).Find(&orphans).Error; err != nil {
+		return 0, err
 	}
 
-	publicURL := nfs.storageClient.GetPublicUrl(bucketName, uniqueFileName)
-	logger.Info(fmt.Sprintf("File uploaded successfully. URL: %s", publicURL.SignedURL))
+	collected := 0
+	for _, blob := range orphans {
+		if err := nfs.CollectObject(blob.Bucket, blob.Key); err != nil {
+			logger.Error(fmt.Sprintf("Failed to collect blob %s: %v", blob.Hash, err))
+			continue
+		}
+		collected++
+	}
+	return collected, nil
+}
+
+func (nfs *FileStorageService) DeleteFile(bucket string, key string) error {
+	if bucket == "" || key == "" {
+		return nil
+	}
+	if err := nfs.backend.Delete(bucket, key); err != nil {
+		logger.Error(fmt.Sprintf("Failed to delete %s/%s from storage: %v", bucket, key, err))
+		return err
+	}
+	return nil
+}
+
+// PutObject stores data under key as is, for objects derived from a blob such as thumbnails
+func (nfs *FileStorageService) PutObject(bucket string, key string, data io.Reader, contentType string) error {
+	if nfs.backend == nil {
+		return errors.New("storage backend is not configured")
+	}
+	return nfs.backend.Put(bucket, key, data, contentType)
+}
+
+func (nfs *FileStorageService) Stat(bucket string, key string) (*storage.ObjectInfo, error) {
+	return nfs.backend.Stat(bucket, key)
+}
+
+func (nfs *FileStorageService) Open(bucket string, key string) (io.ReadCloser, error) {
+	return nfs.backend.Get(bucket, key)
+}
 
-	return publicURL.SignedURL, nil
+func (nfs *FileStorageService) OpenRange(bucket string, key string, offset int64, length int64) (io.ReadCloser, error) {
+	return nfs.backend.GetRange(bucket, key, offset, length)
 }
@@ -0,0 +1,289 @@
+package services
+
+import (
+	"errors"
+	"goCal/internal/db"
+	"goCal/internal/logger"
+	"goCal/internal/schema"
+	"os"
+	"strconv"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var ErrFileVersionNotFound = errors.New("file version not found")
+
+// defaultVersionRetention is how many earlier versions are kept per file
+const defaultVersionRetention = 10
+
+type FileVersionService struct {
+	accessService      *FileAccessService
+	fileStorageService *FileStorageService
+	retention          int
+}
+
+// NewFileVersionService reads FILE_VERSION_RETENTION, the number of earlier
+// versions kept per file. 0 keeps every version.
+func NewFileVersionService(fileStorageService *FileStorageService) *FileVersionService {
+	retention := defaultVersionRetention
+	if raw := os.Getenv("FILE_VERSION_RETENTION"); raw != "" {
+		if parsed, err := strconv.Atoi(raw); err == nil && parsed >= 0 {
+			retention = parsed
+		} else {
+			logger.Warn("Invalid FILE_VERSION_RETENTION " + raw + ", using default")
+		}
+	}
+
+	return &FileVersionService{
+		accessService:      NewFileAccessService(),
+		fileStorageService: fileStorageService,
+		retention:          retention,
+	}
+}
+
+// GetVersions lists the earlier versions of a file, newest first, each
+// compared with the version before it
+func (fv *FileVersionService) GetVersions(fileId uuid.UUID) ([]*schema.FileVersion, error) {
+	var versions []*schema.FileVersion
+	if err := db.DB.Where("file_id = ?", fileId).Order("version_number DESC").Find(&versions).Error; err != nil {
+		logger.Error("Failed to get the versions of file %s: %s", fileId, err)
+		return nil, err
+	}
+
+	for i, version := range versions {
+		if i+1 < len(versions) {
+			previous := versions[i+1]
+			version.SizeDelta = version.FileSize - previous.FileSize
+			version.ContentChanged = version.ContentHash == "" || version.ContentHash != previous.ContentHash
+		} else {
+			version.SizeDelta = version.FileSize
+			version.ContentChanged = true
+		}
+	}
+	return versions, nil
+}
+
+func (fv *FileVersionService) GetVersion(fileId uuid.UUID, versionId string) (*schema.FileVersion, error) {
+	id, err := uuid.Parse(versionId)
+	if err != nil {
+		return nil, ErrFileVersionNotFound
+	}
+
+	var version *schema.FileVersion
+	result := db.DB.Where("id = ? AND file_id = ?", id, fileId).First(&version)
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return nil, ErrFileVersionNotFound
+	}
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return version, nil
+}
+
+// CheckCanEdit fails unless userId may replace the file's content
+func (fv *FileVersionService) CheckCanEdit(file *schema.File, userId string) error {
+	canEdit, err := fv.accessService.CanEdit(file, userId)
+	if err != nil {
+		return err
+	}
+	if !canEdit {
+		return ErrFileAccessDenied
+	}
+	return nil
+}
+
+// AddVersion makes stored the current content of the file and archives the
+// content it replaces. The new bytes are charged to the file owner.
+func (fv *FileVersionService) AddVersion(file *schema.File, userId string, stored *StoredObject, fileType string) (*schema.File, error) {
+	if err := fv.CheckCanEdit(file, userId); err != nil {
+		return nil, err
+	}
+
+	uploaderId, err := uuid.Parse(userId)
+	if err != nil {
+		return nil, err
+	}
+
+	return fv.replaceContent(file.Id, uploaderId, stored.Size, map[string]interface{}{
+		"storage_bucket": stored.Bucket,
+		"storage_key":    stored.Key,
+		"file_size":      stored.Size,
+		"file_type":      fileType,
+		"content_hash":   stored.Hash,
+	}, nil)
+}
+
+// RestoreVersion makes an earlier version the current content again. The
+// version stays in history and shares its object with the file, so nothing
+// new is charged.
+func (fv *FileVersionService) RestoreVersion(file *schema.File, versionId string, userId string) (*schema.File, error) {
+	if err := fv.CheckCanEdit(file, userId); err != nil {
+		return nil, err
+	}
+
+	version, err := fv.GetVersion(file.Id, versionId)
+	if err != nil {
+		return nil, err
+	}
+
+	restorerId, err := uuid.Parse(userId)
+	if err != nil {
+		return nil, err
+	}
+
+	return fv.replaceContent(file.Id, restorerId, version.FileSize, map[string]interface{}{
+		"storage_bucket": version.StorageBucket,
+		"storage_key":    version.StorageKey,
+		"file_size":      version.FileSize,
+		"file_type":      version.FileType,
+		"content_hash":   version.ContentHash,
+	}, version)
+}
+
+// replaceContent archives the current content as a version, applies updates
+// as the new content by modifiedBy, bumps the version number and prunes old
+// versions, all while holding the file row lock. shared is the version whose
+// object the file now reuses, if any. newSize is charged to the owner unless
+// a version of the file already holds the same object.
+func (fv *FileVersionService) replaceContent(fileId uuid.UUID, modifiedBy uuid.UUID, newSize int64, updates map[string]interface{}, shared *schema.FileVersion) (*schema.File, error) {
+	var pruned []schema.FileVersion
+
+	errReplace := db.DB.Transaction(func(tx *gorm.DB) error {
+		var current schema.File
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", fileId).First(&current).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrFileNotFound
+			}
+			return err
+		}
+
+		// The archived content was uploaded by whoever last replaced it, or the owner
+		archivedBy := current.UploadedById
+		if current.LastModifiedById != nil {
+			archivedBy = *current.LastModifiedById
+		}
+
+		archived := &schema.FileVersion{
+			FileId:        current.Id,
+			VersionNumber: current.Version,
+			FileSize:      current.FileSize,
+			FileType:      current.FileType,
+			ContentHash:   current.ContentHash,
+			StorageBucket: current.StorageBucket,
+			StorageKey:    current.StorageKey,
+			UploadedById:  archivedBy,
+		}
+		if err := tx.Create(archived).Error; err != nil {
+			return err
+		}
+
+		// Restoring points the file at a blob a version already holds
+		if shared != nil {
+			if err := acquireBlobReference(tx, shared.StorageBucket, shared.StorageKey); err != nil {
+				return err
+			}
+		}
+
+		updates["version"] = current.Version + 1
+		updates["last_modified_by_id"] = modifiedBy
+		if err := tx.Model(&schema.File{}).Where("id = ?", current.Id).Updates(updates).Error; err != nil {
+			return err
+		}
+		fileType, _ := updates["file_type"].(string)
+		contentHash, _ := updates["content_hash"].(string)
+		if err := queueContentIndex(tx, current.Id, current.FileName, fileType, contentHash); err != nil {
+			return err
+		}
+		if err := queueThumbnails(tx, contentHash, fileType); err != nil {
+			return err
+		}
+
+		// Versions count toward the owner's quota, so the old content stays
+		// charged. Each object is charged once per file however many versions
+		// point at it, restores and re-uploads of old content add nothing.
+		newBucket, _ := updates["storage_bucket"].(string)
+		newKey, _ := updates["storage_key"].(string)
+		var held int64
+		if err := tx.Model(&schema.FileVersion{}).
+			Where("file_id = ? AND storage_bucket = ? AND storage_key = ?", current.Id, newBucket, newKey).
+			Count(&held).Error; err != nil {
+			return err
+		}
+		if held == 0 {
+			if err := adjustStorageUsed(tx, current.UploadedById.String(), newSize); err != nil {
+				return err
+			}
+		}
+
+		var err error
+		pruned, err = fv.pruneVersions(tx, &current, newBucket, newKey)
+		return err
+	})
+	if errReplace != nil {
+		logger.Error("Failed to replace the content of file %s: %s", fileId, errReplace)
+		return nil, errReplace
+	}
+
+	for _, version := range pruned {
+		fv.fileStorageService.CollectObject(version.StorageBucket, version.StorageKey)
+	}
+
+	var file *schema.File
+	if err := db.DB.Where("id = ?", fileId).First(&file).Error; err != nil {
+		return nil, err
+	}
+	if err := attachMetadata(modifiedBy.String(), file); err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+// pruneVersions deletes the versions beyond the retention limit and releases
+// the quota of objects the file no longer points at, bucket and key being
+// its current content. Their objects are removed by the caller after commit.
+func (fv *FileVersionService) pruneVersions(tx *gorm.DB, file *schema.File, bucket string, key string) ([]schema.FileVersion, error) {
+	if fv.retention == 0 {
+		return nil, nil
+	}
+
+	var pruned []schema.FileVersion
+	if err := tx.Where("file_id = ?", file.Id).Order("version_number DESC").Offset(fv.retention).Find(&pruned).Error; err != nil {
+		return nil, err
+	}
+	if len(pruned) == 0 {
+		return nil, nil
+	}
+
+	var kept []schema.FileVersion
+	if err := tx.Select("storage_bucket", "storage_key").Where("file_id = ?", file.Id).
+		Order("version_number DESC").Limit(fv.retention).Find(&kept).Error; err != nil {
+		return nil, err
+	}
+	charged := map[string]bool{bucket + "/" + key: true}
+	for _, version := range kept {
+		charged[version.StorageBucket+"/"+version.StorageKey] = true
+	}
+
+	var freed int64
+	for _, version := range pruned {
+		object := version.StorageBucket + "/" + version.StorageKey
+		if !charged[object] {
+			freed += version.FileSize
+			charged[object] = true
+		}
+	}
+	if err := tx.Delete(&pruned).Error; err != nil {
+		return nil, err
+	}
+	for _, version := range pruned {
+		if err := releaseBlobReference(tx, version.StorageBucket, version.StorageKey); err != nil {
+			return nil, err
+		}
+	}
+	if err := adjustStorageUsed(tx, file.UploadedById.String(), -freed); err != nil {
+		return nil, err
+	}
+	return pruned, nil
+}
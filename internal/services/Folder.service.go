@@ -1,10 +1,45 @@
 package services
 
 import (
+	"errors"
+	"fmt"
 	"goCal/internal/db"
 	"goCal/internal/logger"
+	"goCal/internal/pagination"
 	"goCal/internal/schema"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrFolderNotFound     = errors.New("folder not found")
+	ErrFolderAccessDenied = errors.New("you do not have access to this folder")
+	ErrFolderNameTaken    = errors.New("a folder with this name already exists here")
+	ErrFolderCycle        = errors.New("a folder cannot be moved into itself or one of its subfolders")
+)
+
+// ancestorsQuery walks from a folder up to its root, returned root first
+const ancestorsQuery = `
+WITH RECURSIVE ancestors AS (
+	SELECT id, folder_name, parent_id, 0 AS depth FROM folders WHERE id = ?
+	UNION ALL
+	SELECT f.id, f.folder_name, f.parent_id, a.depth + 1
+	FROM folders f JOIN ancestors a ON f.id = a.parent_id
+)
+SELECT id, folder_name, parent_id FROM ancestors ORDER BY depth DESC`
+
+// descendantsQuery returns a folder and every folder below it
+const descendantsQuery = `
+WITH RECURSIVE tree AS (
+	SELECT id FROM folders WHERE id = ?
+	UNION
+	SELECT f.id FROM folders f JOIN tree t ON f.parent_id = t.id
 )
+SELECT id FROM tree`
 
 type FolderService struct{}
 
@@ -12,21 +47,62 @@ func NewFolderService() *FolderService {
 	return &FolderService{}
 }
 
-func (fo *FolderService) GetFolders() ([]*schema.Folder, error) {
-	var folders []*schema.Folder
+// folderSorts are the orderings GET /api/folder accepts
+var folderSorts = pagination.Sorts{
+	Fields: map[string]pagination.Sort{
+		"created_at":  {Column: "created_at", Kind: pagination.Time},
+		"updated_at":  {Column: "updated_at", Kind: pagination.Time},
+		"folder_name": {Column: "folder_name", Kind: pagination.String},
+	},
+	Default:     "created_at",
+	DefaultDesc: true,
+}
 
-	result := db.DB.Find(&folders)
-	if result.Error != nil {
-		logger.Error("Failed to get all the folder %s ", result.Error)
-		return nil, result.Error
+func (fo *FolderService) GetFolders(query *schema.FolderListQuery) ([]*schema.Folder, *pagination.Page, error) {
+	tx := db.DB.Model(&schema.Folder{})
+
+	if query.Owner != "" {
+		ownerId, err := uuid.Parse(query.Owner)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: owner must be a user id", ErrInvalidListFilter)
+		}
+		tx = tx.Where("created_by_id = ?", ownerId)
+	}
+	switch query.Parent {
+	case "":
+	case "root":
+		tx = tx.Where("parent_id IS NULL")
+	default:
+		parentId, err := uuid.Parse(query.Parent)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: parent must be a folder id or \"root\"", ErrInvalidListFilter)
+		}
+		tx = tx.Where("parent_id = ?", parentId)
+	}
+	if query.Tag != "" {
+		tx = tx.Where("? = ANY(folder_tags)", query.Tag)
 	}
-	return folders, nil
+	tx = createdBetween(tx, "created_at", query.CreatedAfter, query.CreatedBefore)
+
+	folders, page, err := pagination.Find[*schema.Folder](tx, query.Params, folderSorts, "id")
+	if err != nil {
+		logger.Error("Failed to get all the folder %s ", err)
+		return nil, nil, err
+	}
+	return folders, page, nil
 }
 
 func (fo *FolderService) GetFolder(folderId string) (*schema.Folder, error) {
-	var folder *schema.Folder
+	id, err := uuid.Parse(folderId)
+	if err != nil {
+		return nil, ErrFolderNotFound
+	}
 
-	result := db.DB.Find(&folder).Where("id = ?", folderId)
+	var folder *schema.Folder
+	result := db.DB.Where("id = ?", id).First(&folder)
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return nil, ErrFolderNotFound
+	}
 	if result.Error != nil {
 		logger.Error("Failed to get the folder %s ", result.Error)
 		return nil, result.Error
@@ -34,34 +110,190 @@ func (fo *FolderService) GetFolder(folderId string) (*schema.Folder, error) {
 	return folder, nil
 }
 
+// GetUserFolder returns the folder only if it is owned by userId
 func (fo *FolderService) GetUserFolder(folderId string, userId string) (*schema.Folder, error) {
-	var folder *schema.Folder
+	folder, err := fo.GetFolder(folderId)
+	if err != nil {
+		return nil, err
+	}
+	if folder.CreatedById.String() != userId {
+		return nil, ErrFolderAccessDenied
+	}
+	return folder, nil
+}
 
-	result := db.DB.Find(&folder).Where("id = ? AND created_by = ?", folderId, userId)
-	if result.Error != nil {
-		logger.Error("Failed to get the folder %s ", result.Error)
-		return nil, result.Error
+// GetFolderContents returns an owned folder with its direct subfolders and files
+func (fo *FolderService) GetFolderContents(folderId string, userId string) (*schema.Folder, error) {
+	folder, err := fo.GetUserFolder(folderId, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := db.DB.Where("parent_id = ?", folder.ID).Order("folder_name").Find(&folder.Children).Error; err != nil {
+		return nil, err
+	}
+	if err := db.DB.Where("folder_id = ?", folder.ID).Order("file_name").Find(&folder.Files).Error; err != nil {
+		return nil, err
 	}
 	return folder, nil
 }
 
+// GetBreadcrumbs returns the path from the root down to the folder
+func (fo *FolderService) GetBreadcrumbs(folderId string, userId string) ([]schema.FolderPathEntry, error) {
+	folder, err := fo.GetUserFolder(folderId, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	var breadcrumbs []schema.FolderPathEntry
+	if err := db.DB.Raw(ancestorsQuery, folder.ID).Scan(&breadcrumbs).Error; err != nil {
+		logger.Error("Failed to get the breadcrumbs of folder %s: %s", folderId, err)
+		return nil, err
+	}
+	return breadcrumbs, nil
+}
+
+// ResolvePath finds the folder owned by userId at a path like /a/b
+func (fo *FolderService) ResolvePath(path string, userId string) (*schema.Folder, error) {
+	var segments []string
+	for _, segment := range strings.Split(path, "/") {
+		if segment != "" {
+			segments = append(segments, segment)
+		}
+	}
+	if len(segments) == 0 {
+		return nil, fmt.Errorf("path must name at least one folder")
+	}
+
+	var parentId *uuid.UUID
+	var folder *schema.Folder
+	for _, segment := range segments {
+		folder = nil
+		result := siblingsQuery(db.DB, userId, parentId).Where("folder_name = ?", segment).First(&folder)
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, ErrFolderNotFound
+		}
+		if result.Error != nil {
+			return nil, result.Error
+		}
+		parentId = &folder.ID
+	}
+	return folder, nil
+}
+
+// siblingsQuery scopes a folder query to userId's folders directly under parentId
+func siblingsQuery(tx *gorm.DB, userId string, parentId *uuid.UUID) *gorm.DB {
+	query := tx.Model(&schema.Folder{}).Where("created_by_id = ?", userId)
+	if parentId == nil {
+		return query.Where("parent_id IS NULL")
+	}
+	return query.Where("parent_id = ?", *parentId)
+}
+
+// ensureNameAvailable fails when userId already has a folder called name under parentId
+func ensureNameAvailable(tx *gorm.DB, userId string, parentId *uuid.UUID, name string, excludeId *uuid.UUID) error {
+	query := siblingsQuery(tx, userId, parentId).Where("folder_name = ?", name)
+	if excludeId != nil {
+		query = query.Where("id <> ?", *excludeId)
+	}
+
+	var count int64
+	if err := query.Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return ErrFolderNameTaken
+	}
+	return nil
+}
+
 func (fo *FolderService) CreateFolder(folder *schema.Folder, userId string) (*schema.Folder, error) {
-	var existingFolder *schema.Folder
+	ownerId, err := uuid.Parse(userId)
+	if err != nil {
+		return nil, err
+	}
+	folder.CreatedById = ownerId
 
-	result := db.DB.Find(&existingFolder).Where("folder_name = ? AND created_by = ?", folder.FolderName, userId)
-	if result.Error == nil {
-		logger.Error("Folder Already Exists %s ", result.Error)
-		return nil, result.Error
+	if folder.ParentId != nil {
+		if _, err := fo.GetUserFolder(folder.ParentId.String(), userId); err != nil {
+			return nil, err
+		}
 	}
 
-	resultFolderCreation := db.DB.Create(folder)
-	if resultFolderCreation.Error != nil {
-		logger.Error("Failed to create folder %s ", resultFolderCreation.Error)
-		return nil, result.Error
+	if err := ensureNameAvailable(db.DB, userId, folder.ParentId, folder.FolderName, nil); err != nil {
+		return nil, err
+	}
+
+	if err := db.DB.Create(folder).Error; err != nil {
+		logger.Error("Failed to create folder %s ", err)
+		return nil, err
 	}
 	return folder, nil
 }
 
+// MoveFolder re-parents a folder, or moves it to the root when parentId is nil
+func (fo *FolderService) MoveFolder(folderId string, userId string, parentId *uuid.UUID) (*schema.Folder, error) {
+	folder, err := fo.GetUserFolder(folderId, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	errMove := db.DB.Transaction(func(tx *gorm.DB) error {
+		// Lock both rows so two concurrent moves can't build a cycle between them
+		lockIds := []uuid.UUID{folder.ID}
+		if parentId != nil {
+			lockIds = append(lockIds, *parentId)
+		}
+		var locked []schema.Folder
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id IN ?", lockIds).Order("id").Find(&locked).Error; err != nil {
+			return err
+		}
+
+		if parentId != nil {
+			if *parentId == folder.ID {
+				return ErrFolderCycle
+			}
+
+			var parent *schema.Folder
+			result := tx.Where("id = ?", *parentId).First(&parent)
+			if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+				return ErrFolderNotFound
+			}
+			if result.Error != nil {
+				return result.Error
+			}
+			if parent.CreatedById.String() != userId {
+				return ErrFolderAccessDenied
+			}
+
+			var descendantIds []uuid.UUID
+			if err := tx.Raw(descendantsQuery, folder.ID).Scan(&descendantIds).Error; err != nil {
+				return err
+			}
+			for _, id := range descendantIds {
+				if id == *parentId {
+					return ErrFolderCycle
+				}
+			}
+		}
+
+		if err := ensureNameAvailable(tx, userId, parentId, folder.FolderName, &folder.ID); err != nil {
+			return err
+		}
+
+		return tx.Model(&schema.Folder{}).Where("id = ?", folder.ID).Update("parent_id", parentId).Error
+	})
+	if errMove != nil {
+		logger.Error("Failed to move folder %s: %s", folderId, errMove)
+		return nil, errMove
+	}
+
+	return fo.GetFolder(folderId)
+}
+
+// DeleteFolder moves the folder and everything below it to the trash. All of
+// them share one deleted_at so restoring the folder brings back exactly what
+// was trashed with it.
 func (fo *FolderService) DeleteFolder(folderId string, userId string) (message string, err error) {
 	folderFound, err := fo.GetUserFolder(folderId, userId)
 	if err != nil {
@@ -69,16 +301,28 @@ func (fo *FolderService) DeleteFolder(folderId string, userId string) (message s
 		return "Failed to get the folder ", err
 	}
 
-	if deleteError := db.DB.Delete(folderFound).Error; err != nil {
+	deletedAt := time.Now().UTC().Truncate(time.Microsecond)
+	deleteError := db.DB.Transaction(func(tx *gorm.DB) error {
+		var folderIds []uuid.UUID
+		if err := tx.Raw(descendantsQuery, folderFound.ID).Scan(&folderIds).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Model(&schema.File{}).Where("folder_id IN ?", folderIds).Update("deleted_at", deletedAt).Error; err != nil {
+			return err
+		}
+		return tx.Model(&schema.Folder{}).Where("id IN ?", folderIds).Update("deleted_at", deletedAt).Error
+	})
+	if deleteError != nil {
 		logger.Error("Failed to delete the folder %s ", deleteError)
 		return "Failed to delete folder", deleteError
 	}
 
-	return "Folder Deleted Successfully", nil
+	return "Folder Moved To Trash", nil
 }
 
 func (fo *FolderService) UpdateFolder(updatedData *schema.UpdateFolderRequest, folderId string, userId string) (folder *schema.Folder, err error) {
-	_, err = fo.GetUserFolder(folderId, userId)
+	folderFound, err := fo.GetUserFolder(folderId, userId)
 	if err != nil {
 		logger.Error("Failed to get the folder %s", err)
 		return nil, err
@@ -87,6 +331,9 @@ func (fo *FolderService) UpdateFolder(updatedData *schema.UpdateFolderRequest, f
 	updateFields := make(map[string]interface{})
 
 	if updatedData.FolderName != nil {
+		if err := ensureNameAvailable(db.DB, userId, folderFound.ParentId, *updatedData.FolderName, &folderFound.ID); err != nil {
+			return nil, err
+		}
 		updateFields["folder_name"] = *updatedData.FolderName
 	}
 
@@ -99,16 +346,10 @@ func (fo *FolderService) UpdateFolder(updatedData *schema.UpdateFolderRequest, f
 	}
 
 	if len(updateFields) > 0 {
-		if result := db.DB.Model(&schema.Folder{}).Where("id = ? AND created_by = ?", folderId, userId).Updates(updateFields); result.Error != nil {
+		if result := db.DB.Model(&schema.Folder{}).Where("id = ? AND created_by_id = ?", folderFound.ID, userId).Updates(updateFields); result.Error != nil {
 			return nil, result.Error
 		}
 	}
 
-	getUpdatedFolder, errUpdatedFolder := fo.GetUserFolder(folderId, userId)
-	if errUpdatedFolder != nil {
-		logger.Error("Failed to get the updated folder %s", err)
-		return nil, errUpdatedFolder
-	}
-
-	return getUpdatedFolder, nil
+	return fo.GetUserFolder(folderId, userId)
 }
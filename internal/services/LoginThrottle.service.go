@@ -0,0 +1,211 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"goCal/internal/db"
+	"goCal/internal/logger"
+	"goCal/internal/schema"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var ErrTooManyAttempts = errors.New("too many failed attempts, try again later")
+
+const (
+	defaultLoginMaxAttempts   = 5
+	defaultLoginIpMaxAttempts = 20
+	defaultLoginLockoutBase   = time.Minute
+	defaultLoginLockoutMax    = time.Hour
+	// Failures older than this no longer count toward a lockout
+	loginFailureWindow = 15 * time.Minute
+)
+
+// LockoutError says how long the caller has to wait
+type LockoutError struct {
+	RetryAfter time.Duration
+}
+
+func (e *LockoutError) Error() string {
+	return fmt.Sprintf("%s in %s", ErrTooManyAttempts.Error(), e.RetryAfter.Round(time.Second))
+}
+
+func (e *LockoutError) Unwrap() error {
+	return ErrTooManyAttempts
+}
+
+// LoginThrottleService tracks failed sign-in attempts per account and per
+// client address. Once a key passes its limit every further failure locks
+// it for twice as long as the last one, up to the maximum.
+type LoginThrottleService struct {
+	emailService  *EmailService
+	maxAttempts   int
+	ipMaxAttempts int
+	lockoutBase   time.Duration
+	lockoutMax    time.Duration
+}
+
+// NewLoginThrottleService reads LOGIN_MAX_ATTEMPTS, LOGIN_IP_MAX_ATTEMPTS,
+// LOGIN_LOCKOUT_BASE and LOGIN_LOCKOUT_MAX
+func NewLoginThrottleService() *LoginThrottleService {
+	emailService, err := NewEmailServices()
+	if err != nil {
+		logger.Error("Failed to initialize email service: %v", err)
+	}
+
+	throttle := &LoginThrottleService{
+		emailService:  emailService,
+		maxAttempts:   defaultLoginMaxAttempts,
+		ipMaxAttempts: defaultLoginIpMaxAttempts,
+		lockoutBase:   defaultLoginLockoutBase,
+		lockoutMax:    defaultLoginLockoutMax,
+	}
+	if attempts, err := strconv.Atoi(os.Getenv("LOGIN_MAX_ATTEMPTS")); err == nil && attempts > 0 {
+		throttle.maxAttempts = attempts
+	}
+	if attempts, err := strconv.Atoi(os.Getenv("LOGIN_IP_MAX_ATTEMPTS")); err == nil && attempts > 0 {
+		throttle.ipMaxAttempts = attempts
+	}
+	if base, err := time.ParseDuration(os.Getenv("LOGIN_LOCKOUT_BASE")); err == nil && base > 0 {
+		throttle.lockoutBase = base
+	}
+	if max, err := time.ParseDuration(os.Getenv("LOGIN_LOCKOUT_MAX")); err == nil && max > 0 {
+		throttle.lockoutMax = max
+	}
+	return throttle
+}
+
+func accountThrottleKey(userId uuid.UUID) string {
+	return "account:" + userId.String()
+}
+
+func ipThrottleKey(ipAddress string) string {
+	return "ip:" + ipAddress
+}
+
+func shareLinkThrottleKey(linkId uuid.UUID) string {
+	return "share:" + linkId.String()
+}
+
+// CheckAddress fails with a *LockoutError while ipAddress is locked out
+func (lt *LoginThrottleService) CheckAddress(ipAddress string) error {
+	return checkThrottle(ipThrottleKey(ipAddress))
+}
+
+// CheckAccount fails with a *LockoutError while the account is locked out
+func (lt *LoginThrottleService) CheckAccount(user *schema.User) error {
+	return checkThrottle(accountThrottleKey(user.ID))
+}
+
+// RecordAddressFailure counts a failed attempt that could not be tied to an account
+func (lt *LoginThrottleService) RecordAddressFailure(ipAddress string) {
+	if _, err := lt.recordFailure(ipThrottleKey(ipAddress), lt.ipMaxAttempts); err != nil {
+		logger.Error("Failed to record failed attempt from %s: %v", ipAddress, err)
+	}
+}
+
+// RecordFailure counts a failed attempt against the account and the address.
+// The user is emailed when the account becomes locked.
+func (lt *LoginThrottleService) RecordFailure(user *schema.User, ipAddress string) {
+	lt.RecordAddressFailure(ipAddress)
+
+	lockedUntil, err := lt.recordFailure(accountThrottleKey(user.ID), lt.maxAttempts)
+	if err != nil {
+		logger.Error("Failed to record failed attempt for %s: %v", user.Email, err)
+		return
+	}
+	if lockedUntil == nil {
+		return
+	}
+
+	logger.Warn(fmt.Sprintf("Account %s locked until %s after failed sign-in attempts", user.Email, lockedUntil.Format(time.RFC3339)))
+	if lt.emailService != nil {
+		if _, err := lt.emailService.SendAccountLockedEmail(user, ipAddress, *lockedUntil); err != nil {
+			logger.Error("Failed to queue account locked email to %s: %v", user.Email, err)
+		}
+	}
+}
+
+// CheckShareLink fails with a *LockoutError while the link's password is locked out
+func (lt *LoginThrottleService) CheckShareLink(linkId uuid.UUID) error {
+	return checkThrottle(shareLinkThrottleKey(linkId))
+}
+
+// RecordShareLinkFailure counts a wrong password for a share link. Links are
+// locked per link rather than per address, so spreading guesses over many
+// addresses doesn't help.
+func (lt *LoginThrottleService) RecordShareLinkFailure(linkId uuid.UUID) {
+	if _, err := lt.recordFailure(shareLinkThrottleKey(linkId), lt.maxAttempts); err != nil {
+		logger.Error("Failed to record wrong password for share link %s: %v", linkId, err)
+	}
+}
+
+// RecordSuccess clears the account's failures. The address keeps its count so
+// signing in to one account can't reset guessing against others.
+func (lt *LoginThrottleService) RecordSuccess(user *schema.User) {
+	if err := db.DB.Where("key = ?", accountThrottleKey(user.ID)).Delete(&schema.AuthThrottle{}).Error; err != nil {
+		logger.Error("Failed to clear failed attempts for %s: %v", user.Email, err)
+	}
+}
+
+func checkThrottle(key string) error {
+	var throttle schema.AuthThrottle
+	result := db.DB.Where("key = ? AND locked_until > ?", key, time.Now()).Limit(1).Find(&throttle)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil
+	}
+	return &LockoutError{RetryAfter: time.Until(*throttle.LockedUntil)}
+}
+
+// recordFailure adds a failure to key and locks it once limit is reached.
+// It returns the lock expiry only when this failure started a new lockout.
+func (lt *LoginThrottleService) recordFailure(key string, limit int) (*time.Time, error) {
+	now := time.Now()
+	var lockedUntil *time.Time
+
+	errRecord := db.DB.Transaction(func(tx *gorm.DB) error {
+		throttle := schema.AuthThrottle{Key: key, Failures: 1, LastFailureAt: now}
+		if err := tx.Clauses(clause.OnConflict{
+			Columns: []clause.Column{{Name: "key"}},
+			DoUpdates: clause.Assignments(map[string]interface{}{
+				"failures": gorm.Expr("CASE WHEN auth_throttles.last_failure_at < ? AND (auth_throttles.locked_until IS NULL OR auth_throttles.locked_until < ?) THEN 1 ELSE auth_throttles.failures + 1 END",
+					now.Add(-loginFailureWindow), now),
+				"last_failure_at": now,
+			}),
+		}, clause.Returning{}).Create(&throttle).Error; err != nil {
+			return err
+		}
+
+		if throttle.Failures < limit {
+			return nil
+		}
+
+		// Each failure past the limit doubles the lockout
+		lockout := lt.lockoutBase
+		for i := limit; i < throttle.Failures && lockout < lt.lockoutMax; i++ {
+			lockout *= 2
+		}
+		if lockout > lt.lockoutMax {
+			lockout = lt.lockoutMax
+		}
+
+		until := now.Add(lockout)
+		if err := tx.Model(&schema.AuthThrottle{}).Where("key = ?", key).Update("locked_until", until).Error; err != nil {
+			return err
+		}
+		lockedUntil = &until
+		return nil
+	})
+	if errRecord != nil {
+		return nil, errRecord
+	}
+	return lockedUntil, nil
+}
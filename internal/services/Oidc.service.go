@@ -0,0 +1,522 @@
+package services
+
+import (
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"goCal/internal/db"
+	"goCal/internal/emails"
+	"goCal/internal/logger"
+	"goCal/internal/schema"
+	"goCal/internal/utils"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrOidcNotConfigured   = errors.New("OIDC login is not configured")
+	ErrOidcStateInvalid    = errors.New("OIDC login state is invalid or has expired, please start again")
+	ErrOidcTokenInvalid    = errors.New("identity provider returned an invalid ID token")
+	ErrOidcEmailUnverified = errors.New("identity provider did not return a verified email")
+	ErrOidcAccountDeleted  = errors.New("the account for this email has been deleted")
+	// Anyone can sign up with an email they don't own, so such an account is never linked
+	ErrOidcAccountUnverified = errors.New("an unverified account already uses this email")
+)
+
+const (
+	oidcStateTTL        = 10 * time.Minute
+	oidcHTTPTimeout     = 10 * time.Second
+	oidcJwksMinInterval = time.Minute
+)
+
+var usernameInvalidChars = regexp.MustCompile(`[^a-zA-Z0-9_.-]+`)
+
+// oidcDiscovery is the part of /.well-known/openid-configuration we use
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JwksUri               string `json:"jwks_uri"`
+}
+
+type oidcIdTokenClaims struct {
+	jwt.RegisteredClaims
+	Nonce             string `json:"nonce"`
+	AuthorizedParty   string `json:"azp"`
+	Email             string `json:"email"`
+	EmailVerified     bool   `json:"email_verified"`
+	PreferredUsername string `json:"preferred_username"`
+	Name              string `json:"name"`
+	Picture           string `json:"picture"`
+	Locale            string `json:"locale"`
+}
+
+// oidcStateStore keeps the state of logins between the redirect to the
+// provider and the callback
+type oidcStateStore interface {
+	Save(loginState *schema.OidcLoginState) error
+	// Take removes and returns the unexpired state with this hash, nil when there is none
+	Take(stateHash string) (*schema.OidcLoginState, error)
+}
+
+// dbOidcStateStore keeps login states in oidc_login_states so any instance can finish a login
+type dbOidcStateStore struct{}
+
+func (dbOidcStateStore) Save(loginState *schema.OidcLoginState) error {
+	// Expired states of abandoned logins are cleared as new ones come in
+	if err := db.DB.Where("expires_at < ?", time.Now()).Delete(&schema.OidcLoginState{}).Error; err != nil {
+		logger.Warn("Failed to clear expired OIDC states: %v", err)
+	}
+	return db.DB.Create(loginState).Error
+}
+
+func (dbOidcStateStore) Take(stateHash string) (*schema.OidcLoginState, error) {
+	// Deleting the state makes it single use
+	var loginStates []schema.OidcLoginState
+	result := db.DB.Clauses(clause.Returning{}).
+		Where("state = ? AND expires_at > ?", stateHash, time.Now()).
+		Delete(&loginStates)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 || len(loginStates) == 0 {
+		return nil, nil
+	}
+	return &loginStates[0], nil
+}
+
+type OidcService struct {
+	issuerUrl    string
+	clientId     string
+	clientSecret string
+	redirectUrl  string
+	scopes       string
+	httpClient   *http.Client
+	states       oidcStateStore
+
+	mu            sync.Mutex
+	discovery     *oidcDiscovery
+	keys          map[string]*rsa.PublicKey
+	keysFetchedAt time.Time
+}
+
+// NewOidcService reads OIDC_ISSUER_URL, OIDC_CLIENT_ID, OIDC_CLIENT_SECRET,
+// OIDC_REDIRECT_URL and OIDC_SCOPES. Login is disabled without an issuer.
+func NewOidcService() *OidcService {
+	scopes := os.Getenv("OIDC_SCOPES")
+	if scopes == "" {
+		scopes = "openid email profile"
+	}
+	return &OidcService{
+		issuerUrl:    strings.TrimRight(os.Getenv("OIDC_ISSUER_URL"), "/"),
+		clientId:     os.Getenv("OIDC_CLIENT_ID"),
+		clientSecret: os.Getenv("OIDC_CLIENT_SECRET"),
+		redirectUrl:  os.Getenv("OIDC_REDIRECT_URL"),
+		scopes:       scopes,
+		httpClient:   &http.Client{Timeout: oidcHTTPTimeout},
+		states:       dbOidcStateStore{},
+	}
+}
+
+func (o *OidcService) Enabled() bool {
+	return o.issuerUrl != "" && o.clientId != "" && o.redirectUrl != ""
+}
+
+// AuthorizationURL starts a login: it stores a fresh state, nonce and PKCE
+// verifier and returns where to send the browser
+func (o *OidcService) AuthorizationURL() (string, error) {
+	if !o.Enabled() {
+		return "", ErrOidcNotConfigured
+	}
+	discovery, err := o.getDiscovery()
+	if err != nil {
+		return "", err
+	}
+
+	state, err := utils.GenerateToken(32)
+	if err != nil {
+		return "", err
+	}
+	nonce, err := utils.GenerateToken(32)
+	if err != nil {
+		return "", err
+	}
+	verifier, err := utils.GenerateToken(48)
+	if err != nil {
+		return "", err
+	}
+
+	if err := o.states.Save(&schema.OidcLoginState{
+		State:        utils.HashToken(state),
+		CodeVerifier: verifier,
+		Nonce:        nonce,
+		ExpiresAt:    time.Now().Add(oidcStateTTL),
+	}); err != nil {
+		return "", err
+	}
+
+	challenge := sha256.Sum256([]byte(verifier))
+	query := url.Values{}
+	query.Set("response_type", "code")
+	query.Set("client_id", o.clientId)
+	query.Set("redirect_uri", o.redirectUrl)
+	query.Set("scope", o.scopes)
+	query.Set("state", state)
+	query.Set("nonce", nonce)
+	query.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	query.Set("code_challenge_method", "S256")
+
+	separator := "?"
+	if strings.Contains(discovery.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return discovery.AuthorizationEndpoint + separator + query.Encode(), nil
+}
+
+// HandleCallback finishes a login: it redeems the code, validates the ID
+// token and returns the linked or newly provisioned user
+func (o *OidcService) HandleCallback(state string, code string) (*schema.User, error) {
+	if !o.Enabled() {
+		return nil, ErrOidcNotConfigured
+	}
+
+	claims, err := o.redeem(state, code)
+	if err != nil {
+		return nil, err
+	}
+	return o.linkUser(claims)
+}
+
+// redeem uses up the login state, exchanges the code with its PKCE verifier
+// and returns the verified claims of the ID token
+func (o *OidcService) redeem(state string, code string) (*oidcIdTokenClaims, error) {
+	loginState, err := o.states.Take(utils.HashToken(state))
+	if err != nil {
+		return nil, err
+	}
+	if loginState == nil {
+		return nil, ErrOidcStateInvalid
+	}
+
+	rawIdToken, err := o.exchangeCode(code, loginState.CodeVerifier)
+	if err != nil {
+		return nil, err
+	}
+	return o.verifyIdToken(rawIdToken, loginState.Nonce)
+}
+
+func (o *OidcService) getDiscovery() (*oidcDiscovery, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.discovery != nil {
+		return o.discovery, nil
+	}
+
+	var discovery oidcDiscovery
+	if err := o.getJSON(o.issuerUrl+"/.well-known/openid-configuration", &discovery); err != nil {
+		return nil, fmt.Errorf("failed to load OIDC discovery document: %w", err)
+	}
+	if strings.TrimRight(discovery.Issuer, "/") != o.issuerUrl {
+		return nil, fmt.Errorf("OIDC discovery issuer %q does not match %q", discovery.Issuer, o.issuerUrl)
+	}
+	if discovery.AuthorizationEndpoint == "" || discovery.TokenEndpoint == "" || discovery.JwksUri == "" {
+		return nil, errors.New("OIDC discovery document is missing endpoints")
+	}
+	o.discovery = &discovery
+	return o.discovery, nil
+}
+
+func (o *OidcService) getJSON(endpoint string, target interface{}) error {
+	response, err := o.httpClient.Get(endpoint)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s returned %s", endpoint, response.Status)
+	}
+	return json.NewDecoder(response.Body).Decode(target)
+}
+
+// exchangeCode redeems the authorization code at the token endpoint and
+// returns the ID token
+func (o *OidcService) exchangeCode(code string, verifier string) (string, error) {
+	discovery, err := o.getDiscovery()
+	if err != nil {
+		return "", err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", o.redirectUrl)
+	form.Set("code_verifier", verifier)
+	form.Set("client_id", o.clientId)
+
+	request, err := http.NewRequest(http.MethodPost, discovery.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Accept", "application/json")
+	if o.clientSecret != "" {
+		request.SetBasicAuth(url.QueryEscape(o.clientId), url.QueryEscape(o.clientSecret))
+	}
+
+	response, err := o.httpClient.Do(request)
+	if err != nil {
+		return "", fmt.Errorf("failed to reach the OIDC token endpoint: %w", err)
+	}
+	defer response.Body.Close()
+
+	var tokenResponse struct {
+		IdToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(response.Body).Decode(&tokenResponse); err != nil {
+		return "", fmt.Errorf("failed to read the OIDC token response: %w", err)
+	}
+	if response.StatusCode != http.StatusOK || tokenResponse.Error != "" {
+		return "", fmt.Errorf("OIDC token exchange failed: %s %s", tokenResponse.Error, tokenResponse.ErrorDescription)
+	}
+	if tokenResponse.IdToken == "" {
+		return "", ErrOidcTokenInvalid
+	}
+	return tokenResponse.IdToken, nil
+}
+
+// verifyIdToken checks the RS256 signature against the provider's JWKS along
+// with the issuer, audience, expiry and nonce
+func (o *OidcService) verifyIdToken(rawIdToken string, nonce string) (*oidcIdTokenClaims, error) {
+	discovery, err := o.getDiscovery()
+	if err != nil {
+		return nil, err
+	}
+
+	claims := &oidcIdTokenClaims{}
+	token, err := jwt.ParseWithClaims(rawIdToken, claims, func(t *jwt.Token) (interface{}, error) {
+		if t.Method.Alg() != jwt.SigningMethodRS256.Alg() {
+			return nil, fmt.Errorf("unexpected signing method %v", t.Header["alg"])
+		}
+		kid, _ := t.Header["kid"].(string)
+		return o.getKey(discovery.JwksUri, kid)
+	})
+	if err != nil || !token.Valid {
+		logger.Warn("Rejected OIDC ID token: %v", err)
+		return nil, ErrOidcTokenInvalid
+	}
+
+	if claims.Issuer != discovery.Issuer || !claims.VerifyAudience(o.clientId, true) || claims.Subject == "" {
+		return nil, ErrOidcTokenInvalid
+	}
+	if claims.ExpiresAt == nil {
+		return nil, ErrOidcTokenInvalid
+	}
+	if len(claims.Audience) > 1 && claims.AuthorizedParty != o.clientId {
+		return nil, ErrOidcTokenInvalid
+	}
+	if claims.Nonce != nonce {
+		return nil, ErrOidcTokenInvalid
+	}
+	return claims, nil
+}
+
+// getKey returns the signing key with kid, refetching the JWKS when the
+// provider may have rotated keys
+func (o *OidcService) getKey(jwksUri string, kid string) (*rsa.PublicKey, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if key, ok := o.lookupKey(kid); ok {
+		return key, nil
+	}
+	if time.Since(o.keysFetchedAt) < oidcJwksMinInterval && o.keys != nil {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+
+	var jwks struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := o.getJSON(jwksUri, &jwks); err != nil {
+		return nil, fmt.Errorf("failed to load OIDC signing keys: %w", err)
+	}
+
+	keys := make(map[string]*rsa.PublicKey)
+	for _, jwk := range jwks.Keys {
+		if jwk.Kty != "RSA" || (jwk.Use != "" && jwk.Use != "sig") {
+			continue
+		}
+		n, errN := base64.RawURLEncoding.DecodeString(jwk.N)
+		e, errE := base64.RawURLEncoding.DecodeString(jwk.E)
+		if errN != nil || errE != nil {
+			continue
+		}
+		keys[jwk.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+	o.keys = keys
+	o.keysFetchedAt = time.Now()
+
+	if key, ok := o.lookupKey(kid); ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+// lookupKey finds kid, or the only key when the token names none
+func (o *OidcService) lookupKey(kid string) (*rsa.PublicKey, bool) {
+	if kid == "" && len(o.keys) == 1 {
+		for _, key := range o.keys {
+			return key, true
+		}
+	}
+	key, ok := o.keys[kid]
+	return key, ok
+}
+
+// linkUser finds the user behind the identity. A new identity is linked to
+// the verified account with the same email, or a new, verified account is
+// provisioned. An unverified account with the email is left alone, whoever
+// created it may know its password.
+func (o *OidcService) linkUser(claims *oidcIdTokenClaims) (*schema.User, error) {
+	var user schema.User
+	errLink := db.DB.Transaction(func(tx *gorm.DB) error {
+		var identity schema.UserIdentity
+		result := tx.Where("issuer = ? AND subject = ?", claims.Issuer, claims.Subject).Limit(1).Find(&identity)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected > 0 {
+			if err := tx.Where("id = ?", identity.UserId).First(&user).Error; err != nil {
+				if errors.Is(err, gorm.ErrRecordNotFound) {
+					return ErrOidcAccountDeleted
+				}
+				return err
+			}
+			return tx.Model(&identity).Updates(map[string]interface{}{
+				"last_login_at": time.Now(),
+				"email":         claims.Email,
+			}).Error
+		}
+
+		if claims.Email == "" || !claims.EmailVerified {
+			return ErrOidcEmailUnverified
+		}
+		email := strings.ToLower(claims.Email)
+
+		result = tx.Unscoped().Where("LOWER(email) = ?", email).Limit(1).Find(&user)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected > 0 && user.DeletedAt.Valid {
+			return ErrOidcAccountDeleted
+		}
+		if result.RowsAffected > 0 && !user.IsVerified {
+			return ErrOidcAccountUnverified
+		}
+		if result.RowsAffected == 0 {
+			if err := provisionUser(tx, &user, email, claims); err != nil {
+				return err
+			}
+			if err := tx.Model(&user).Updates(map[string]interface{}{
+				"is_verified": true,
+				"verify_code": "",
+			}).Error; err != nil {
+				return err
+			}
+			user.IsVerified = true
+		}
+
+		return tx.Create(&schema.UserIdentity{
+			UserId:      user.ID,
+			Issuer:      claims.Issuer,
+			Subject:     claims.Subject,
+			Email:       email,
+			LastLoginAt: time.Now(),
+		}).Error
+	})
+	if errLink != nil {
+		logger.Error("Failed to link OIDC identity %s: %v", claims.Subject, errLink)
+		return nil, errLink
+	}
+	return &user, nil
+}
+
+// provisionUser creates an account for a first-time OIDC login. It gets an
+// unusable random password, the user can set one with "forgot password".
+func provisionUser(tx *gorm.DB, user *schema.User, email string, claims *oidcIdTokenClaims) error {
+	base := claims.PreferredUsername
+	if base == "" {
+		base, _, _ = strings.Cut(email, "@")
+	}
+	base = usernameInvalidChars.ReplaceAllString(base, "")
+	if len(base) < 3 {
+		base = "user"
+	}
+	if len(base) > 40 {
+		base = base[:40]
+	}
+
+	username := base
+	for attempt := 0; ; attempt++ {
+		var taken int64
+		if err := tx.Unscoped().Model(&schema.User{}).Where("username = ?", username).Count(&taken).Error; err != nil {
+			return err
+		}
+		if taken == 0 {
+			break
+		}
+		if attempt >= 5 {
+			return fmt.Errorf("could not find a free username for %s", email)
+		}
+		suffix, err := utils.GenerateToken(3)
+		if err != nil {
+			return err
+		}
+		username = base + "-" + strings.ToLower(usernameInvalidChars.ReplaceAllString(suffix, ""))
+	}
+
+	secret, err := utils.GenerateToken(32)
+	if err != nil {
+		return err
+	}
+	password, err := utils.HashPassword(secret)
+	if err != nil {
+		return err
+	}
+
+	*user = schema.User{
+		Username:   username,
+		Email:      email,
+		Password:   password,
+		ProfileUrl: claims.Picture,
+		Locale:     emails.NormalizeLocale(claims.Locale),
+	}
+	if err := tx.Create(user).Error; err != nil {
+		return err
+	}
+	logger.Info("Provisioned user %s from OIDC login", user.Email)
+	return nil
+}
@@ -0,0 +1,445 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"database/sql/driver"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"goCal/internal/logger"
+	"goCal/internal/schema"
+	"io"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
+)
+
+func TestMain(m *testing.M) {
+	discard := slog.New(slog.NewTextHandler(io.Discard, nil))
+	logger.InfoLogger, logger.WarningLogger, logger.ErrorLogger = discard, discard, discard
+	os.Exit(m.Run())
+}
+
+// memoryOidcStateStore stands in for the oidc_login_states table
+type memoryOidcStateStore struct {
+	mu     sync.Mutex
+	states map[string]schema.OidcLoginState
+}
+
+func (m *memoryOidcStateStore) Save(loginState *schema.OidcLoginState) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.states[loginState.State] = *loginState
+	return nil
+}
+
+func (m *memoryOidcStateStore) Take(stateHash string) (*schema.OidcLoginState, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	loginState, ok := m.states[stateHash]
+	if !ok || time.Now().After(loginState.ExpiresAt) {
+		return nil, nil
+	}
+	delete(m.states, stateHash)
+	return &loginState, nil
+}
+
+// mockIssuer is an identity provider serving discovery, JWKS and a token
+// endpoint that enforces PKCE. Codes are handed out by authorize.
+type mockIssuer struct {
+	t      *testing.T
+	server *httptest.Server
+	key    *rsa.PrivateKey
+	kid    string
+
+	mu          sync.Mutex
+	codes       map[string]mockGrant
+	jwksFetches int
+}
+
+// mockGrant is what the provider remembers about an authorization code
+type mockGrant struct {
+	challenge string
+	claims    jwt.MapClaims
+	signer    *rsa.PrivateKey
+}
+
+const mockClientId = "gocal-test"
+
+func newMockIssuer(t *testing.T) *mockIssuer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuer := &mockIssuer{t: t, key: key, kid: "key-1", codes: make(map[string]mockGrant)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 issuer.server.URL,
+			"authorization_endpoint": issuer.server.URL + "/authorize",
+			"token_endpoint":         issuer.server.URL + "/token",
+			"jwks_uri":               issuer.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		issuer.mu.Lock()
+		issuer.jwksFetches++
+		current, kid := issuer.key, issuer.kid
+		issuer.mu.Unlock()
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": kid,
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(current.PublicKey.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(current.PublicKey.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", issuer.token)
+	issuer.server = httptest.NewServer(mux)
+	t.Cleanup(issuer.server.Close)
+	return issuer
+}
+
+func (m *mockIssuer) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	tokenError := func(code string) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": code})
+	}
+	if r.PostForm.Get("grant_type") != "authorization_code" || r.PostForm.Get("client_id") != mockClientId {
+		tokenError("invalid_request")
+		return
+	}
+
+	m.mu.Lock()
+	grant, ok := m.codes[r.PostForm.Get("code")]
+	delete(m.codes, r.PostForm.Get("code"))
+	m.mu.Unlock()
+	if !ok {
+		tokenError("invalid_grant")
+		return
+	}
+	verifier := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if base64.RawURLEncoding.EncodeToString(verifier[:]) != grant.challenge {
+		tokenError("invalid_grant")
+		return
+	}
+
+	m.mu.Lock()
+	kid := m.kid
+	m.mu.Unlock()
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, grant.claims)
+	token.Header["kid"] = kid
+	signed, err := token.SignedString(grant.signer)
+	if err != nil {
+		m.t.Fatal(err)
+	}
+	json.NewEncoder(w).Encode(map[string]string{"id_token": signed, "token_type": "Bearer"})
+}
+
+// authorize plays the browser visiting the authorization URL: it checks the
+// request and returns the state and a code for an ID token with claims.
+// edit may change the claims and signer before the code is issued.
+func (m *mockIssuer) authorize(authorizationURL string, edit func(claims jwt.MapClaims, grant *mockGrant)) (string, string) {
+	parsed, err := url.Parse(authorizationURL)
+	if err != nil {
+		m.t.Fatal(err)
+	}
+	query := parsed.Query()
+	if parsed.Path != "/authorize" || query.Get("client_id") != mockClientId || query.Get("response_type") != "code" {
+		m.t.Fatalf("unexpected authorization URL %s", authorizationURL)
+	}
+	if query.Get("code_challenge_method") != "S256" || query.Get("code_challenge") == "" {
+		m.t.Fatalf("authorization URL without a PKCE challenge: %s", authorizationURL)
+	}
+	if query.Get("state") == "" || query.Get("nonce") == "" {
+		m.t.Fatalf("authorization URL without state or nonce: %s", authorizationURL)
+	}
+
+	grant := mockGrant{
+		challenge: query.Get("code_challenge"),
+		signer:    m.signingKey(),
+		claims: jwt.MapClaims{
+			"iss":            m.server.URL,
+			"sub":            "user-123",
+			"aud":            mockClientId,
+			"exp":            time.Now().Add(time.Hour).Unix(),
+			"iat":            time.Now().Unix(),
+			"nonce":          query.Get("nonce"),
+			"email":          "ada@example.com",
+			"email_verified": true,
+		},
+	}
+	if edit != nil {
+		edit(grant.claims, &grant)
+	}
+
+	code := "code-" + query.Get("state")[:8]
+	m.mu.Lock()
+	m.codes[code] = grant
+	m.mu.Unlock()
+	return query.Get("state"), code
+}
+
+func (m *mockIssuer) signingKey() *rsa.PrivateKey {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.key
+}
+
+func newTestOidcService(issuerUrl string) *OidcService {
+	return &OidcService{
+		issuerUrl:   issuerUrl,
+		clientId:    mockClientId,
+		redirectUrl: "http://localhost/api/auth/oidc/callback",
+		scopes:      "openid email",
+		httpClient:  &http.Client{Timeout: 5 * time.Second},
+		states:      &memoryOidcStateStore{states: make(map[string]schema.OidcLoginState)},
+	}
+}
+
+func TestOidcLoginRoundTrip(t *testing.T) {
+	issuer := newMockIssuer(t)
+	service := newTestOidcService(issuer.server.URL)
+
+	authorizationURL, err := service.AuthorizationURL()
+	if err != nil {
+		t.Fatal(err)
+	}
+	state, code := issuer.authorize(authorizationURL, nil)
+
+	claims, err := service.redeem(state, code)
+	if err != nil {
+		t.Fatalf("redeem: %v", err)
+	}
+	if claims.Subject != "user-123" || claims.Email != "ada@example.com" || !claims.EmailVerified {
+		t.Fatalf("unexpected claims %+v", claims)
+	}
+
+	// The state is single use
+	if _, err := service.redeem(state, code); !errors.Is(err, ErrOidcStateInvalid) {
+		t.Fatalf("reused state: got %v, want ErrOidcStateInvalid", err)
+	}
+}
+
+func TestOidcRejectsUnknownState(t *testing.T) {
+	issuer := newMockIssuer(t)
+	service := newTestOidcService(issuer.server.URL)
+
+	authorizationURL, err := service.AuthorizationURL()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, code := issuer.authorize(authorizationURL, nil)
+
+	if _, err := service.redeem("forged-state", code); !errors.Is(err, ErrOidcStateInvalid) {
+		t.Fatalf("got %v, want ErrOidcStateInvalid", err)
+	}
+}
+
+func TestOidcRejectsExpiredState(t *testing.T) {
+	issuer := newMockIssuer(t)
+	service := newTestOidcService(issuer.server.URL)
+
+	authorizationURL, err := service.AuthorizationURL()
+	if err != nil {
+		t.Fatal(err)
+	}
+	state, code := issuer.authorize(authorizationURL, nil)
+
+	store := service.states.(*memoryOidcStateStore)
+	for hash, loginState := range store.states {
+		loginState.ExpiresAt = time.Now().Add(-time.Second)
+		store.states[hash] = loginState
+	}
+	if _, err := service.redeem(state, code); !errors.Is(err, ErrOidcStateInvalid) {
+		t.Fatalf("got %v, want ErrOidcStateInvalid", err)
+	}
+}
+
+func TestOidcRejectsWrongCodeVerifier(t *testing.T) {
+	issuer := newMockIssuer(t)
+	service := newTestOidcService(issuer.server.URL)
+
+	authorizationURL, err := service.AuthorizationURL()
+	if err != nil {
+		t.Fatal(err)
+	}
+	state, code := issuer.authorize(authorizationURL, nil)
+
+	// A code intercepted by someone else is useless without the verifier
+	store := service.states.(*memoryOidcStateStore)
+	for hash, loginState := range store.states {
+		loginState.CodeVerifier = "not-the-verifier"
+		store.states[hash] = loginState
+	}
+	_, err = service.redeem(state, code)
+	if err == nil || errors.Is(err, ErrOidcTokenInvalid) {
+		t.Fatalf("got %v, want the token exchange to fail", err)
+	}
+}
+
+func TestOidcRejectsInvalidIdTokens(t *testing.T) {
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		edit func(claims jwt.MapClaims, grant *mockGrant)
+	}{
+		{"bad signature", func(claims jwt.MapClaims, grant *mockGrant) { grant.signer = otherKey }},
+		{"bad nonce", func(claims jwt.MapClaims, grant *mockGrant) { claims["nonce"] = "replayed-nonce" }},
+		{"missing nonce", func(claims jwt.MapClaims, grant *mockGrant) { delete(claims, "nonce") }},
+		{"wrong issuer", func(claims jwt.MapClaims, grant *mockGrant) { claims["iss"] = "https://evil.example.com" }},
+		{"wrong audience", func(claims jwt.MapClaims, grant *mockGrant) { claims["aud"] = "someone-else" }},
+		{"expired", func(claims jwt.MapClaims, grant *mockGrant) { claims["exp"] = time.Now().Add(-time.Hour).Unix() }},
+		{"no expiry", func(claims jwt.MapClaims, grant *mockGrant) { delete(claims, "exp") }},
+		{"no subject", func(claims jwt.MapClaims, grant *mockGrant) { delete(claims, "sub") }},
+		{"other party among audiences", func(claims jwt.MapClaims, grant *mockGrant) {
+			claims["aud"] = []string{mockClientId, "someone-else"}
+			claims["azp"] = "someone-else"
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			issuer := newMockIssuer(t)
+			service := newTestOidcService(issuer.server.URL)
+
+			authorizationURL, err := service.AuthorizationURL()
+			if err != nil {
+				t.Fatal(err)
+			}
+			state, code := issuer.authorize(authorizationURL, test.edit)
+
+			if _, err := service.redeem(state, code); !errors.Is(err, ErrOidcTokenInvalid) {
+				t.Fatalf("got %v, want ErrOidcTokenInvalid", err)
+			}
+		})
+	}
+}
+
+func TestOidcDiscoveryIssuerMustMatch(t *testing.T) {
+	issuer := newMockIssuer(t)
+	// Same server under another name, as a misconfigured or spoofed issuer would be
+	service := newTestOidcService(issuer.server.URL + "/other")
+
+	if _, err := service.AuthorizationURL(); err == nil {
+		t.Fatal("expected discovery to fail when the issuer does not match")
+	}
+}
+
+func TestOidcJwksIsCachedAndRefetchedForNewKeys(t *testing.T) {
+	issuer := newMockIssuer(t)
+	service := newTestOidcService(issuer.server.URL)
+
+	login := func() error {
+		authorizationURL, err := service.AuthorizationURL()
+		if err != nil {
+			return err
+		}
+		state, code := issuer.authorize(authorizationURL, nil)
+		_, err = service.redeem(state, code)
+		return err
+	}
+	for i := 0; i < 3; i++ {
+		if err := login(); err != nil {
+			t.Fatalf("login %d: %v", i, err)
+		}
+	}
+	if issuer.jwksFetches != 1 {
+		t.Fatalf("JWKS fetched %d times, want 1", issuer.jwksFetches)
+	}
+
+	// The provider rotates its key. The new kid is fetched once the cache may be refreshed.
+	newKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuer.mu.Lock()
+	issuer.key, issuer.kid = newKey, "key-2"
+	issuer.mu.Unlock()
+	service.keysFetchedAt = time.Now().Add(-2 * oidcJwksMinInterval)
+
+	if err := login(); err != nil {
+		t.Fatalf("login after rotation: %v", err)
+	}
+	if issuer.jwksFetches != 2 {
+		t.Fatalf("JWKS fetched %d times, want 2", issuer.jwksFetches)
+	}
+}
+
+func TestOidcLinksOnlyVerifiedAccounts(t *testing.T) {
+	claims := &oidcIdTokenClaims{
+		RegisteredClaims: jwt.RegisteredClaims{Issuer: "https://issuer.example.com", Subject: "user-123"},
+		Email:            "Ada@example.com",
+		EmailVerified:    true,
+	}
+
+	tests := []struct {
+		name     string
+		verified bool
+		err      error
+	}{
+		{"verified account is linked", true, nil},
+		{"unverified account is refused", false, ErrOidcAccountUnverified},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			accountId := uuid.New()
+			var statements []string
+			useFakeDB(t, func(query string, args []driver.Value) fakeResult {
+				statements = append(statements, query)
+				switch {
+				case strings.HasPrefix(query, `SELECT * FROM "user_identities"`):
+					return fakeResult{columns: []string{"id"}}
+				case strings.HasPrefix(query, `SELECT * FROM "users"`):
+					return selectRow(map[string]driver.Value{"id": accountId.String(), "email": "ada@example.com", "is_verified": test.verified},
+						"id", "email", "is_verified")
+				}
+				return fakeResult{affected: 1}
+			})
+
+			user, err := newTestOidcService("").linkUser(claims)
+			if !errors.Is(err, test.err) {
+				t.Fatalf("err = %v, want %v", err, test.err)
+			}
+
+			linked := slices.ContainsFunc(statements, func(query string) bool {
+				return strings.HasPrefix(query, `INSERT INTO "user_identities"`)
+			})
+			changedUser := slices.ContainsFunc(statements, func(query string) bool {
+				return strings.HasPrefix(query, `UPDATE "users"`)
+			})
+			if changedUser {
+				t.Error("an existing account was modified")
+			}
+			if linked != (test.err == nil) {
+				t.Fatalf("identity linked = %v, want %v", linked, test.err == nil)
+			}
+			if test.err == nil && user.ID != accountId {
+				t.Fatalf("logged in as %s, want the existing account %s", user.ID, accountId)
+			}
+		})
+	}
+}
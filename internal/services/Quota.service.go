@@ -0,0 +1,158 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"goCal/internal/db"
+	"goCal/internal/logger"
+	"goCal/internal/schema"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var ErrQuotaExceeded = errors.New("storage quota exceeded")
+
+// quotaWarningPercent of the storage limit is where users get a warning email
+const quotaWarningPercent = 90
+
+// QuotaExceededError carries the numbers behind a rejected upload
+type QuotaExceededError struct {
+	StorageUsed  int64 `json:"storage_used"`
+	StorageLimit int64 `json:"storage_limit"`
+	Requested    int64 `json:"requested"`
+}
+
+func (e *QuotaExceededError) Error() string {
+	return fmt.Sprintf("storage quota exceeded: %d of %d bytes used, %d more requested", e.StorageUsed, e.StorageLimit, e.Requested)
+}
+
+func (e *QuotaExceededError) Is(target error) bool {
+	return target == ErrQuotaExceeded
+}
+
+type QuotaService struct{}
+
+func NewQuotaService() *QuotaService {
+	return &QuotaService{}
+}
+
+// CheckQuota is a cheap pre-flight check before uploading bytes to storage.
+// adjustStorageUsed is what actually enforces the limit.
+func (q *QuotaService) CheckQuota(userId string, additional int64) error {
+	var user schema.User
+	if err := db.DB.Select("id", "storage_used", "storage_limit").Where("id = ?", userId).First(&user).Error; err != nil {
+		return err
+	}
+	if additional > 0 && user.StorageUsed+additional > user.StorageLimit {
+		return &QuotaExceededError{
+			StorageUsed:  user.StorageUsed,
+			StorageLimit: user.StorageLimit,
+			Requested:    additional,
+		}
+	}
+	return nil
+}
+
+// adjustStorageUsed changes a user's usage by delta inside tx. Increases fail
+// with a QuotaExceededError when they would cross the limit.
+func adjustStorageUsed(tx *gorm.DB, userId string, delta int64) error {
+	if delta == 0 {
+		return nil
+	}
+
+	if delta < 0 {
+		return tx.Model(&schema.User{}).Unscoped().
+			Where("id = ?", userId).
+			Update("storage_used", gorm.Expr("GREATEST(storage_used + ?, 0)", delta)).Error
+	}
+
+	var updated []schema.User
+	result := tx.Model(&updated).
+		Clauses(clause.Returning{}).
+		Where("id = ? AND storage_used + ? <= storage_limit", userId, delta).
+		Update("storage_used", gorm.Expr("storage_used + ?", delta))
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 || len(updated) == 0 {
+		var user schema.User
+		if err := tx.Select("id", "storage_used", "storage_limit").Where("id = ?", userId).First(&user).Error; err != nil {
+			return err
+		}
+		return &QuotaExceededError{
+			StorageUsed:  user.StorageUsed,
+			StorageLimit: user.StorageLimit,
+			Requested:    delta,
+		}
+	}
+
+	// Warn once, when this change crosses the threshold
+	user := &updated[0]
+	threshold := user.StorageLimit * quotaWarningPercent / 100
+	if user.StorageLimit > 0 && user.StorageUsed >= threshold && user.StorageUsed-delta < threshold {
+		// A savepoint keeps a failed insert from aborting the caller's transaction
+		errQueue := tx.Transaction(func(tx *gorm.DB) error {
+			return queueQuotaWarningEmail(tx, user)
+		})
+		if errQueue != nil {
+			logger.Error("Failed to queue quota warning for user %s: %v", userId, errQueue)
+		}
+	}
+	return nil
+}
+
+func (q *QuotaService) SetStorageLimit(userId string, storageLimit int64) (*schema.User, error) {
+	if storageLimit < 0 {
+		return nil, errors.New("storage limit cannot be negative")
+	}
+
+	result := db.DB.Model(&schema.User{}).Where("id = ?", userId).Update("storage_limit", storageLimit)
+	if result.Error != nil {
+		logger.Error("Failed to update storage limit for user %s: %v", userId, result.Error)
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, fmt.Errorf("user not found")
+	}
+
+	var user *schema.User
+	if err := db.DB.Where("id = ?", userId).First(&user).Error; err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// storageUsageQuery computes each user's real usage from the files table.
+// Trashed files are included since their objects are kept until purged, and
+// so are earlier versions, which are charged to the file's owner. An object
+// the file and its versions share, after a restore, is charged once.
+const storageUsageQuery = `
+	SELECT COALESCE(SUM(objects.file_size), 0)
+	FROM (
+		SELECT DISTINCT files.id, stored.storage_bucket, stored.storage_key, stored.file_size
+		FROM files CROSS JOIN LATERAL (
+			SELECT files.storage_bucket, files.storage_key, files.file_size
+			UNION ALL
+			SELECT file_versions.storage_bucket, file_versions.storage_key, file_versions.file_size
+			FROM file_versions WHERE file_versions.file_id = files.id
+		) stored
+		WHERE files.uploaded_by_id = users.id
+	) objects`
+
+// ReconcileStorageUsage recomputes storage_used for every user whose counter
+// drifted from the files table and returns how many users were corrected.
+func (q *QuotaService) ReconcileStorageUsage() (int64, error) {
+	result := db.DB.Exec(`
+		UPDATE users
+		SET storage_used = (` + storageUsageQuery + `)
+		WHERE storage_used IS DISTINCT FROM (` + storageUsageQuery + `)`)
+	if result.Error != nil {
+		logger.Error("Failed to reconcile storage usage: %v", result.Error)
+		return 0, result.Error
+	}
+	if result.RowsAffected > 0 {
+		logger.Warn(fmt.Sprintf("Storage reconciliation corrected %d user(s)", result.RowsAffected))
+	}
+	return result.RowsAffected, nil
+}
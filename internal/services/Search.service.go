@@ -0,0 +1,158 @@
+package services
+
+import (
+	"errors"
+	"goCal/internal/db"
+	"goCal/internal/logger"
+	"goCal/internal/pagination"
+	"goCal/internal/schema"
+	"html"
+	"regexp"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+var ErrSearchQueryEmpty = errors.New("search needs a query or a tag")
+
+const maxSearchWords = 10
+
+// ts_headline marks matches with these control characters, the snippet is
+// HTML-escaped before they are swapped for <mark> tags
+const (
+	snippetStart = "\x02"
+	snippetStop  = "\x03"
+)
+
+var searchWordPattern = regexp.MustCompile(`[\p{L}\p{N}]+`)
+
+// Search results are ordered most relevant first unless a sort is given
+var searchSorts = pagination.Sorts{
+	Fields: map[string]pagination.Sort{
+		"relevance":  {Column: "rank", Kind: pagination.Float},
+		"name":       {Column: "name", Kind: pagination.String},
+		"updated_at": {Column: "updated_at", Kind: pagination.Time},
+	},
+	Default:     "relevance",
+	DefaultDesc: true,
+}
+
+type SearchService struct{}
+
+func NewSearchService() *SearchService {
+	return &SearchService{}
+}
+
+// searchTsQuery turns free text into a tsquery matching every word as a
+// prefix. Only letters and digits survive, so input can't inject tsquery operators.
+func searchTsQuery(q string) string {
+	words := searchWordPattern.FindAllString(strings.ToLower(q), maxSearchWords)
+	for i, word := range words {
+		words[i] = word + ":*"
+	}
+	return strings.Join(words, " & ")
+}
+
+// Search finds the files and folders matching query that userId can see.
+// Files also match on the text the content indexer extracted from them.
+// includeFiles and includeFolders come from the caller's API key scopes.
+func (s *SearchService) Search(userId string, query *schema.SearchQuery, includeFiles bool, includeFolders bool) ([]*schema.SearchResult, *pagination.Page, error) {
+	tsQuery := searchTsQuery(query.Q)
+	if tsQuery == "" && len(query.Tags) == 0 {
+		return nil, nil, ErrSearchQueryEmpty
+	}
+	var matches []*gorm.DB
+
+	if includeFiles && query.Type != schema.SearchTypeFolder {
+		files := s.fileMatches(userId, tsQuery, query.Tags)
+		snippet, rank, args := searchColumns(tsQuery, "files.file_name", "files.search_vector", true)
+		matches = append(matches, files.Select(`'file' AS type, files.id, files.file_name AS name,
+			`+snippet+` AS snippet, `+rank+` AS rank,
+			files.folder_id AS parent_id, files.file_type, files.visibility,
+			files.uploaded_by_id AS owner_id, files.updated_at`, args...))
+	}
+
+	// Folders have no sharing, only their owner finds them
+	if includeFolders && userId != "" && query.Type != schema.SearchTypeFile {
+		folders := s.folderMatches(userId, tsQuery, query.Tags)
+		snippet, rank, args := searchColumns(tsQuery,
+			"concat_ws(' - ', folders.folder_name, folders.folder_description, array_to_string(folders.folder_tags, ', '))",
+			"folders.search_vector", false)
+		matches = append(matches, folders.Select(`'folder' AS type, folders.id, folders.folder_name AS name,
+			`+snippet+` AS snippet, `+rank+` AS rank,
+			folders.parent_id, '' AS file_type, '' AS visibility,
+			folders.created_by_id AS owner_id, folders.updated_at`, args...))
+	}
+
+	if len(matches) == 0 {
+		return []*schema.SearchResult{}, &pagination.Page{Limit: query.PageLimit()}, nil
+	}
+
+	parts := make([]string, len(matches))
+	args := make([]interface{}, len(matches))
+	for i, match := range matches {
+		parts[i] = "(?)"
+		args[i] = match
+	}
+	union := db.DB.Raw(strings.Join(parts, " UNION ALL "), args...)
+
+	results, page, err := pagination.Find[*schema.SearchResult](db.DB.Table("(?) AS results", union), query.Params, searchSorts, "id")
+	if err != nil {
+		if !errors.Is(err, pagination.ErrInvalidCursor) && !errors.Is(err, pagination.ErrInvalidSort) {
+			logger.Error("Search for %q failed: %v", query.Q, err)
+		}
+		return nil, nil, err
+	}
+	if results == nil {
+		results = []*schema.SearchResult{}
+	}
+
+	highlight := strings.NewReplacer(snippetStart, "<mark>", snippetStop, "</mark>")
+	for _, result := range results {
+		result.Snippet = highlight.Replace(html.EscapeString(result.Snippet))
+	}
+	return results, page, nil
+}
+
+func (s *SearchService) fileMatches(userId string, tsQuery string, tags []string) *gorm.DB {
+	tx := db.DB.Model(&schema.File{}).Scopes(visibleFilesScope(userId))
+	if tsQuery != "" {
+		tx = tx.Where("files.search_vector @@ to_tsquery('simple', ?)", tsQuery)
+	}
+	if len(tags) > 0 {
+		tx = tx.Where("files.folder_id IN (?)",
+			db.DB.Model(&schema.Folder{}).Select("id").Where("folder_tags @> ARRAY[?]::text[]", tags))
+	}
+	return tx
+}
+
+func (s *SearchService) folderMatches(userId string, tsQuery string, tags []string) *gorm.DB {
+	tx := db.DB.Model(&schema.Folder{}).Where("folders.created_by_id = ?", userId)
+	if tsQuery != "" {
+		tx = tx.Where("folders.search_vector @@ to_tsquery('simple', ?)", tsQuery)
+	}
+	if len(tags) > 0 {
+		tx = tx.Where("folders.folder_tags @> ARRAY[?]::text[]", tags)
+	}
+	return tx
+}
+
+// searchColumns returns the snippet and rank expressions for one kind of
+// result and their arguments. Names are short, so they are highlighted whole;
+// longer text is cut down around the matches. Tag-only searches have nothing
+// to rank or highlight.
+func searchColumns(tsQuery string, text string, vector string, whole bool) (string, string, []interface{}) {
+	if tsQuery == "" {
+		return text, "0::float8", nil
+	}
+
+	options := "StartSel=" + snippetStart + ", StopSel=" + snippetStop
+	if whole {
+		options += ", HighlightAll=true"
+	} else {
+		options += ", MaxWords=30, MinWords=10, MaxFragments=2"
+	}
+	return "ts_headline('simple', " + text + ", to_tsquery('simple', ?), ?)",
+		"ts_rank_cd(" + vector + ", to_tsquery('simple', ?))::float8",
+		[]interface{}{tsQuery, options, tsQuery}
+}
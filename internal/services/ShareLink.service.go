@@ -0,0 +1,247 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"goCal/internal/db"
+	"goCal/internal/logger"
+	"goCal/internal/pagination"
+	"goCal/internal/schema"
+	"goCal/internal/utils"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrShareLinkInvalid          = errors.New("share link is invalid")
+	ErrShareLinkExpired          = errors.New("share link has expired")
+	ErrShareLinkRevoked          = errors.New("share link has been revoked")
+	ErrShareLinkExhausted        = errors.New("share link has reached its download limit")
+	ErrShareLinkPasswordRequired = errors.New("share link requires a password")
+	ErrShareLinkPasswordInvalid  = errors.New("share link password is incorrect")
+)
+
+const (
+	defaultShareLinkExpiry = 7 * 24 * time.Hour
+	maxShareLinkExpiry     = 365 * 24 * time.Hour
+)
+
+type ShareLinkService struct {
+	secret   []byte
+	throttle *LoginThrottleService
+}
+
+// NewShareLinkService reads SHARE_LINK_SECRET. It is kept apart from JWT_KEY and
+// the server won't start without it, an empty key would let anyone sign links.
+func NewShareLinkService() *ShareLinkService {
+	secret := os.Getenv("SHARE_LINK_SECRET")
+	if secret == "" {
+		logger.Error("Missing SHARE_LINK_SECRET")
+		panic("SHARE_LINK_SECRET is required to sign share links")
+	}
+	return &ShareLinkService{
+		secret:   []byte(secret),
+		throttle: NewLoginThrottleService(),
+	}
+}
+
+func (sl *ShareLinkService) sign(linkId uuid.UUID, expires int64) string {
+	mac := hmac.New(sha256.New, sl.secret)
+	fmt.Fprintf(mac, "%s.%d", linkId, expires)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Token builds the public token for a link: "<link id>.<expiry unix>.<hmac>"
+func (sl *ShareLinkService) Token(link *schema.ShareLink) string {
+	expires := link.ExpiresAt.Unix()
+	return fmt.Sprintf("%s.%d.%s", link.ID, expires, sl.sign(link.ID, expires))
+}
+
+// parseToken verifies the token signature and returns the link id it names
+func (sl *ShareLinkService) parseToken(token string) (uuid.UUID, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return uuid.Nil, ErrShareLinkInvalid
+	}
+	linkId, err := uuid.Parse(parts[0])
+	if err != nil {
+		return uuid.Nil, ErrShareLinkInvalid
+	}
+	expires, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return uuid.Nil, ErrShareLinkInvalid
+	}
+	if !hmac.Equal([]byte(parts[2]), []byte(sl.sign(linkId, expires))) {
+		return uuid.Nil, ErrShareLinkInvalid
+	}
+	if time.Now().Unix() > expires {
+		return uuid.Nil, ErrShareLinkExpired
+	}
+	return linkId, nil
+}
+
+func (sl *ShareLinkService) CreateShareLink(file *schema.File, userId string, request *schema.CreateShareLinkRequest) (*schema.ShareLink, error) {
+	expiresAt := time.Now().Add(defaultShareLinkExpiry)
+	if request.ExpiresAt != nil {
+		expiresAt = *request.ExpiresAt
+	}
+	if !expiresAt.After(time.Now()) {
+		return nil, errors.New("expires_at must be in the future")
+	}
+	if expiresAt.After(time.Now().Add(maxShareLinkExpiry)) {
+		return nil, errors.New("share links can be valid for at most one year")
+	}
+	if request.MaxDownloads != nil && *request.MaxDownloads < 1 {
+		return nil, errors.New("max_downloads must be at least 1")
+	}
+
+	link := &schema.ShareLink{
+		FileId:       file.Id,
+		CreatedById:  uuid.MustParse(userId),
+		ExpiresAt:    expiresAt.Truncate(time.Second),
+		MaxDownloads: request.MaxDownloads,
+	}
+
+	if request.Password != nil && *request.Password != "" {
+		hashedPassword, err := utils.HashPassword(*request.Password)
+		if err != nil {
+			return nil, err
+		}
+		link.PasswordHash = hashedPassword
+		link.PasswordProtected = true
+	}
+
+	if err := db.DB.Create(link).Error; err != nil {
+		logger.Error("Failed to create share link for file %s: %v", file.Id, err)
+		return nil, err
+	}
+	return link, nil
+}
+
+func (sl *ShareLinkService) GetShareLinks(fileId uuid.UUID) ([]*schema.ShareLink, error) {
+	var links []*schema.ShareLink
+	result := db.DB.Where("file_id = ?", fileId).Order("created_at DESC").Find(&links)
+	if result.Error != nil {
+		logger.Error("Failed to get share links %s ", result.Error)
+		return nil, result.Error
+	}
+	return links, nil
+}
+
+func (sl *ShareLinkService) getFileShareLink(fileId uuid.UUID, linkId string) (*schema.ShareLink, error) {
+	if _, err := uuid.Parse(linkId); err != nil {
+		return nil, ErrShareLinkInvalid
+	}
+	var link schema.ShareLink
+	if err := db.DB.Where("id = ? AND file_id = ?", linkId, fileId).First(&link).Error; err != nil {
+		return nil, ErrShareLinkInvalid
+	}
+	return &link, nil
+}
+
+func (sl *ShareLinkService) RevokeShareLink(fileId uuid.UUID, linkId string) (*schema.ShareLink, error) {
+	link, err := sl.getFileShareLink(fileId, linkId)
+	if err != nil {
+		return nil, err
+	}
+	if link.RevokedAt == nil {
+		now := time.Now()
+		link.RevokedAt = &now
+		if err := db.DB.Model(link).Update("revoked_at", now).Error; err != nil {
+			return nil, err
+		}
+	}
+	return link, nil
+}
+
+// downloadSorts orders a link's download log
+var downloadSorts = pagination.Sorts{
+	Fields: map[string]pagination.Sort{
+		"downloaded_at": {Column: "downloaded_at", Kind: pagination.Time},
+	},
+	Default:     "downloaded_at",
+	DefaultDesc: true,
+}
+
+func (sl *ShareLinkService) GetShareLinkDownloads(fileId uuid.UUID, linkId string, params pagination.Params) ([]*schema.ShareLinkDownload, *pagination.Page, error) {
+	link, err := sl.getFileShareLink(fileId, linkId)
+	if err != nil {
+		return nil, nil, err
+	}
+	tx := db.DB.Model(&schema.ShareLinkDownload{}).Where("share_link_id = ?", link.ID)
+	return pagination.Find[*schema.ShareLinkDownload](tx, params, downloadSorts, "id")
+}
+
+// ResolveToken validates a public token and password and returns the link with
+// its file. Wrong passwords lock the link out like failed sign-ins do.
+func (sl *ShareLinkService) ResolveToken(token string, password string) (*schema.ShareLink, error) {
+	linkId, err := sl.parseToken(token)
+	if err != nil {
+		return nil, err
+	}
+
+	var link schema.ShareLink
+	result := db.DB.Preload("File").Where("id = ?", linkId).First(&link)
+	if result.Error != nil {
+		return nil, ErrShareLinkInvalid
+	}
+	// Trashed files are not preloaded, their links stop working until restored
+	if link.File.Id == uuid.Nil {
+		return nil, ErrShareLinkInvalid
+	}
+	if link.RevokedAt != nil {
+		return nil, ErrShareLinkRevoked
+	}
+	if time.Now().After(link.ExpiresAt) {
+		return nil, ErrShareLinkExpired
+	}
+	if link.MaxDownloads != nil && link.DownloadCount >= *link.MaxDownloads {
+		return nil, ErrShareLinkExhausted
+	}
+	if link.PasswordProtected {
+		if password == "" {
+			return nil, ErrShareLinkPasswordRequired
+		}
+		if err := sl.throttle.CheckShareLink(link.ID); err != nil {
+			return nil, err
+		}
+		if utils.CompareHashAndPassword(link.PasswordHash, password) != nil {
+			sl.throttle.RecordShareLinkFailure(link.ID)
+			return nil, ErrShareLinkPasswordInvalid
+		}
+	}
+	return &link, nil
+}
+
+// RecordDownload counts a download against the link, failing once the limit is
+// reached so concurrent requests cannot overshoot max_downloads
+func (sl *ShareLinkService) RecordDownload(link *schema.ShareLink, ipAddress string, userAgent string) error {
+	return db.DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&schema.ShareLink{}).
+			Where("id = ? AND revoked_at IS NULL AND (max_downloads IS NULL OR download_count < max_downloads)", link.ID).
+			Update("download_count", gorm.Expr("download_count + 1"))
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrShareLinkExhausted
+		}
+
+		if len(userAgent) > 500 {
+			userAgent = userAgent[:500]
+		}
+		return tx.Create(&schema.ShareLinkDownload{
+			ShareLinkId: link.ID,
+			IpAddress:   ipAddress,
+			UserAgent:   userAgent,
+		}).Error
+	})
+}
@@ -0,0 +1,358 @@
+package services
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"goCal/internal/db"
+	"goCal/internal/logger"
+	"goCal/internal/schema"
+	"goCal/internal/storage"
+	"goCal/internal/thumbnail"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrThumbnailUnavailable = errors.New("thumbnails are not available for this file")
+	ErrThumbnailFailed      = errors.New("thumbnails could not be generated for this file")
+	ErrInvalidThumbnailSize = errors.New("size must be one of " + strings.Join(thumbnail.SizeNames(), ", "))
+	ErrThumbnailPending     = errors.New("thumbnails are being generated, try again shortly")
+)
+
+const (
+	defaultThumbnailMaxAttempts = 3
+	thumbnailRetryBase          = time.Minute
+	thumbnailRetryMax           = time.Hour
+	// An image stuck in "generating" longer than this is picked up again
+	thumbnailLease = 5 * time.Minute
+	// How many thumbnails requests may generate at once, the rest are queued
+	defaultThumbnailInlineLimit = 2
+)
+
+// ThumbnailObject is where one stored thumbnail lives
+type ThumbnailObject struct {
+	Bucket      string
+	Key         string
+	Size        string
+	ContentHash string
+	GeneratedAt time.Time
+}
+
+// ThumbnailService makes the previews of uploaded images, in the background
+// after upload, or on request for their owner when they are missing
+type ThumbnailService struct {
+	fileStorageService *FileStorageService
+	maxAttempts        int
+	inline             chan struct{} // slots for generating during a request
+}
+
+// NewThumbnailService reads THUMBNAIL_MAX_ATTEMPTS and THUMBNAIL_INLINE_LIMIT
+func NewThumbnailService(fileStorageService *FileStorageService) *ThumbnailService {
+	maxAttempts := defaultThumbnailMaxAttempts
+	if attempts, err := strconv.Atoi(os.Getenv("THUMBNAIL_MAX_ATTEMPTS")); err == nil && attempts > 0 {
+		maxAttempts = attempts
+	}
+	inlineLimit := defaultThumbnailInlineLimit
+	if limit, err := strconv.Atoi(os.Getenv("THUMBNAIL_INLINE_LIMIT")); err == nil && limit >= 0 {
+		inlineLimit = limit
+	}
+
+	return &ThumbnailService{
+		fileStorageService: fileStorageService,
+		maxAttempts:        maxAttempts,
+		inline:             make(chan struct{}, inlineLimit),
+	}
+}
+
+// thumbnailKey is where the thumbnail of the given size is stored inside the original's bucket
+func thumbnailKey(hash string, size string) string {
+	return "thumbnails/" + hash[:2] + "/" + hash + "/" + size + ".jpg"
+}
+
+// queueThumbnails asks the thumbnail generator to make previews of new image
+// content. Content that already has them is left alone.
+func queueThumbnails(tx *gorm.DB, contentHash string, fileType string) error {
+	if contentHash == "" || !thumbnail.Supported(fileType) {
+		return nil
+	}
+
+	thumb := &schema.Thumbnail{
+		ContentHash:   contentHash,
+		Status:        schema.ThumbnailStatusPending,
+		NextAttemptAt: time.Now(),
+	}
+	if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(thumb).Error; err != nil {
+		logger.Error("Failed to queue thumbnails for %s: %v", contentHash, err)
+		return err
+	}
+	return nil
+}
+
+// ProcessNext claims one queued image and generates its thumbnails. It
+// reports false when nothing was due.
+func (s *ThumbnailService) ProcessNext() (bool, error) {
+	thumb, err := s.claim()
+	if err != nil || thumb == nil {
+		return false, err
+	}
+
+	var blob schema.Blob
+	result := db.DB.Where("hash = ?", thumb.ContentHash).Limit(1).Find(&blob)
+	if result.Error != nil {
+		return true, s.recordFailure(thumb, result.Error)
+	}
+	if result.RowsAffected == 0 {
+		// The content was collected before its turn came
+		return true, db.DB.Delete(thumb).Error
+	}
+
+	width, height, errGenerate := s.generate(thumb.ContentHash, blob.Bucket, blob.Key)
+	if errGenerate != nil {
+		return true, s.recordFailure(thumb, errGenerate)
+	}
+	return true, s.recordReady(thumb.ContentHash, blob.Bucket, width, height)
+}
+
+// claim marks the oldest due image as generating. SKIP LOCKED lets several
+// workers claim at once without handing out the same row.
+func (s *ThumbnailService) claim() (*schema.Thumbnail, error) {
+	now := time.Now()
+	due := db.DB.Model(&schema.Thumbnail{}).
+		Select("content_hash").
+		Where("(status = ? AND next_attempt_at <= ?) OR (status = ? AND locked_until < ?)",
+			schema.ThumbnailStatusPending, now, schema.ThumbnailStatusGenerating, now).
+		Order("next_attempt_at").
+		Limit(1).
+		Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"})
+
+	var claimed []schema.Thumbnail
+	result := db.DB.Model(&claimed).
+		Clauses(clause.Returning{}).
+		Where("content_hash IN (?)", due).
+		Updates(map[string]interface{}{
+			"status":       schema.ThumbnailStatusGenerating,
+			"locked_until": now.Add(thumbnailLease),
+			"attempts":     gorm.Expr("attempts + 1"),
+		})
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if len(claimed) == 0 {
+		return nil, nil
+	}
+	return &claimed[0], nil
+}
+
+// generate decodes the original and stores a thumbnail of every size next
+// to it. It returns the upright size of the original.
+func (s *ThumbnailService) generate(hash string, bucket string, key string) (int, int, error) {
+	original, err := s.fileStorageService.Open(bucket, key)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer original.Close()
+
+	source, err := thumbnail.Decode(original)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	for _, size := range thumbnail.SizeNames() {
+		var encoded bytes.Buffer
+		if err := thumbnail.Encode(&encoded, source.Thumbnail(thumbnail.Sizes[size])); err != nil {
+			return 0, 0, err
+		}
+		if err := s.fileStorageService.PutObject(bucket, thumbnailKey(hash, size), &encoded, thumbnail.ContentType); err != nil {
+			return 0, 0, fmt.Errorf("failed to store %s thumbnail: %w", size, err)
+		}
+	}
+
+	width, height := source.Size()
+	return width, height, nil
+}
+
+func (s *ThumbnailService) recordReady(hash string, bucket string, width int, height int) error {
+	now := time.Now()
+	thumb := &schema.Thumbnail{
+		ContentHash:   hash,
+		Status:        schema.ThumbnailStatusReady,
+		NextAttemptAt: now,
+		Bucket:        bucket,
+		Width:         width,
+		Height:        height,
+		GeneratedAt:   &now,
+	}
+	return db.DB.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "content_hash"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"status":       schema.ThumbnailStatusReady,
+			"locked_until": nil,
+			"bucket":       bucket,
+			"width":        width,
+			"height":       height,
+			"last_error":   "",
+			"generated_at": now,
+			"updated_at":   now,
+		}),
+	}).Create(thumb).Error
+}
+
+// recordFailure schedules another attempt with exponential backoff, or marks
+// the image failed once it has used up its attempts
+func (s *ThumbnailService) recordFailure(thumb *schema.Thumbnail, errGenerate error) error {
+	updates := map[string]interface{}{
+		"locked_until": nil,
+		"last_error":   errGenerate.Error(),
+	}
+
+	// Images that can't be decoded won't decode on the next attempt either
+	permanent := errors.Is(errGenerate, thumbnail.ErrUnsupported) || errors.Is(errGenerate, thumbnail.ErrTooLarge)
+	if permanent || thumb.Attempts >= s.maxAttempts {
+		updates["status"] = schema.ThumbnailStatusFailed
+		logger.Error("Giving up on thumbnails for %s after %d attempts: %v", thumb.ContentHash, thumb.Attempts, errGenerate)
+	} else {
+		delay := thumbnailRetryBase
+		for i := 1; i < thumb.Attempts && delay < thumbnailRetryMax; i++ {
+			delay *= 2
+		}
+		if delay > thumbnailRetryMax {
+			delay = thumbnailRetryMax
+		}
+		updates["status"] = schema.ThumbnailStatusPending
+		updates["next_attempt_at"] = time.Now().Add(delay)
+		logger.Warn("Generating thumbnails for %s failed (attempt %d), retrying in %s: %v", thumb.ContentHash, thumb.Attempts, delay, errGenerate)
+	}
+
+	// A request may have generated them in the meantime
+	return db.DB.Model(&schema.Thumbnail{}).
+		Where("content_hash = ? AND status = ?", thumb.ContentHash, schema.ThumbnailStatusGenerating).
+		Updates(updates).Error
+}
+
+// GetThumbnail finds the thumbnail of the given size for an image file. An
+// empty size picks the default. Thumbnails that are missing, because the
+// generator hasn't got to them yet or their objects were lost, are queued and
+// ErrThumbnailPending returned. Only when generateMissing is set, for the
+// file's owner, are they made on the spot, a few at a time.
+func (s *ThumbnailService) GetThumbnail(file *schema.File, size string, generateMissing bool) (*ThumbnailObject, error) {
+	if size == "" {
+		size = thumbnail.DefaultSize
+	}
+	if _, ok := thumbnail.Sizes[size]; !ok {
+		return nil, ErrInvalidThumbnailSize
+	}
+	// Files stored before deduplication have no hash to key thumbnails by
+	if file.ContentHash == "" || !thumbnail.Supported(file.FileType) {
+		return nil, ErrThumbnailUnavailable
+	}
+
+	var thumb schema.Thumbnail
+	result := db.DB.Where("content_hash = ?", file.ContentHash).Limit(1).Find(&thumb)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	if result.RowsAffected > 0 {
+		switch thumb.Status {
+		case schema.ThumbnailStatusFailed:
+			return nil, fmt.Errorf("%w: %s", ErrThumbnailFailed, thumb.LastError)
+		case schema.ThumbnailStatusPending, schema.ThumbnailStatusGenerating:
+			// The generator has them, making them here as well would only duplicate its work
+			return nil, ErrThumbnailPending
+		case schema.ThumbnailStatusReady:
+			key := thumbnailKey(file.ContentHash, size)
+			_, err := s.fileStorageService.Stat(thumb.Bucket, key)
+			if err == nil {
+				generatedAt := thumb.UpdatedAt
+				if thumb.GeneratedAt != nil {
+					generatedAt = *thumb.GeneratedAt
+				}
+				return &ThumbnailObject{Bucket: thumb.Bucket, Key: key, Size: size, ContentHash: file.ContentHash, GeneratedAt: generatedAt}, nil
+			}
+			if !errors.Is(err, storage.ErrObjectNotFound) {
+				return nil, err
+			}
+			logger.Warn("Thumbnails of %s are missing from storage", file.ContentHash)
+		}
+	}
+
+	if !generateMissing || !s.acquireInline() {
+		if err := s.requeue(file); err != nil {
+			return nil, err
+		}
+		return nil, ErrThumbnailPending
+	}
+	defer s.releaseInline()
+
+	width, height, err := s.generate(file.ContentHash, file.StorageBucket, file.StorageKey)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrThumbnailFailed, err)
+	}
+	if err := s.recordReady(file.ContentHash, file.StorageBucket, width, height); err != nil {
+		return nil, err
+	}
+	return &ThumbnailObject{
+		Bucket:      file.StorageBucket,
+		Key:         thumbnailKey(file.ContentHash, size),
+		Size:        size,
+		ContentHash: file.ContentHash,
+		GeneratedAt: time.Now(),
+	}, nil
+}
+
+// acquireInline takes a slot for generating during a request without
+// waiting for one, so a burst of requests can't pile up decoding images
+func (s *ThumbnailService) acquireInline() bool {
+	select {
+	case s.inline <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s *ThumbnailService) releaseInline() {
+	<-s.inline
+}
+
+// requeue hands missing thumbnails to the generator: content without a row
+// is queued, and a ready row whose objects were lost starts over
+func (s *ThumbnailService) requeue(file *schema.File) error {
+	if err := queueThumbnails(db.DB, file.ContentHash, file.FileType); err != nil {
+		return err
+	}
+	return db.DB.Model(&schema.Thumbnail{}).
+		Where("content_hash = ? AND status = ?", file.ContentHash, schema.ThumbnailStatusReady).
+		Updates(map[string]interface{}{
+			"status":          schema.ThumbnailStatusPending,
+			"attempts":        0,
+			"next_attempt_at": time.Now(),
+			"locked_until":    nil,
+		}).Error
+}
+
+// deleteThumbnails removes the thumbnails of content that is being collected
+func (nfs *FileStorageService) deleteThumbnails(tx *gorm.DB, hash string) error {
+	var thumb schema.Thumbnail
+	result := tx.Where("content_hash = ?", hash).Limit(1).Find(&thumb)
+	if result.Error != nil || result.RowsAffected == 0 {
+		return result.Error
+	}
+
+	if thumb.Bucket != "" {
+		keys := make([]string, 0, len(thumbnail.Sizes))
+		for _, size := range thumbnail.SizeNames() {
+			keys = append(keys, thumbnailKey(hash, size))
+		}
+		if err := nfs.backend.Delete(thumb.Bucket, keys...); err != nil && !errors.Is(err, storage.ErrObjectNotFound) {
+			return err
+		}
+	}
+	return tx.Delete(&thumb).Error
+}
@@ -0,0 +1,318 @@
+package services
+
+import (
+	"errors"
+	"goCal/internal/db"
+	"goCal/internal/logger"
+	"goCal/internal/schema"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+var ErrTrashItemNotFound = errors.New("item not found in trash")
+
+// TrashContents holds the items a user trashed directly. Items trashed along
+// with a folder are restored or purged with it and are not listed on their own.
+type TrashContents struct {
+	Files   []*schema.File   `json:"files"`
+	Folders []*schema.Folder `json:"folders"`
+}
+
+type TrashService struct {
+	fileStorageService *FileStorageService
+}
+
+func NewTrashService(fileStorageService *FileStorageService) *TrashService {
+	return &TrashService{
+		fileStorageService: fileStorageService,
+	}
+}
+
+func (t *TrashService) GetTrash(userId string) (*TrashContents, error) {
+	contents := &TrashContents{}
+
+	if err := db.DB.Unscoped().
+		Where("uploaded_by_id = ? AND deleted_at IS NOT NULL", userId).
+		Where("NOT EXISTS (SELECT 1 FROM folders p WHERE p.id = files.folder_id AND p.deleted_at = files.deleted_at)").
+		Order("deleted_at DESC").Find(&contents.Files).Error; err != nil {
+		logger.Error("Failed to get the trashed files %s", err)
+		return nil, err
+	}
+
+	if err := db.DB.Unscoped().
+		Where("created_by_id = ? AND deleted_at IS NOT NULL", userId).
+		Where("NOT EXISTS (SELECT 1 FROM folders p WHERE p.id = folders.parent_id AND p.deleted_at = folders.deleted_at)").
+		Order("deleted_at DESC").Find(&contents.Folders).Error; err != nil {
+		logger.Error("Failed to get the trashed folders %s", err)
+		return nil, err
+	}
+
+	return contents, nil
+}
+
+func getTrashedFile(fileId string, userId string) (*schema.File, error) {
+	id, err := uuid.Parse(fileId)
+	if err != nil {
+		return nil, ErrTrashItemNotFound
+	}
+
+	var file *schema.File
+	result := db.DB.Unscoped().Where("id = ? AND uploaded_by_id = ? AND deleted_at IS NOT NULL", id, userId).First(&file)
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return nil, ErrTrashItemNotFound
+	}
+	return file, result.Error
+}
+
+func getTrashedFolder(folderId string, userId string) (*schema.Folder, error) {
+	id, err := uuid.Parse(folderId)
+	if err != nil {
+		return nil, ErrTrashItemNotFound
+	}
+
+	var folder *schema.Folder
+	result := db.DB.Unscoped().Where("id = ? AND created_by_id = ? AND deleted_at IS NOT NULL", id, userId).First(&folder)
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return nil, ErrTrashItemNotFound
+	}
+	return folder, result.Error
+}
+
+// RestoreFile puts a trashed file back in its folder, or at the root when that
+// folder is gone or still in the trash
+func (t *TrashService) RestoreFile(fileId string, userId string) (*schema.File, error) {
+	file, err := getTrashedFile(fileId, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	folderId := file.FolderId
+	if folderId != nil && ensureFolderOwner(*folderId, userId) != nil {
+		folderId = nil
+	}
+
+	var count int64
+	if err := db.DB.Model(&schema.File{}).Where("file_name = ? AND uploaded_by_id = ?", file.FileName, userId).Count(&count).Error; err != nil {
+		return nil, err
+	}
+	if count > 0 {
+		return nil, ErrFileNameTaken
+	}
+
+	if err := db.DB.Unscoped().Model(&schema.File{}).Where("id = ?", file.Id).
+		Updates(map[string]interface{}{"deleted_at": nil, "folder_id": folderId}).Error; err != nil {
+		logger.Error("Failed to restore the file %s: %s", fileId, err)
+		return nil, err
+	}
+
+	var restored *schema.File
+	if err := db.DB.Where("id = ?", file.Id).First(&restored).Error; err != nil {
+		return nil, err
+	}
+	return restored, nil
+}
+
+// RestoreFolder restores a trashed folder together with everything that was
+// trashed with it
+func (t *TrashService) RestoreFolder(folderId string, userId string) (*schema.Folder, error) {
+	folder, err := getTrashedFolder(folderId, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	parentId := folder.ParentId
+	if parentId != nil && ensureFolderOwner(*parentId, userId) != nil {
+		parentId = nil
+	}
+
+	errRestore := db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := ensureNameAvailable(tx, userId, parentId, folder.FolderName, nil); err != nil {
+			return err
+		}
+
+		var folderIds []uuid.UUID
+		if err := tx.Raw(descendantsQuery, folder.ID).Scan(&folderIds).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Unscoped().Model(&schema.File{}).
+			Where("folder_id IN ? AND deleted_at = ?", folderIds, folder.DeletedAt.Time).
+			Update("deleted_at", nil).Error; err != nil {
+			return err
+		}
+		if err := tx.Unscoped().Model(&schema.Folder{}).
+			Where("id IN ? AND deleted_at = ?", folderIds, folder.DeletedAt.Time).
+			Update("deleted_at", nil).Error; err != nil {
+			return err
+		}
+		return tx.Model(&schema.Folder{}).Where("id = ?", folder.ID).Update("parent_id", parentId).Error
+	})
+	if errRestore != nil {
+		logger.Error("Failed to restore the folder %s: %s", folderId, errRestore)
+		return nil, errRestore
+	}
+
+	var restored *schema.Folder
+	if err := db.DB.Where("id = ?", folder.ID).First(&restored).Error; err != nil {
+		return nil, err
+	}
+	return restored, nil
+}
+
+// DeleteFilePermanently purges a single trashed file
+func (t *TrashService) DeleteFilePermanently(fileId string, userId string) error {
+	file, err := getTrashedFile(fileId, userId)
+	if err != nil {
+		return err
+	}
+	return t.purgeFiles([]schema.File{*file})
+}
+
+// DeleteFolderPermanently purges a trashed folder and everything inside it
+func (t *TrashService) DeleteFolderPermanently(folderId string, userId string) error {
+	folder, err := getTrashedFolder(folderId, userId)
+	if err != nil {
+		return err
+	}
+	return t.purgeFolders([]uuid.UUID{folder.ID})
+}
+
+// EmptyTrash purges everything userId has in the trash
+func (t *TrashService) EmptyTrash(userId string) error {
+	return t.purgeTrashed("deleted_at IS NOT NULL AND created_by_id = ?", "deleted_at IS NOT NULL AND uploaded_by_id = ?", userId)
+}
+
+// PurgeExpired purges every item that has been in the trash longer than retention
+func (t *TrashService) PurgeExpired(retention time.Duration) error {
+	cutoff := time.Now().Add(-retention)
+	return t.purgeTrashed("deleted_at < ?", "deleted_at < ?", cutoff)
+}
+
+// purgeTrashed purges the trashed folders and files matching the conditions,
+// which share the same arguments
+func (t *TrashService) purgeTrashed(folderCondition string, fileCondition string, args ...interface{}) error {
+	var folderIds []uuid.UUID
+	if err := db.DB.Unscoped().Model(&schema.Folder{}).Where(folderCondition, args...).Pluck("id", &folderIds).Error; err != nil {
+		return err
+	}
+	if err := t.purgeFolders(folderIds); err != nil {
+		return err
+	}
+
+	var files []schema.File
+	if err := db.DB.Unscoped().Where(fileCondition, args...).Find(&files).Error; err != nil {
+		return err
+	}
+	return t.purgeFiles(files)
+}
+
+// purgeFolders removes the folders, their subfolders and all files inside them
+func (t *TrashService) purgeFolders(folderIds []uuid.UUID) error {
+	if len(folderIds) == 0 {
+		return nil
+	}
+
+	var treeIds []uuid.UUID
+	for _, folderId := range folderIds {
+		var ids []uuid.UUID
+		if err := db.DB.Raw(descendantsQuery, folderId).Scan(&ids).Error; err != nil {
+			return err
+		}
+		treeIds = append(treeIds, ids...)
+	}
+
+	var files []schema.File
+	if err := db.DB.Unscoped().Where("folder_id IN ?", treeIds).Find(&files).Error; err != nil {
+		return err
+	}
+	if err := t.purgeFiles(files); err != nil {
+		return err
+	}
+
+	if err := db.DB.Unscoped().Where("id IN ?", treeIds).Delete(&schema.Folder{}).Error; err != nil {
+		logger.Error("Failed to purge folders %s", err)
+		return err
+	}
+	return nil
+}
+
+// purgeFiles deletes the rows and their versions, releases their quota and
+// blob references and then removes the stored objects nothing else references. An object that
+// fails to delete is only logged, the row is already gone so retrying would
+// not find it.
+func (t *TrashService) purgeFiles(files []schema.File) error {
+	if len(files) == 0 {
+		return nil
+	}
+
+	// Each object is charged once per file, versions often share the file's
+	fileIds := make([]uuid.UUID, 0, len(files))
+	freed := make(map[uuid.UUID]int64)
+	owners := make(map[uuid.UUID]uuid.UUID)
+	charged := make(map[string]bool)
+	for _, file := range files {
+		fileIds = append(fileIds, file.Id)
+		freed[file.UploadedById] += file.FileSize
+		owners[file.Id] = file.UploadedById
+		charged[file.Id.String()+"/"+file.StorageBucket+"/"+file.StorageKey] = true
+	}
+
+	var versions []schema.FileVersion
+	errPurge := db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("file_id IN ?", fileIds).Find(&versions).Error; err != nil {
+			return err
+		}
+		for _, version := range versions {
+			object := version.FileId.String() + "/" + version.StorageBucket + "/" + version.StorageKey
+			if !charged[object] {
+				freed[owners[version.FileId]] += version.FileSize
+				charged[object] = true
+			}
+		}
+
+		if len(versions) > 0 {
+			if err := tx.Delete(&versions).Error; err != nil {
+				return err
+			}
+		}
+		if err := tx.Unscoped().Delete(&files).Error; err != nil {
+			return err
+		}
+
+		for _, version := range versions {
+			if err := releaseBlobReference(tx, version.StorageBucket, version.StorageKey); err != nil {
+				return err
+			}
+		}
+		for _, file := range files {
+			if err := releaseBlobReference(tx, file.StorageBucket, file.StorageKey); err != nil {
+				return err
+			}
+		}
+
+		for ownerId, size := range freed {
+			if err := adjustStorageUsed(tx, ownerId.String(), -size); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if errPurge != nil {
+		logger.Error("Failed to purge files %s", errPurge)
+		return errPurge
+	}
+
+	for _, file := range files {
+		if err := t.fileStorageService.CollectObject(file.StorageBucket, file.StorageKey); err != nil {
+			logger.Error("Failed to delete stored object %s/%s: %s", file.StorageBucket, file.StorageKey, err)
+		}
+	}
+	for _, version := range versions {
+		if err := t.fileStorageService.CollectObject(version.StorageBucket, version.StorageKey); err != nil {
+			logger.Error("Failed to delete stored object %s/%s: %s", version.StorageBucket, version.StorageKey, err)
+		}
+	}
+	return nil
+}
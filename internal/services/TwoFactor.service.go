@@ -0,0 +1,274 @@
+package services
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"goCal/internal/db"
+	"goCal/internal/logger"
+	"goCal/internal/schema"
+	"goCal/internal/utils"
+	"os"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrTwoFactorAlreadyEnabled = errors.New("two-factor authentication is already enabled")
+	ErrTwoFactorNotEnabled     = errors.New("two-factor authentication is not enabled")
+	ErrTwoFactorNotEnrolling   = errors.New("start two-factor setup before confirming it")
+	ErrTwoFactorCodeInvalid    = errors.New("invalid two-factor code")
+)
+
+const recoveryCodeCount = 10
+
+// TwoFactorEnrollment is what the user scans into an authenticator app
+type TwoFactorEnrollment struct {
+	Secret     string `json:"secret"`
+	OtpauthUri string `json:"otpauth_uri"`
+}
+
+type TwoFactorService struct {
+	issuer string
+}
+
+// NewTwoFactorService reads TOTP_ISSUER, the name authenticator apps show
+func NewTwoFactorService() *TwoFactorService {
+	issuer := os.Getenv("TOTP_ISSUER")
+	if issuer == "" {
+		issuer = "GoCal"
+	}
+	return &TwoFactorService{
+		issuer: issuer,
+	}
+}
+
+// BeginEnrollment gives userId a fresh secret. It is not enforced until
+// ConfirmEnrollment proves the authenticator produces matching codes.
+func (tf *TwoFactorService) BeginEnrollment(userId string) (*TwoFactorEnrollment, error) {
+	var user schema.User
+	if err := db.DB.Where("id = ?", userId).First(&user).Error; err != nil {
+		return nil, err
+	}
+	if user.TwoFactorEnabled {
+		return nil, ErrTwoFactorAlreadyEnabled
+	}
+
+	secret, err := utils.GenerateTOTPSecret()
+	if err != nil {
+		return nil, err
+	}
+	if err := db.DB.Model(&user).Updates(map[string]interface{}{
+		"totp_secret":    secret,
+		"totp_last_step": 0,
+	}).Error; err != nil {
+		logger.Error("Failed to store the TOTP secret of user %s: %v", userId, err)
+		return nil, err
+	}
+
+	return &TwoFactorEnrollment{
+		Secret:     secret,
+		OtpauthUri: utils.TOTPURI(tf.issuer, user.Email, secret),
+	}, nil
+}
+
+// ConfirmEnrollment turns 2FA on once code matches the pending secret and
+// returns the recovery codes, which are never shown again
+func (tf *TwoFactorService) ConfirmEnrollment(userId string, code string) ([]string, error) {
+	var codes []string
+	errConfirm := db.DB.Transaction(func(tx *gorm.DB) error {
+		user, err := lockUser(tx, userId)
+		if err != nil {
+			return err
+		}
+		if user.TwoFactorEnabled {
+			return ErrTwoFactorAlreadyEnabled
+		}
+		if user.TotpSecret == "" {
+			return ErrTwoFactorNotEnrolling
+		}
+
+		step, ok := utils.ValidateTOTP(user.TotpSecret, code, time.Now())
+		if !ok {
+			return ErrTwoFactorCodeInvalid
+		}
+		if err := tx.Model(user).Updates(map[string]interface{}{
+			"two_factor_enabled": true,
+			"totp_last_step":     step,
+		}).Error; err != nil {
+			return err
+		}
+
+		codes, err = replaceRecoveryCodes(tx, user)
+		return err
+	})
+	if errConfirm != nil {
+		return nil, errConfirm
+	}
+
+	logger.Info("Two-factor authentication enabled for user %s", userId)
+	return codes, nil
+}
+
+// Verify accepts a current TOTP code or an unused recovery code. Each code
+// only works once.
+func (tf *TwoFactorService) Verify(userId string, code string) error {
+	return db.DB.Transaction(func(tx *gorm.DB) error {
+		user, err := lockUser(tx, userId)
+		if err != nil {
+			return err
+		}
+		return verifyTwoFactorCode(tx, user, code)
+	})
+}
+
+// RegenerateRecoveryCodes replaces every recovery code of userId after a
+// second factor check
+func (tf *TwoFactorService) RegenerateRecoveryCodes(userId string, code string) ([]string, error) {
+	var codes []string
+	errRegenerate := db.DB.Transaction(func(tx *gorm.DB) error {
+		user, err := lockUser(tx, userId)
+		if err != nil {
+			return err
+		}
+		if err := verifyTwoFactorCode(tx, user, code); err != nil {
+			return err
+		}
+		codes, err = replaceRecoveryCodes(tx, user)
+		return err
+	})
+	if errRegenerate != nil {
+		return nil, errRegenerate
+	}
+	return codes, nil
+}
+
+// Disable turns 2FA off for a user who can still present both factors
+func (tf *TwoFactorService) Disable(userId string, password string, code string) error {
+	return db.DB.Transaction(func(tx *gorm.DB) error {
+		user, err := lockUser(tx, userId)
+		if err != nil {
+			return err
+		}
+		if err := utils.CompareHashAndPassword(user.Password, password); err != nil {
+			return ErrIncorrectPassword
+		}
+		if err := verifyTwoFactorCode(tx, user, code); err != nil {
+			return err
+		}
+		return clearTwoFactor(tx, user)
+	})
+}
+
+// Reset turns 2FA off for a user who lost both the authenticator and the
+// recovery codes (admin only)
+func (tf *TwoFactorService) Reset(userId string) (*schema.User, error) {
+	var user *schema.User
+	errReset := db.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		user, err = lockUser(tx, userId)
+		if err != nil {
+			return err
+		}
+		return clearTwoFactor(tx, user)
+	})
+	if errReset != nil {
+		logger.Error("Failed to reset two-factor authentication of user %s: %v", userId, errReset)
+		return nil, errReset
+	}
+
+	logger.Info("Two-factor authentication reset for user %s", userId)
+	user.TwoFactorEnabled = false
+	return user, nil
+}
+
+func lockUser(tx *gorm.DB, userId string) (*schema.User, error) {
+	var user schema.User
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", userId).First(&user).Error; err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// verifyTwoFactorCode checks code against the locked user's TOTP secret and
+// then against the recovery codes, using up whichever matched
+func verifyTwoFactorCode(tx *gorm.DB, user *schema.User, code string) error {
+	if !user.TwoFactorEnabled {
+		return ErrTwoFactorNotEnabled
+	}
+
+	if step, ok := utils.ValidateTOTP(user.TotpSecret, code, time.Now()); ok {
+		// A code seen before, or an older one, may have been shoulder-surfed
+		if step <= user.TotpLastStep {
+			return ErrTwoFactorCodeInvalid
+		}
+		return tx.Model(user).Update("totp_last_step", step).Error
+	}
+
+	result := tx.Model(&schema.RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", user.ID, utils.HashToken(normalizeRecoveryCode(code))).
+		Update("used_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrTwoFactorCodeInvalid
+	}
+	logger.Info("Recovery code used by user %s", user.ID)
+	return nil
+}
+
+func clearTwoFactor(tx *gorm.DB, user *schema.User) error {
+	if err := tx.Model(user).Updates(map[string]interface{}{
+		"two_factor_enabled": false,
+		"totp_secret":        "",
+		"totp_last_step":     0,
+	}).Error; err != nil {
+		return err
+	}
+	return tx.Where("user_id = ?", user.ID).Delete(&schema.RecoveryCode{}).Error
+}
+
+// replaceRecoveryCodes drops the user's recovery codes and stores fresh ones
+func replaceRecoveryCodes(tx *gorm.DB, user *schema.User) ([]string, error) {
+	if err := tx.Where("user_id = ?", user.ID).Delete(&schema.RecoveryCode{}).Error; err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, 0, recoveryCodeCount)
+	rows := make([]schema.RecoveryCode, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		code, err := generateRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+		rows = append(rows, schema.RecoveryCode{
+			UserId:   user.ID,
+			CodeHash: utils.HashToken(normalizeRecoveryCode(code)),
+		})
+	}
+	if err := tx.Create(&rows).Error; err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// generateRecoveryCode returns 50 random bits as "xxxxx-xxxxx"
+func generateRecoveryCode() (string, error) {
+	buf := make([]byte, 10)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	encoded := strings.ToLower(base32.StdEncoding.EncodeToString(buf))[:10]
+	return encoded[:5] + "-" + encoded[5:], nil
+}
+
+// normalizeRecoveryCode lets users type codes without the dash or in capitals
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
@@ -0,0 +1,280 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"goCal/internal/db"
+	"goCal/internal/logger"
+	"goCal/internal/schema"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrUploadNotFound       = errors.New("upload not found")
+	ErrUploadExpired        = errors.New("upload has expired")
+	ErrUploadLocked         = errors.New("upload is already being written to")
+	ErrUploadOffsetMismatch = errors.New("upload offset does not match the server offset")
+	ErrUploadTooLarge       = errors.New("upload exceeds the maximum allowed size")
+)
+
+const (
+	defaultUploadMaxSize = 5 << 30 // 5 GB
+	defaultUploadExpiry  = 24 * time.Hour
+)
+
+// UploadService implements resumable uploads. Chunks are appended to a temp
+// file on local disk and the finished file is pushed to the storage backend.
+type UploadService struct {
+	uploadDir          string
+	maxSize            int64
+	expiry             time.Duration
+	fileService        *FileService
+	fileStorageService *FileStorageService
+	quotaService       *QuotaService
+	locks              sync.Map
+}
+
+func NewUploadService(fileService *FileService, fileStorageService *FileStorageService, quotaService *QuotaService) *UploadService {
+	service := &UploadService{
+		uploadDir:          os.Getenv("TUS_UPLOAD_DIR"),
+		maxSize:            defaultUploadMaxSize,
+		expiry:             defaultUploadExpiry,
+		fileService:        fileService,
+		fileStorageService: fileStorageService,
+		quotaService:       quotaService,
+	}
+
+	if service.uploadDir == "" {
+		service.uploadDir = filepath.Join(os.TempDir(), "gocal-uploads")
+	}
+	if maxSize, err := strconv.ParseInt(os.Getenv("TUS_MAX_SIZE"), 10, 64); err == nil && maxSize > 0 {
+		service.maxSize = maxSize
+	}
+	if expiry, err := time.ParseDuration(os.Getenv("TUS_UPLOAD_EXPIRY")); err == nil && expiry > 0 {
+		service.expiry = expiry
+	}
+	if err := os.MkdirAll(service.uploadDir, 0o755); err != nil {
+		logger.Error("Failed to create upload directory %s: %v", service.uploadDir, err)
+	}
+	return service
+}
+
+func (u *UploadService) MaxSize() int64 {
+	return u.maxSize
+}
+
+func (u *UploadService) CreateUpload(userId string, uploadLength int64, fileName string, fileType string, folderId *uuid.UUID) (*schema.UploadSession, error) {
+	if fileName == "" {
+		return nil, errors.New("filename metadata is required")
+	}
+	if uploadLength < 0 {
+		return nil, errors.New("upload length cannot be negative")
+	}
+	if uploadLength > u.maxSize {
+		return nil, ErrUploadTooLarge
+	}
+	if err := u.quotaService.CheckQuota(userId, uploadLength); err != nil {
+		return nil, err
+	}
+	if folderId != nil {
+		if err := ensureFolderOwner(*folderId, userId); err != nil {
+			return nil, err
+		}
+	}
+
+	session := &schema.UploadSession{
+		UserId:       uuid.MustParse(userId),
+		FileName:     fileName,
+		FileType:     fileType,
+		FolderId:     folderId,
+		UploadLength: uploadLength,
+		ExpiresAt:    time.Now().Add(u.expiry),
+	}
+
+	tempFile, err := os.CreateTemp(u.uploadDir, "upload-*.part")
+	if err != nil {
+		logger.Error("Failed to create upload temp file: %v", err)
+		return nil, fmt.Errorf("failed to create upload: %w", err)
+	}
+	tempFile.Close()
+	session.TempPath = tempFile.Name()
+
+	if err := db.DB.Create(session).Error; err != nil {
+		os.Remove(session.TempPath)
+		logger.Error("Failed to create upload session: %v", err)
+		return nil, err
+	}
+	return session, nil
+}
+
+func (u *UploadService) GetUpload(uploadId string, userId string) (*schema.UploadSession, error) {
+	if _, err := uuid.Parse(uploadId); err != nil {
+		return nil, ErrUploadNotFound
+	}
+
+	var session schema.UploadSession
+	result := db.DB.Where("id = ? AND user_id = ?", uploadId, userId).First(&session)
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return nil, ErrUploadNotFound
+	}
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if session.FileId == nil && time.Now().After(session.ExpiresAt) {
+		return nil, ErrUploadExpired
+	}
+	return &session, nil
+}
+
+// WriteChunk appends body at offset. Whatever arrived before a dropped connection
+// is kept so the client can resume from the new offset. When the last byte lands
+// the upload is finalized and the created file is returned.
+func (u *UploadService) WriteChunk(uploadId string, userId string, offset int64, body io.Reader) (*schema.UploadSession, *schema.File, error) {
+	lock, _ := u.locks.LoadOrStore(uploadId, &sync.Mutex{})
+	mutex := lock.(*sync.Mutex)
+	if !mutex.TryLock() {
+		return nil, nil, ErrUploadLocked
+	}
+	defer mutex.Unlock()
+
+	session, err := u.GetUpload(uploadId, userId)
+	if err != nil {
+		return nil, nil, err
+	}
+	if session.FileId != nil {
+		return session, nil, nil
+	}
+	if offset != session.UploadOffset {
+		return session, nil, ErrUploadOffsetMismatch
+	}
+
+	tempFile, err := os.OpenFile(session.TempPath, os.O_WRONLY, 0o600)
+	if err != nil {
+		logger.Error("Failed to open upload temp file %s: %v", session.TempPath, err)
+		return nil, nil, fmt.Errorf("failed to open upload: %w", err)
+	}
+	// Drop anything past the acknowledged offset left by an interrupted write
+	if err := tempFile.Truncate(offset); err != nil {
+		tempFile.Close()
+		return nil, nil, err
+	}
+	if _, err := tempFile.Seek(offset, io.SeekStart); err != nil {
+		tempFile.Close()
+		return nil, nil, err
+	}
+
+	written, copyErr := io.Copy(tempFile, io.LimitReader(body, session.UploadLength-offset))
+	if err := tempFile.Close(); err != nil && copyErr == nil {
+		copyErr = err
+	}
+
+	session.UploadOffset = offset + written
+	if err := db.DB.Model(session).Update("upload_offset", session.UploadOffset).Error; err != nil {
+		return nil, nil, err
+	}
+	if copyErr != nil {
+		logger.Warn(fmt.Sprintf("Upload %s interrupted at offset %d: %v", uploadId, session.UploadOffset, copyErr))
+		return session, nil, copyErr
+	}
+
+	if session.UploadOffset < session.UploadLength {
+		return session, nil, nil
+	}
+
+	file, err := u.finalize(session)
+	if err != nil {
+		return session, nil, err
+	}
+	return session, file, nil
+}
+
+// finalize pushes the assembled temp file to storage and records it as a File
+func (u *UploadService) finalize(session *schema.UploadSession) (*schema.File, error) {
+	userId := session.UserId.String()
+
+	tempFile, err := os.Open(session.TempPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open upload: %w", err)
+	}
+	storedObject, err := u.fileStorageService.UploadFile(userId, session.FileName, tempFile, session.FileType)
+	tempFile.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	newFile := &schema.File{
+		FolderId:      session.FolderId,
+		FileName:      session.FileName,
+		FileSize:      session.UploadLength,
+		FileType:      session.FileType,
+		StorageBucket: storedObject.Bucket,
+		StorageKey:    storedObject.Key,
+		ContentHash:   storedObject.Hash,
+		UploadedById:  session.UserId,
+	}
+
+	createdFile, err := u.fileService.CreateFile(newFile, userId)
+	if err != nil {
+		u.fileStorageService.ReleaseObject(storedObject.Bucket, storedObject.Key)
+		return nil, err
+	}
+
+	session.FileId = &createdFile.Id
+	if err := db.DB.Model(session).Update("file_id", createdFile.Id).Error; err != nil {
+		logger.Error("Failed to link upload %s to file %s: %v", session.ID, createdFile.Id, err)
+	}
+	if err := os.Remove(session.TempPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		logger.Warn(fmt.Sprintf("Failed to remove upload temp file %s: %v", session.TempPath, err))
+	}
+	u.locks.Delete(session.ID.String())
+
+	return createdFile, nil
+}
+
+func (u *UploadService) TerminateUpload(uploadId string, userId string) error {
+	session, err := u.GetUpload(uploadId, userId)
+	if err != nil && !errors.Is(err, ErrUploadExpired) {
+		return err
+	}
+	if session == nil {
+		// Expired sessions are still removable by their owner
+		if err := db.DB.Where("id = ? AND user_id = ?", uploadId, userId).First(&session).Error; err != nil {
+			return ErrUploadNotFound
+		}
+	}
+	return u.removeSession(session)
+}
+
+func (u *UploadService) removeSession(session *schema.UploadSession) error {
+	if err := os.Remove(session.TempPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		logger.Warn(fmt.Sprintf("Failed to remove upload temp file %s: %v", session.TempPath, err))
+	}
+	u.locks.Delete(session.ID.String())
+	return db.DB.Delete(session).Error
+}
+
+// CleanupExpiredUploads removes abandoned sessions and their temp files
+func (u *UploadService) CleanupExpiredUploads() (int, error) {
+	var sessions []*schema.UploadSession
+	if err := db.DB.Where("expires_at < ?", time.Now()).Find(&sessions).Error; err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	for _, session := range sessions {
+		if err := u.removeSession(session); err != nil {
+			logger.Error("Failed to remove expired upload %s: %v", session.ID, err)
+			continue
+		}
+		removed++
+	}
+	return removed, nil
+}
@@ -1,17 +1,42 @@
 package services
 
 import (
+	"crypto/subtle"
+	"errors"
 	"fmt"
 	"goCal/internal/db"
+	"goCal/internal/emails"
 	"goCal/internal/logger"
+	"goCal/internal/pagination"
+	"goCal/internal/permissions"
 	"goCal/internal/schema"
+	"goCal/internal/utils"
+	"os"
+	"strconv"
 	"time"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrPasswordResetInvalid  = errors.New("password reset token is invalid or has expired")
+	ErrIncorrectPassword     = errors.New("current password is incorrect")
+	ErrInvalidRole           = errors.New("unknown role")
+	ErrLastAdmin             = errors.New("the last admin cannot be demoted")
+	ErrVerifyCodeInvalidated = errors.New("verification code is no longer valid, request a new one")
+	ErrUnsupportedLocale     = errors.New("unsupported locale")
+)
+
+const (
+	defaultPasswordResetTTL  = time.Hour
+	defaultMaxVerifyAttempts = 5
 )
 
 type UserService struct {
-	emailService *EmailService
+	emailService      *EmailService
+	passwordResetTTL  time.Duration
+	maxVerifyAttempts int
 }
 
 func NewUserService() *UserService {
@@ -21,20 +46,71 @@ func NewUserService() *UserService {
 		// You can decide whether to fail completely or continue without email service
 		// For now, we'll log the error and continue
 	}
+
+	passwordResetTTL := defaultPasswordResetTTL
+	if ttl, err := time.ParseDuration(os.Getenv("PASSWORD_RESET_TTL")); err == nil && ttl > 0 {
+		passwordResetTTL = ttl
+	}
+
+	maxVerifyAttempts := defaultMaxVerifyAttempts
+	if attempts, err := strconv.Atoi(os.Getenv("VERIFY_MAX_ATTEMPTS")); err == nil && attempts > 0 {
+		maxVerifyAttempts = attempts
+	}
+
 	return &UserService{
-		emailService: emailService,
+		emailService:      emailService,
+		passwordResetTTL:  passwordResetTTL,
+		maxVerifyAttempts: maxVerifyAttempts,
 	}
 }
 
-func (s *UserService) GetUsers() ([]*schema.User, error) {
-	var users []*schema.User
+// userSorts are the orderings the public user list accepts. Anything that
+// would rank or probe accounts by role, verification or usage is admin only.
+var userSorts = pagination.Sorts{
+	Fields: map[string]pagination.Sort{
+		"created_at": {Column: "created_at", Kind: pagination.Time},
+		"username":   {Column: "username", Kind: pagination.String},
+		"email":      {Column: "email", Kind: pagination.String},
+	},
+	Default: "created_at",
+}
+
+// deletedUserSorts adds storage_used and deleted_at for the admin's deleted list
+var deletedUserSorts = pagination.Sorts{
+	Fields: map[string]pagination.Sort{
+		"created_at":   userSorts.Fields["created_at"],
+		"username":     userSorts.Fields["username"],
+		"email":        userSorts.Fields["email"],
+		"storage_used": {Column: "storage_used", Kind: pagination.Int},
+		"deleted_at":   {Column: "deleted_at", Kind: pagination.Time},
+	},
+	Default:     "deleted_at",
+	DefaultDesc: true,
+}
+
+// filterDeletedUsers applies the admin only filters on top of the public ones
+func filterDeletedUsers(tx *gorm.DB, query *schema.DeletedUserListQuery) (*gorm.DB, error) {
+	if query.Role != "" {
+		if !permissions.IsValidRole(query.Role) {
+			return nil, fmt.Errorf("%w: unknown role %q", ErrInvalidListFilter, query.Role)
+		}
+		tx = tx.Where("role = ?", query.Role)
+	}
+	if query.Verified != nil {
+		tx = tx.Where("is_verified = ?", *query.Verified)
+	}
+	return createdBetween(tx, "created_at", query.CreatedAfter, query.CreatedBefore), nil
+}
+
+func (s *UserService) GetUsers(query *schema.UserListQuery) ([]*schema.User, *pagination.Page, error) {
 	// This automatically excludes soft-deleted records due to GORM's default behavior
-	result := db.DB.Find(&users)
-	if result.Error != nil {
-		logger.Error(`Failed to get Users %w`, result.Error)
-		return nil, result.Error
+	tx := createdBetween(db.DB.Model(&schema.User{}), "created_at", query.CreatedAfter, query.CreatedBefore)
+	users, page, err := pagination.Find[*schema.User](tx, query.Params, userSorts, "id")
+	if err != nil {
+		logger.Error(`Failed to get Users %w`, err)
+		return nil, nil, err
 	}
-	return users, nil
+	return users, page, nil
 }
 
 func (s *UserService) GetUser(id string) (*schema.User, error) {
@@ -68,15 +144,18 @@ func (s *UserService) GetUserByEmailIncludingDeleted(email string) (*schema.User
 	return user, nil
 }
 
-// GetSoftDeletedUsers returns all soft-deleted users
-func (s *UserService) GetSoftDeletedUsers() ([]*schema.User, error) {
-	var users []*schema.User
-	result := db.DB.Unscoped().Where("deleted_at IS NOT NULL").Find(&users)
-	if result.Error != nil {
-		logger.Error(`Failed to get soft-deleted users %w`, result.Error)
-		return nil, result.Error
+// GetSoftDeletedUsers returns a page of soft-deleted users
+func (s *UserService) GetSoftDeletedUsers(query *schema.DeletedUserListQuery) ([]*schema.User, *pagination.Page, error) {
+	tx, err := filterDeletedUsers(db.DB.Model(&schema.User{}).Unscoped().Where("deleted_at IS NOT NULL"), query)
+	if err != nil {
+		return nil, nil, err
 	}
-	return users, nil
+	users, page, err := pagination.Find[*schema.User](tx, query.Params, deletedUserSorts, "id")
+	if err != nil {
+		logger.Error(`Failed to get soft-deleted users %w`, err)
+		return nil, nil, err
+	}
+	return users, page, nil
 }
 
 // RestoreUser restores a soft-deleted user
@@ -115,6 +194,8 @@ func (s *UserService) PermanentlyDeleteUser(id string) error {
 }
 
 func (s *UserService) CreateUser(newUser *schema.User) (*schema.User, error) {
+	newUser.Locale = emails.NormalizeLocale(newUser.Locale)
+
 	// Check if user with this email exists (including soft-deleted)
 	var existingUser *schema.User
 	err := db.DB.Unscoped().Where("email = ?", newUser.Email).First(&existingUser).Error
@@ -128,6 +209,7 @@ func (s *UserService) CreateUser(newUser *schema.User) (*schema.User, error) {
 			existingUser.Password = newUser.Password
 			existingUser.ProfileUrl = newUser.ProfileUrl
 			existingUser.CustomLink = newUser.CustomLink
+			existingUser.Locale = newUser.Locale
 
 			if err := db.DB.Save(&existingUser).Error; err != nil {
 				return nil, err
@@ -135,11 +217,9 @@ func (s *UserService) CreateUser(newUser *schema.User) (*schema.User, error) {
 
 			// Send verification email for restored user
 			if s.emailService != nil {
-				go func() {
-					if _, err := s.emailService.SendVerificationEmail(existingUser); err != nil {
-						logger.Error("Failed to send verification email to restored user %s: %v", existingUser.Email, err)
-					}
-				}()
+				if _, err := s.emailService.SendVerificationEmail(existingUser); err != nil {
+					logger.Error("Failed to queue verification email for restored user %s: %v", existingUser.Email, err)
+				}
 			}
 
 			return existingUser, nil
@@ -157,13 +237,11 @@ func (s *UserService) CreateUser(newUser *schema.User) (*schema.User, error) {
 
 	// Send verification email for new user
 	if s.emailService != nil {
-		go func() {
-			if emailResponse, err := s.emailService.SendVerificationEmail(newUser); err != nil {
-				logger.Error("Failed to send verification email to new user %s: %v", newUser.Email, err)
-			} else {
-				logger.Info("Verification email queued for user %s: %s", newUser.Email, emailResponse.Message)
-			}
-		}()
+		if emailResponse, err := s.emailService.SendVerificationEmail(newUser); err != nil {
+			logger.Error("Failed to queue verification email for new user %s: %v", newUser.Email, err)
+		} else {
+			logger.Info("Verification email queued for user %s: %s", newUser.Email, emailResponse.Message)
+		}
 	} else {
 		logger.Warn("Email service not available - verification email not sent for user: %s", newUser.Email)
 	}
@@ -198,6 +276,12 @@ func (s *UserService) UpdateUser(id string, updateRequest *schema.UpdateUserRequ
 	if updateRequest.CustomLink != nil {
 		updateFields["custom_link"] = *updateRequest.CustomLink
 	}
+	if updateRequest.Locale != nil {
+		if !emails.IsSupportedLocale(*updateRequest.Locale) {
+			return nil, fmt.Errorf("%w, choose one of %v", ErrUnsupportedLocale, emails.Locales())
+		}
+		updateFields["locale"] = *updateRequest.Locale
+	}
 
 	// Update only the specified fields
 	if len(updateFields) > 0 {
@@ -238,8 +322,17 @@ func (s *UserService) ResendVerificationEmail(email string) (*EmailResponse, err
 		}, fmt.Errorf("Already Verified")
 	}
 
-	user.VerifyCode = fmt.Sprintf("%40d")
-	user.CodeExpiry = time.Now().Add(15 * time.Minute)
+	code, err := utils.GenerateCode(4)
+	if err != nil {
+		return &EmailResponse{
+			Success: false,
+			Message: "Failed to update verification code",
+			Error:   err.Error(),
+		}, err
+	}
+	user.VerifyCode = code
+	user.CodeExpiry = time.Now().Add(schema.VerifyCodeTTL)
+	user.VerifyTries = 0
 
 	if err := db.DB.Save(user).Error; err != nil {
 		return &EmailResponse{
@@ -263,21 +356,207 @@ func (s *UserService) VerifyUser(email, verificationCode string) (*schema.User,
 		return user, nil
 	}
 
-	if user.VerifyCode != verificationCode {
-		return nil, fmt.Errorf("Invalid Verification Code")
+	// Every guess takes an attempt in a single conditional update, so parallel
+	// requests can't each pass the check before any of them is counted
+	var claimed []schema.User
+	result := db.DB.Model(&claimed).
+		Clauses(clause.Returning{}).
+		Where("id = ? AND verify_code <> '' AND verify_tries < ?", user.ID, s.maxVerifyAttempts).
+		Update("verify_tries", gorm.Expr("verify_tries + 1"))
+	if result.Error != nil {
+		return nil, result.Error
 	}
+	if result.RowsAffected == 0 || len(claimed) == 0 {
+		return nil, ErrVerifyCodeInvalidated
+	}
+	current := &claimed[0]
 
-	if time.Now().After(user.CodeExpiry) {
+	if subtle.ConstantTimeCompare([]byte(current.VerifyCode), []byte(verificationCode)) != 1 {
+		if current.VerifyTries < s.maxVerifyAttempts {
+			return nil, fmt.Errorf("Invalid Verification Code")
+		}
+		// Too many wrong guesses burn the code, a new one has to be requested
+		if err := db.DB.Model(&schema.User{}).
+			Where("id = ? AND verify_tries >= ?", user.ID, s.maxVerifyAttempts).
+			Update("verify_code", "").Error; err != nil {
+			return nil, err
+		}
+		logger.Warn("Verification code of %s invalidated after %d wrong guesses", user.Email, s.maxVerifyAttempts)
+		return nil, ErrVerifyCodeInvalidated
+	}
+
+	if time.Now().After(current.CodeExpiry) {
 		return nil, fmt.Errorf("verification code has expired")
 	}
 
+	// Only the code that was checked is consumed, not one resent in the meantime
+	verified := db.DB.Model(&schema.User{}).
+		Where("id = ? AND verify_code = ?", user.ID, current.VerifyCode).
+		Updates(map[string]interface{}{"is_verified": true, "verify_code": "", "verify_tries": 0})
+	if verified.Error != nil {
+		return nil, fmt.Errorf("failed to update user verification status: %w", verified.Error)
+	}
+	if verified.RowsAffected == 0 {
+		return nil, ErrVerifyCodeInvalidated
+	}
 	user.IsVerified = true
 	user.VerifyCode = ""
+	user.VerifyTries = 0
 
-	if err := db.DB.Save(user).Error; err != nil {
-		return nil, fmt.Errorf("failed to update user verification status: %w", err)
+	logger.Info("User %s successfully verified", user.Email)
+	return user, nil
+}
+
+// RequestPasswordReset emails a single-use reset token. It succeeds whether or
+// not the email belongs to a user so callers can't probe for accounts, and any
+// token sent earlier stops working.
+func (s *UserService) RequestPasswordReset(email string) error {
+	user, err := s.GetUserByEmail(email)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		logger.Info("Password reset requested for unknown email %s", email)
+		return nil
+	}
+	if err != nil {
+		return err
 	}
 
-	logger.Info("User %s successfully verified", user.Email)
+	if s.emailService == nil {
+		return fmt.Errorf("Email Service Not Available")
+	}
+
+	token, err := utils.GenerateToken(32)
+	if err != nil {
+		return err
+	}
+
+	errCreate := db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&schema.PasswordResetToken{}).
+			Where("user_id = ? AND used_at IS NULL", user.ID).
+			Update("used_at", time.Now()).Error; err != nil {
+			return err
+		}
+		return tx.Create(&schema.PasswordResetToken{
+			UserId:    user.ID,
+			TokenHash: utils.HashToken(token),
+			ExpiresAt: time.Now().Add(s.passwordResetTTL),
+		}).Error
+	})
+	if errCreate != nil {
+		logger.Error("Failed to create password reset token for %s: %v", user.Email, errCreate)
+		return errCreate
+	}
+
+	if _, err := s.emailService.SendPasswordResetEmail(user, token, s.passwordResetTTL); err != nil {
+		logger.Error("Failed to queue password reset email to %s: %v", user.Email, err)
+	}
+	return nil
+}
+
+// ResetPassword sets a new password with an emailed token, uses the token up
+// and signs the user out everywhere
+func (s *UserService) ResetPassword(token string, newPassword string) error {
+	hashedPassword, err := utils.HashPassword(newPassword)
+	if err != nil {
+		return err
+	}
+
+	return db.DB.Transaction(func(tx *gorm.DB) error {
+		var reset schema.PasswordResetToken
+		result := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("token_hash = ? AND used_at IS NULL AND expires_at > ?", utils.HashToken(token), time.Now()).
+			Limit(1).Find(&reset)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrPasswordResetInvalid
+		}
+
+		if err := tx.Model(&reset).Update("used_at", time.Now()).Error; err != nil {
+			return err
+		}
+
+		// Soft-deleted users can't reset their password
+		updated := tx.Model(&schema.User{}).Where("id = ?", reset.UserId).Update("password", hashedPassword)
+		if updated.Error != nil {
+			return updated.Error
+		}
+		if updated.RowsAffected == 0 {
+			return ErrPasswordResetInvalid
+		}
+
+		if err := revokeUserSessions(tx, reset.UserId).Error; err != nil {
+			return err
+		}
+		logger.Info("Password reset for user %s", reset.UserId)
+		return nil
+	})
+}
+
+// ChangePassword replaces the password of userId after checking the current
+// one and signs the user out everywhere
+func (s *UserService) ChangePassword(userId string, currentPassword string, newPassword string) (*schema.User, error) {
+	user, err := s.GetUser(userId)
+	if err != nil {
+		return nil, err
+	}
+	if err := utils.CompareHashAndPassword(user.Password, currentPassword); err != nil {
+		return nil, ErrIncorrectPassword
+	}
+
+	hashedPassword, err := utils.HashPassword(newPassword)
+	if err != nil {
+		return nil, err
+	}
+
+	errChange := db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&schema.User{}).Where("id = ?", user.ID).Update("password", hashedPassword).Error; err != nil {
+			return err
+		}
+		// Reset links sent before the change must not undo it
+		if err := tx.Model(&schema.PasswordResetToken{}).
+			Where("user_id = ? AND used_at IS NULL", user.ID).
+			Update("used_at", time.Now()).Error; err != nil {
+			return err
+		}
+		return revokeUserSessions(tx, user.ID).Error
+	})
+	if errChange != nil {
+		logger.Error("Failed to change the password of user %s: %v", userId, errChange)
+		return nil, errChange
+	}
+	return user, nil
+}
+
+// SetRole promotes or demotes a user. The last admin can't be demoted, so
+// there is always someone left to manage roles.
+func (s *UserService) SetRole(id string, role string) (*schema.User, error) {
+	if !permissions.IsValidRole(role) {
+		return nil, fmt.Errorf("%w %q", ErrInvalidRole, role)
+	}
+
+	var user *schema.User
+	errRole := db.DB.Transaction(func(tx *gorm.DB) error {
+		// Locking every admin serialises concurrent demotions
+		var admins []schema.User
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("role = ?", permissions.RoleAdmin).Find(&admins).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Where("id = ?", id).First(&user).Error; err != nil {
+			return err
+		}
+		if user.Role == permissions.RoleAdmin && role != permissions.RoleAdmin && len(admins) <= 1 {
+			return ErrLastAdmin
+		}
+		return tx.Model(user).Update("role", role).Error
+	})
+	if errRole != nil {
+		logger.Error("Failed to set the role of user %s: %v", id, errRole)
+		return nil, errRole
+	}
+
+	logger.Info("User %s is now %s", id, role)
 	return user, nil
 }
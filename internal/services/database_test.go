@@ -0,0 +1,169 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"goCal/internal/db"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	gormlogger "gorm.io/gorm/logger"
+)
+
+// fakeResult is what a fakeConn answers a statement with
+type fakeResult struct {
+	columns  []string
+	rows     [][]driver.Value
+	affected int64
+	err      error
+}
+
+// fakeConn is a database/sql driver that hands every statement to handle, so
+// services run their real queries without a Postgres server. Values reach
+// handle after their driver.Valuer, the way pgx receives them.
+type fakeConn struct {
+	mu     sync.Mutex
+	handle func(query string, args []driver.Value) fakeResult
+}
+
+func (c *fakeConn) Prepare(string) (driver.Stmt, error) {
+	return nil, errors.New("prepare is not supported")
+}
+func (c *fakeConn) Close() error              { return nil }
+func (c *fakeConn) Begin() (driver.Tx, error) { return fakeTx{}, nil }
+func (c *fakeConn) BeginTx(context.Context, driver.TxOptions) (driver.Tx, error) {
+	return fakeTx{}, nil
+}
+
+// CheckNamedValue lets every argument through, like pgx does
+func (c *fakeConn) CheckNamedValue(value *driver.NamedValue) error {
+	if valuer, ok := value.Value.(driver.Valuer); ok {
+		v, err := valuer.Value()
+		if err != nil {
+			return err
+		}
+		value.Value = v
+	}
+	return nil
+}
+
+func (c *fakeConn) run(query string, args []driver.NamedValue) fakeResult {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	values := make([]driver.Value, len(args))
+	for i, arg := range args {
+		values[i] = arg.Value
+	}
+	return c.handle(query, values)
+}
+
+func (c *fakeConn) QueryContext(_ context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	result := c.run(query, args)
+	if result.err != nil {
+		return nil, result.err
+	}
+	return &fakeRows{columns: result.columns, values: result.rows}, nil
+}
+
+func (c *fakeConn) ExecContext(_ context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	result := c.run(query, args)
+	if result.err != nil {
+		return nil, result.err
+	}
+	return driver.RowsAffected(result.affected), nil
+}
+
+type fakeTx struct{}
+
+func (fakeTx) Commit() error   { return nil }
+func (fakeTx) Rollback() error { return nil }
+
+type fakeRows struct {
+	columns []string
+	values  [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string { return r.columns }
+func (r *fakeRows) Close() error      { return nil }
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.values[0])
+	r.values = r.values[1:]
+	return nil
+}
+
+// fakeConnector hands each test its own fakeConn, keyed by the DSN
+type fakeConnector struct{}
+
+var (
+	fakeConns        sync.Map
+	registerFakeOnce sync.Once
+)
+
+func (fakeConnector) Open(name string) (driver.Conn, error) {
+	conn, ok := fakeConns.Load(name)
+	if !ok {
+		return nil, errors.New("no fake connection for " + name)
+	}
+	return conn.(*fakeConn), nil
+}
+
+// useFakeDB points db.DB at a fakeConn answering with handle for the rest of the test
+func useFakeDB(t *testing.T, handle func(query string, args []driver.Value) fakeResult) {
+	t.Helper()
+	registerFakeOnce.Do(func() { sql.Register("services-fake", fakeConnector{}) })
+	fakeConns.Store(t.Name(), &fakeConn{handle: handle})
+	t.Cleanup(func() { fakeConns.Delete(t.Name()) })
+
+	sqlDB, err := sql.Open("services-fake", t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sqlDB.Close() })
+
+	gormDB, err := gorm.Open(postgres.New(postgres.Config{Conn: sqlDB}), &gorm.Config{
+		DisableAutomaticPing: true,
+		Logger:               gormlogger.Discard,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := db.DB
+	db.DB = gormDB
+	t.Cleanup(func() { db.DB = previous })
+}
+
+var insertColumns = regexp.MustCompile(`^INSERT INTO "\w+" \(([^)]*)\)`)
+
+// insertedRow maps the columns of an INSERT statement to its arguments
+func insertedRow(query string, args []driver.Value) map[string]driver.Value {
+	match := insertColumns.FindStringSubmatch(query)
+	if match == nil {
+		return nil
+	}
+	row := map[string]driver.Value{}
+	for i, column := range strings.Split(match[1], ",") {
+		if i < len(args) {
+			row[strings.Trim(column, `" `)] = args[i]
+		}
+	}
+	return row
+}
+
+// selectRow answers a query with one row holding the given columns of row
+func selectRow(row map[string]driver.Value, columns ...string) fakeResult {
+	values := make([]driver.Value, len(columns))
+	for i, column := range columns {
+		values[i] = row[column]
+	}
+	return fakeResult{columns: columns, rows: [][]driver.Value{values}}
+}
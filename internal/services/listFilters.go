@@ -0,0 +1,27 @@
+package services
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var ErrInvalidListFilter = errors.New("invalid filter")
+
+// createdBetween limits column to [after, before], either bound may be nil
+func createdBetween(tx *gorm.DB, column string, after *time.Time, before *time.Time) *gorm.DB {
+	if after != nil {
+		tx = tx.Where(column+" >= ?", *after)
+	}
+	if before != nil {
+		tx = tx.Where(column+" <= ?", *before)
+	}
+	return tx
+}
+
+// escapeLike makes value match literally inside a LIKE pattern
+func escapeLike(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(value)
+}
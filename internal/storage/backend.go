@@ -0,0 +1,54 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"time"
+)
+
+const (
+	BucketOthers = "goCal-Other-Bucket"
+	BucketDocs   = "goCal-Docs-Bucket"
+	BucketAlbums = "goCal-Albums-Bucket"
+	BucketAudios = "goCal-Audios-Bucket"
+	BucketVideos = "goCal-Videos-Bucket"
+)
+
+// Buckets lists every bucket the application writes to
+var Buckets = []string{BucketOthers, BucketDocs, BucketAlbums, BucketAudios, BucketVideos}
+
+var ErrObjectNotFound = errors.New("object not found")
+
+// ObjectInfo describes a stored object
+type ObjectInfo struct {
+	Key          string    `json:"key"`
+	Size         int64     `json:"size"`
+	ContentType  string    `json:"content_type"`
+	ETag         string    `json:"etag"`
+	LastModified time.Time `json:"last_modified"`
+}
+
+// StorageBackend is implemented by every blob store the file API can run against
+type StorageBackend interface {
+	// Name returns the driver name, e.g. "supabase" or "local"
+	Name() string
+	EnsureBucket(bucket string) error
+	Put(bucket string, key string, data io.Reader, contentType string) error
+	Get(bucket string, key string) (io.ReadCloser, error)
+	// GetRange reads length bytes starting at offset
+	GetRange(bucket string, key string, offset int64, length int64) (io.ReadCloser, error)
+	Delete(bucket string, keys ...string) error
+	Stat(bucket string, key string) (*ObjectInfo, error)
+	List(bucket string, prefix string) ([]ObjectInfo, error)
+	Presign(bucket string, key string, expiresIn time.Duration) (string, error)
+}
+
+type limitedReadCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// limitReadCloser caps reads at n bytes while still closing the underlying reader
+func limitReadCloser(reader io.ReadCloser, n int64) io.ReadCloser {
+	return limitedReadCloser{Reader: io.LimitReader(reader, n), Closer: reader}
+}
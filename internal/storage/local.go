@@ -0,0 +1,231 @@
+package storage
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LocalBackend stores objects on the local filesystem under root/<bucket>/<key>.
+// Presigned URLs are served back through the /api/storage route, see StorageController.
+type LocalBackend struct {
+	root       string
+	baseURL    string
+	signingKey []byte
+}
+
+func NewLocalBackend(root string, baseURL string, signingKey string) (*LocalBackend, error) {
+	if root == "" {
+		return nil, errors.New("local storage root is required")
+	}
+	if signingKey == "" {
+		return nil, errors.New("local storage signing key is required")
+	}
+	absRoot, err := filepath.Abs(root)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve local storage root: %w", err)
+	}
+	if err := os.MkdirAll(absRoot, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create local storage root: %w", err)
+	}
+
+	return &LocalBackend{
+		root:       absRoot,
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		signingKey: []byte(signingKey),
+	}, nil
+}
+
+func (l *LocalBackend) Name() string {
+	return "local"
+}
+
+func (l *LocalBackend) EnsureBucket(bucket string) error {
+	dir, err := l.resolve(bucket, "")
+	if err != nil {
+		return err
+	}
+	return os.MkdirAll(dir, 0o755)
+}
+
+func (l *LocalBackend) Put(bucket string, key string, data io.Reader, contentType string) error {
+	target, err := l.resolve(bucket, key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return fmt.Errorf("failed to create directory for %s/%s: %w", bucket, key, err)
+	}
+
+	// Write to a temp file first so readers never observe a partial object
+	tmp, err := os.CreateTemp(filepath.Dir(target), ".upload-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write %s/%s: %w", bucket, key, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write %s/%s: %w", bucket, key, err)
+	}
+	return os.Rename(tmp.Name(), target)
+}
+
+func (l *LocalBackend) Get(bucket string, key string) (io.ReadCloser, error) {
+	target, err := l.resolve(bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(target)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrObjectNotFound
+	}
+	return file, err
+}
+
+func (l *LocalBackend) GetRange(bucket string, key string, offset int64, length int64) (io.ReadCloser, error) {
+	reader, err := l.Get(bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	file := reader.(*os.File)
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return limitReadCloser(file, length), nil
+}
+
+func (l *LocalBackend) Delete(bucket string, keys ...string) error {
+	for _, key := range keys {
+		target, err := l.resolve(bucket, key)
+		if err != nil {
+			return err
+		}
+		if err := os.Remove(target); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("failed to delete %s/%s: %w", bucket, key, err)
+		}
+	}
+	return nil
+}
+
+func (l *LocalBackend) Stat(bucket string, key string) (*ObjectInfo, error) {
+	target, err := l.resolve(bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	stat, err := os.Stat(target)
+	if errors.Is(err, fs.ErrNotExist) || (err == nil && stat.IsDir()) {
+		return nil, ErrObjectNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return l.objectInfo(key, stat), nil
+}
+
+func (l *LocalBackend) List(bucket string, prefix string) ([]ObjectInfo, error) {
+	bucketDir, err := l.resolve(bucket, "")
+	if err != nil {
+		return nil, err
+	}
+
+	var infos []ObjectInfo
+	err = filepath.WalkDir(bucketDir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || strings.HasPrefix(d.Name(), ".upload-") {
+			return nil
+		}
+		rel, err := filepath.Rel(bucketDir, p)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+		stat, err := d.Info()
+		if err != nil {
+			return err
+		}
+		infos = append(infos, *l.objectInfo(key, stat))
+		return nil
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		return []ObjectInfo{}, nil
+	}
+	return infos, err
+}
+
+func (l *LocalBackend) Presign(bucket string, key string, expiresIn time.Duration) (string, error) {
+	if _, err := l.resolve(bucket, key); err != nil {
+		return "", err
+	}
+	expires := time.Now().Add(expiresIn).Unix()
+	query := url.Values{}
+	query.Set("expires", strconv.FormatInt(expires, 10))
+	query.Set("signature", l.sign(bucket, key, expires))
+	return l.baseURL + "/api/storage/" + url.PathEscape(bucket) + "/" + escapeKey(key) + "?" + query.Encode(), nil
+}
+
+// VerifySignature checks a signature produced by Presign
+func (l *LocalBackend) VerifySignature(bucket string, key string, expires int64, signature string) bool {
+	if time.Now().Unix() > expires {
+		return false
+	}
+	expected := l.sign(bucket, key, expires)
+	return hmac.Equal([]byte(expected), []byte(signature))
+}
+
+func (l *LocalBackend) sign(bucket string, key string, expires int64) string {
+	mac := hmac.New(sha256.New, l.signingKey)
+	fmt.Fprintf(mac, "%s\n%s\n%d", bucket, key, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// resolve maps a bucket/key pair onto a path inside root, rejecting traversal
+func (l *LocalBackend) resolve(bucket string, key string) (string, error) {
+	if bucket == "" || strings.ContainsAny(bucket, `/\`) || bucket == "." || bucket == ".." {
+		return "", fmt.Errorf("invalid bucket name %q", bucket)
+	}
+	cleanKey := path.Clean("/" + key)
+	if key != "" && (cleanKey == "/" || strings.Contains(key, "..")) {
+		return "", fmt.Errorf("invalid object key %q", key)
+	}
+	return filepath.Join(l.root, bucket, filepath.FromSlash(cleanKey)), nil
+}
+
+func (l *LocalBackend) objectInfo(key string, stat fs.FileInfo) *ObjectInfo {
+	return &ObjectInfo{
+		Key:          key,
+		Size:         stat.Size(),
+		ContentType:  mime.TypeByExtension(path.Ext(key)),
+		ETag:         fmt.Sprintf("%x-%x", stat.ModTime().UnixNano(), stat.Size()),
+		LastModified: stat.ModTime(),
+	}
+}
+
+func escapeKey(key string) string {
+	segments := strings.Split(key, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return strings.Join(segments, "/")
+}
@@ -0,0 +1,189 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	storage_go "github.com/supabase-community/storage-go"
+)
+
+// SupabaseBackend stores objects in Supabase Storage
+type SupabaseBackend struct {
+	client  *storage_go.Client
+	baseURL string
+}
+
+func NewSupabaseBackend(projectURL string, key string) *SupabaseBackend {
+	baseURL := strings.TrimRight(projectURL, "/") + "/storage/v1"
+	return &SupabaseBackend{
+		client:  storage_go.NewClient(baseURL, key, nil),
+		baseURL: baseURL,
+	}
+}
+
+func (s *SupabaseBackend) Name() string {
+	return "supabase"
+}
+
+// EnsureBucket creates the bucket as private, and makes buckets left public by
+// older deployments private; objects are only served through the file API.
+func (s *SupabaseBackend) EnsureBucket(bucket string) error {
+	if existing, err := s.client.GetBucket(bucket); err == nil {
+		if existing.Public {
+			if _, err := s.client.UpdateBucket(bucket, storage_go.BucketOptions{Public: false}); err != nil {
+				return fmt.Errorf("failed to make bucket %s private: %w", bucket, err)
+			}
+		}
+		return nil
+	}
+
+	_, err := s.client.CreateBucket(bucket, storage_go.BucketOptions{
+		Public:        false,
+		FileSizeLimit: "100",
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create bucket %s: %w", bucket, err)
+	}
+	return nil
+}
+
+func (s *SupabaseBackend) Put(bucket string, key string, data io.Reader, contentType string) error {
+	options := storage_go.FileOptions{}
+	if contentType != "" {
+		options.ContentType = &contentType
+	}
+	if _, err := s.client.UploadFile(bucket, key, data, options); err != nil {
+		return fmt.Errorf("failed to upload %s/%s: %w", bucket, key, err)
+	}
+	return nil
+}
+
+func (s *SupabaseBackend) Get(bucket string, key string) (io.ReadCloser, error) {
+	req, err := s.client.NewRequest(http.MethodGet, s.objectURL(bucket, key))
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := s.client.Do(req, nil)
+	if err != nil {
+		return nil, s.mapError(res, err)
+	}
+	return res.Body, nil
+}
+
+func (s *SupabaseBackend) GetRange(bucket string, key string, offset int64, length int64) (io.ReadCloser, error) {
+	req, err := s.client.NewRequest(http.MethodGet, s.objectURL(bucket, key))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+
+	res, err := s.client.Do(req, nil)
+	if err != nil {
+		return nil, s.mapError(res, err)
+	}
+	if res.StatusCode == http.StatusOK {
+		// The server ignored the range, skip to the requested window ourselves
+		if _, err := io.CopyN(io.Discard, res.Body, offset); err != nil {
+			res.Body.Close()
+			return nil, err
+		}
+	}
+	return limitReadCloser(res.Body, length), nil
+}
+
+func (s *SupabaseBackend) Delete(bucket string, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	if _, err := s.client.RemoveFile(bucket, keys); err != nil {
+		return fmt.Errorf("failed to delete objects from %s: %w", bucket, err)
+	}
+	return nil
+}
+
+func (s *SupabaseBackend) Stat(bucket string, key string) (*ObjectInfo, error) {
+	req, err := s.client.NewRequest(http.MethodHead, s.objectURL(bucket, key))
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := s.client.Do(req, nil)
+	if err != nil {
+		return nil, s.mapError(res, err)
+	}
+	defer res.Body.Close()
+
+	info := &ObjectInfo{
+		Key:         key,
+		ContentType: res.Header.Get("Content-Type"),
+		ETag:        strings.Trim(res.Header.Get("ETag"), `"`),
+	}
+	if size, err := strconv.ParseInt(res.Header.Get("Content-Length"), 10, 64); err == nil {
+		info.Size = size
+	}
+	if modified, err := http.ParseTime(res.Header.Get("Last-Modified")); err == nil {
+		info.LastModified = modified
+	}
+	return info, nil
+}
+
+func (s *SupabaseBackend) List(bucket string, prefix string) ([]ObjectInfo, error) {
+	objects, err := s.client.ListFiles(bucket, prefix, storage_go.FileSearchOptions{Limit: 1000})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s/%s: %w", bucket, prefix, err)
+	}
+
+	infos := make([]ObjectInfo, 0, len(objects))
+	for _, object := range objects {
+		info := ObjectInfo{Key: strings.TrimPrefix(prefix+"/"+object.Name, "/")}
+		if metadata, ok := object.Metadata.(map[string]interface{}); ok {
+			if size, ok := metadata["size"].(float64); ok {
+				info.Size = int64(size)
+			}
+			if mimeType, ok := metadata["mimetype"].(string); ok {
+				info.ContentType = mimeType
+			}
+			if eTag, ok := metadata["eTag"].(string); ok {
+				info.ETag = strings.Trim(eTag, `"`)
+			}
+		}
+		if modified, err := time.Parse(time.RFC3339, object.UpdatedAt); err == nil {
+			info.LastModified = modified
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+func (s *SupabaseBackend) Presign(bucket string, key string, expiresIn time.Duration) (string, error) {
+	signed, err := s.client.CreateSignedUrl(bucket, key, int(expiresIn.Seconds()))
+	if err != nil {
+		return "", fmt.Errorf("failed to sign %s/%s: %w", bucket, key, err)
+	}
+	return signed.SignedURL, nil
+}
+
+func (s *SupabaseBackend) objectURL(bucket string, key string) string {
+	return s.baseURL + "/object/authenticated/" + bucket + "/" + key
+}
+
+func (s *SupabaseBackend) mapError(res *http.Response, err error) error {
+	if res != nil {
+		if res.Body != nil {
+			res.Body.Close()
+		}
+		if res.StatusCode == http.StatusNotFound || res.StatusCode == http.StatusBadRequest {
+			return ErrObjectNotFound
+		}
+	}
+	if errors.Is(err, ErrObjectNotFound) {
+		return err
+	}
+	return fmt.Errorf("supabase storage request failed: %w", err)
+}
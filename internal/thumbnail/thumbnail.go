@@ -0,0 +1,189 @@
+// Package thumbnail decodes uploaded images and scales them down to the
+// preview sizes clients show in grids, turned upright per their EXIF orientation
+package thumbnail
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"goCal/internal/exif"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"io"
+	"sort"
+	"strings"
+
+	xdraw "golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+const (
+	// DefaultSize is served when the client doesn't ask for one
+	DefaultSize = "medium"
+	ContentType = "image/jpeg"
+
+	// Larger images are refused before decoding, a small file can claim
+	// huge dimensions and take the server's memory with it
+	maxPixels    = 50_000_000
+	maxFileBytes = 64 << 20
+	jpegQuality  = 85
+)
+
+var (
+	ErrUnsupported = errors.New("thumbnails are only generated for JPEG, PNG, GIF and WebP images")
+	ErrTooLarge    = errors.New("image is too large to generate thumbnails for")
+)
+
+// Sizes maps each size name to the longest edge of its thumbnails in pixels
+var Sizes = map[string]int{
+	"small":  160,
+	"medium": 480,
+	"large":  1280,
+}
+
+// SizeNames lists the size names from smallest to largest
+func SizeNames() []string {
+	names := make([]string, 0, len(Sizes))
+	for name := range Sizes {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool { return Sizes[names[i]] < Sizes[names[j]] })
+	return names
+}
+
+// Supported reports whether thumbnails can be made for a file of this MIME type
+func Supported(fileType string) bool {
+	switch strings.ToLower(strings.TrimSpace(strings.SplitN(fileType, ";", 2)[0])) {
+	case "image/jpeg", "image/jpg", "image/png", "image/gif", "image/webp":
+		return true
+	}
+	return false
+}
+
+// Source is a decoded image along with how it has to be turned to stand upright
+type Source struct {
+	img         image.Image
+	orientation int
+}
+
+// Decode reads an image and its EXIF orientation. GIFs yield their first frame.
+func Decode(r io.Reader) (*Source, error) {
+	data, err := io.ReadAll(io.LimitReader(r, maxFileBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxFileBytes {
+		return nil, ErrTooLarge
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		if errors.Is(err, image.ErrFormat) {
+			return nil, ErrUnsupported
+		}
+		return nil, err
+	}
+	if config.Width <= 0 || config.Height <= 0 || config.Width*config.Height > maxPixels {
+		return nil, ErrTooLarge
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image: %w", err)
+	}
+
+	source := &Source{img: img, orientation: 1}
+	if tags, err := exif.Read(data); err == nil {
+		source.orientation = tags.Orientation()
+	}
+	return source, nil
+}
+
+// Size is the width and height of the image once turned upright
+func (s *Source) Size() (int, int) {
+	bounds := s.img.Bounds()
+	if s.orientation >= 5 {
+		return bounds.Dy(), bounds.Dx()
+	}
+	return bounds.Dx(), bounds.Dy()
+}
+
+// Thumbnail fits the upright image inside a square of maxEdge pixels. Images
+// that already fit are kept at their size rather than blown up. Scaling
+// comes first so only the small image is turned.
+func (s *Source) Thumbnail(maxEdge int) image.Image {
+	return orient(scale(s.img, maxEdge), s.orientation)
+}
+
+func scale(img image.Image, maxEdge int) image.Image {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width <= maxEdge && height <= maxEdge {
+		return img
+	}
+
+	if width >= height {
+		height = max(1, height*maxEdge/width)
+		width = maxEdge
+	} else {
+		width = max(1, width*maxEdge/height)
+		height = maxEdge
+	}
+
+	scaled := image.NewRGBA(image.Rect(0, 0, width, height))
+	xdraw.CatmullRom.Scale(scaled, scaled.Bounds(), img, bounds, xdraw.Src, nil)
+	return scaled
+}
+
+// Encode writes img as a JPEG. JPEG has no transparency, so transparent
+// areas are filled with white.
+func Encode(w io.Writer, img image.Image) error {
+	flat := image.NewRGBA(img.Bounds())
+	draw.Draw(flat, flat.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.Draw(flat, flat.Bounds(), img, img.Bounds().Min, draw.Over)
+	return jpeg.Encode(w, flat, &jpeg.Options{Quality: jpegQuality})
+}
+
+// orient applies an EXIF orientation, 1 to 8, so the image displays upright
+func orient(img image.Image, orientation int) image.Image {
+	if orientation <= 1 || orientation > 8 {
+		return img
+	}
+
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	// 5 to 8 are stored on their side
+	outWidth, outHeight := width, height
+	if orientation >= 5 {
+		outWidth, outHeight = height, width
+	}
+
+	out := image.NewRGBA(image.Rect(0, 0, outWidth, outHeight))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2: // flip horizontally
+				dx, dy = width-1-x, y
+			case 3: // rotate 180°
+				dx, dy = width-1-x, height-1-y
+			case 4: // flip vertically
+				dx, dy = x, height-1-y
+			case 5: // transpose
+				dx, dy = y, x
+			case 6: // rotate 90° clockwise
+				dx, dy = height-1-y, x
+			case 7: // transverse
+				dx, dy = height-1-y, width-1-x
+			case 8: // rotate 90° counter-clockwise
+				dx, dy = y, width-1-x
+			}
+			out.Set(dx, dy, img.At(bounds.Min.X+x, bounds.Min.Y+y))
+		}
+	}
+	return out
+}
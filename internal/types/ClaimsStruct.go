@@ -4,4 +4,6 @@ import "github.com/golang-jwt/jwt/v4"
 
 type Claims struct {
 	jwt.StandardClaims
+	// SessionId ties an access token to the session that issued it
+	SessionId string `json:"sid,omitempty"`
 }
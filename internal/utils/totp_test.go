@@ -0,0 +1,121 @@
+package utils
+
+import (
+	"testing"
+	"time"
+)
+
+// rfc6238Secret is the SHA-1 key of the RFC 6238 appendix B test vectors
+var rfc6238Secret = totpEncoding.EncodeToString([]byte("12345678901234567890"))
+
+// The RFC lists 8 digit codes, ours are their last 6 digits
+var rfc6238Vectors = []struct {
+	unix int64
+	code string
+}{
+	{59, "287082"},
+	{1111111109, "081804"},
+	{1111111111, "050471"},
+	{1234567890, "005924"},
+	{2000000000, "279037"},
+	{20000000000, "353130"},
+}
+
+func TestTOTPCodeMatchesRFC6238(t *testing.T) {
+	key := []byte("12345678901234567890")
+	for _, vector := range rfc6238Vectors {
+		if got := totpCode(key, vector.unix/totpPeriod); got != vector.code {
+			t.Errorf("totpCode at %d = %s, want %s", vector.unix, got, vector.code)
+		}
+	}
+}
+
+func TestValidateTOTPAcceptsRFC6238Vectors(t *testing.T) {
+	for _, vector := range rfc6238Vectors {
+		now := time.Unix(vector.unix, 0)
+		step, ok := ValidateTOTP(rfc6238Secret, vector.code, now)
+		if !ok {
+			t.Errorf("code %s rejected at %d", vector.code, vector.unix)
+			continue
+		}
+		if step != vector.unix/totpPeriod {
+			t.Errorf("code %s matched step %d, want %d", vector.code, step, vector.unix/totpPeriod)
+		}
+	}
+}
+
+func TestValidateTOTPWindow(t *testing.T) {
+	key := []byte("12345678901234567890")
+	now := time.Unix(1234567890, 0)
+	current := now.Unix() / totpPeriod
+
+	tests := []struct {
+		name  string
+		step  int64
+		valid bool
+	}{
+		{"current step", current, true},
+		{"one step behind", current - 1, true},
+		{"one step ahead", current + 1, true},
+		{"two steps behind", current - 2, false},
+		{"two steps ahead", current + 2, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			step, ok := ValidateTOTP(rfc6238Secret, totpCode(key, test.step), now)
+			if ok != test.valid {
+				t.Fatalf("valid = %v, want %v", ok, test.valid)
+			}
+			if ok && step != test.step {
+				t.Fatalf("matched step %d, want %d", step, test.step)
+			}
+		})
+	}
+}
+
+func TestValidateTOTPInput(t *testing.T) {
+	key := []byte("12345678901234567890")
+	now := time.Unix(1111111111, 0)
+	code := totpCode(key, now.Unix()/totpPeriod)
+
+	tests := []struct {
+		name   string
+		secret string
+		code   string
+		valid  bool
+	}{
+		{"spaces are ignored", rfc6238Secret, code[:3] + " " + code[3:], true},
+		{"lower case secret", "gezdgnbvgy3tqojqgezdgnbvgy3tqojq", code, true},
+		{"padded secret", rfc6238Secret + "====", code, true},
+		{"too short", rfc6238Secret, code[:5], false},
+		{"too long", rfc6238Secret, code + "0", false},
+		{"wrong code", rfc6238Secret, "000000", code == "000000"},
+		{"invalid secret", "not base32!", code, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, ok := ValidateTOTP(test.secret, test.code, now); ok != test.valid {
+				t.Fatalf("valid = %v, want %v", ok, test.valid)
+			}
+		})
+	}
+}
+
+func TestGenerateTOTPSecretRoundTrips(t *testing.T) {
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := totpEncoding.DecodeString(secret)
+	if err != nil {
+		t.Fatalf("secret %q is not base32: %v", secret, err)
+	}
+	if len(key) != 20 {
+		t.Fatalf("secret is %d bytes, want 20", len(key))
+	}
+
+	now := time.Now()
+	if _, ok := ValidateTOTP(secret, totpCode(key, now.Unix()/totpPeriod), now); !ok {
+		t.Fatal("code for a generated secret was rejected")
+	}
+}